@@ -6,13 +6,18 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	genericoptions "zmc.io/oasis/pkg/server/options"
 
+	"k8s.io/client-go/dynamic"
 	cliflag "k8s.io/component-base/cli/flag"
 	"k8s.io/klog"
 	"zmc.io/oasis/pkg/apiserver"
 	apiserverconfig "zmc.io/oasis/pkg/apiserver/config"
+	"zmc.io/oasis/pkg/controller/destinationrule"
+	"zmc.io/oasis/pkg/controller/virtualservice/util"
+	"zmc.io/oasis/pkg/controller/workload"
 	"zmc.io/oasis/pkg/informers"
 	"zmc.io/oasis/pkg/simple/client/k8s"
 )
@@ -23,6 +28,10 @@ type ServerRunOptions struct {
 
 	// from local file
 	*apiserverconfig.Config
+
+	// SubsetGracePeriod is how long subsets are held in previewed destinationrules after their
+	// workloads become unready, it should be the same as the one of controller manager
+	SubsetGracePeriod time.Duration
 }
 
 func NewServerRunOptions() *ServerRunOptions {
@@ -30,6 +39,7 @@ func NewServerRunOptions() *ServerRunOptions {
 	s := ServerRunOptions{
 		GenericServerRunOptions: genericoptions.NewServerRunOptions(),
 		Config:                  apiserverconfig.New(),
+		SubsetGracePeriod:       destinationrule.DefaultSubsetGracePeriod,
 	}
 
 	return &s
@@ -41,6 +51,11 @@ func (s *ServerRunOptions) Flags() (fss cliflag.NamedFlagSets) {
 
 	s.KubernetesOptions.AddFlags(fss.FlagSet("kubernetes"), s.KubernetesOptions)
 
+	dfs := fss.FlagSet("destinationrule")
+	dfs.DurationVar(&s.SubsetGracePeriod, "subset-grace-period", s.SubsetGracePeriod, ""+
+		"How long a subset is kept in previewed destinationrules after its workloads have no ready "+
+		"replicas, it should be the same as the one of controller manager.")
+
 	fs := fss.FlagSet("klog")
 	local := flag.NewFlagSet("klog", flag.ExitOnError)
 	klog.InitFlags(local)
@@ -57,15 +72,31 @@ func (s *ServerRunOptions) NewAPIServer(stopCh <-chan struct{}) (*apiserver.APIS
 	apiServer := &apiserver.APIServer{
 		Config: s.Config,
 	}
+
+	// applications are identified by the configured labels
+	util.ApplyLabelOptions(s.ServiceMeshOptions)
+
 	kubernetesClient, err := k8s.NewKubernetesClient(s.KubernetesOptions)
 	if err != nil {
 		return nil, err
 	}
 	apiServer.KubernetesClient = kubernetesClient
 
-	informerFactory := informers.NewInformerFactories(kubernetesClient.Kubernetes(), nil, nil)
+	informerFactory := informers.NewInformerFactories(kubernetesClient.Kubernetes(), kubernetesClient.Mesh(), kubernetesClient.Istio())
 	apiServer.InformerFactory = informerFactory
 
+	// versions of services are collected from built-in workloads, and argo rollouts if installed
+	apiServer.WorkloadSources = workload.KubernetesSources(informerFactory.KubernetesSharedInformerFactory())
+	apiServer.DynamicInformerFactory = informers.NewDynamicInformerFactory(dynamic.NewForConfigOrDie(kubernetesClient.Config()))
+	rolloutInstalled, err := workload.IsRolloutInstalled(kubernetesClient.Discovery())
+	if err != nil {
+		return nil, err
+	}
+	if rolloutInstalled {
+		apiServer.WorkloadSources = append(apiServer.WorkloadSources, workload.NewRolloutSource(apiServer.DynamicInformerFactory))
+	}
+	apiServer.SubsetGracePeriod = s.SubsetGracePeriod
+
 	server := &http.Server{
 		Addr: fmt.Sprintf(":%d", s.GenericServerRunOptions.InsecurePort),
 	}
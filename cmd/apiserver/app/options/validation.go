@@ -1,5 +1,7 @@
 package options
 
+import "fmt"
+
 // Validate validates server run options, to find
 // options' misconfiguration
 func (s *ServerRunOptions) Validate() []error {
@@ -8,5 +10,9 @@ func (s *ServerRunOptions) Validate() []error {
 	errors = append(errors, s.KubernetesOptions.Validate()...)
 	// errors = append(errors, s.ServiceMeshOptions.Validate()...)
 
+	if s.SubsetGracePeriod < 0 {
+		errors = append(errors, fmt.Errorf("subset grace period must not be negative, got %s", s.SubsetGracePeriod))
+	}
+
 	return errors
 }
@@ -21,6 +21,7 @@ func NewAPIServerCommand() *cobra.Command {
 		s = &options.ServerRunOptions{
 			GenericServerRunOptions: s.GenericServerRunOptions,
 			Config:                  conf,
+			SubsetGracePeriod:       s.SubsetGracePeriod,
 		}
 	}
 
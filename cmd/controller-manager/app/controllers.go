@@ -1,18 +1,35 @@
 package app
 
 import (
+	"time"
+
 	"k8s.io/klog"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"zmc.io/oasis/pkg/controller/destinationrule"
+	"zmc.io/oasis/pkg/controller/scope"
 	"zmc.io/oasis/pkg/controller/virtualservice"
+	"zmc.io/oasis/pkg/controller/workload"
 	"zmc.io/oasis/pkg/informers"
+	"zmc.io/oasis/pkg/server/healthz"
 	"zmc.io/oasis/pkg/simple/client/k8s"
+	"zmc.io/oasis/pkg/simple/client/prometheus"
 )
 
+// controller is a manager runnable which tells if its caches are synced
+type controller interface {
+	manager.Runnable
+	HasSynced() bool
+}
+
 func addControllers(
 	mgr manager.Manager,
+	probes *healthz.Probes,
+	controllerScope *scope.Scope,
+	workloadSources []workload.Source,
+	subsetGracePeriod time.Duration,
 	client k8s.Client,
 	informerFactory informers.InformerFactory,
+	prometheusClient prometheus.Interface,
 	serviceMeshEnabled bool,
 	stopCh <-chan struct{}) error {
 
@@ -20,26 +37,32 @@ func addControllers(
 	istioInformer := informerFactory.IstioSharedInformerFactory()
 	msInformer := informerFactory.MeshSharedInformerFactory()
 
-	var vsController, drController manager.Runnable
+	var vsController, drController controller
 	if serviceMeshEnabled {
 		vsController = virtualservice.NewVirtualServiceController(kubernetesInformer.Core().V1().Services(),
 			istioInformer.Networking().V1beta1().VirtualServices(),
 			istioInformer.Networking().V1beta1().DestinationRules(),
 			msInformer.Servicemesh().V1alpha1().Strategies(),
+			kubernetesInformer.Apps().V1().ControllerRevisions(),
 			client.Kubernetes(),
 			client.Istio(),
-			client.Mesh())
+			client.Mesh(),
+			prometheusClient,
+			controllerScope)
 
-		drController = destinationrule.NewDestinationRuleController(kubernetesInformer.Apps().V1().Deployments(),
+		drController = destinationrule.NewDestinationRuleController(workloadSources,
 			istioInformer.Networking().V1beta1().DestinationRules(),
 			kubernetesInformer.Core().V1().Services(),
 			msInformer.Servicemesh().V1alpha1().ServicePolicies(),
+			msInformer.Servicemesh().V1alpha1().Strategies(),
 			client.Kubernetes(),
 			client.Istio(),
-			client.Mesh())
+			client.Mesh(),
+			controllerScope,
+			subsetGracePeriod)
 	}
 
-	controllers := map[string]manager.Runnable{
+	controllers := map[string]controller{
 		"virtualservice-controller":  vsController,
 		"destinationrule-controller": drController,
 	}
@@ -54,6 +77,8 @@ func addControllers(
 			klog.Error(err, "add controller to manager failed", "name", name)
 			return err
 		}
+
+		probes.AddReadyzCheck(name, healthz.InformersSynced(ctrl.HasSynced))
 	}
 
 	return nil
@@ -2,34 +2,58 @@ package options
 
 import (
 	"flag"
+	"fmt"
 	"strings"
 	"time"
 
 	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/tools/leaderelection"
 	cliflag "k8s.io/component-base/cli/flag"
 	"k8s.io/klog"
+	"zmc.io/oasis/pkg/controller/destinationrule"
 	"zmc.io/oasis/pkg/simple/client/k8s"
-	// "zmc.io/oasis/pkg/simple/client/servicemesh"
+	"zmc.io/oasis/pkg/simple/client/servicemesh"
 )
 
 type ControllerManagerOptions struct {
-	KubernetesOptions *k8s.KubernetesOptions
-	// ServiceMeshOptions *servicemesh.Options
-	LeaderElect    bool
-	LeaderElection *leaderelection.LeaderElectionConfig
+	KubernetesOptions      *k8s.KubernetesOptions
+	ServiceMeshOptions     *servicemesh.Options
+	LeaderElect            bool
+	LeaderElection         *leaderelection.LeaderElectionConfig
+	LeaderElectionID       string
+	WebhookPort            int
+	WebhookCertDir         string
+	MetricsBindAddress     string
+	HealthProbeBindAddress string
+
+	// Namespaces, NamespaceSelector and Shards scope namespaces reconciled by controllers,
+	// several controller-managers split a cluster by namespaces if they don't overlap.
+	Namespaces        []string
+	NamespaceSelector string
+	Shards            int
+	Shard             int
+
+	// SubsetGracePeriod is how long subsets are kept after their workloads become unready
+	SubsetGracePeriod time.Duration
 }
 
 func NewControllerManagerOptions() *ControllerManagerOptions {
 	s := &ControllerManagerOptions{
-		KubernetesOptions: k8s.NewKubernetesOptions(),
-		// ServiceMeshOptions: servicemesh.NewServiceMeshOptions(),
+		KubernetesOptions:  k8s.NewKubernetesOptions(),
+		ServiceMeshOptions: servicemesh.NewServiceMeshOptions(),
 		LeaderElection: &leaderelection.LeaderElectionConfig{
 			LeaseDuration: 30 * time.Second,
 			RenewDeadline: 15 * time.Second,
 			RetryPeriod:   5 * time.Second,
 		},
-		LeaderElect: false,
+		LeaderElect:            false,
+		LeaderElectionID:       "ms-controller-manager-leader-election",
+		WebhookPort:            8443,
+		MetricsBindAddress:     ":8080",
+		HealthProbeBindAddress: ":8081",
+		Shards:                 1,
+		SubsetGracePeriod:      destinationrule.DefaultSubsetGracePeriod,
 	}
 
 	return s
@@ -38,7 +62,7 @@ func NewControllerManagerOptions() *ControllerManagerOptions {
 func (s *ControllerManagerOptions) Flags() cliflag.NamedFlagSets {
 	fss := cliflag.NamedFlagSets{}
 	s.KubernetesOptions.AddFlags(fss.FlagSet("kubernetes"), s.KubernetesOptions)
-	// s.ServiceMeshOptions.AddFlags(fss.FlagSet("servicemesh"), s.ServiceMeshOptions)
+	s.ServiceMeshOptions.AddFlags(fss.FlagSet("servicemesh"), s.ServiceMeshOptions)
 
 	fs := fss.FlagSet("leaderelection")
 	s.bindLeaderElectionFlags(s.LeaderElection, fs)
@@ -46,6 +70,42 @@ func (s *ControllerManagerOptions) Flags() cliflag.NamedFlagSets {
 	fs.BoolVar(&s.LeaderElect, "leader-elect", s.LeaderElect, ""+
 		"Whether to enable leader election. This field should be enabled when controller manager"+
 		"deployed with multiple replicas.")
+	fs.StringVar(&s.LeaderElectionID, "leader-elect-id", s.LeaderElectionID, ""+
+		"The name of the lease replicas of controller manager compete for, controller managers "+
+		"reconciling different namespaces must use different names. Index of shard is appended "+
+		"if namespaces are split into shards.")
+
+	wfs := fss.FlagSet("webhook")
+	wfs.IntVar(&s.WebhookPort, "webhook-port", s.WebhookPort, "The port webhook server serves at.")
+	wfs.StringVar(&s.WebhookCertDir, "webhook-cert-dir", s.WebhookCertDir, ""+
+		"The directory that contains the server key and certificate, named tls.key and tls.crt. "+
+		"Webhooks validating and defaulting strategies and servicepolicies are disabled if empty.")
+
+	mfs := fss.FlagSet("metrics")
+	mfs.StringVar(&s.MetricsBindAddress, "metrics-bind-address", s.MetricsBindAddress, ""+
+		"The address prometheus metrics of reconciliations and workqueues are served at, "+
+		"e.g. :8080. Set it to 0 to disable the metrics endpoint.")
+
+	hfs := fss.FlagSet("healthz")
+	hfs.StringVar(&s.HealthProbeBindAddress, "health-probe-bind-address", s.HealthProbeBindAddress, ""+
+		"The address /healthz, /livez and /readyz probes are served at, e.g. :8081. "+
+		"Set it to 0 to disable health probes.")
+
+	sfs := fss.FlagSet("scope")
+	sfs.StringSliceVar(&s.Namespaces, "namespaces", s.Namespaces, ""+
+		"Namespaces whose services are reconciled, all namespaces are reconciled if empty.")
+	sfs.StringVar(&s.NamespaceSelector, "namespace-selector", s.NamespaceSelector, ""+
+		"Label selector of namespaces whose services are reconciled, e.g. env=staging.")
+	sfs.IntVar(&s.Shards, "shards", s.Shards, ""+
+		"Number of shards namespaces are split into by hash of their names, every shard is "+
+		"reconciled by a controller manager deployment.")
+	sfs.IntVar(&s.Shard, "shard", s.Shard, ""+
+		"Index of the shard reconciled by this controller manager, from 0 to shards - 1.")
+
+	dfs := fss.FlagSet("destinationrule")
+	dfs.DurationVar(&s.SubsetGracePeriod, "subset-grace-period", s.SubsetGracePeriod, ""+
+		"How long a subset is kept in destinationrule after its workloads have no ready replicas, "+
+		"e.g. during rolling restarts. Subsets strategies route traffic to are kept regardless.")
 
 	kfs := fss.FlagSet("klog")
 	local := flag.NewFlagSet("klog", flag.ExitOnError)
@@ -77,5 +137,18 @@ func (s *ControllerManagerOptions) bindLeaderElectionFlags(l *leaderelection.Lea
 func (s *ControllerManagerOptions) Validate() []error {
 	var errs []error
 	errs = append(errs, s.KubernetesOptions.Validate()...)
+	errs = append(errs, s.ServiceMeshOptions.Validate()...)
+
+	if _, err := labels.Parse(s.NamespaceSelector); err != nil {
+		errs = append(errs, fmt.Errorf("invalid namespace selector %q, %v", s.NamespaceSelector, err))
+	}
+	if s.SubsetGracePeriod < 0 {
+		errs = append(errs, fmt.Errorf("subset grace period must not be negative, got %s", s.SubsetGracePeriod))
+	}
+	if s.Shards < 1 {
+		errs = append(errs, fmt.Errorf("shards must be at least 1, got %d", s.Shards))
+	} else if s.Shard < 0 || s.Shard >= s.Shards {
+		errs = append(errs, fmt.Errorf("shard must be in [0, %d), got %d", s.Shards, s.Shard))
+	}
 	return errs
 }
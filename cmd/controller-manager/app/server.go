@@ -5,7 +5,9 @@ import (
 	"os"
 
 	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/labels"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/dynamic"
 	cliflag "k8s.io/component-base/cli/flag"
 	"k8s.io/klog"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
@@ -13,8 +15,13 @@ import (
 	"zmc.io/oasis/cmd/controller-manager/app/options"
 	apis "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
 	controllerconfig "zmc.io/oasis/pkg/apiserver/config"
+	"zmc.io/oasis/pkg/controller/scope"
+	"zmc.io/oasis/pkg/controller/virtualservice/util"
+	"zmc.io/oasis/pkg/controller/workload"
 	"zmc.io/oasis/pkg/informers"
+	"zmc.io/oasis/pkg/server/healthz"
 	"zmc.io/oasis/pkg/simple/client/k8s"
+	"zmc.io/oasis/pkg/simple/client/prometheus"
 	"zmc.io/oasis/pkg/utils/term"
 )
 
@@ -24,10 +31,20 @@ func NewControllerManagerCommand() *cobra.Command {
 	if err == nil {
 		// make sure LeaderElection is not nil
 		s = &options.ControllerManagerOptions{
-			KubernetesOptions: conf.KubernetesOptions,
-			// ServiceMeshOptions: conf.ServiceMeshOptions,
-			LeaderElection: s.LeaderElection,
-			LeaderElect:    s.LeaderElect,
+			KubernetesOptions:      conf.KubernetesOptions,
+			ServiceMeshOptions:     conf.ServiceMeshOptions,
+			LeaderElection:         s.LeaderElection,
+			LeaderElect:            s.LeaderElect,
+			LeaderElectionID:       s.LeaderElectionID,
+			WebhookPort:            s.WebhookPort,
+			WebhookCertDir:         s.WebhookCertDir,
+			MetricsBindAddress:     s.MetricsBindAddress,
+			HealthProbeBindAddress: s.HealthProbeBindAddress,
+			Namespaces:             s.Namespaces,
+			NamespaceSelector:      s.NamespaceSelector,
+			Shards:                 s.Shards,
+			Shard:                  s.Shard,
+			SubsetGracePeriod:      s.SubsetGracePeriod,
 		}
 	} else {
 		klog.Fatal("Failed to load configuration from disk", err)
@@ -73,23 +90,40 @@ func run(s *options.ControllerManagerOptions, stopCh <-chan struct{}) error {
 		return err
 	}
 
+	var prometheusClient prometheus.Interface
+	if s.ServiceMeshOptions != nil && len(s.ServiceMeshOptions.ServicemeshPrometheusHost) != 0 {
+		prometheusClient, err = prometheus.NewPrometheus(s.ServiceMeshOptions.ServicemeshPrometheusHost)
+		if err != nil {
+			klog.Errorf("Failed to create prometheus client %v", err)
+			return err
+		}
+	}
+
+	// applications are identified by the configured labels
+	util.ApplyLabelOptions(s.ServiceMeshOptions)
+
 	informerFactory := informers.NewInformerFactories(
 		kubernetesClient.Kubernetes(),
 		kubernetesClient.Mesh(),
 		kubernetesClient.Istio(),
 	)
 
-	mgrOptions := manager.Options{}
+	mgrOptions := manager.Options{
+		Port:               s.WebhookPort,
+		CertDir:            s.WebhookCertDir,
+		MetricsBindAddress: s.MetricsBindAddress,
+	}
 
 	if s.LeaderElect {
-		mgrOptions = manager.Options{
-			LeaderElection:          s.LeaderElect,
-			LeaderElectionNamespace: "linkedcare-system",
-			LeaderElectionID:        "ms-controller-manager-leader-election",
-			LeaseDuration:           &s.LeaderElection.LeaseDuration,
-			RetryPeriod:             &s.LeaderElection.RetryPeriod,
-			RenewDeadline:           &s.LeaderElection.RenewDeadline,
+		mgrOptions.LeaderElection = s.LeaderElect
+		mgrOptions.LeaderElectionNamespace = "linkedcare-system"
+		mgrOptions.LeaderElectionID = s.LeaderElectionID
+		if s.Shards > 1 {
+			mgrOptions.LeaderElectionID = fmt.Sprintf("%s-shard-%d", s.LeaderElectionID, s.Shard)
 		}
+		mgrOptions.LeaseDuration = &s.LeaderElection.LeaseDuration
+		mgrOptions.RetryPeriod = &s.LeaderElection.RetryPeriod
+		mgrOptions.RenewDeadline = &s.LeaderElection.RenewDeadline
 	}
 
 	klog.V(0).Info("setting up manager")
@@ -107,18 +141,55 @@ func run(s *options.ControllerManagerOptions, stopCh <-chan struct{}) error {
 	// servicemeshEnabled := s.ServiceMeshOptions != nil && len(s.ServiceMeshOptions.IstioPilotHost) != 0
 	servicemeshEnabled := true
 
+	// the manager is ready once it leads, caches of its controllers are synced,
+	// and istio resources can be discovered
+	probes := healthz.NewProbes()
+	probes.AddReadyzCheck("leader-election", healthz.LeaderElected(mgr.Elected()))
+	probes.AddReadyzCheck("istio-resources", healthz.IstioResourcesReachable(kubernetesClient.Discovery()))
+
+	// selector is validated along with options
+	namespaceSelector, _ := labels.Parse(s.NamespaceSelector)
+	controllerScope := scope.NewScope(s.Namespaces, namespaceSelector,
+		informerFactory.KubernetesSharedInformerFactory().Core().V1().Namespaces(), s.Shards, s.Shard)
+	klog.V(0).Infof("controllers reconcile %s", controllerScope)
+
+	// versions of services are collected from built-in workloads, and argo rollouts if installed
+	workloadSources := workload.KubernetesSources(informerFactory.KubernetesSharedInformerFactory())
+	dynamicInformerFactory := informers.NewDynamicInformerFactory(dynamic.NewForConfigOrDie(kubernetesClient.Config()))
+	rolloutInstalled, err := workload.IsRolloutInstalled(kubernetesClient.Discovery())
+	if err != nil {
+		klog.Errorf("Failed to discover %s %v", workload.RolloutResource, err)
+		return err
+	}
+	if rolloutInstalled {
+		workloadSources = append(workloadSources, workload.NewRolloutSource(dynamicInformerFactory))
+	}
+
 	// Add controllers
 	if err = addControllers(mgr,
+		probes,
+		controllerScope,
+		workloadSources,
+		s.SubsetGracePeriod,
 		kubernetesClient,
 		informerFactory,
+		prometheusClient,
 		servicemeshEnabled,
 		stopCh); err != nil {
 		klog.Fatalf("unable to register controllers to the manager: %v", err)
 	}
 
+	if len(s.WebhookCertDir) != 0 {
+		klog.V(0).Info("registering webhooks to the webhook server")
+		addWebhooks(mgr, informerFactory)
+	}
+
 	// Start cache data after all informer is registered
 	klog.V(0).Info("Starting cache resource from apiserver...")
 	informerFactory.Start(stopCh)
+	dynamicInformerFactory.Start(stopCh)
+
+	probes.Serve(s.HealthProbeBindAddress, stopCh)
 
 	klog.V(0).Info("Starting the controllers.")
 	if err = mgr.Start(stopCh); err != nil {
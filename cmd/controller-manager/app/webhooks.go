@@ -0,0 +1,26 @@
+package app
+
+import (
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"zmc.io/oasis/pkg/informers"
+	servicemeshwebhook "zmc.io/oasis/pkg/webhook/servicemesh"
+)
+
+func addWebhooks(mgr manager.Manager, informerFactory informers.InformerFactory) {
+	serviceInformer := informerFactory.KubernetesSharedInformerFactory().Core().V1().Services()
+	destinationRuleInformer := informerFactory.IstioSharedInformerFactory().Networking().V1beta1().DestinationRules()
+	serviceLister := serviceInformer.Lister()
+	destinationRuleLister := destinationRuleInformer.Lister()
+	listersSynced := func() bool {
+		return serviceInformer.Informer().HasSynced() && destinationRuleInformer.Informer().HasSynced()
+	}
+
+	hookServer := mgr.GetWebhookServer()
+	hookServer.Register("/mutate-servicemesh-linkedcare-io-v1alpha1-strategy",
+		&webhook.Admission{Handler: &servicemeshwebhook.StrategyDefaulter{}})
+	hookServer.Register("/validate-servicemesh-linkedcare-io-v1alpha1-strategy",
+		&webhook.Admission{Handler: &servicemeshwebhook.StrategyValidator{ServiceLister: serviceLister, DestinationRuleLister: destinationRuleLister, ListersSynced: listersSynced}})
+	hookServer.Register("/validate-servicemesh-linkedcare-io-v1alpha1-servicepolicy",
+		&webhook.Admission{Handler: &servicemeshwebhook.ServicePolicyValidator{ServiceLister: serviceLister, DestinationRuleLister: destinationRuleLister, ListersSynced: listersSynced}})
+}
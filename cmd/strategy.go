@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/util/duration"
+
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+	"zmc.io/oasis/pkg/models/servicemesh/strategy"
+)
+
+var (
+	server    string
+	namespace string
+	diffTo    int64
+
+	// strategyCmd groups commands operating strategies through apiserver
+	strategyCmd = &cobra.Command{
+		Use:   "strategy",
+		Short: "Manage revisions of strategies",
+	}
+
+	strategyHistoryCmd = &cobra.Command{
+		Use:   "history STRATEGY",
+		Short: "List revisions of a strategy",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var revisions []strategy.Revision
+			if err := callAPIServer(http.MethodGet, fmt.Sprintf("/strategies/%s/revisions", args[0]), &revisions); err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+			fmt.Fprintln(w, "REVISION\tNAME\tAGE\tCURRENT")
+			for _, revision := range revisions {
+				current := ""
+				if revision.Current {
+					current = "*"
+				}
+				fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", revision.Revision, revision.Name,
+					duration.HumanDuration(time.Since(revision.CreationTimestamp.Time)), current)
+			}
+			return w.Flush()
+		},
+	}
+
+	strategyDiffCmd = &cobra.Command{
+		Use:   "diff STRATEGY REVISION",
+		Short: "Show differences between a revision and current spec, or another revision with --to",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			revision, err := strconv.ParseInt(args[1], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid revision %s", args[1])
+			}
+
+			var result strategy.RevisionDiff
+			path := fmt.Sprintf("/strategies/%s/revisions/%d/diff", args[0], revision)
+			if diffTo != 0 {
+				path = fmt.Sprintf("%s?to=%d", path, diffTo)
+			}
+			if err = callAPIServer(http.MethodGet, path, &result); err != nil {
+				return err
+			}
+
+			if len(result.Diff) == 0 {
+				fmt.Println("no differences")
+				return nil
+			}
+			fmt.Println(result.Diff)
+			return nil
+		},
+	}
+
+	strategyRollbackCmd = &cobra.Command{
+		Use:   "rollback STRATEGY REVISION",
+		Short: "Rollback spec of a strategy to a revision",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			revision, err := strconv.ParseInt(args[1], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid revision %s", args[1])
+			}
+
+			var result servicemeshv1alpha1.Strategy
+			if err = callAPIServer(http.MethodPost, fmt.Sprintf("/strategies/%s/revisions/%d/rollback", args[0], revision), &result); err != nil {
+				return err
+			}
+
+			fmt.Printf("strategy %s/%s rolled back to revision %d\n", result.Namespace, result.Name, revision)
+			return nil
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(strategyCmd)
+	strategyCmd.AddCommand(strategyHistoryCmd, strategyDiffCmd, strategyRollbackCmd)
+
+	strategyCmd.PersistentFlags().StringVar(&server, "server", "http://localhost:9090", "address of oasis apiserver")
+	strategyCmd.PersistentFlags().StringVarP(&namespace, "namespace", "n", "default", "namespace of the strategy")
+	strategyDiffCmd.Flags().Int64Var(&diffTo, "to", 0, "revision to diff with, current spec of the strategy if not specified")
+}
+
+// callAPIServer requests servicemesh api of strategies in namespace, and decodes response into result
+func callAPIServer(method, path string, result interface{}) error {
+	url := fmt.Sprintf("%s/apis/servicemesh/v1alpha1/namespaces/%s%s", server, namespace, path)
+
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: %s, %s", method, url, resp.Status, body)
+	}
+
+	return json.Unmarshal(body, result)
+}
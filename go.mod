@@ -7,10 +7,13 @@ require (
 	github.com/emicklei/go-restful v2.14.2+incompatible
 	github.com/emicklei/go-restful-openapi v1.4.1
 	github.com/go-logr/logr v0.3.0 // indirect
+	github.com/gogo/protobuf v1.3.1
 	github.com/google/gofuzz v1.2.0 // indirect
 	github.com/googleapis/gnostic v0.5.1 // indirect
 	github.com/imdario/mergo v0.3.11 // indirect
 	github.com/mitchellh/go-homedir v1.1.0
+	github.com/prometheus/client_golang v1.7.1
+	github.com/prometheus/common v0.10.0
 	github.com/spf13/cobra v1.0.0
 	github.com/spf13/pflag v1.0.5
 	github.com/spf13/viper v1.7.1
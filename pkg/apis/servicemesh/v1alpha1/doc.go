@@ -1,5 +1,5 @@
 // +k8s:deepcopy-gen=package,register
-// +groupName=servicemesh.zmc.io
+// +groupName=servicemesh.linkedcare.io
 
 // Package v1alpha1 is the v1alpha1 version of the API.
 package v1alpha1 // import "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
@@ -36,15 +36,109 @@ type ServicePolicyList struct {
 // ServicePolicySpec defines the desired state of ServicePolicy
 type ServicePolicySpec struct {
 
+	// Priority of the servicepolicy among all servicepolicies applied to the same
+	// service, traffic policies of higher priority servicepolicies take precedence.
+	// ServicePolicies with the same priority are ordered by name.
+	// +optional
+	Priority int32 `json:"priority,omitempty"`
+
 	// Label selector for destination rules.
 	// +optional
 	Selector *metav1.LabelSelector `json:"selector,omitempty"`
 
+	// Resilience presets expanded into traffic policy of the destination rule,
+	// connection pool and outlier detection in template take precedence.
+	// +optional
+	Resilience *ResiliencePolicy `json:"resilience,omitempty"`
+
 	// Template used to create a destination rule
 	// +optional
 	Template DestinationRuleSpecTemplate `json:"template,omitempty"`
 }
 
+// ResiliencePolicy describes presets protecting a service from overload and
+// failing endpoints, parameters not specified take their defaults.
+type ResiliencePolicy struct {
+	// ConnectionLimits limits connections opened to each endpoint
+	// +optional
+	ConnectionLimits *ConnectionLimits `json:"connectionLimits,omitempty"`
+
+	// CircuitBreaker fails requests fast once too many of them are
+	// pending or in flight
+	// +optional
+	CircuitBreaker *CircuitBreaker `json:"circuitBreaker,omitempty"`
+
+	// OutlierEjection ejects endpoints returning consecutive errors from
+	// load balancing pool
+	// +optional
+	OutlierEjection *OutlierEjection `json:"outlierEjection,omitempty"`
+}
+
+// ConnectionLimits describes limits of tcp connections to each endpoint
+type ConnectionLimits struct {
+	// Max connections to each endpoint, default to 1024
+	// +optional
+	MaxConnections *int32 `json:"maxConnections,omitempty"`
+
+	// Timeout of establishing a connection, default to 10s
+	// +optional
+	ConnectTimeout *metav1.Duration `json:"connectTimeout,omitempty"`
+
+	// Max requests sent over a connection before it is closed,
+	// unlimited if not specified
+	// +optional
+	MaxRequestsPerConnection *int32 `json:"maxRequestsPerConnection,omitempty"`
+
+	// Time a connection is kept without active requests, default to 1h
+	// +optional
+	IdleTimeout *metav1.Duration `json:"idleTimeout,omitempty"`
+}
+
+// CircuitBreaker describes thresholds beyond which requests fail at once
+type CircuitBreaker struct {
+	// Max requests waiting for a connection, default to 1024
+	// +optional
+	MaxPendingRequests *int32 `json:"maxPendingRequests,omitempty"`
+
+	// Max requests in flight, default to 1024
+	// +optional
+	MaxRequests *int32 `json:"maxRequests,omitempty"`
+
+	// Max retries in flight, unlimited if not specified
+	// +optional
+	MaxRetries *int32 `json:"maxRetries,omitempty"`
+}
+
+// OutlierEjection describes when endpoints are ejected and for how long
+type OutlierEjection struct {
+	// Consecutive 5xx errors before an endpoint is ejected, default to 5
+	// +optional
+	Consecutive5xxErrors *int32 `json:"consecutive5xxErrors,omitempty"`
+
+	// Consecutive 502, 503 and 504 errors before an endpoint is ejected,
+	// not counted separately if not specified
+	// +optional
+	ConsecutiveGatewayErrors *int32 `json:"consecutiveGatewayErrors,omitempty"`
+
+	// Time between ejection sweeps, default to 10s
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+
+	// Min time an endpoint is ejected, multiplied by times it has been
+	// ejected, default to 30s
+	// +optional
+	BaseEjectionTime *metav1.Duration `json:"baseEjectionTime,omitempty"`
+
+	// Max percentage of endpoints ejected, 0-100, default to 10
+	// +optional
+	MaxEjectionPercent *int32 `json:"maxEjectionPercent,omitempty"`
+
+	// Ejection is disabled once percentage of healthy endpoints falls
+	// below it, 0-100, default to 0
+	// +optional
+	MinHealthPercent *int32 `json:"minHealthPercent,omitempty"`
+}
+
 type DestinationRuleSpecTemplate struct {
 
 	// Metadata of the virtual services created from this template
@@ -65,6 +159,14 @@ const (
 
 	// StrategyFailed means the strategy has failed its delivery to istio.
 	ServicePolicyFailed ServicePolicyConditionType = "Failed"
+
+	// ServicePolicyConflicted means part of the servicepolicy is overridden by
+	// another servicepolicy with higher priority.
+	ServicePolicyConflicted ServicePolicyConditionType = "Conflicted"
+
+	// ServicePolicyDrifted means the destinationrule is overridden on purpose,
+	// and differs from what the servicepolicy generates.
+	ServicePolicyDrifted ServicePolicyConditionType = "Drifted"
 )
 
 // StrategyCondition describes current state of a strategy.
@@ -106,4 +208,41 @@ type ServicePolicyStatus struct {
 	// It is represented in RFC3339 form and is in UTC.
 	// +optional
 	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// The generation of servicepolicy observed by the controller.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Subsets kept in destinationrule while their workloads have no ready replicas
+	// +optional
+	HeldSubsets []HeldSubset `json:"heldSubsets,omitempty"`
+}
+
+const (
+	// subset is held within retention grace period since its workloads became unready
+	SubsetHeldInGracePeriod = "GracePeriod"
+	// subset is held as long as strategies route traffic to it
+	SubsetHeldByStrategy = "ReferencedByStrategy"
+)
+
+// HeldSubset is a subset whose workloads have no ready replicas, kept so routes
+// to it stay valid
+type HeldSubset struct {
+	// Name of the subset
+	Name string `json:"name"`
+
+	// Reason the subset is held, GracePeriod or ReferencedByStrategy
+	Reason string `json:"reason"`
+
+	// Strategies routing traffic to the subset
+	// +optional
+	Strategies []string `json:"strategies,omitempty"`
+
+	// Time when workloads of the subset became unready
+	UnreadySince metav1.Time `json:"unreadySince"`
+
+	// Time when the subset is removed unless its workloads get ready,
+	// absent if the subset is held by strategies
+	// +optional
+	ExpireTime *metav1.Time `json:"expireTime,omitempty"`
 }
@@ -44,6 +44,9 @@ const (
 
 	// Mirror strategy type
 	Mirror StrategyType = "Mirror"
+
+	// FaultInjection strategy type
+	FaultInjectionType StrategyType = "FaultInjection"
 )
 
 type StrategyPolicy string
@@ -64,6 +67,13 @@ type StrategySpec struct {
 	// Strategy type
 	Type StrategyType `json:"type,omitempty"`
 
+	// Priority of the strategy among all strategies applied to the same service,
+	// routes of higher priority strategies are matched first, and only the one
+	// with the highest priority decides where the default traffic goes.
+	// Strategies with the same priority are ordered by name.
+	// +optional
+	Priority int32 `json:"priority,omitempty"`
+
 	// Principal version, the one as reference version
 	// label version value
 	// +optional
@@ -74,6 +84,63 @@ type StrategySpec struct {
 	// +optional
 	GovernorVersion string `json:"governor,omitempty"`
 
+	// Canary version, the version traffic shifted to by steps
+	// label version value
+	// +optional
+	CanaryVersion string `json:"canary,omitempty"`
+
+	// Steps describe how traffic is shifted from principal version to
+	// canary version, steps are walked through one by one by the
+	// strategy controller.
+	// +optional
+	Steps []CanaryStep `json:"steps,omitempty"`
+
+	// Analysis describes metric thresholds canary version is checked
+	// against at every step, traffic is rolled back to principal version
+	// once any of them is breached.
+	// +optional
+	Analysis *CanaryAnalysis `json:"analysis,omitempty"`
+
+	// Segments route requests matching the conditions to a given version,
+	// segments are matched in order, ahead of the default route.
+	// +optional
+	Segments []UserSegment `json:"segments,omitempty"`
+
+	// BlueGreen describes how the new version is previewed before
+	// taking over all traffic, only used by BlueGreen strategies.
+	// +optional
+	BlueGreen *BlueGreenStrategy `json:"blueGreen,omitempty"`
+
+	// Mirror describes a copy of live traffic sent to another version,
+	// only used by Mirror strategies.
+	// +optional
+	Mirror *MirrorStrategy `json:"mirror,omitempty"`
+
+	// FaultInjection describes faults injected into requests until the
+	// experiment expires, only used by FaultInjection strategies.
+	// +optional
+	FaultInjection *FaultInjectionStrategy `json:"faultInjection,omitempty"`
+
+	// Timeout of http requests, applied to every http route generated
+	// from the strategy, routes in template with their own timeout are kept.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+
+	// Retries of failed http requests, applied to every http route generated
+	// from the strategy, routes in template with their own retries are kept.
+	// +optional
+	Retries *RetryPolicy `json:"retries,omitempty"`
+
+	// Ingress binds strategy routes to istio gateways, so traffic from
+	// outside of mesh is routed the same way as mesh internal traffic.
+	// +optional
+	Ingress *IngressStrategy `json:"ingress,omitempty"`
+
+	// Schedule limits strategy to be active only in time windows,
+	// strategy is always active if not specified.
+	// +optional
+	Schedule *StrategySchedule `json:"schedule,omitempty"`
+
 	// Label selector for virtual services.
 	// +optional
 	Selector *metav1.LabelSelector `json:"selector,omitempty"`
@@ -86,6 +153,183 @@ type StrategySpec struct {
 	StrategyPolicy StrategyPolicy `json:"strategyPolicy,omitempty"`
 }
 
+// CanaryStep describes a single traffic shifting step of a canary
+type CanaryStep struct {
+	// Percentage of traffic routed to canary version, 0-100
+	Weight int32 `json:"weight"`
+
+	// How long the step lasts before moving on to the next one,
+	// the last step lasts until the strategy changes.
+	// +optional
+	Pause metav1.Duration `json:"pause,omitempty"`
+}
+
+// CanaryAnalysis describes metric thresholds of a canary
+type CanaryAnalysis struct {
+	// Max percentage of 5xx responses of canary version, 0-100
+	// +optional
+	MaxErrorRate *int32 `json:"maxErrorRate,omitempty"`
+
+	// Max 99th percentile latency of canary version
+	// +optional
+	MaxLatency *metav1.Duration `json:"maxLatency,omitempty"`
+
+	// Time range metrics are evaluated over, default to 1m
+	// +optional
+	Interval metav1.Duration `json:"interval,omitempty"`
+}
+
+// UserSegment describes a group of requests routed to the same version,
+// all the conditions specified must be satisfied.
+type UserSegment struct {
+	// Name of the segment, used as the http route name
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Version requests of the segment routed to
+	// label version value
+	Version string `json:"version"`
+
+	// Request headers must match exactly
+	// +optional
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Cookie request must carry
+	// +optional
+	Cookie *CookieMatch `json:"cookie,omitempty"`
+
+	// Labels of the workload requests come from
+	// +optional
+	SourceLabels map[string]string `json:"sourceLabels,omitempty"`
+
+	// Prefix of request uri
+	// +optional
+	URIPrefix string `json:"uriPrefix,omitempty"`
+}
+
+// CookieMatch matches a cookie by name and exact value
+type CookieMatch struct {
+	// Name of the cookie
+	Name string `json:"name"`
+
+	// Value of the cookie
+	Value string `json:"value"`
+}
+
+// BlueGreenStrategy describes the new version of a blue/green strategy,
+// principal version serves all traffic until the new version is promoted.
+type BlueGreenStrategy struct {
+	// Preview version, the new version waiting to be promoted
+	// label version value
+	PreviewVersion string `json:"preview"`
+
+	// Host only routes to preview version
+	// +optional
+	PreviewHost string `json:"previewHost,omitempty"`
+}
+
+// MirrorStrategy describes the version traffic mirrored to, responses
+// of mirrored requests are discarded.
+type MirrorStrategy struct {
+	// Version traffic mirrored to
+	// label version value
+	Version string `json:"version"`
+
+	// Percentage of traffic mirrored, 0-100, default to 100
+	// +optional
+	Percentage *int32 `json:"percentage,omitempty"`
+}
+
+// FaultInjectionStrategy describes faults injected into http requests, to a
+// version or a user segment, or all requests of the service if neither is specified.
+type FaultInjectionStrategy struct {
+	// Version faults are injected into, only routes sending all their
+	// traffic to the version are affected.
+	// label version value
+	// +optional
+	Version string `json:"version,omitempty"`
+
+	// Name of the user segment faults are injected into
+	// +optional
+	Segment string `json:"segment,omitempty"`
+
+	// Delay requests before forwarding them
+	// +optional
+	Delay *FaultDelay `json:"delay,omitempty"`
+
+	// Abort requests with an error status
+	// +optional
+	Abort *FaultAbort `json:"abort,omitempty"`
+
+	// Faults are removed since this time
+	// +optional
+	ExpireTime *metav1.Time `json:"expireTime,omitempty"`
+}
+
+// FaultDelay delays a percentage of requests
+type FaultDelay struct {
+	// How long requests are delayed
+	FixedDelay metav1.Duration `json:"fixedDelay"`
+
+	// Percentage of requests delayed, 0-100
+	Percentage int32 `json:"percentage"`
+}
+
+// FaultAbort aborts a percentage of requests
+type FaultAbort struct {
+	// Http status code returned to aborted requests
+	HTTPStatus int32 `json:"httpStatus"`
+
+	// Percentage of requests aborted, 0-100
+	Percentage int32 `json:"percentage"`
+}
+
+// RetryPolicy describes how failed http requests are retried
+type RetryPolicy struct {
+	// Number of retries for a request
+	Attempts int32 `json:"attempts"`
+
+	// Timeout of every try, including the initial one
+	// +optional
+	PerTryTimeout *metav1.Duration `json:"perTryTimeout,omitempty"`
+
+	// Conditions requests are retried on, such as 5xx, gateway-error,
+	// connect-failure or retriable status codes
+	// +optional
+	RetryOn []string `json:"retryOn,omitempty"`
+}
+
+// IngressStrategy describes how strategy serves north-south traffic
+type IngressStrategy struct {
+	// Istio gateways traffic comes from, in form of <namespace>/<name>,
+	// or name of gateway in the same namespace
+	Gateways []string `json:"gateways"`
+
+	// External hosts requested through gateways
+	Hosts []string `json:"hosts"`
+}
+
+// StrategySchedule describes time windows a strategy is active in
+type StrategySchedule struct {
+	// Strategy is not active before this time
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// Strategy is not active since this time
+	// +optional
+	EndTime *metav1.Time `json:"endTime,omitempty"`
+
+	// Cron expression with fields of minute, hour, day of month, month and
+	// day of week, a window opens every time it fires. It is always evaluated
+	// in UTC, time zones are not supported. Day of week 0 and 7 are sunday.
+	// +optional
+	Cron string `json:"cron,omitempty"`
+
+	// How long a window opened by cron lasts, required if cron is specified
+	// +optional
+	Duration *metav1.Duration `json:"duration,omitempty"`
+}
+
 // VirtualServiceTemplateSpec
 type VirtualServiceTemplateSpec struct {
 
@@ -116,6 +360,59 @@ type StrategyStatus struct {
 	// It is represented in RFC3339 form and is in UTC.
 	// +optional
 	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// The generation of strategy observed by the controller.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Index of the canary step currently applied.
+	// +optional
+	CurrentStep *int32 `json:"currentStep,omitempty"`
+
+	// Represents time when the current canary step was applied.
+	// It is represented in RFC3339 form and is in UTC.
+	// +optional
+	CurrentStepTime *metav1.Time `json:"currentStepTime,omitempty"`
+
+	// Observed state of a blue/green strategy.
+	// +optional
+	BlueGreen *BlueGreenStatus `json:"blueGreen,omitempty"`
+
+	// Count of hash collisions of the strategy revisions. The controller uses
+	// it as a collision avoidance mechanism when it needs to create the name
+	// for the newest revision.
+	// +optional
+	CollisionCount *int32 `json:"collisionCount,omitempty"`
+}
+
+type BlueGreenPhase string
+
+// These are valid phases of a blue/green strategy.
+const (
+	// BlueGreenPreviewing means preview version is only reachable through preview host.
+	BlueGreenPreviewing BlueGreenPhase = "Previewing"
+
+	// BlueGreenPromoted means preview version takes all traffic.
+	BlueGreenPromoted BlueGreenPhase = "Promoted"
+
+	// BlueGreenAborted means all traffic reverted to principal version.
+	BlueGreenAborted BlueGreenPhase = "Aborted"
+)
+
+// BlueGreenStatus describes current state of a blue/green strategy.
+type BlueGreenStatus struct {
+	// Phase of the blue/green strategy.
+	Phase BlueGreenPhase `json:"phase,omitempty"`
+
+	// Preview version the phase applies to.
+	PreviewVersion string `json:"previewVersion,omitempty"`
+
+	// Version serves all traffic of the service.
+	ActiveVersion string `json:"activeVersion,omitempty"`
+
+	// Last time the phase transit from one to another
+	// +optional
+	LastTransitionTime *metav1.Time `json:"lastTransitionTime,omitempty"`
 }
 
 type StrategyConditionType string
@@ -127,6 +424,21 @@ const (
 
 	// StrategyFailed means the strategy has failed its delivery to istio.
 	StrategyFailed StrategyConditionType = "Failed"
+
+	// StrategyConflicted means part of the strategy is overridden by
+	// another strategy with higher priority.
+	StrategyConflicted StrategyConditionType = "Conflicted"
+
+	// StrategyActive tells if a scheduled strategy is in its time window.
+	StrategyActive StrategyConditionType = "Active"
+
+	// StrategyDrifted means the virtualservice is overridden on purpose, and
+	// differs from what the strategy generates.
+	StrategyDrifted StrategyConditionType = "Drifted"
+
+	// StrategyAnalysisUnavailable means canary metrics can't be checked against
+	// analysis thresholds, canary steps go on without analysis.
+	StrategyAnalysisUnavailable StrategyConditionType = "AnalysisUnavailable"
 )
 
 // StrategyCondition describes current state of a strategy.
@@ -5,6 +5,8 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"reflect"
+	"sync/atomic"
 	"time"
 
 	rt "runtime"
@@ -12,14 +14,18 @@ import (
 	"github.com/emicklei/go-restful"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	urlruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/dynamic/dynamicinformer"
 	"k8s.io/klog"
 
 	apiserverconfig "zmc.io/oasis/pkg/apiserver/config"
+	"zmc.io/oasis/pkg/controller/workload"
 	"zmc.io/oasis/pkg/informers"
 	configv1alpha2 "zmc.io/oasis/pkg/kapis/config/v1alpha2"
 	resourcesv1alpha2 "zmc.io/oasis/pkg/kapis/resources/v1alpha2"
 	resourcev1alpha3 "zmc.io/oasis/pkg/kapis/resources/v1alpha3"
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/kapis/servicemesh/v1alpha1"
 	"zmc.io/oasis/pkg/kapis/version"
+	"zmc.io/oasis/pkg/server/healthz"
 	"zmc.io/oasis/pkg/simple/client/k8s"
 	utilnet "zmc.io/oasis/pkg/utils/net"
 )
@@ -46,14 +52,28 @@ type APIServer struct {
 	// mainly for fast query
 	InformerFactory informers.InformerFactory
 
+	// DynamicInformerFactory watches resources without typed clients, i.e. argo rollouts
+	DynamicInformerFactory dynamicinformer.DynamicSharedInformerFactory
+
+	// WorkloadSources are where versions of services are collected, the same as controllers
+	WorkloadSources []workload.Source
+
+	// SubsetGracePeriod is how long subsets are held after their workloads become unready
+	SubsetGracePeriod time.Duration
+
 	// cache is used for short lived objects, like session
 	// CacheClient cache.Interface
+
+	// cacheSynced is set to 1 once informer caches are synced, api requests
+	// are rejected before that
+	cacheSynced int32
 }
 
 func (s *APIServer) PrepareRun(stopCh <-chan struct{}) error {
 
 	s.container = restful.NewContainer()
 	s.container.Filter(logRequestAndResponse)
+	s.container.Filter(s.rejectUntilCacheSynced)
 	s.container.Router(restful.CurlyRouter{})
 	s.container.RecoverHandler(func(panicReason interface{}, httpWriter http.ResponseWriter) {
 		logStackOnRecover(panicReason, httpWriter)
@@ -65,7 +85,11 @@ func (s *APIServer) PrepareRun(stopCh <-chan struct{}) error {
 		klog.V(2).Infof("%s", ws.RootPath())
 	}
 
-	s.Server.Handler = s.container
+	// health probes are served aside from webservices, so they work while caches are syncing
+	mux := http.NewServeMux()
+	s.installHealthProbes(mux)
+	mux.Handle("/", s.container)
+	s.Server.Handler = mux
 
 	// s.buildHandlerChain(stopCh)
 
@@ -82,15 +106,35 @@ func (s *APIServer) installKubernetesAPIs() {
 		s.KubernetesClient.Master()))
 	// urlruntime.Must(terminalv1alpha2.AddToContainer(s.container, s.KubernetesClient.Kubernetes(), s.KubernetesClient.Config()))
 	urlruntime.Must(version.AddToContainer(s.container, s.KubernetesClient.Discovery()))
+	urlruntime.Must(servicemeshv1alpha1.AddToContainer(s.container, s.KubernetesClient.Mesh(), s.InformerFactory,
+		s.WorkloadSources, s.SubsetGracePeriod))
 }
 
-func (s *APIServer) Run(stopCh <-chan struct{}) (err error) {
+// installHealthProbes registers /healthz, /livez and /readyz, the server is ready
+// once caches are synced, and as long as istio resources can be discovered.
+func (s *APIServer) installHealthProbes(mux *http.ServeMux) {
+	probes := healthz.NewProbes()
+	probes.AddReadyzCheck("informer-sync", healthz.InformersSynced(s.hasCacheSynced))
+	probes.AddReadyzCheck("istio-resources", healthz.IstioResourcesReachable(s.KubernetesClient.Discovery()))
+	probes.Install(mux)
+}
 
-	err = s.waitForResourceSync(stopCh)
-	if err != nil {
-		return err
+func (s *APIServer) hasCacheSynced() bool {
+	return atomic.LoadInt32(&s.cacheSynced) == 1
+}
+
+// rejectUntilCacheSynced fails api requests with 503 until caches are synced, as
+// listers return empty results before that.
+func (s *APIServer) rejectUntilCacheSynced(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+	if !s.hasCacheSynced() {
+		resp.Header().Set("Retry-After", "1")
+		_ = resp.WriteErrorString(http.StatusServiceUnavailable, "caches are not synced yet\n")
+		return
 	}
+	chain.ProcessFilter(req, resp)
+}
 
+func (s *APIServer) Run(stopCh <-chan struct{}) (err error) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -99,14 +143,46 @@ func (s *APIServer) Run(stopCh <-chan struct{}) (err error) {
 		_ = s.Server.Shutdown(ctx)
 	}()
 
-	klog.V(0).Infof("Start listening on %s", s.Server.Addr)
-	if s.Server.TLSConfig != nil {
-		err = s.Server.ListenAndServeTLS("", "")
-	} else {
-		err = s.Server.ListenAndServe()
+	// start listening before caches are synced, so health probes are answered
+	errCh := make(chan error, 1)
+	go func() {
+		klog.V(0).Infof("Start listening on %s", s.Server.Addr)
+		if s.Server.TLSConfig != nil {
+			errCh <- s.Server.ListenAndServeTLS("", "")
+		} else {
+			errCh <- s.Server.ListenAndServe()
+		}
+	}()
+
+	// listening may fail while caches are syncing, e.g. address in use
+	syncErrCh := make(chan error, 1)
+	go func() {
+		syncErrCh <- s.waitForResourceSync(stopCh)
+	}()
+
+	select {
+	case err = <-errCh:
+		return err
+	case err = <-syncErrCh:
+		if err != nil {
+			_ = s.Server.Shutdown(ctx)
+			return err
+		}
 	}
+	atomic.StoreInt32(&s.cacheSynced, 1)
 
-	return err
+	return <-errCh
+}
+
+// checkCacheSynced returns an error if any informer is not synced, which happens
+// when it is stopped before that.
+func checkCacheSynced(synced map[reflect.Type]bool) error {
+	for informerType, ok := range synced {
+		if !ok {
+			return fmt.Errorf("cache of %v is not synced", informerType)
+		}
+	}
+	return nil
 }
 
 func (s *APIServer) waitForResourceSync(stopCh <-chan struct{}) error {
@@ -178,7 +254,34 @@ func (s *APIServer) waitForResourceSync(stopCh <-chan struct{}) error {
 	}
 
 	s.InformerFactory.KubernetesSharedInformerFactory().Start(stopCh)
-	s.InformerFactory.KubernetesSharedInformerFactory().WaitForCacheSync(stopCh)
+	if err = checkCacheSynced(s.InformerFactory.KubernetesSharedInformerFactory().WaitForCacheSync(stopCh)); err != nil {
+		return err
+	}
+
+	// servicemesh and istio resources, used to preview generated objects
+	meshInformerFactory := s.InformerFactory.MeshSharedInformerFactory()
+	meshInformerFactory.Servicemesh().V1alpha1().Strategies().Informer()
+	meshInformerFactory.Servicemesh().V1alpha1().ServicePolicies().Informer()
+	meshInformerFactory.Start(stopCh)
+	if err = checkCacheSynced(meshInformerFactory.WaitForCacheSync(stopCh)); err != nil {
+		return err
+	}
+
+	istioInformerFactory := s.InformerFactory.IstioSharedInformerFactory()
+	istioInformerFactory.Networking().V1beta1().VirtualServices().Informer()
+	istioInformerFactory.Networking().V1beta1().DestinationRules().Informer()
+	istioInformerFactory.Start(stopCh)
+	if err = checkCacheSynced(istioInformerFactory.WaitForCacheSync(stopCh)); err != nil {
+		return err
+	}
+
+	// workload sources register informers of rollouts if installed
+	s.DynamicInformerFactory.Start(stopCh)
+	for gvr, ok := range s.DynamicInformerFactory.WaitForCacheSync(stopCh) {
+		if !ok {
+			return fmt.Errorf("cache of %s is not synced", gvr)
+		}
+	}
 
 	// apiextensionsInformerFactory := s.InformerFactory.ApiExtensionSharedInformerFactory()
 	// apiextensionsGVRs := []schema.GroupVersionResource{
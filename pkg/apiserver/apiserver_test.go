@@ -0,0 +1,65 @@
+package apiserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/emicklei/go-restful"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestRejectUntilCacheSynced(t *testing.T) {
+	tests := []struct {
+		name   string
+		synced int32
+		want   int
+	}{
+		{name: "syncing", synced: 0, want: http.StatusServiceUnavailable},
+		{name: "synced", synced: 1, want: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &APIServer{cacheSynced: test.synced}
+
+			container := restful.NewContainer()
+			container.Filter(s.rejectUntilCacheSynced)
+			ws := new(restful.WebService)
+			ws.Route(ws.GET("/test").To(func(_ *restful.Request, resp *restful.Response) {
+				resp.WriteHeader(http.StatusOK)
+			}))
+			container.Add(ws)
+
+			recorder := httptest.NewRecorder()
+			container.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/test", nil))
+			if recorder.Code != test.want {
+				t.Errorf("status %d, want %d", recorder.Code, test.want)
+			}
+			if test.want == http.StatusServiceUnavailable && len(recorder.Header().Get("Retry-After")) == 0 {
+				t.Errorf("Retry-After header is missing")
+			}
+		})
+	}
+}
+
+func TestCheckCacheSynced(t *testing.T) {
+	tests := []struct {
+		name    string
+		synced  map[reflect.Type]bool
+		wantErr bool
+	}{
+		{name: "no informer"},
+		{name: "all synced", synced: map[reflect.Type]bool{reflect.TypeOf(&v1.Pod{}): true, reflect.TypeOf(&v1.Service{}): true}},
+		{name: "stopped before synced", synced: map[reflect.Type]bool{reflect.TypeOf(&v1.Pod{}): true, reflect.TypeOf(&v1.Service{}): false}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := checkCacheSynced(test.synced); (err != nil) != test.wantErr {
+				t.Errorf("error %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"zmc.io/oasis/pkg/simple/client/servicemesh"
+)
+
+func TestUnmarshalServiceMeshOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want *servicemesh.Options
+	}{
+		{
+			name: "keys omitted",
+			yaml: `
+servicemesh:
+  IstioPilotHost: "127.0.0.1"
+`,
+			want: &servicemesh.Options{IstioPilotHost: "127.0.0.1"},
+		},
+		{
+			name: "keys configured",
+			yaml: `
+servicemesh:
+  IstioPilotHost: "127.0.0.1"
+  AppLabel: "app.kubernetes.io/name"
+  VersionLabel: "app.kubernetes.io/version"
+  ApplicationNameLabel: "app.kubernetes.io/part-of"
+  ApplicationVersionLabel: "app.kubernetes.io/release"
+  ServiceMeshEnabledAnnotation: "sidecar.istio.io/inject"
+`,
+			want: &servicemesh.Options{
+				IstioPilotHost:               "127.0.0.1",
+				AppLabel:                     "app.kubernetes.io/name",
+				VersionLabel:                 "app.kubernetes.io/version",
+				ApplicationNameLabel:         "app.kubernetes.io/part-of",
+				ApplicationVersionLabel:      "app.kubernetes.io/release",
+				ServiceMeshEnabledAnnotation: "sidecar.istio.io/inject",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v := viper.New()
+			v.SetConfigType("yaml")
+			if err := v.ReadConfig(strings.NewReader(test.yaml)); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			conf := New()
+			if err := v.Unmarshal(conf); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if !reflect.DeepEqual(conf.ServiceMeshOptions, test.want) {
+				t.Errorf("servicemesh options %+v, want %+v", conf.ServiceMeshOptions, test.want)
+			}
+		})
+	}
+}
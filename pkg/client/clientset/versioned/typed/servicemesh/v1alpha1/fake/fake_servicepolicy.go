@@ -36,9 +36,9 @@ type FakeServicePolicies struct {
 	ns   string
 }
 
-var servicepoliciesResource = schema.GroupVersionResource{Group: "servicemesh.zmc.io", Version: "v1alpha1", Resource: "servicepolicies"}
+var servicepoliciesResource = schema.GroupVersionResource{Group: "servicemesh.linkedcare.io", Version: "v1alpha1", Resource: "servicepolicies"}
 
-var servicepoliciesKind = schema.GroupVersionKind{Group: "servicemesh.zmc.io", Version: "v1alpha1", Kind: "ServicePolicy"}
+var servicepoliciesKind = schema.GroupVersionKind{Group: "servicemesh.linkedcare.io", Version: "v1alpha1", Kind: "ServicePolicy"}
 
 // Get takes name of the servicePolicy, and returns the corresponding servicePolicy object, and an error if there is any.
 func (c *FakeServicePolicies) Get(ctx context.Context, name string, options v1.GetOptions) (result *v1alpha1.ServicePolicy, err error) {
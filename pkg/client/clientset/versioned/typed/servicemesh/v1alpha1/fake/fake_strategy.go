@@ -36,9 +36,9 @@ type FakeStrategies struct {
 	ns   string
 }
 
-var strategiesResource = schema.GroupVersionResource{Group: "servicemesh.zmc.io", Version: "v1alpha1", Resource: "strategies"}
+var strategiesResource = schema.GroupVersionResource{Group: "servicemesh.linkedcare.io", Version: "v1alpha1", Resource: "strategies"}
 
-var strategiesKind = schema.GroupVersionKind{Group: "servicemesh.zmc.io", Version: "v1alpha1", Kind: "Strategy"}
+var strategiesKind = schema.GroupVersionKind{Group: "servicemesh.linkedcare.io", Version: "v1alpha1", Kind: "Strategy"}
 
 // Get takes name of the strategy, and returns the corresponding strategy object, and an error if there is any.
 func (c *FakeStrategies) Get(ctx context.Context, name string, options v1.GetOptions) (result *v1alpha1.Strategy, err error) {
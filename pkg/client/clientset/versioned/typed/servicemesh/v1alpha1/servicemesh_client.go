@@ -30,7 +30,7 @@ type ServicemeshV1alpha1Interface interface {
 	StrategiesGetter
 }
 
-// ServicemeshV1alpha1Client is used to interact with features provided by the servicemesh.zmc.io group.
+// ServicemeshV1alpha1Client is used to interact with features provided by the servicemesh.linkedcare.io group.
 type ServicemeshV1alpha1Client struct {
 	restClient rest.Interface
 }
@@ -52,7 +52,7 @@ func (f *genericInformer) Lister() cache.GenericLister {
 // TODO extend this to unknown resources with a client pool
 func (f *sharedInformerFactory) ForResource(resource schema.GroupVersionResource) (GenericInformer, error) {
 	switch resource {
-	// Group=servicemesh.zmc.io, Version=v1alpha1
+	// Group=servicemesh.linkedcare.io, Version=v1alpha1
 	case v1alpha1.SchemeGroupVersion.WithResource("servicepolicies"):
 		return &genericInformer{resource: resource.GroupResource(), informer: f.Servicemesh().V1alpha1().ServicePolicies().Informer()}, nil
 	case v1alpha1.SchemeGroupVersion.WithResource("strategies"):
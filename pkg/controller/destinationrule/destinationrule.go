@@ -4,9 +4,9 @@ import (
 	"context"
 	"fmt"
 	"reflect"
+	"strings"
 	"time"
 
-	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -25,18 +25,20 @@ import (
 	istioclient "istio.io/client-go/pkg/clientset/versioned"
 	clientset "k8s.io/client-go/kubernetes"
 	servicemeshclient "zmc.io/oasis/pkg/client/clientset/versioned"
+	servicemeshscheme "zmc.io/oasis/pkg/client/clientset/versioned/scheme"
 
 	istiolisters "istio.io/client-go/pkg/listers/networking/v1beta1"
-	listersv1 "k8s.io/client-go/listers/apps/v1"
 	corelisters "k8s.io/client-go/listers/core/v1"
 	servicemeshlisters "zmc.io/oasis/pkg/client/listers/servicemesh/v1alpha1"
 
 	istioinformers "istio.io/client-go/pkg/informers/externalversions/networking/v1beta1"
-	informersv1 "k8s.io/client-go/informers/apps/v1"
 	coreinformers "k8s.io/client-go/informers/core/v1"
 	servicemeshinformers "zmc.io/oasis/pkg/client/informers/externalversions/servicemesh/v1alpha1"
 
+	"zmc.io/oasis/pkg/controller/metrics"
+	"zmc.io/oasis/pkg/controller/scope"
 	"zmc.io/oasis/pkg/controller/virtualservice/util"
+	"zmc.io/oasis/pkg/controller/workload"
 
 	networkingv1beta1 "istio.io/client-go/pkg/apis/networking/v1beta1"
 	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
@@ -51,6 +53,9 @@ const (
 	//
 	// 5ms, 10ms, 20ms, 40ms, 80ms, 160ms, 320ms, 640ms, 1.3s, 2.6s, 5.1s, 10.2s, 20.4s, 41s, 82s
 	maxRetries = 15
+
+	// controllerName names the workqueue and labels metrics of the controller
+	controllerName = "destinationrule"
 )
 
 // DestinationRuleController Contact ServicePolicy
@@ -66,27 +71,42 @@ type DestinationRuleController struct {
 	serviceLister corelisters.ServiceLister
 	serviceSynced cache.InformerSynced
 
-	deploymentLister listersv1.DeploymentLister
-	deploymentSynced cache.InformerSynced
+	// workloads running versions of services
+	workloadSources []workload.Source
+	workloadSynced  []cache.InformerSynced
 
 	servicePolicyLister servicemeshlisters.ServicePolicyLister
 	servicePolicySynced cache.InformerSynced
 
+	strategyLister servicemeshlisters.StrategyLister
+	strategySynced cache.InformerSynced
+
+	// how long subsets are kept after their workloads become unready
+	subsetGracePeriod time.Duration
+
 	destinationRuleLister istiolisters.DestinationRuleLister
 	destinationRuleSynced cache.InformerSynced
+	// namespaces reconciled by the controller
+	scope *scope.Scope
 	// 工作队列
 	queue workqueue.RateLimitingInterface
 	// 工作循环周期
 	workerLoopPeriod time.Duration
 }
 
-func NewDestinationRuleController(deploymentInformer informersv1.DeploymentInformer,
+func NewDestinationRuleController(workloadSources []workload.Source,
 	destinationRuleInformer istioinformers.DestinationRuleInformer,
 	serviceInformer coreinformers.ServiceInformer,
 	servicePolicyInformer servicemeshinformers.ServicePolicyInformer,
+	strategyInformer servicemeshinformers.StrategyInformer,
 	client clientset.Interface,
 	destinationRuleClient istioclient.Interface,
-	servicemeshClient servicemeshclient.Interface) *DestinationRuleController {
+	servicemeshClient servicemeshclient.Interface,
+	scope *scope.Scope,
+	subsetGracePeriod time.Duration) *DestinationRuleController {
+
+	// events are also recorded on servicepolicies
+	utilruntime.Must(servicemeshscheme.AddToScheme(scheme.Scheme))
 
 	broadcaster := record.NewBroadcaster()
 	broadcaster.StartLogging(func(format string, args ...interface{}) {
@@ -99,20 +119,29 @@ func NewDestinationRuleController(deploymentInformer informersv1.DeploymentInfor
 		client:                client,
 		destinationRuleClient: destinationRuleClient,
 		servicemeshClient:     servicemeshClient,
-		queue:                 workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "destinationrule"),
+		scope:                 scope,
+		subsetGracePeriod:     subsetGracePeriod,
+		queue:                 workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), controllerName),
 		workerLoopPeriod:      time.Second,
 	}
 
-	v.deploymentLister = deploymentInformer.Lister()
-	v.deploymentSynced = deploymentInformer.Informer().HasSynced
+	v.workloadSources = workloadSources
+	for _, source := range workloadSources {
+		source := source
+		v.workloadSynced = append(v.workloadSynced, source.Informer().HasSynced)
 
-	deploymentInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc:    v.addDeployment,
-		DeleteFunc: v.deleteDeployment,
-		UpdateFunc: func(old, cur interface{}) {
-			v.addDeployment(cur)
-		},
-	})
+		source.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				v.addWorkload(source, obj)
+			},
+			DeleteFunc: func(obj interface{}) {
+				v.deleteWorkload(source, obj)
+			},
+			UpdateFunc: func(old, cur interface{}) {
+				v.addWorkload(source, cur)
+			},
+		})
+	}
 
 	v.serviceLister = serviceInformer.Lister()
 	v.serviceSynced = serviceInformer.Informer().HasSynced
@@ -120,14 +149,15 @@ func NewDestinationRuleController(deploymentInformer informersv1.DeploymentInfor
 	serviceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc:    v.enqueueService,
 		DeleteFunc: v.enqueueService,
-		UpdateFunc: func(old, cur interface{}) {
-			v.enqueueService(cur)
-		},
+		UpdateFunc: v.updateService,
 	})
 
 	v.destinationRuleLister = destinationRuleInformer.Lister()
 	v.destinationRuleSynced = destinationRuleInformer.Informer().HasSynced
 
+	// managed destinationrules changed or deleted by others are reverted
+	destinationRuleInformer.Informer().AddEventHandler(util.ControllerServiceHandler(v.queue))
+
 	v.servicePolicyLister = servicePolicyInformer.Lister()
 	v.servicePolicySynced = servicePolicyInformer.Informer().HasSynced
 
@@ -139,6 +169,22 @@ func NewDestinationRuleController(deploymentInformer informersv1.DeploymentInfor
 		DeleteFunc: v.addServicePolicy,
 	})
 
+	v.strategyLister = strategyInformer.Lister()
+	v.strategySynced = strategyInformer.Informer().HasSynced
+
+	strategyInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: v.addStrategy,
+		UpdateFunc: func(old, cur interface{}) {
+			// status updates don't change subsets strategy routes to
+			if old.(*servicemeshv1alpha1.Strategy).Generation != cur.(*servicemeshv1alpha1.Strategy).Generation {
+				v.addStrategy(cur)
+			}
+		},
+		DeleteFunc: v.addStrategy,
+	})
+
+	scope.AddNamespaceHandler(v.enqueueNamespace)
+
 	v.eventBroadcaster = broadcaster
 	v.eventRecorder = recorder
 
@@ -149,6 +195,21 @@ func (v *DestinationRuleController) Start(stopCh <-chan struct{}) error {
 	return v.Run(5, stopCh)
 }
 
+// cacheSynced returns sync states of all informers the controller watches
+func (v *DestinationRuleController) cacheSynced() []cache.InformerSynced {
+	return append([]cache.InformerSynced{v.serviceSynced, v.destinationRuleSynced, v.servicePolicySynced, v.strategySynced, v.scope.HasSynced}, v.workloadSynced...)
+}
+
+// HasSynced tells if caches of all informers the controller watches are synced
+func (v *DestinationRuleController) HasSynced() bool {
+	for _, synced := range v.cacheSynced() {
+		if !synced() {
+			return false
+		}
+	}
+	return true
+}
+
 func (v *DestinationRuleController) Run(workers int, stopCh <-chan struct{}) error {
 	defer utilruntime.HandleCrash()
 	defer v.queue.ShutDown()
@@ -156,7 +217,7 @@ func (v *DestinationRuleController) Run(workers int, stopCh <-chan struct{}) err
 	log.Info("starting destinationrule controller")
 	defer log.Info("shutting down destinationrule controller")
 
-	if !cache.WaitForCacheSync(stopCh, v.serviceSynced, v.destinationRuleSynced, v.deploymentSynced, v.servicePolicySynced) {
+	if !cache.WaitForCacheSync(stopCh, v.cacheSynced()...) {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
 
@@ -182,7 +243,9 @@ func (v *DestinationRuleController) processNextWorkItem() bool {
 
 	defer v.queue.Done(eKey)
 
+	startTime := time.Now()
 	err := v.syncService(eKey.(string))
+	metrics.ObserveReconcile(controllerName, startTime, err)
 	v.handleErr(err, eKey)
 
 	return true
@@ -201,12 +264,15 @@ func (v *DestinationRuleController) syncService(key string) error {
 		return err
 	}
 
+	// namespaces out of scope are left to other controller-managers
+	if !v.scope.Contains(namespace) {
+		return nil
+	}
+
 	service, err := v.serviceLister.Services(namespace).Get(name)
 	if err != nil {
 		// delete the corresponding destinationrule if there is any, as the service has been deleted.
-		err = v.destinationRuleClient.NetworkingV1beta1().DestinationRules(namespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
-		if err != nil && !errors.IsNotFound(err) {
-			log.Errorf("delete destination rule failed %s/%s, error %v.", namespace, name, err)
+		if err = v.deleteOwnedDestinationRule(namespace, name); err != nil {
 			return err
 		}
 
@@ -228,45 +294,18 @@ func (v *DestinationRuleController) syncService(key string) error {
 		// or they don't have necessary labels
 		// or they don't have servicemesh enabled
 		// or they don't have any ports defined
-		return nil
+		// destinationrule generated before is torn down, i.e. service relabeled out of servicemesh
+		return v.deleteOwnedDestinationRule(namespace, name)
 	}
 
 	appName := util.GetComponentName(&service.ObjectMeta)
 
-	// fetch all deployments that match with service selector
-	deployments, err := v.deploymentLister.Deployments(namespace).List(labels.Set(service.Spec.Selector).AsSelectorPreValidated())
+	// fetch all workloads that match with service selector
+	workloads, err := workload.ListAll(v.workloadSources, namespace, labels.Set(service.Spec.Selector).AsSelectorPreValidated())
 	if err != nil {
 		return err
 	}
 
-	subsets := make([]*networkingv1beta1api.Subset, 0)
-	for _, deployment := range deployments {
-
-		// not a valid deployment we required
-		if !util.IsApplicationComponent(deployment.Labels) ||
-			!util.IsApplicationComponent(deployment.Spec.Selector.MatchLabels) ||
-			deployment.Status.ReadyReplicas == 0 ||
-			!util.IsServicemeshEnabled(deployment.Annotations) {
-			continue
-		}
-
-		version := util.GetComponentVersion(&deployment.ObjectMeta)
-
-		if len(version) == 0 {
-			log.V(4).Infof("Deployment %s doesn't have a version label", types.NamespacedName{Namespace: deployment.Namespace, Name: deployment.Name}.String())
-			continue
-		}
-
-		subset := &networkingv1beta1api.Subset{
-			Name: util.NormalizeVersionName(version),
-			Labels: map[string]string{
-				util.VersionLabel: version,
-			},
-		}
-
-		subsets = append(subsets, subset)
-	}
-
 	currentDestinationRule, err := v.destinationRuleLister.DestinationRules(namespace).Get(name)
 	if err != nil {
 		if errors.IsNotFound(err) {
@@ -275,9 +314,6 @@ func (v *DestinationRuleController) syncService(key string) error {
 					Name:   service.Name,
 					Labels: service.Labels,
 				},
-				Spec: networkingv1beta1api.DestinationRule{
-					Host: name,
-				},
 			}
 		} else {
 			log.Error(err, "Couldn't get destinationrule for service", "key", key)
@@ -292,45 +328,68 @@ func (v *DestinationRuleController) syncService(key string) error {
 		return err
 	}
 
+	// servicepolicies are merged in order of priority
+	SortServicePolicies(servicePolicies)
+	spec, conflicts := GenerateDestinationRuleSpec(service, workloads, servicePolicies)
+
+	// subsets whose workloads are no longer ready are held as long as strategies route to
+	// them, or for a grace period, so virtualservices don't route to missing subsets
+	strategies, err := v.strategyLister.Strategies(namespace).List(labels.SelectorFromSet(map[string]string{util.AppLabel: appName}))
+	if err != nil {
+		log.Error(err, "could not list strategies in namespace with component name", "namespace", namespace, "name", appName)
+		return err
+	}
+
+	heldSubsets, unreadySubsets, expireAfter := RetainSubsets(spec, currentDestinationRule, strategies, v.subsetGracePeriod, time.Now())
+	if expireAfter > 0 {
+		v.queue.AddAfter(key, expireAfter)
+	}
+
 	dr := currentDestinationRule.DeepCopy()
-	dr.Spec.TrafficPolicy = nil
-	dr.Spec.Subsets = subsets
-	//
-	if len(servicePolicies) > 0 {
-		if len(servicePolicies) > 1 {
-			err = fmt.Errorf("more than one service policy associated with service %s/%s is forbidden", namespace, name)
-			log.Error(err, "")
-			return err
-		}
+	dr.Spec.Host = spec.Host
+	dr.Spec.TrafficPolicy = spec.TrafficPolicy
+	dr.Spec.Subsets = spec.Subsets
 
-		sp := servicePolicies[0]
-		if sp.Spec.Template.Spec.TrafficPolicy != nil {
-			dr.Spec.TrafficPolicy = sp.Spec.Template.Spec.TrafficPolicy
-		}
+	createDestinationRule := len(currentDestinationRule.ResourceVersion) == 0
 
-		for _, subset := range sp.Spec.Template.Spec.Subsets {
-			for i := range dr.Spec.Subsets {
-				if subset.Name == dr.Spec.Subsets[i].Name && subset.TrafficPolicy != nil {
-					dr.Spec.Subsets[i].TrafficPolicy = subset.TrafficPolicy
-				}
-			}
+	// never overwrite a destinationrule created by others
+	if !createDestinationRule && !util.CanManage(currentDestinationRule, service) {
+		err = fmt.Errorf("destinationrule %s/%s is not managed by oasis", namespace, name)
+		v.eventRecorder.Event(service, v1.EventTypeWarning, "DestinationRuleNotManaged", err.Error())
+		for _, sp := range servicePolicies {
+			_ = v.servicePolicyFailed(sp, ReasonNotManaged, err)
 		}
+		return nil
 	}
 
-	createDestinationRule := len(currentDestinationRule.ResourceVersion) == 0
+	managedLabels := util.ManagedLabels(service)
 
 	if !createDestinationRule && reflect.DeepEqual(currentDestinationRule.Spec, dr.Spec) &&
-		reflect.DeepEqual(currentDestinationRule.Labels, service.Labels) {
+		reflect.DeepEqual(currentDestinationRule.Labels, managedLabels) &&
+		currentDestinationRule.Annotations[util.UnreadySubsetsAnnotation] == unreadySubsets &&
+		metav1.IsControlledBy(currentDestinationRule, service) {
 		log.V(5).Info("destinationrule are equal, skipping update", "key", types.NamespacedName{Namespace: service.Namespace, Name: service.Name}.String())
-		return nil
+		return v.servicePoliciesDelivered(servicePolicies, conflicts, "", heldSubsets)
+	}
+
+	// changes made on purpose are kept, servicepolicies report the drift
+	if !createDestinationRule && util.IsOverridden(currentDestinationRule) {
+		drift := fmt.Sprintf("destinationrule %s/%s is overridden, generated subsets and policies are not applied", namespace, name)
+		return v.servicePoliciesDelivered(servicePolicies, conflicts, drift, heldSubsets)
 	}
 
 	newDestinationRule := currentDestinationRule.DeepCopy()
 	newDestinationRule.Spec = dr.Spec
-	newDestinationRule.Labels = service.Labels
+	newDestinationRule.Labels = managedLabels
+	util.SetServiceControllerRef(newDestinationRule, service)
 	if newDestinationRule.Annotations == nil {
 		newDestinationRule.Annotations = make(map[string]string)
 	}
+	if len(unreadySubsets) > 0 {
+		newDestinationRule.Annotations[util.UnreadySubsetsAnnotation] = unreadySubsets
+	} else {
+		delete(newDestinationRule.Annotations, util.UnreadySubsetsAnnotation)
+	}
 
 	if createDestinationRule {
 		_, err = v.destinationRuleClient.NetworkingV1beta1().DestinationRules(namespace).Create(context.TODO(), newDestinationRule, metav1.CreateOptions{})
@@ -353,14 +412,73 @@ func (v *DestinationRuleController) syncService(key string) error {
 			v.eventRecorder.Event(newDestinationRule, v1.EventTypeWarning, "FailedToUpdateDestinationRule", fmt.Sprintf("Failed to update destinationrule for service %v/%v: %v", service.Namespace, service.Name, err))
 		}
 
-		return err
+		for _, sp := range servicePolicies {
+			_ = v.servicePolicyFailed(sp, ReasonFailedToDeliver, err)
+		}
+		return metrics.WithReason(ReasonFailedToDeliver, err)
 	}
 
-	return nil
+	if names := newlyHeldSubsets(heldSubsets, currentDestinationRule); len(names) > 0 {
+		v.eventRecorder.Event(service, v1.EventTypeNormal, ReasonSubsetsHeld,
+			fmt.Sprintf("subsets %s of destinationrule %s/%s are held, their workloads are not ready", strings.Join(names, ", "), namespace, name))
+	}
+
+	return v.servicePoliciesDelivered(servicePolicies, conflicts, "", heldSubsets)
+}
+
+// GenerateDestinationRuleSpec generates spec of destinationrule for service, with a subset
+// for every version of ready workloads, and servicepolicies merged in order of priority.
+// Conflicts of every servicepolicy are returned in the same order as servicepolicies.
+func GenerateDestinationRuleSpec(service *v1.Service, workloads []*workload.Workload,
+	servicePolicies []*servicemeshv1alpha1.ServicePolicy) (*networkingv1beta1api.DestinationRule, []string) {
+	subsets := make([]*networkingv1beta1api.Subset, 0)
+	names := sets.String{}
+	for _, w := range workloads {
+
+		// not a valid workload we required
+		if !util.IsApplicationComponent(w.Labels) ||
+			!util.IsApplicationComponent(w.Selector) ||
+			w.ReadyReplicas == 0 ||
+			!util.IsServicemeshEnabled(w.Annotations) {
+			continue
+		}
+
+		version := util.GetComponentVersion(&metav1.ObjectMeta{Labels: w.Labels})
+
+		if len(version) == 0 {
+			log.V(4).Infof("%s %s doesn't have a version label", w.Kind, types.NamespacedName{Namespace: w.Namespace, Name: w.Name}.String())
+			continue
+		}
+
+		// workloads of different kinds may run the same version
+		if names.Has(util.NormalizeVersionName(version)) {
+			continue
+		}
+		names.Insert(util.NormalizeVersionName(version))
+
+		subset := &networkingv1beta1api.Subset{
+			Name: util.NormalizeVersionName(version),
+			Labels: map[string]string{
+				util.VersionLabel: version,
+			},
+		}
+
+		subsets = append(subsets, subset)
+	}
+
+	spec := &networkingv1beta1api.DestinationRule{
+		Host:    util.ServiceFQDN(service),
+		Subsets: subsets,
+	}
+
+	conflicts := mergeServicePolicies(spec, servicePolicies)
+
+	return spec, conflicts
 }
 
 func (v *DestinationRuleController) enqueueService(obj interface{}) {
-	key, err := cache.MetaNamespaceKeyFunc(obj)
+	// deleted services may come as tombstones
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
 	if err != nil {
 		utilruntime.HandleError(fmt.Errorf("couldn't get key for object %+v: %v", obj, err))
 		return
@@ -369,6 +487,74 @@ func (v *DestinationRuleController) enqueueService(obj interface{}) {
 	v.queue.Add(key)
 }
 
+// enqueueNamespace enqueues all services of namespace, i.e. namespace moved into scope
+func (v *DestinationRuleController) enqueueNamespace(namespace string) {
+	services, err := v.serviceLister.Services(namespace).List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("couldn't list services of namespace %s: %v", namespace, err))
+		return
+	}
+
+	for _, service := range services {
+		v.enqueueService(service)
+	}
+}
+
+// deleteOwnedDestinationRule deletes destinationrule generated for service of name
+func (v *DestinationRuleController) deleteOwnedDestinationRule(namespace, name string) error {
+	destinationRule, err := v.destinationRuleLister.DestinationRules(namespace).Get(name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	if !util.IsManaged(destinationRule) || !util.IsControlledByService(destinationRule, name) {
+		return nil
+	}
+
+	err = v.destinationRuleClient.NetworkingV1beta1().DestinationRules(namespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		log.Errorf("delete destination rule failed %s/%s, error %v.", namespace, name, err)
+		return err
+	}
+
+	return nil
+}
+
+// updateService enqueues service, and services sharing its previous application
+// identity if labels are changed, so servicepolicies of both identities are delivered again.
+func (v *DestinationRuleController) updateService(old, cur interface{}) {
+	oldService := old.(*v1.Service)
+	curService := cur.(*v1.Service)
+
+	v.enqueueService(cur)
+
+	if !util.IsApplicationChanged(&oldService.ObjectMeta, &curService.ObjectMeta) {
+		return
+	}
+
+	log.V(2).Infof("service %s/%s application changed from %v to %v", curService.Namespace, curService.Name, oldService.Labels, curService.Labels)
+
+	lbs := util.ExtractApplicationLabels(&oldService.ObjectMeta)
+	if len(lbs) == 0 {
+		return
+	}
+
+	services, err := v.serviceLister.Services(oldService.Namespace).List(labels.SelectorFromSet(lbs))
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+
+	for _, service := range services {
+		if service.Name != curService.Name {
+			v.enqueueService(service)
+		}
+	}
+}
+
 func (v *DestinationRuleController) handleErr(err error, key interface{}) {
 	if err == nil {
 		v.queue.Forget(key)
@@ -386,33 +572,34 @@ func (v *DestinationRuleController) handleErr(err error, key interface{}) {
 	utilruntime.HandleError(err)
 }
 
-// When a destinationrule is added, figure out which service it will be used
-// and enqueue it. obj must have *appsv1.Deployment type
-func (v *DestinationRuleController) addDeployment(obj interface{}) {
-	deploy := obj.(*appsv1.Deployment)
+// addWorkload figures out which services workload of source serves, and enqueues them
+func (v *DestinationRuleController) addWorkload(source workload.Source, obj interface{}) {
+	w, ok := source.Workload(obj)
+	if !ok {
+		utilruntime.HandleError(fmt.Errorf("object is not a %s %#v", source.Kind(), obj))
+		return
+	}
 
 	// not a application component
-	if !util.IsApplicationComponent(deploy.Labels) || !util.IsApplicationComponent(deploy.Spec.Selector.MatchLabels) {
+	if !util.IsApplicationComponent(w.Labels) || !util.IsApplicationComponent(w.Selector) {
 		return
 	}
 
-	services, err := v.getDeploymentServiceMemberShip(deploy)
+	services, err := v.getWorkloadServiceMemberShip(w)
 	if err != nil {
-		utilruntime.HandleError(fmt.Errorf("unable to get deployment %s/%s's service memberships", deploy.Namespace, deploy.Name))
+		utilruntime.HandleError(fmt.Errorf("unable to get %s %s/%s's service memberships", w.Kind, w.Namespace, w.Name))
 		return
 	}
 
 	for key := range services {
 		v.queue.Add(key)
 	}
-
-	return
 }
 
-func (v *DestinationRuleController) getDeploymentServiceMemberShip(deployment *appsv1.Deployment) (sets.String, error) {
+func (v *DestinationRuleController) getWorkloadServiceMemberShip(w *workload.Workload) (sets.String, error) {
 	set := sets.String{}
 
-	allServices, err := v.serviceLister.Services(deployment.Namespace).List(labels.Everything())
+	allServices, err := v.serviceLister.Services(w.Namespace).List(labels.Everything())
 	if err != nil {
 		return set, err
 	}
@@ -426,7 +613,7 @@ func (v *DestinationRuleController) getDeploymentServiceMemberShip(deployment *a
 			continue
 		}
 		selector := labels.Set(service.Spec.Selector).AsSelectorPreValidated()
-		if selector.Matches(labels.Set(deployment.Spec.Selector.MatchLabels)) {
+		if selector.Matches(labels.Set(w.Selector)) {
 			key, err := cache.MetaNamespaceKeyFunc(service)
 			if err != nil {
 				return nil, err
@@ -438,9 +625,9 @@ func (v *DestinationRuleController) getDeploymentServiceMemberShip(deployment *a
 	return set, nil
 }
 
-func (v *DestinationRuleController) deleteDeployment(obj interface{}) {
-	if _, ok := obj.(*appsv1.Deployment); ok {
-		v.addDeployment(obj)
+func (v *DestinationRuleController) deleteWorkload(source workload.Source, obj interface{}) {
+	if _, ok := source.Workload(obj); ok {
+		v.addWorkload(source, obj)
 		return
 	}
 
@@ -450,24 +637,53 @@ func (v *DestinationRuleController) deleteDeployment(obj interface{}) {
 		return
 	}
 
-	deploy, ok := tombstone.Obj.(*appsv1.Deployment)
+	v.addWorkload(source, tombstone.Obj)
+}
+
+func (v *DestinationRuleController) addServicePolicy(obj interface{}) {
+	servicePolicy, ok := obj.(*servicemeshv1alpha1.ServicePolicy)
 	if !ok {
-		utilruntime.HandleError(fmt.Errorf("tombstone contained object that is not a deployment %#v", obj))
-		return
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("couldn't get object from tombstone %#v", obj))
+			return
+		}
+		servicePolicy, ok = tombstone.Obj.(*servicemeshv1alpha1.ServicePolicy)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("tombstone contained object that is not a servicepolicy %#v", obj))
+			return
+		}
 	}
 
-	v.addDeployment(deploy)
+	v.enqueueComponent(servicePolicy.Namespace, servicePolicy.Labels[util.AppLabel])
 }
 
-func (v *DestinationRuleController) addServicePolicy(obj interface{}) {
-	servicePolicy := obj.(*servicemeshv1alpha1.ServicePolicy)
+// addStrategy enqueues services strategy is applied to, subsets held by strategy
+// are released once it no longer routes to them
+func (v *DestinationRuleController) addStrategy(obj interface{}) {
+	strategy, ok := obj.(*servicemeshv1alpha1.Strategy)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("couldn't get object from tombstone %#v", obj))
+			return
+		}
+		strategy, ok = tombstone.Obj.(*servicemeshv1alpha1.Strategy)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("tombstone contained object that is not a strategy %#v", obj))
+			return
+		}
+	}
 
-	appName := servicePolicy.Labels[util.AppLabel]
+	v.enqueueComponent(strategy.Namespace, strategy.Labels[util.AppLabel])
+}
 
-	services, err := v.serviceLister.Services(servicePolicy.Namespace).List(labels.SelectorFromSet(map[string]string{util.AppLabel: appName}))
+// enqueueComponent enqueues services of application component appName
+func (v *DestinationRuleController) enqueueComponent(namespace, appName string) {
+	services, err := v.serviceLister.Services(namespace).List(labels.SelectorFromSet(map[string]string{util.AppLabel: appName}))
 	if err != nil {
-		log.Error(err, "cannot list services", "namespace", servicePolicy.Namespace, "name", appName)
-		utilruntime.HandleError(fmt.Errorf("cannot list services in namespace %s, with component name %v", servicePolicy.Namespace, appName))
+		log.Error(err, "cannot list services", "namespace", namespace, "name", appName)
+		utilruntime.HandleError(fmt.Errorf("cannot list services in namespace %s, with component name %v", namespace, appName))
 		return
 	}
 
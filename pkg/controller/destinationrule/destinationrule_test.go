@@ -0,0 +1,365 @@
+package destinationrule
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	istiofake "istio.io/client-go/pkg/clientset/versioned/fake"
+	istioinformers "istio.io/client-go/pkg/informers/externalversions"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	k8sinformers "k8s.io/client-go/informers"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+
+	networkingv1beta1api "istio.io/api/networking/v1beta1"
+	networkingv1beta1 "istio.io/client-go/pkg/apis/networking/v1beta1"
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+	servicemeshfake "zmc.io/oasis/pkg/client/clientset/versioned/fake"
+	servicemeshinformers "zmc.io/oasis/pkg/client/informers/externalversions"
+	"zmc.io/oasis/pkg/controller/virtualservice/util"
+	"zmc.io/oasis/pkg/controller/workload"
+)
+
+const (
+	testNamespace = "default"
+	testApp       = "reviews"
+)
+
+// fixture is a controller reading objects from informer caches filled by tests,
+// and writing them through fake clients
+type fixture struct {
+	t *testing.T
+
+	controller *DestinationRuleController
+	recorder   *record.FakeRecorder
+
+	k8sClient         *k8sfake.Clientset
+	istioClient       *istiofake.Clientset
+	servicemeshClient *servicemeshfake.Clientset
+
+	k8sInformers         k8sinformers.SharedInformerFactory
+	istioInformers       istioinformers.SharedInformerFactory
+	servicemeshInformers servicemeshinformers.SharedInformerFactory
+}
+
+func newFixture(t *testing.T, objects ...runtime.Object) *fixture {
+	f := &fixture{t: t}
+
+	var k8sObjects, istioObjects, servicemeshObjects []runtime.Object
+	for _, obj := range objects {
+		switch obj.(type) {
+		case *networkingv1beta1.DestinationRule:
+			istioObjects = append(istioObjects, obj)
+		case *servicemeshv1alpha1.ServicePolicy, *servicemeshv1alpha1.Strategy:
+			servicemeshObjects = append(servicemeshObjects, obj)
+		default:
+			k8sObjects = append(k8sObjects, obj)
+		}
+	}
+
+	f.k8sClient = k8sfake.NewSimpleClientset(k8sObjects...)
+	f.istioClient = istiofake.NewSimpleClientset(istioObjects...)
+	f.servicemeshClient = servicemeshfake.NewSimpleClientset(servicemeshObjects...)
+
+	f.k8sInformers = k8sinformers.NewSharedInformerFactory(f.k8sClient, 0)
+	f.istioInformers = istioinformers.NewSharedInformerFactory(f.istioClient, 0)
+	f.servicemeshInformers = servicemeshinformers.NewSharedInformerFactory(f.servicemeshClient, 0)
+
+	f.controller = NewDestinationRuleController(workload.KubernetesSources(f.k8sInformers),
+		f.istioInformers.Networking().V1beta1().DestinationRules(),
+		f.k8sInformers.Core().V1().Services(),
+		f.servicemeshInformers.Servicemesh().V1alpha1().ServicePolicies(),
+		f.servicemeshInformers.Servicemesh().V1alpha1().Strategies(),
+		f.k8sClient,
+		f.istioClient,
+		f.servicemeshClient,
+		nil,
+		5*time.Minute)
+
+	f.controller.eventBroadcaster.Shutdown()
+	f.recorder = record.NewFakeRecorder(100)
+	f.controller.eventRecorder = f.recorder
+
+	for _, obj := range objects {
+		f.addToCache(obj)
+	}
+
+	return f
+}
+
+// addToCache puts obj into cache of informer watching it
+func (f *fixture) addToCache(obj runtime.Object) {
+	var informer cache.SharedIndexInformer
+	switch obj.(type) {
+	case *v1.Service:
+		informer = f.k8sInformers.Core().V1().Services().Informer()
+	case *networkingv1beta1.DestinationRule:
+		informer = f.istioInformers.Networking().V1beta1().DestinationRules().Informer()
+	case *servicemeshv1alpha1.ServicePolicy:
+		informer = f.servicemeshInformers.Servicemesh().V1alpha1().ServicePolicies().Informer()
+	case *servicemeshv1alpha1.Strategy:
+		informer = f.servicemeshInformers.Servicemesh().V1alpha1().Strategies().Informer()
+	default:
+		for _, source := range f.controller.workloadSources {
+			if _, ok := source.Workload(obj); ok {
+				informer = source.Informer()
+			}
+		}
+	}
+
+	if informer == nil {
+		f.t.Fatalf("no informer watches %T", obj)
+	}
+	if err := informer.GetIndexer().Add(obj); err != nil {
+		f.t.Fatalf("add %T to cache failed, %v", obj, err)
+	}
+}
+
+// events drains events recorded so far
+func (f *fixture) events() []string {
+	var events []string
+	for {
+		select {
+		case event := <-f.recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func testApplicationLabels() map[string]string {
+	return map[string]string{
+		util.AppLabel:                testApp,
+		util.ApplicationNameLabel:    "bookinfo",
+		util.ApplicationVersionLabel: "v1",
+	}
+}
+
+func newTestService(name string) *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   testNamespace,
+			UID:         types.UID("uid-" + name),
+			Labels:      testApplicationLabels(),
+			Annotations: map[string]string{util.ServiceMeshEnabledAnnotation: "true"},
+		},
+		Spec: v1.ServiceSpec{
+			Selector: map[string]string{util.AppLabel: testApp},
+			Ports:    []v1.ServicePort{{Name: "http", Port: 80}},
+		},
+	}
+}
+
+func newTestDeployment(version string, readyReplicas int32) *appsv1.Deployment {
+	labels := testApplicationLabels()
+	labels[util.VersionLabel] = version
+
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        testApp + "-" + version,
+			Namespace:   testNamespace,
+			Labels:      labels,
+			Annotations: map[string]string{util.ServiceMeshEnabledAnnotation: "true"},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+		},
+		Status: appsv1.DeploymentStatus{ReadyReplicas: readyReplicas},
+	}
+}
+
+func newTestServicePolicy(name string, priority int32) *servicemeshv1alpha1.ServicePolicy {
+	return &servicemeshv1alpha1.ServicePolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: testNamespace,
+			Labels:    testApplicationLabels(),
+		},
+		Spec: servicemeshv1alpha1.ServicePolicySpec{Priority: priority},
+	}
+}
+
+func TestAddServicePolicy(t *testing.T) {
+	sp := newTestServicePolicy("policy", 0)
+
+	tests := []struct {
+		name string
+		obj  interface{}
+		want int
+	}{
+		{name: "servicepolicy", obj: sp, want: 1},
+		{name: "tombstone", obj: cache.DeletedFinalStateUnknown{Key: "default/policy", Obj: sp}, want: 1},
+		{name: "tombstone of another kind", obj: cache.DeletedFinalStateUnknown{Key: "default/policy", Obj: &v1.Pod{}}, want: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t, newTestService("reviews"))
+			f.controller.addServicePolicy(test.obj)
+			if got := f.controller.queue.Len(); got != test.want {
+				t.Errorf("enqueued %d services, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+// sync syncs service of name, and returns the destinationrule written
+func (f *fixture) sync(name string) *networkingv1beta1.DestinationRule {
+	if err := f.controller.syncService(testNamespace + "/" + name); err != nil {
+		f.t.Fatalf("sync service %s failed, %v", name, err)
+	}
+
+	dr, err := f.istioClient.NetworkingV1beta1().DestinationRules(testNamespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		f.t.Fatalf("get destinationrule failed, %v", err)
+	}
+	return dr
+}
+
+func TestSyncServiceAdoption(t *testing.T) {
+	service := newTestService("reviews")
+
+	tests := []struct {
+		name    string
+		labels  map[string]string
+		managed bool
+	}{
+		{
+			name:    "generated by earlier releases",
+			labels:  testApplicationLabels(),
+			managed: true,
+		},
+		{
+			name:    "marked but not controlled",
+			labels:  util.ManagedLabels(service),
+			managed: true,
+		},
+		{
+			name:   "created by others",
+			labels: map[string]string{"team": "reviews"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			current := &networkingv1beta1.DestinationRule{
+				ObjectMeta: metav1.ObjectMeta{Name: "reviews", Namespace: testNamespace, ResourceVersion: "1", Labels: test.labels},
+				Spec:       networkingv1beta1api.DestinationRule{Host: "reviews"},
+			}
+			f := newFixture(t, service, newTestDeployment("v1", 1), current)
+
+			dr := f.sync("reviews")
+			if adopted := util.IsManaged(dr) && metav1.IsControlledBy(dr, service); adopted != test.managed {
+				t.Errorf("adopted %v, want %v, labels %v owners %v", adopted, test.managed, dr.Labels, dr.OwnerReferences)
+			}
+			if updated := len(dr.Spec.Subsets) > 0; updated != test.managed {
+				t.Errorf("updated %v, want %v, subsets %v", updated, test.managed, dr.Spec.Subsets)
+			}
+		})
+	}
+}
+
+// queuedKeys drains keys enqueued so far, in sorted order
+func queuedKeys(queue workqueue.RateLimitingInterface) []string {
+	keys := sets.NewString()
+	for queue.Len() > 0 {
+		key, _ := queue.Get()
+		keys.Insert(key.(string))
+		queue.Done(key)
+	}
+	return keys.List()
+}
+
+func TestUpdateService(t *testing.T) {
+	relabeled := newTestService("reviews")
+	relabeled.Labels[util.AppLabel] = "ratings"
+
+	disabled := newTestService("reviews")
+	disabled.Annotations[util.ServiceMeshEnabledAnnotation] = "false"
+
+	tests := []struct {
+		name string
+		cur  *v1.Service
+		want []string
+	}{
+		{name: "application unchanged", cur: newTestService("reviews"), want: []string{"default/reviews"}},
+		{name: "app label changed", cur: relabeled, want: []string{"default/reviews", "default/reviews-v2"}},
+		{name: "servicemesh disabled", cur: disabled, want: []string{"default/reviews", "default/reviews-v2"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t, test.cur, newTestService("reviews-v2"))
+			f.controller.updateService(newTestService("reviews"), test.cur)
+
+			if keys := queuedKeys(f.controller.queue); !reflect.DeepEqual(keys, test.want) {
+				t.Errorf("enqueued %v, want %v", keys, test.want)
+			}
+		})
+	}
+}
+
+func TestSyncServiceRelabeledOut(t *testing.T) {
+	tests := []struct {
+		name        string
+		service     func(service *v1.Service)
+		wantDeleted bool
+	}{
+		{
+			name:    "in servicemesh",
+			service: func(service *v1.Service) {},
+		},
+		{
+			name: "servicemesh disabled",
+			service: func(service *v1.Service) {
+				service.Annotations[util.ServiceMeshEnabledAnnotation] = "false"
+			},
+			wantDeleted: true,
+		},
+		{
+			name: "app label removed",
+			service: func(service *v1.Service) {
+				delete(service.Labels, util.AppLabel)
+			},
+			wantDeleted: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			service := newTestService("reviews")
+			current := &networkingv1beta1.DestinationRule{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            "reviews",
+					Namespace:       testNamespace,
+					ResourceVersion: "1",
+					Labels:          util.ManagedLabels(service),
+					OwnerReferences: []metav1.OwnerReference{*util.NewServiceControllerRef(service)},
+				},
+			}
+			test.service(service)
+			f := newFixture(t, service, newTestDeployment("v1", 1), current)
+
+			if err := f.controller.syncService(testNamespace + "/reviews"); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			_, err := f.istioClient.NetworkingV1beta1().DestinationRules(testNamespace).Get(context.TODO(), "reviews", metav1.GetOptions{})
+			if deleted := errors.IsNotFound(err); deleted != test.wantDeleted {
+				t.Errorf("deleted %v, want %v, %v", deleted, test.wantDeleted, err)
+			}
+		})
+	}
+}
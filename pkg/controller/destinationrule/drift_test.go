@@ -0,0 +1,83 @@
+package destinationrule
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	networkingv1beta1 "istio.io/client-go/pkg/apis/networking/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	networkingv1beta1api "istio.io/api/networking/v1beta1"
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+	"zmc.io/oasis/pkg/controller/virtualservice/util"
+)
+
+// newGeneratedDestinationRule returns destinationrule generated for service, edited by others since
+func newGeneratedDestinationRule(service *v1.Service, resourceVersion string) *networkingv1beta1.DestinationRule {
+	return &networkingv1beta1.DestinationRule{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            service.Name,
+			Namespace:       testNamespace,
+			ResourceVersion: resourceVersion,
+			Labels:          util.ManagedLabels(service),
+			OwnerReferences: []metav1.OwnerReference{*util.NewServiceControllerRef(service)},
+		},
+		Spec: networkingv1beta1api.DestinationRule{
+			Host:    "reviews.default.svc.cluster.local",
+			Subsets: []*networkingv1beta1api.Subset{{Name: "v3", Labels: map[string]string{util.VersionLabel: "v3"}}},
+		},
+	}
+}
+
+func TestSyncServiceDrift(t *testing.T) {
+	service := newTestService("reviews")
+
+	tests := []struct {
+		name       string
+		overridden bool
+
+		wantReverted bool
+		wantDrift    string
+	}{
+		{
+			name:         "edited by others",
+			wantReverted: true,
+		},
+		{
+			name:       "overridden on purpose",
+			overridden: true,
+			wantDrift:  "destinationrule default/reviews is overridden, generated subsets and policies are not applied",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			current := newGeneratedDestinationRule(service, "1")
+			if test.overridden {
+				current.Annotations = map[string]string{util.OverrideAnnotation: "true"}
+			}
+			sp := newTestServicePolicy("policy", 0)
+			f := newFixture(t, service, newTestDeployment("v1", 1), sp, current)
+
+			dr := f.sync("reviews")
+			if reverted := !reflect.DeepEqual(dr.Spec, current.Spec); reverted != test.wantReverted {
+				t.Errorf("reverted %v, want %v, subsets %v", reverted, test.wantReverted, dr.Spec.Subsets)
+			}
+
+			got, err := f.servicemeshClient.ServicemeshV1alpha1().ServicePolicies(testNamespace).Get(context.TODO(), sp.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			drifted := util.GetServicePolicyCondition(got.Status, servicemeshv1alpha1.ServicePolicyDrifted)
+			if len(test.wantDrift) == 0 && drifted != nil {
+				t.Errorf("unexpected condition %v", drifted)
+			}
+			if len(test.wantDrift) > 0 && (drifted == nil || drifted.Message != test.wantDrift) {
+				t.Errorf("drifted condition %v, want message %q", drifted, test.wantDrift)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package destinationrule
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	networkingv1beta1api "istio.io/api/networking/v1beta1"
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+)
+
+// SortServicePolicies orders servicepolicies by priority from high to low, and by
+// name when priorities are the same, so merge result is deterministic.
+func SortServicePolicies(servicePolicies []*servicemeshv1alpha1.ServicePolicy) {
+	sort.SliceStable(servicePolicies, func(i, j int) bool {
+		if servicePolicies[i].Spec.Priority != servicePolicies[j].Spec.Priority {
+			return servicePolicies[i].Spec.Priority > servicePolicies[j].Spec.Priority
+		}
+		return servicePolicies[i].Name < servicePolicies[j].Name
+	})
+}
+
+// mergeServicePolicies applies traffic policies of sorted servicepolicies to destinationrule spec.
+// A traffic policy, of the whole host or of a subset, is taken from the servicepolicy
+// with highest priority that defines it, resilience presets count as traffic policy of
+// the whole host. It returns conflict messages for each servicepolicy.
+func mergeServicePolicies(spec *networkingv1beta1api.DestinationRule, servicePolicies []*servicemeshv1alpha1.ServicePolicy) []string {
+	conflicts := make([]string, len(servicePolicies))
+
+	var trafficPolicyOwner string
+	subsetOwners := make(map[string]string)
+
+	for i, sp := range servicePolicies {
+		overridden := make([]string, 0)
+
+		if trafficPolicy := servicePolicyTrafficPolicy(sp); trafficPolicy != nil {
+			if len(trafficPolicyOwner) == 0 {
+				spec.TrafficPolicy = trafficPolicy
+				trafficPolicyOwner = sp.Name
+			} else {
+				overridden = append(overridden, fmt.Sprintf("traffic policy is overridden by servicepolicy %s", trafficPolicyOwner))
+			}
+		}
+
+		for _, subset := range sp.Spec.Template.Spec.Subsets {
+			if subset.TrafficPolicy == nil {
+				continue
+			}
+
+			if owner, ok := subsetOwners[subset.Name]; ok {
+				overridden = append(overridden, fmt.Sprintf("traffic policy of subset %s is overridden by servicepolicy %s", subset.Name, owner))
+				continue
+			}
+
+			for j := range spec.Subsets {
+				if subset.Name == spec.Subsets[j].Name {
+					spec.Subsets[j].TrafficPolicy = subset.TrafficPolicy.DeepCopy()
+					subsetOwners[subset.Name] = sp.Name
+				}
+			}
+		}
+
+		conflicts[i] = strings.Join(overridden, ", ")
+	}
+
+	return conflicts
+}
@@ -0,0 +1,125 @@
+package destinationrule
+
+import (
+	"reflect"
+	"testing"
+
+	networkingv1beta1api "istio.io/api/networking/v1beta1"
+
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+)
+
+func TestSortServicePolicies(t *testing.T) {
+	servicePolicies := []*servicemeshv1alpha1.ServicePolicy{
+		newTestServicePolicy("c", 0),
+		newTestServicePolicy("b", 10),
+		newTestServicePolicy("a", 0),
+		newTestServicePolicy("d", 10),
+	}
+
+	SortServicePolicies(servicePolicies)
+
+	names := make([]string, 0, len(servicePolicies))
+	for _, sp := range servicePolicies {
+		names = append(names, sp.Name)
+	}
+
+	if want := []string{"b", "d", "a", "c"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("sorted %v, want %v", names, want)
+	}
+}
+
+func roundRobin() *networkingv1beta1api.TrafficPolicy {
+	return &networkingv1beta1api.TrafficPolicy{
+		LoadBalancer: &networkingv1beta1api.LoadBalancerSettings{
+			LbPolicy: &networkingv1beta1api.LoadBalancerSettings_Simple{Simple: networkingv1beta1api.LoadBalancerSettings_ROUND_ROBIN},
+		},
+	}
+}
+
+func leastConn() *networkingv1beta1api.TrafficPolicy {
+	return &networkingv1beta1api.TrafficPolicy{
+		LoadBalancer: &networkingv1beta1api.LoadBalancerSettings{
+			LbPolicy: &networkingv1beta1api.LoadBalancerSettings_Simple{Simple: networkingv1beta1api.LoadBalancerSettings_LEAST_CONN},
+		},
+	}
+}
+
+func TestMergeServicePolicies(t *testing.T) {
+	newServicePolicy := func(name string, priority int32, trafficPolicy *networkingv1beta1api.TrafficPolicy, subsets ...*networkingv1beta1api.Subset) *servicemeshv1alpha1.ServicePolicy {
+		sp := newTestServicePolicy(name, priority)
+		sp.Spec.Template.Spec.TrafficPolicy = trafficPolicy
+		sp.Spec.Template.Spec.Subsets = subsets
+		return sp
+	}
+
+	tests := []struct {
+		name            string
+		servicePolicies []*servicemeshv1alpha1.ServicePolicy
+
+		wantTrafficPolicy *networkingv1beta1api.TrafficPolicy
+		wantSubsets       map[string]*networkingv1beta1api.TrafficPolicy
+		wantConflicts     []string
+	}{
+		{
+			name: "host and subset policies composed",
+			servicePolicies: []*servicemeshv1alpha1.ServicePolicy{
+				newServicePolicy("high", 10, roundRobin()),
+				newServicePolicy("low", 0, nil, &networkingv1beta1api.Subset{Name: "v2", TrafficPolicy: leastConn()}),
+			},
+			wantTrafficPolicy: roundRobin(),
+			wantSubsets:       map[string]*networkingv1beta1api.TrafficPolicy{"v2": leastConn()},
+			wantConflicts:     []string{"", ""},
+		},
+		{
+			name: "host policy of lower priority overridden",
+			servicePolicies: []*servicemeshv1alpha1.ServicePolicy{
+				newServicePolicy("high", 10, roundRobin()),
+				newServicePolicy("low", 0, leastConn()),
+			},
+			wantTrafficPolicy: roundRobin(),
+			wantConflicts:     []string{"", "traffic policy is overridden by servicepolicy high"},
+		},
+		{
+			name: "subset policy of lower priority overridden",
+			servicePolicies: []*servicemeshv1alpha1.ServicePolicy{
+				newServicePolicy("high", 10, nil, &networkingv1beta1api.Subset{Name: "v1", TrafficPolicy: roundRobin()}),
+				newServicePolicy("low", 0, nil, &networkingv1beta1api.Subset{Name: "v1", TrafficPolicy: leastConn()}),
+			},
+			wantSubsets:   map[string]*networkingv1beta1api.TrafficPolicy{"v1": roundRobin()},
+			wantConflicts: []string{"", "traffic policy of subset v1 is overridden by servicepolicy high"},
+		},
+		{
+			name: "subsets without workload ignored",
+			servicePolicies: []*servicemeshv1alpha1.ServicePolicy{
+				newServicePolicy("high", 10, nil, &networkingv1beta1api.Subset{Name: "v3", TrafficPolicy: roundRobin()}),
+			},
+			wantConflicts: []string{""},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			spec := &networkingv1beta1api.DestinationRule{
+				Host:    "reviews.default.svc.cluster.local",
+				Subsets: []*networkingv1beta1api.Subset{{Name: "v1"}, {Name: "v2"}},
+			}
+
+			conflicts := mergeServicePolicies(spec, test.servicePolicies)
+
+			if !reflect.DeepEqual(spec.TrafficPolicy, test.wantTrafficPolicy) {
+				t.Errorf("traffic policy %v, want %v", spec.TrafficPolicy, test.wantTrafficPolicy)
+			}
+
+			for _, subset := range spec.Subsets {
+				if want := test.wantSubsets[subset.Name]; !reflect.DeepEqual(subset.TrafficPolicy, want) {
+					t.Errorf("traffic policy of subset %s %v, want %v", subset.Name, subset.TrafficPolicy, want)
+				}
+			}
+
+			if !reflect.DeepEqual(conflicts, test.wantConflicts) {
+				t.Errorf("conflicts %q, want %q", conflicts, test.wantConflicts)
+			}
+		})
+	}
+}
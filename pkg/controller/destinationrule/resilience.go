@@ -0,0 +1,128 @@
+package destinationrule
+
+import (
+	"time"
+
+	"github.com/gogo/protobuf/types"
+	networkingv1beta1api "istio.io/api/networking/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+)
+
+// defaults of resilience presets
+const (
+	defaultMaxConnections     = 1024
+	defaultConnectTimeout     = 10 * time.Second
+	defaultIdleTimeout        = time.Hour
+	defaultMaxPendingRequests = 1024
+	defaultMaxRequests        = 1024
+
+	defaultConsecutive5xxErrors = 5
+	defaultEjectionInterval     = 10 * time.Second
+	defaultBaseEjectionTime     = 30 * time.Second
+	defaultMaxEjectionPercent   = 10
+)
+
+// servicePolicyTrafficPolicy returns traffic policy servicepolicy applies to the whole host.
+// Resilience presets are expanded first, then tcp and http settings of connection pool, and
+// outlier detection, in template replace what presets expand into, so template remains the
+// way to tune what presets don't cover.
+func servicePolicyTrafficPolicy(sp *servicemeshv1alpha1.ServicePolicy) *networkingv1beta1api.TrafficPolicy {
+	trafficPolicy := sp.Spec.Template.Spec.TrafficPolicy.DeepCopy()
+	if sp.Spec.Resilience == nil {
+		return trafficPolicy
+	}
+
+	preset := expandResilience(sp.Spec.Resilience)
+	if trafficPolicy == nil {
+		return preset
+	}
+
+	if trafficPolicy.ConnectionPool == nil {
+		trafficPolicy.ConnectionPool = preset.ConnectionPool
+	} else if preset.ConnectionPool != nil {
+		if trafficPolicy.ConnectionPool.Tcp == nil {
+			trafficPolicy.ConnectionPool.Tcp = preset.ConnectionPool.Tcp
+		}
+		if trafficPolicy.ConnectionPool.Http == nil {
+			trafficPolicy.ConnectionPool.Http = preset.ConnectionPool.Http
+		}
+	}
+
+	if trafficPolicy.OutlierDetection == nil {
+		trafficPolicy.OutlierDetection = preset.OutlierDetection
+	}
+
+	return trafficPolicy
+}
+
+// expandResilience converts resilience presets to traffic policy, taking defaults
+// of parameters not specified
+func expandResilience(resilience *servicemeshv1alpha1.ResiliencePolicy) *networkingv1beta1api.TrafficPolicy {
+	trafficPolicy := &networkingv1beta1api.TrafficPolicy{}
+
+	if limits := resilience.ConnectionLimits; limits != nil {
+		connectionPool := connectionPoolOf(trafficPolicy)
+		connectionPool.Tcp = &networkingv1beta1api.ConnectionPoolSettings_TCPSettings{
+			MaxConnections: int32OrDefault(limits.MaxConnections, defaultMaxConnections),
+			ConnectTimeout: types.DurationProto(durationOrDefault(limits.ConnectTimeout, defaultConnectTimeout)),
+		}
+
+		httpSettings := httpSettingsOf(connectionPool)
+		httpSettings.MaxRequestsPerConnection = int32OrDefault(limits.MaxRequestsPerConnection, 0)
+		httpSettings.IdleTimeout = types.DurationProto(durationOrDefault(limits.IdleTimeout, defaultIdleTimeout))
+	}
+
+	if circuitBreaker := resilience.CircuitBreaker; circuitBreaker != nil {
+		httpSettings := httpSettingsOf(connectionPoolOf(trafficPolicy))
+		httpSettings.Http1MaxPendingRequests = int32OrDefault(circuitBreaker.MaxPendingRequests, defaultMaxPendingRequests)
+		httpSettings.Http2MaxRequests = int32OrDefault(circuitBreaker.MaxRequests, defaultMaxRequests)
+		httpSettings.MaxRetries = int32OrDefault(circuitBreaker.MaxRetries, 0)
+	}
+
+	if ejection := resilience.OutlierEjection; ejection != nil {
+		outlierDetection := &networkingv1beta1api.OutlierDetection{
+			Consecutive_5XxErrors: &types.UInt32Value{Value: uint32(int32OrDefault(ejection.Consecutive5xxErrors, defaultConsecutive5xxErrors))},
+			Interval:              types.DurationProto(durationOrDefault(ejection.Interval, defaultEjectionInterval)),
+			BaseEjectionTime:      types.DurationProto(durationOrDefault(ejection.BaseEjectionTime, defaultBaseEjectionTime)),
+			MaxEjectionPercent:    int32OrDefault(ejection.MaxEjectionPercent, defaultMaxEjectionPercent),
+			MinHealthPercent:      int32OrDefault(ejection.MinHealthPercent, 0),
+		}
+		if ejection.ConsecutiveGatewayErrors != nil {
+			outlierDetection.ConsecutiveGatewayErrors = &types.UInt32Value{Value: uint32(*ejection.ConsecutiveGatewayErrors)}
+		}
+		trafficPolicy.OutlierDetection = outlierDetection
+	}
+
+	return trafficPolicy
+}
+
+func connectionPoolOf(trafficPolicy *networkingv1beta1api.TrafficPolicy) *networkingv1beta1api.ConnectionPoolSettings {
+	if trafficPolicy.ConnectionPool == nil {
+		trafficPolicy.ConnectionPool = &networkingv1beta1api.ConnectionPoolSettings{}
+	}
+	return trafficPolicy.ConnectionPool
+}
+
+func httpSettingsOf(connectionPool *networkingv1beta1api.ConnectionPoolSettings) *networkingv1beta1api.ConnectionPoolSettings_HTTPSettings {
+	if connectionPool.Http == nil {
+		connectionPool.Http = &networkingv1beta1api.ConnectionPoolSettings_HTTPSettings{}
+	}
+	return connectionPool.Http
+}
+
+// int32OrDefault returns value if specified, or defaultValue, zero means unlimited to istio
+func int32OrDefault(value *int32, defaultValue int32) int32 {
+	if value != nil {
+		return *value
+	}
+	return defaultValue
+}
+
+func durationOrDefault(value *metav1.Duration, defaultValue time.Duration) time.Duration {
+	if value != nil {
+		return value.Duration
+	}
+	return defaultValue
+}
@@ -0,0 +1,204 @@
+package destinationrule
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/types"
+	networkingv1beta1api "istio.io/api/networking/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+)
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+func durationPtr(d time.Duration) *metav1.Duration {
+	return &metav1.Duration{Duration: d}
+}
+
+func TestExpandResilience(t *testing.T) {
+	tests := []struct {
+		name       string
+		resilience *servicemeshv1alpha1.ResiliencePolicy
+		want       *networkingv1beta1api.TrafficPolicy
+	}{
+		{
+			name:       "connection limits by default",
+			resilience: &servicemeshv1alpha1.ResiliencePolicy{ConnectionLimits: &servicemeshv1alpha1.ConnectionLimits{}},
+			want: &networkingv1beta1api.TrafficPolicy{ConnectionPool: &networkingv1beta1api.ConnectionPoolSettings{
+				Tcp: &networkingv1beta1api.ConnectionPoolSettings_TCPSettings{
+					MaxConnections: defaultMaxConnections,
+					ConnectTimeout: types.DurationProto(defaultConnectTimeout),
+				},
+				Http: &networkingv1beta1api.ConnectionPoolSettings_HTTPSettings{
+					IdleTimeout: types.DurationProto(defaultIdleTimeout),
+				},
+			}},
+		},
+		{
+			name: "connection limits specified",
+			resilience: &servicemeshv1alpha1.ResiliencePolicy{ConnectionLimits: &servicemeshv1alpha1.ConnectionLimits{
+				MaxConnections:           int32Ptr(100),
+				ConnectTimeout:           durationPtr(time.Second),
+				MaxRequestsPerConnection: int32Ptr(10),
+				IdleTimeout:              durationPtr(time.Minute),
+			}},
+			want: &networkingv1beta1api.TrafficPolicy{ConnectionPool: &networkingv1beta1api.ConnectionPoolSettings{
+				Tcp: &networkingv1beta1api.ConnectionPoolSettings_TCPSettings{
+					MaxConnections: 100,
+					ConnectTimeout: types.DurationProto(time.Second),
+				},
+				Http: &networkingv1beta1api.ConnectionPoolSettings_HTTPSettings{
+					MaxRequestsPerConnection: 10,
+					IdleTimeout:              types.DurationProto(time.Minute),
+				},
+			}},
+		},
+		{
+			name:       "circuit breaker by default",
+			resilience: &servicemeshv1alpha1.ResiliencePolicy{CircuitBreaker: &servicemeshv1alpha1.CircuitBreaker{}},
+			want: &networkingv1beta1api.TrafficPolicy{ConnectionPool: &networkingv1beta1api.ConnectionPoolSettings{
+				Http: &networkingv1beta1api.ConnectionPoolSettings_HTTPSettings{
+					Http1MaxPendingRequests: defaultMaxPendingRequests,
+					Http2MaxRequests:        defaultMaxRequests,
+				},
+			}},
+		},
+		{
+			name: "connection limits and circuit breaker share http settings",
+			resilience: &servicemeshv1alpha1.ResiliencePolicy{
+				ConnectionLimits: &servicemeshv1alpha1.ConnectionLimits{MaxConnections: int32Ptr(100)},
+				CircuitBreaker:   &servicemeshv1alpha1.CircuitBreaker{MaxPendingRequests: int32Ptr(50), MaxRequests: int32Ptr(200), MaxRetries: int32Ptr(3)},
+			},
+			want: &networkingv1beta1api.TrafficPolicy{ConnectionPool: &networkingv1beta1api.ConnectionPoolSettings{
+				Tcp: &networkingv1beta1api.ConnectionPoolSettings_TCPSettings{
+					MaxConnections: 100,
+					ConnectTimeout: types.DurationProto(defaultConnectTimeout),
+				},
+				Http: &networkingv1beta1api.ConnectionPoolSettings_HTTPSettings{
+					IdleTimeout:             types.DurationProto(defaultIdleTimeout),
+					Http1MaxPendingRequests: 50,
+					Http2MaxRequests:        200,
+					MaxRetries:              3,
+				},
+			}},
+		},
+		{
+			name:       "outlier ejection by default",
+			resilience: &servicemeshv1alpha1.ResiliencePolicy{OutlierEjection: &servicemeshv1alpha1.OutlierEjection{}},
+			want: &networkingv1beta1api.TrafficPolicy{OutlierDetection: &networkingv1beta1api.OutlierDetection{
+				Consecutive_5XxErrors: &types.UInt32Value{Value: defaultConsecutive5xxErrors},
+				Interval:              types.DurationProto(defaultEjectionInterval),
+				BaseEjectionTime:      types.DurationProto(defaultBaseEjectionTime),
+				MaxEjectionPercent:    defaultMaxEjectionPercent,
+			}},
+		},
+		{
+			name: "outlier ejection specified",
+			resilience: &servicemeshv1alpha1.ResiliencePolicy{OutlierEjection: &servicemeshv1alpha1.OutlierEjection{
+				Consecutive5xxErrors:     int32Ptr(3),
+				ConsecutiveGatewayErrors: int32Ptr(2),
+				Interval:                 durationPtr(time.Second),
+				BaseEjectionTime:         durationPtr(time.Minute),
+				MaxEjectionPercent:       int32Ptr(50),
+				MinHealthPercent:         int32Ptr(20),
+			}},
+			want: &networkingv1beta1api.TrafficPolicy{OutlierDetection: &networkingv1beta1api.OutlierDetection{
+				Consecutive_5XxErrors:    &types.UInt32Value{Value: 3},
+				ConsecutiveGatewayErrors: &types.UInt32Value{Value: 2},
+				Interval:                 types.DurationProto(time.Second),
+				BaseEjectionTime:         types.DurationProto(time.Minute),
+				MaxEjectionPercent:       50,
+				MinHealthPercent:         20,
+			}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := expandResilience(test.resilience); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("traffic policy %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestServicePolicyTrafficPolicy(t *testing.T) {
+	resilience := &servicemeshv1alpha1.ResiliencePolicy{
+		ConnectionLimits: &servicemeshv1alpha1.ConnectionLimits{},
+		OutlierEjection:  &servicemeshv1alpha1.OutlierEjection{},
+	}
+	preset := expandResilience(resilience)
+
+	tcp := &networkingv1beta1api.ConnectionPoolSettings_TCPSettings{MaxConnections: 10}
+	outlierDetection := &networkingv1beta1api.OutlierDetection{MaxEjectionPercent: 100}
+
+	tests := []struct {
+		name          string
+		resilience    *servicemeshv1alpha1.ResiliencePolicy
+		trafficPolicy *networkingv1beta1api.TrafficPolicy
+		want          *networkingv1beta1api.TrafficPolicy
+	}{
+		{
+			name: "neither resilience nor traffic policy",
+		},
+		{
+			name:          "traffic policy of template",
+			trafficPolicy: roundRobin(),
+			want:          roundRobin(),
+		},
+		{
+			name:       "resilience only",
+			resilience: resilience,
+			want:       preset,
+		},
+		{
+			name:          "resilience merged with load balancer of template",
+			resilience:    resilience,
+			trafficPolicy: roundRobin(),
+			want: &networkingv1beta1api.TrafficPolicy{
+				LoadBalancer:     roundRobin().LoadBalancer,
+				ConnectionPool:   preset.ConnectionPool,
+				OutlierDetection: preset.OutlierDetection,
+			},
+		},
+		{
+			name:          "tcp settings of template take precedence",
+			resilience:    resilience,
+			trafficPolicy: &networkingv1beta1api.TrafficPolicy{ConnectionPool: &networkingv1beta1api.ConnectionPoolSettings{Tcp: tcp}},
+			want: &networkingv1beta1api.TrafficPolicy{
+				ConnectionPool:   &networkingv1beta1api.ConnectionPoolSettings{Tcp: tcp, Http: preset.ConnectionPool.Http},
+				OutlierDetection: preset.OutlierDetection,
+			},
+		},
+		{
+			name:          "outlier detection of template takes precedence",
+			resilience:    resilience,
+			trafficPolicy: &networkingv1beta1api.TrafficPolicy{OutlierDetection: outlierDetection},
+			want: &networkingv1beta1api.TrafficPolicy{
+				ConnectionPool:   preset.ConnectionPool,
+				OutlierDetection: outlierDetection,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sp := newTestServicePolicy("policy", 0)
+			sp.Spec.Resilience = test.resilience
+			sp.Spec.Template.Spec.TrafficPolicy = test.trafficPolicy
+
+			if got := servicePolicyTrafficPolicy(sp); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("traffic policy %v, want %v", got, test.want)
+			}
+			// template of servicepolicy in the informer cache is left untouched
+			if !reflect.DeepEqual(sp.Spec.Template.Spec.TrafficPolicy, test.trafficPolicy) {
+				t.Errorf("template modified to %v", sp.Spec.Template.Spec.TrafficPolicy)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package destinationrule
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	networkingv1beta1api "istio.io/api/networking/v1beta1"
+	networkingv1beta1 "istio.io/client-go/pkg/apis/networking/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	log "k8s.io/klog"
+
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+	"zmc.io/oasis/pkg/controller/virtualservice"
+	"zmc.io/oasis/pkg/controller/virtualservice/util"
+)
+
+// DefaultSubsetGracePeriod is how long subsets are kept after their workloads become unready,
+// unless configured otherwise
+const DefaultSubsetGracePeriod = 5 * time.Minute
+
+// RetainSubsets keeps subsets of current destinationrule whose workloads are no longer ready,
+// as long as strategies route traffic to them, or within gracePeriod since they became unready,
+// so routes to them stay valid during restarts and scale-downs. Held subsets are appended to spec.
+// It returns held subsets, value of unready subsets annotation, and duration after which a held
+// subset expires, zero if none expires.
+func RetainSubsets(spec *networkingv1beta1api.DestinationRule, current *networkingv1beta1.DestinationRule,
+	strategies []*servicemeshv1alpha1.Strategy, gracePeriod time.Duration, now time.Time) ([]servicemeshv1alpha1.HeldSubset, string, time.Duration) {
+	ready := sets.String{}
+	for _, subset := range spec.Subsets {
+		ready.Insert(subset.Name)
+	}
+
+	referencedBy := strategiesBySubset(strategies)
+	unreadySince := parseUnreadySubsets(current)
+
+	var held []servicemeshv1alpha1.HeldSubset
+	var requeueAfter time.Duration
+	unready := make(map[string]metav1.Time)
+
+	for _, subset := range current.Spec.Subsets {
+		if ready.Has(subset.Name) {
+			continue
+		}
+
+		// annotation keeps seconds only, so does status
+		since, ok := unreadySince[subset.Name]
+		if !ok {
+			since = metav1.NewTime(now.Truncate(time.Second))
+		}
+
+		heldSubset := servicemeshv1alpha1.HeldSubset{
+			Name:         subset.Name,
+			UnreadySince: since,
+		}
+
+		if names := referencedBy[subset.Name]; len(names) > 0 {
+			heldSubset.Reason = servicemeshv1alpha1.SubsetHeldByStrategy
+			heldSubset.Strategies = names
+		} else if expireTime := since.Add(gracePeriod); now.Before(expireTime) {
+			heldSubset.Reason = servicemeshv1alpha1.SubsetHeldInGracePeriod
+			heldSubset.ExpireTime = &metav1.Time{Time: expireTime}
+			if after := expireTime.Sub(now); requeueAfter == 0 || after < requeueAfter {
+				requeueAfter = after
+			}
+		} else {
+			continue
+		}
+
+		held = append(held, heldSubset)
+		unready[subset.Name] = since
+		spec.Subsets = append(spec.Subsets, subset.DeepCopy())
+	}
+
+	if len(unready) == 0 {
+		return held, "", 0
+	}
+
+	// keys of map are sorted, so the value is stable
+	value, _ := json.Marshal(unready)
+	return held, string(value), requeueAfter
+}
+
+// newlyHeldSubsets returns names of held subsets not yet recorded as unready in current destinationrule
+func newlyHeldSubsets(held []servicemeshv1alpha1.HeldSubset, current *networkingv1beta1.DestinationRule) []string {
+	recorded := parseUnreadySubsets(current)
+
+	var names []string
+	for _, subset := range held {
+		if _, ok := recorded[subset.Name]; !ok {
+			names = append(names, subset.Name)
+		}
+	}
+	return names
+}
+
+// strategiesBySubset returns names of strategies routing traffic to every subset
+func strategiesBySubset(strategies []*servicemeshv1alpha1.Strategy) map[string][]string {
+	result := make(map[string][]string)
+	for _, strategy := range strategies {
+		// strategies being deleted no longer hold subsets
+		if strategy.DeletionTimestamp != nil {
+			continue
+		}
+
+		for subset := range virtualservice.StrategySubsets(strategy) {
+			result[subset] = append(result[subset], strategy.Name)
+		}
+	}
+
+	for _, names := range result {
+		sort.Strings(names)
+	}
+	return result
+}
+
+// parseUnreadySubsets returns since when subsets of destinationrule are unready, which is
+// recorded in annotation of destinationrule
+func parseUnreadySubsets(dr *networkingv1beta1.DestinationRule) map[string]metav1.Time {
+	unreadySince := make(map[string]metav1.Time)
+
+	value, ok := dr.Annotations[util.UnreadySubsetsAnnotation]
+	if !ok || len(value) == 0 {
+		return unreadySince
+	}
+
+	if err := json.Unmarshal([]byte(value), &unreadySince); err != nil {
+		log.V(2).Infof("invalid annotation %s of destinationrule %s/%s, %v", util.UnreadySubsetsAnnotation, dr.Namespace, dr.Name, err)
+		return make(map[string]metav1.Time)
+	}
+
+	return unreadySince
+}
@@ -0,0 +1,279 @@
+package destinationrule
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	networkingv1beta1api "istio.io/api/networking/v1beta1"
+	networkingv1beta1 "istio.io/client-go/pkg/apis/networking/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+	"zmc.io/oasis/pkg/controller/virtualservice/util"
+)
+
+// newRoutingStrategy returns strategy routing all traffic to subset
+func newRoutingStrategy(name, subset string) *servicemeshv1alpha1.Strategy {
+	strategy := &servicemeshv1alpha1.Strategy{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace, Labels: testApplicationLabels()},
+	}
+	strategy.Spec.Template.Spec.Http = []*networkingv1beta1api.HTTPRoute{{
+		Route: []*networkingv1beta1api.HTTPRouteDestination{{
+			Destination: &networkingv1beta1api.Destination{Host: testApp, Subset: subset},
+			Weight:      100,
+		}},
+	}}
+	return strategy
+}
+
+// newRetainedDestinationRule returns destinationrule with subsets, and annotation recording
+// since when subsets are unready
+func newRetainedDestinationRule(t *testing.T, unreadySince map[string]metav1.Time, subsets ...string) *networkingv1beta1.DestinationRule {
+	dr := &networkingv1beta1.DestinationRule{ObjectMeta: metav1.ObjectMeta{Name: testApp, Namespace: testNamespace}}
+	for _, subset := range subsets {
+		dr.Spec.Subsets = append(dr.Spec.Subsets, &networkingv1beta1api.Subset{Name: subset, Labels: map[string]string{util.VersionLabel: subset}})
+	}
+
+	if unreadySince != nil {
+		value, err := json.Marshal(unreadySince)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		dr.Annotations = map[string]string{util.UnreadySubsetsAnnotation: string(value)}
+	}
+	return dr
+}
+
+func TestRetainSubsets(t *testing.T) {
+	gracePeriod := 5 * time.Minute
+	now := time.Now().Truncate(time.Second)
+	since := func(ago time.Duration) metav1.Time {
+		return metav1.NewTime(now.Add(-ago))
+	}
+	expire := func(ago time.Duration) *metav1.Time {
+		return &metav1.Time{Time: now.Add(-ago).Add(gracePeriod)}
+	}
+
+	deleting := newRoutingStrategy("deleting", "v2")
+	deleting.DeletionTimestamp = &metav1.Time{Time: now}
+
+	tests := []struct {
+		name       string
+		ready      []string
+		current    *networkingv1beta1.DestinationRule
+		strategies []*servicemeshv1alpha1.Strategy
+
+		wantSubsets      []string
+		wantHeld         []servicemeshv1alpha1.HeldSubset
+		wantUnready      map[string]metav1.Time
+		wantRequeueAfter time.Duration
+	}{
+		{
+			name:        "all subsets ready",
+			ready:       []string{"v1", "v2"},
+			current:     newRetainedDestinationRule(t, nil, "v1", "v2"),
+			wantSubsets: []string{"v1", "v2"},
+		},
+		{
+			name:        "no destinationrule yet",
+			ready:       []string{"v1"},
+			current:     newRetainedDestinationRule(t, nil),
+			wantSubsets: []string{"v1"},
+		},
+		{
+			name:        "subset just became unready",
+			ready:       []string{"v1"},
+			current:     newRetainedDestinationRule(t, nil, "v1", "v2"),
+			wantSubsets: []string{"v1", "v2"},
+			wantHeld: []servicemeshv1alpha1.HeldSubset{
+				{Name: "v2", Reason: servicemeshv1alpha1.SubsetHeldInGracePeriod, UnreadySince: since(0), ExpireTime: expire(0)},
+			},
+			wantUnready:      map[string]metav1.Time{"v2": since(0)},
+			wantRequeueAfter: gracePeriod,
+		},
+		{
+			name:        "subset within grace period",
+			ready:       []string{"v1"},
+			current:     newRetainedDestinationRule(t, map[string]metav1.Time{"v2": since(2 * time.Minute)}, "v1", "v2"),
+			wantSubsets: []string{"v1", "v2"},
+			wantHeld: []servicemeshv1alpha1.HeldSubset{
+				{Name: "v2", Reason: servicemeshv1alpha1.SubsetHeldInGracePeriod, UnreadySince: since(2 * time.Minute), ExpireTime: expire(2 * time.Minute)},
+			},
+			wantUnready:      map[string]metav1.Time{"v2": since(2 * time.Minute)},
+			wantRequeueAfter: 3 * time.Minute,
+		},
+		{
+			name:        "grace period expired",
+			ready:       []string{"v1"},
+			current:     newRetainedDestinationRule(t, map[string]metav1.Time{"v2": since(10 * time.Minute)}, "v1", "v2"),
+			wantSubsets: []string{"v1"},
+		},
+		{
+			name:        "requeued when the first subset expires",
+			ready:       []string{"v1"},
+			current:     newRetainedDestinationRule(t, map[string]metav1.Time{"v2": since(time.Minute), "v3": since(4 * time.Minute)}, "v1", "v2", "v3"),
+			wantSubsets: []string{"v1", "v2", "v3"},
+			wantHeld: []servicemeshv1alpha1.HeldSubset{
+				{Name: "v2", Reason: servicemeshv1alpha1.SubsetHeldInGracePeriod, UnreadySince: since(time.Minute), ExpireTime: expire(time.Minute)},
+				{Name: "v3", Reason: servicemeshv1alpha1.SubsetHeldInGracePeriod, UnreadySince: since(4 * time.Minute), ExpireTime: expire(4 * time.Minute)},
+			},
+			wantUnready:      map[string]metav1.Time{"v2": since(time.Minute), "v3": since(4 * time.Minute)},
+			wantRequeueAfter: time.Minute,
+		},
+		{
+			name:        "held by strategies after grace period",
+			ready:       []string{"v1"},
+			current:     newRetainedDestinationRule(t, map[string]metav1.Time{"v2": since(10 * time.Minute)}, "v1", "v2"),
+			strategies:  []*servicemeshv1alpha1.Strategy{newRoutingStrategy("b", "v2"), newRoutingStrategy("a", "v2"), newRoutingStrategy("c", "v1")},
+			wantSubsets: []string{"v1", "v2"},
+			wantHeld: []servicemeshv1alpha1.HeldSubset{
+				{Name: "v2", Reason: servicemeshv1alpha1.SubsetHeldByStrategy, Strategies: []string{"a", "b"}, UnreadySince: since(10 * time.Minute)},
+			},
+			wantUnready: map[string]metav1.Time{"v2": since(10 * time.Minute)},
+		},
+		{
+			name:        "strategy being deleted holds nothing",
+			ready:       []string{"v1"},
+			current:     newRetainedDestinationRule(t, map[string]metav1.Time{"v2": since(10 * time.Minute)}, "v1", "v2"),
+			strategies:  []*servicemeshv1alpha1.Strategy{deleting},
+			wantSubsets: []string{"v1"},
+		},
+		{
+			name:  "invalid annotation",
+			ready: []string{"v1"},
+			current: func() *networkingv1beta1.DestinationRule {
+				dr := newRetainedDestinationRule(t, nil, "v1", "v2")
+				dr.Annotations = map[string]string{util.UnreadySubsetsAnnotation: "v2"}
+				return dr
+			}(),
+			wantSubsets: []string{"v1", "v2"},
+			wantHeld: []servicemeshv1alpha1.HeldSubset{
+				{Name: "v2", Reason: servicemeshv1alpha1.SubsetHeldInGracePeriod, UnreadySince: since(0), ExpireTime: expire(0)},
+			},
+			wantUnready:      map[string]metav1.Time{"v2": since(0)},
+			wantRequeueAfter: gracePeriod,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			spec := &networkingv1beta1api.DestinationRule{}
+			for _, subset := range test.ready {
+				spec.Subsets = append(spec.Subsets, &networkingv1beta1api.Subset{Name: subset, Labels: map[string]string{util.VersionLabel: subset}})
+			}
+
+			held, unready, requeueAfter := RetainSubsets(spec, test.current, test.strategies, gracePeriod, now)
+
+			names := make([]string, 0, len(spec.Subsets))
+			for _, subset := range spec.Subsets {
+				names = append(names, subset.Name)
+			}
+			if !reflect.DeepEqual(names, test.wantSubsets) {
+				t.Errorf("subsets %v, want %v", names, test.wantSubsets)
+			}
+
+			if !reflect.DeepEqual(held, test.wantHeld) {
+				t.Errorf("held subsets %+v, want %+v", held, test.wantHeld)
+			}
+
+			if requeueAfter != test.wantRequeueAfter {
+				t.Errorf("requeue after %s, want %s", requeueAfter, test.wantRequeueAfter)
+			}
+
+			if len(test.wantUnready) == 0 {
+				if len(unready) > 0 {
+					t.Errorf("unexpected unready subsets %s", unready)
+				}
+				return
+			}
+			got := newRetainedDestinationRule(t, nil)
+			got.Annotations = map[string]string{util.UnreadySubsetsAnnotation: unready}
+			if gotUnready := parseUnreadySubsets(got); !reflect.DeepEqual(gotUnready, test.wantUnready) {
+				t.Errorf("unready subsets %v, want %v", gotUnready, test.wantUnready)
+			}
+		})
+	}
+}
+
+func TestSyncServiceHeldSubsets(t *testing.T) {
+	tests := []struct {
+		name       string
+		current    func(t *testing.T, service string) *networkingv1beta1.DestinationRule
+		wantEvent  bool
+		wantDrift  bool
+		wantStatus []string
+	}{
+		{
+			name: "subset becomes held",
+			current: func(t *testing.T, service string) *networkingv1beta1.DestinationRule {
+				return newGeneratedDestinationRule(newTestService(service), "1")
+			},
+			wantEvent:  true,
+			wantStatus: []string{"v2"},
+		},
+		{
+			name: "subset already held",
+			current: func(t *testing.T, service string) *networkingv1beta1.DestinationRule {
+				dr := newGeneratedDestinationRule(newTestService(service), "1")
+				dr.Annotations = newRetainedDestinationRule(t, map[string]metav1.Time{"v2": metav1.NewTime(time.Now().Add(-time.Minute).Truncate(time.Second))}).Annotations
+				return dr
+			},
+			wantStatus: []string{"v2"},
+		},
+		{
+			name: "overridden destinationrule",
+			current: func(t *testing.T, service string) *networkingv1beta1.DestinationRule {
+				dr := newGeneratedDestinationRule(newTestService(service), "1")
+				dr.Annotations = map[string]string{util.OverrideAnnotation: "true"}
+				return dr
+			},
+			wantDrift:  true,
+			wantStatus: []string{"v2"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			current := test.current(t, "reviews")
+			current.Spec.Subsets = []*networkingv1beta1api.Subset{
+				{Name: "v1", Labels: map[string]string{util.VersionLabel: "v1"}},
+				{Name: "v2", Labels: map[string]string{util.VersionLabel: "v2"}},
+			}
+			sp := newTestServicePolicy("policy", 0)
+
+			f := newFixture(t, newTestService("reviews"), newTestDeployment("v1", 1), newTestDeployment("v2", 0), current, sp)
+			if err := f.controller.syncService(testNamespace + "/reviews"); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			var held []string
+			for _, event := range f.events() {
+				if strings.Contains(event, ReasonSubsetsHeld) {
+					held = append(held, event)
+				}
+			}
+			if (len(held) > 0) != test.wantEvent {
+				t.Errorf("events %v, want subsets held event %v", held, test.wantEvent)
+			}
+
+			got, err := f.servicemeshClient.ServicemeshV1alpha1().ServicePolicies(testNamespace).Get(context.TODO(), sp.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			var names []string
+			for _, subset := range got.Status.HeldSubsets {
+				names = append(names, subset.Name)
+			}
+			if !reflect.DeepEqual(names, test.wantStatus) {
+				t.Errorf("held subsets in status %v, want %v", names, test.wantStatus)
+			}
+			if drifted := util.GetServicePolicyCondition(got.Status, servicemeshv1alpha1.ServicePolicyDrifted) != nil; drifted != test.wantDrift {
+				t.Errorf("drifted %v, want %v", drifted, test.wantDrift)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package destinationrule
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"zmc.io/oasis/pkg/controller/scope"
+)
+
+func TestSyncServiceScope(t *testing.T) {
+	tests := []struct {
+		name        string
+		scope       *scope.Scope
+		wantCreated bool
+	}{
+		{name: "all namespaces", wantCreated: true},
+		{name: "namespace listed", scope: scope.NewScope([]string{testNamespace}, nil, nil, 1, 0), wantCreated: true},
+		{name: "namespace not listed", scope: scope.NewScope([]string{"staging"}, nil, nil, 1, 0)},
+		{name: "namespace of another shard", scope: scope.NewScope(nil, nil, nil, 2, 1-scope.Shard(testNamespace, 2))},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t, newTestService("reviews"), newTestDeployment("v1", 1))
+			f.controller.scope = test.scope
+
+			if err := f.controller.syncService(testNamespace + "/reviews"); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			_, err := f.istioClient.NetworkingV1beta1().DestinationRules(testNamespace).Get(context.TODO(), "reviews", metav1.GetOptions{})
+			if created := !errors.IsNotFound(err); created != test.wantCreated {
+				t.Errorf("destinationrule created %v, want %v", created, test.wantCreated)
+			}
+		})
+	}
+}
+
+func TestEnqueueNamespace(t *testing.T) {
+	other := newTestService("details")
+	other.Namespace = "staging"
+
+	f := newFixture(t, newTestService("reviews"), newTestService("ratings"), other)
+	f.controller.enqueueNamespace(testNamespace)
+
+	want := []string{"default/ratings", "default/reviews"}
+	if keys := queuedKeys(f.controller.queue); !reflect.DeepEqual(keys, want) {
+		t.Errorf("enqueued %v, want %v", keys, want)
+	}
+}
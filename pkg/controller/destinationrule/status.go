@@ -0,0 +1,126 @@
+package destinationrule
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	log "k8s.io/klog"
+
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+	"zmc.io/oasis/pkg/controller/virtualservice/util"
+)
+
+// reasons of servicepolicy conditions
+const (
+	ReasonDelivered       = "Delivered"
+	ReasonConflict        = "Conflict"
+	ReasonFailedToDeliver = "FailedToDeliver"
+	ReasonNotManaged      = "NotManaged"
+	ReasonOverridden      = "Overridden"
+
+	// ReasonSubsetsHeld is reason of events recorded on service when subsets become held
+	ReasonSubsetsHeld = "SubsetsHeld"
+)
+
+// servicePoliciesDelivered records every servicepolicy applied to service has been delivered,
+// drift is not empty when destinationrule is overridden on purpose, held are subsets kept
+// without ready workloads.
+func (v *DestinationRuleController) servicePoliciesDelivered(servicePolicies []*servicemeshv1alpha1.ServicePolicy, conflicts []string,
+	drift string, held []servicemeshv1alpha1.HeldSubset) error {
+	var errs []error
+	for i, sp := range servicePolicies {
+		if err := v.servicePolicyDelivered(sp, conflicts[i], drift, held); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
+
+// servicePolicyDelivered records servicepolicy has been delivered to istio, conflict is
+// not empty when part of servicepolicy is overridden by higher priority servicepolicies,
+// drift is not empty when destinationrule is overridden on purpose.
+func (v *DestinationRuleController) servicePolicyDelivered(sp *servicemeshv1alpha1.ServicePolicy, conflict, drift string,
+	held []servicemeshv1alpha1.HeldSubset) error {
+	if sp == nil {
+		return nil
+	}
+
+	return v.updateServicePolicyStatus(sp, func(status *servicemeshv1alpha1.ServicePolicyStatus) {
+		util.SetServicePolicyCondition(status, *util.NewServicePolicyCondition(servicemeshv1alpha1.ServicePolicyComplete, v1.ConditionTrue,
+			ReasonDelivered, "servicepolicy has been delivered to destinationrule"))
+
+		if len(conflict) > 0 {
+			util.SetServicePolicyCondition(status, *util.NewServicePolicyCondition(servicemeshv1alpha1.ServicePolicyConflicted, v1.ConditionTrue, ReasonConflict, conflict))
+		} else {
+			util.RemoveServicePolicyCondition(status, servicemeshv1alpha1.ServicePolicyConflicted)
+		}
+
+		if len(drift) > 0 {
+			util.SetServicePolicyCondition(status, *util.NewServicePolicyCondition(servicemeshv1alpha1.ServicePolicyDrifted, v1.ConditionTrue, ReasonOverridden, drift))
+		} else {
+			util.RemoveServicePolicyCondition(status, servicemeshv1alpha1.ServicePolicyDrifted)
+		}
+		status.HeldSubsets = held
+		util.RemoveServicePolicyCondition(status, servicemeshv1alpha1.ServicePolicyFailed)
+		if status.CompletionTime == nil {
+			now := metav1.Now()
+			status.CompletionTime = &now
+		}
+	})
+}
+
+// servicePolicyFailed records servicepolicy failed its delivery to istio
+func (v *DestinationRuleController) servicePolicyFailed(sp *servicemeshv1alpha1.ServicePolicy, reason string, err error) error {
+	if sp == nil {
+		return nil
+	}
+
+	return v.updateServicePolicyStatus(sp, func(status *servicemeshv1alpha1.ServicePolicyStatus) {
+		util.SetServicePolicyCondition(status, *util.NewServicePolicyCondition(servicemeshv1alpha1.ServicePolicyFailed, v1.ConditionTrue, reason, err.Error()))
+		util.SetServicePolicyCondition(status, *util.NewServicePolicyCondition(servicemeshv1alpha1.ServicePolicyComplete, v1.ConditionFalse, reason, err.Error()))
+		status.CompletionTime = nil
+	})
+}
+
+// updateServicePolicyStatus mutates a copy of servicepolicy status, writes it through status
+// subresource if anything changes, and records an event when conditions transit.
+func (v *DestinationRuleController) updateServicePolicyStatus(sp *servicemeshv1alpha1.ServicePolicy, mutate func(status *servicemeshv1alpha1.ServicePolicyStatus)) error {
+	status := sp.Status.DeepCopy()
+
+	// servicepolicy acknowledged for the first time, or changed since last time
+	if status.StartTime == nil || status.ObservedGeneration != sp.Generation {
+		now := metav1.Now()
+		status.StartTime = &now
+		status.CompletionTime = nil
+		status.ObservedGeneration = sp.Generation
+	}
+
+	mutate(status)
+
+	if equality.Semantic.DeepEqual(status, &sp.Status) {
+		return nil
+	}
+
+	newServicePolicy := sp.DeepCopy()
+	newServicePolicy.Status = *status
+	newServicePolicy, err := v.servicemeshClient.ServicemeshV1alpha1().ServicePolicies(sp.Namespace).UpdateStatus(context.TODO(), newServicePolicy, metav1.UpdateOptions{})
+	if err != nil {
+		log.Errorf("update servicepolicy %s/%s status failed, %v", sp.Namespace, sp.Name, err)
+		return err
+	}
+
+	oldCond := util.GetServicePolicyCondition(sp.Status, servicemeshv1alpha1.ServicePolicyComplete)
+	newCond := util.GetServicePolicyCondition(*status, servicemeshv1alpha1.ServicePolicyComplete)
+	if newCond != nil && (oldCond == nil || oldCond.Status != newCond.Status || oldCond.Reason != newCond.Reason) {
+		failed := util.GetServicePolicyCondition(*status, servicemeshv1alpha1.ServicePolicyFailed)
+		eventType := util.ConditionEventType(failed != nil && failed.Status == v1.ConditionTrue)
+		v.eventRecorder.Event(newServicePolicy, eventType, newCond.Reason, fmt.Sprintf("ServicePolicy %s/%s: %s", sp.Namespace, sp.Name, newCond.Message))
+	}
+
+	return nil
+}
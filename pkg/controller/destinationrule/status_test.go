@@ -0,0 +1,197 @@
+package destinationrule
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+	"zmc.io/oasis/pkg/controller/virtualservice/util"
+)
+
+func TestServicePolicyStatus(t *testing.T) {
+	held := []servicemeshv1alpha1.HeldSubset{{Name: "v2", Reason: servicemeshv1alpha1.SubsetHeldInGracePeriod}}
+
+	tests := []struct {
+		name    string
+		prepare func(v *DestinationRuleController, sp *servicemeshv1alpha1.ServicePolicy) error
+		update  func(v *DestinationRuleController, sp *servicemeshv1alpha1.ServicePolicy) error
+
+		wantComplete v1.ConditionStatus
+		wantReason   string
+		wantConflict string
+		wantDrift    string
+		wantHeld     int
+		wantEvent    string
+		// start time is reset since status was prepared
+		wantRestarted bool
+	}{
+		{
+			name: "delivered",
+			update: func(v *DestinationRuleController, sp *servicemeshv1alpha1.ServicePolicy) error {
+				return v.servicePolicyDelivered(sp, "", "", nil)
+			},
+			wantComplete: v1.ConditionTrue,
+			wantReason:   ReasonDelivered,
+			wantEvent:    "Normal Delivered",
+		},
+		{
+			name: "delivered with held subsets",
+			update: func(v *DestinationRuleController, sp *servicemeshv1alpha1.ServicePolicy) error {
+				return v.servicePolicyDelivered(sp, "", "", held)
+			},
+			wantComplete: v1.ConditionTrue,
+			wantReason:   ReasonDelivered,
+			wantHeld:     1,
+			wantEvent:    "Normal Delivered",
+		},
+		{
+			name: "delivered again",
+			prepare: func(v *DestinationRuleController, sp *servicemeshv1alpha1.ServicePolicy) error {
+				return v.servicePolicyDelivered(sp, "", "", nil)
+			},
+			update: func(v *DestinationRuleController, sp *servicemeshv1alpha1.ServicePolicy) error {
+				return v.servicePolicyDelivered(sp, "", "", nil)
+			},
+			wantComplete: v1.ConditionTrue,
+			wantReason:   ReasonDelivered,
+		},
+		{
+			name: "delivered again after spec changed",
+			prepare: func(v *DestinationRuleController, sp *servicemeshv1alpha1.ServicePolicy) error {
+				return v.servicePolicyDelivered(sp, "", "", nil)
+			},
+			update: func(v *DestinationRuleController, sp *servicemeshv1alpha1.ServicePolicy) error {
+				sp.Generation++
+				return v.servicePolicyDelivered(sp, "", "", nil)
+			},
+			wantComplete:  v1.ConditionTrue,
+			wantReason:    ReasonDelivered,
+			wantRestarted: true,
+		},
+		{
+			name: "delivered with conflict",
+			update: func(v *DestinationRuleController, sp *servicemeshv1alpha1.ServicePolicy) error {
+				return v.servicePolicyDelivered(sp, "subset v2 is overridden by servicepolicy a", "", nil)
+			},
+			wantComplete: v1.ConditionTrue,
+			wantReason:   ReasonDelivered,
+			wantConflict: "subset v2 is overridden by servicepolicy a",
+			wantEvent:    "Normal Delivered",
+		},
+		{
+			name: "drift message changed",
+			prepare: func(v *DestinationRuleController, sp *servicemeshv1alpha1.ServicePolicy) error {
+				return v.servicePolicyDelivered(sp, "", "subsets differ", nil)
+			},
+			update: func(v *DestinationRuleController, sp *servicemeshv1alpha1.ServicePolicy) error {
+				return v.servicePolicyDelivered(sp, "", "traffic policy differs", nil)
+			},
+			wantComplete: v1.ConditionTrue,
+			wantReason:   ReasonDelivered,
+			wantDrift:    "traffic policy differs",
+		},
+		{
+			name: "recovered from failure",
+			prepare: func(v *DestinationRuleController, sp *servicemeshv1alpha1.ServicePolicy) error {
+				return v.servicePolicyFailed(sp, ReasonFailedToDeliver, errors.New("forbidden"))
+			},
+			update: func(v *DestinationRuleController, sp *servicemeshv1alpha1.ServicePolicy) error {
+				return v.servicePolicyDelivered(sp, "", "", nil)
+			},
+			wantComplete: v1.ConditionTrue,
+			wantReason:   ReasonDelivered,
+			wantEvent:    "Normal Delivered",
+		},
+		{
+			name: "failed",
+			update: func(v *DestinationRuleController, sp *servicemeshv1alpha1.ServicePolicy) error {
+				return v.servicePolicyFailed(sp, ReasonFailedToDeliver, errors.New("forbidden"))
+			},
+			wantComplete: v1.ConditionFalse,
+			wantReason:   ReasonFailedToDeliver,
+			wantEvent:    "Warning FailedToDeliver",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sp := newTestServicePolicy("policy", 0)
+			f := newFixture(t, sp)
+
+			get := func() *servicemeshv1alpha1.ServicePolicy {
+				got, err := f.servicemeshClient.ServicemeshV1alpha1().ServicePolicies(testNamespace).Get(context.TODO(), sp.Name, metav1.GetOptions{})
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				return got
+			}
+
+			if test.prepare != nil {
+				if err := test.prepare(f.controller, sp); err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				sp = get()
+				f.events()
+			}
+
+			prepared := sp.Status.DeepCopy()
+			if err := test.update(f.controller, sp); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			got := get()
+
+			if got.Status.StartTime == nil || got.Status.ObservedGeneration != sp.Generation {
+				t.Errorf("servicepolicy is not acknowledged, status %v", got.Status)
+			}
+			if restarted := prepared.StartTime != nil && !got.Status.StartTime.Equal(prepared.StartTime); restarted != test.wantRestarted {
+				t.Errorf("start time %v, prepared %v, want restarted %v", got.Status.StartTime, prepared.StartTime, test.wantRestarted)
+			}
+			if (got.Status.CompletionTime != nil) != (test.wantComplete == v1.ConditionTrue) ||
+				(test.wantRestarted && !got.Status.CompletionTime.After(prepared.CompletionTime.Time)) {
+				t.Errorf("completion time %v, prepared %v, want complete %s", got.Status.CompletionTime, prepared.CompletionTime, test.wantComplete)
+			}
+
+			complete := util.GetServicePolicyCondition(got.Status, servicemeshv1alpha1.ServicePolicyComplete)
+			if complete == nil || complete.Status != test.wantComplete || complete.Reason != test.wantReason {
+				t.Errorf("complete condition %v, want %s %s", complete, test.wantComplete, test.wantReason)
+			}
+
+			conflicted := util.GetServicePolicyCondition(got.Status, servicemeshv1alpha1.ServicePolicyConflicted)
+			if len(test.wantConflict) == 0 && conflicted != nil {
+				t.Errorf("unexpected condition %v", conflicted)
+			}
+			if len(test.wantConflict) > 0 && (conflicted == nil || conflicted.Message != test.wantConflict) {
+				t.Errorf("conflicted condition %v, want message %q", conflicted, test.wantConflict)
+			}
+
+			drifted := util.GetServicePolicyCondition(got.Status, servicemeshv1alpha1.ServicePolicyDrifted)
+			if len(test.wantDrift) == 0 && drifted != nil {
+				t.Errorf("unexpected condition %v", drifted)
+			}
+			if len(test.wantDrift) > 0 && (drifted == nil || drifted.Message != test.wantDrift) {
+				t.Errorf("drifted condition %v, want message %q", drifted, test.wantDrift)
+			}
+
+			if len(got.Status.HeldSubsets) != test.wantHeld {
+				t.Errorf("held subsets %v, want %d", got.Status.HeldSubsets, test.wantHeld)
+			}
+
+			if failed := util.GetServicePolicyCondition(got.Status, servicemeshv1alpha1.ServicePolicyFailed); (failed != nil) != (test.wantComplete != v1.ConditionTrue) {
+				t.Errorf("failed condition %v, want complete %s", failed, test.wantComplete)
+			}
+
+			events := f.events()
+			if len(test.wantEvent) == 0 && len(events) > 0 {
+				t.Errorf("unexpected events %v", events)
+			}
+			if len(test.wantEvent) > 0 && (len(events) != 1 || !strings.HasPrefix(events[0], test.wantEvent)) {
+				t.Errorf("events %v, want %s", events, test.wantEvent)
+			}
+		})
+	}
+}
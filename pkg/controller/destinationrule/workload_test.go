@@ -0,0 +1,209 @@
+package destinationrule
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/cache"
+
+	"zmc.io/oasis/pkg/controller/virtualservice/util"
+	"zmc.io/oasis/pkg/controller/workload"
+	"zmc.io/oasis/pkg/informers"
+)
+
+// newTestWorkload returns a ready workload of kind running version of the application
+func newTestWorkload(kind, version string) *workload.Workload {
+	labels := testApplicationLabels()
+	labels[util.VersionLabel] = version
+
+	return &workload.Workload{
+		Kind:          kind,
+		Namespace:     testNamespace,
+		Name:          testApp + "-" + version,
+		Labels:        labels,
+		Annotations:   map[string]string{util.ServiceMeshEnabledAnnotation: "true"},
+		Selector:      labels,
+		ReadyReplicas: 1,
+	}
+}
+
+func newTestRollout(version string, readyReplicas int64) *unstructured.Unstructured {
+	labels := testApplicationLabels()
+	labels[util.VersionLabel] = version
+
+	rollout := &unstructured.Unstructured{}
+	rollout.SetAPIVersion(workload.RolloutResource.GroupVersion().String())
+	rollout.SetKind(workload.RolloutKind)
+	rollout.SetNamespace(testNamespace)
+	rollout.SetName(testApp + "-" + version)
+	rollout.SetLabels(labels)
+	rollout.SetAnnotations(map[string]string{util.ServiceMeshEnabledAnnotation: "true"})
+	_ = unstructured.SetNestedStringMap(rollout.Object, labels, "spec", "selector", "matchLabels")
+	_ = unstructured.SetNestedField(rollout.Object, readyReplicas, "status", "readyReplicas")
+	return rollout
+}
+
+func TestGenerateDestinationRuleSpecSubsets(t *testing.T) {
+	tests := []struct {
+		name      string
+		workloads func() []*workload.Workload
+		want      []string
+	}{
+		{
+			name: "workloads of every kind",
+			workloads: func() []*workload.Workload {
+				return []*workload.Workload{
+					newTestWorkload(workload.DeploymentKind, "v1"),
+					newTestWorkload(workload.StatefulSetKind, "v2"),
+					newTestWorkload(workload.DaemonSetKind, "v3"),
+					newTestWorkload(workload.RolloutKind, "v4"),
+				}
+			},
+			want: []string{"v1", "v2", "v3", "v4"},
+		},
+		{
+			name: "same version of different kinds",
+			workloads: func() []*workload.Workload {
+				return []*workload.Workload{
+					newTestWorkload(workload.DeploymentKind, "v1"),
+					newTestWorkload(workload.RolloutKind, "v1"),
+				}
+			},
+			want: []string{"v1"},
+		},
+		{
+			name: "version names normalized",
+			workloads: func() []*workload.Workload {
+				return []*workload.Workload{newTestWorkload(workload.RolloutKind, "v1.2_3")}
+			},
+			want: []string{util.NormalizeVersionName("v1.2_3")},
+		},
+		{
+			name: "unready workloads skipped",
+			workloads: func() []*workload.Workload {
+				unready := newTestWorkload(workload.RolloutKind, "v2")
+				unready.ReadyReplicas = 0
+				return []*workload.Workload{newTestWorkload(workload.DeploymentKind, "v1"), unready}
+			},
+			want: []string{"v1"},
+		},
+		{
+			name: "workloads without version skipped",
+			workloads: func() []*workload.Workload {
+				unversioned := newTestWorkload(workload.StatefulSetKind, "v2")
+				delete(unversioned.Labels, util.VersionLabel)
+				return []*workload.Workload{newTestWorkload(workload.DeploymentKind, "v1"), unversioned}
+			},
+			want: []string{"v1"},
+		},
+		{
+			name: "workloads out of servicemesh skipped",
+			workloads: func() []*workload.Workload {
+				disabled := newTestWorkload(workload.DaemonSetKind, "v2")
+				disabled.Annotations = nil
+				notComponent := newTestWorkload(workload.DeploymentKind, "v3")
+				notComponent.Selector = map[string]string{util.VersionLabel: "v3"}
+				return []*workload.Workload{newTestWorkload(workload.DeploymentKind, "v1"), disabled, notComponent}
+			},
+			want: []string{"v1"},
+		},
+		{
+			name:      "no workloads",
+			workloads: func() []*workload.Workload { return nil },
+			want:      []string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			spec, _ := GenerateDestinationRuleSpec(newTestService("reviews"), test.workloads(), nil)
+
+			names := make([]string, 0, len(spec.Subsets))
+			for _, subset := range spec.Subsets {
+				names = append(names, subset.Name)
+			}
+			if !reflect.DeepEqual(names, test.want) {
+				t.Errorf("subsets %v, want %v", names, test.want)
+			}
+			if spec.Host != util.ServiceFQDN(newTestService("reviews")) {
+				t.Errorf("host %s, want fqdn of service", spec.Host)
+			}
+		})
+	}
+}
+
+func TestAddWorkload(t *testing.T) {
+	other := newTestService("ratings")
+	other.Spec.Selector = map[string]string{util.AppLabel: "ratings"}
+	disabled := newTestService("reviews-disabled")
+	disabled.Annotations = nil
+
+	deployment := newTestDeployment("v1", 1)
+	notComponent := newTestDeployment("v2", 1)
+	notComponent.Spec.Selector.MatchLabels = map[string]string{util.VersionLabel: "v2"}
+
+	tests := []struct {
+		name   string
+		delete bool
+		obj    interface{}
+		want   []string
+	}{
+		{name: "deployment", obj: deployment, want: []string{"default/reviews"}},
+		{name: "rollout", obj: newTestRollout("v2", 1), want: []string{"default/reviews"}},
+		{name: "not a component", obj: notComponent, want: []string{}},
+		{name: "deleted deployment", delete: true, obj: deployment, want: []string{"default/reviews"}},
+		{name: "tombstone of rollout", delete: true, obj: cache.DeletedFinalStateUnknown{Key: "default/reviews-v2", Obj: newTestRollout("v2", 1)}, want: []string{"default/reviews"}},
+		{name: "tombstone of another kind", delete: true, obj: cache.DeletedFinalStateUnknown{Key: "default/reviews-v2", Obj: &v1.Pod{}}, want: []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t, newTestService("reviews"), other, disabled)
+			rolloutSource := workload.NewRolloutSource(informers.NewDynamicInformerFactory(dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())))
+
+			// objects are handled by source of their kind, others by the deployment one
+			obj := test.obj
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			source := f.controller.workloadSources[0]
+			for _, s := range append(f.controller.workloadSources, rolloutSource) {
+				if _, ok := s.Workload(obj); ok {
+					source = s
+				}
+			}
+
+			if test.delete {
+				f.controller.deleteWorkload(source, test.obj)
+			} else {
+				f.controller.addWorkload(source, test.obj)
+			}
+
+			if keys := queuedKeys(f.controller.queue); !reflect.DeepEqual(keys, test.want) {
+				t.Errorf("enqueued %v, want %v", keys, test.want)
+			}
+		})
+	}
+}
+
+func TestSyncServiceRolloutSubsets(t *testing.T) {
+	f := newFixture(t, newTestService("reviews"), newTestDeployment("v1", 1))
+	f.controller.workloadSources = append(f.controller.workloadSources,
+		workload.NewRolloutSource(informers.NewDynamicInformerFactory(dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()))))
+	f.addToCache(newTestRollout("v2", 1))
+	f.addToCache(newTestRollout("v3", 0))
+
+	dr := f.sync("reviews")
+
+	names := make([]string, 0, len(dr.Spec.Subsets))
+	for _, subset := range dr.Spec.Subsets {
+		names = append(names, subset.Name)
+	}
+	if want := []string{"v1", "v2"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("subsets %v, want %v", names, want)
+	}
+}
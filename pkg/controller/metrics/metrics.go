@@ -0,0 +1,123 @@
+package metrics
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	// registering workqueue metrics provider, depth, adds, latency and retries of
+	// named controller queues are exposed along with metrics here
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	subsystem = "oasis_controller"
+
+	ResultSuccess = "success"
+	ResultError   = "error"
+
+	// ReasonUnknown labels errors carrying no reason
+	ReasonUnknown = "Unknown"
+)
+
+var (
+	reconcileTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: subsystem,
+		Name:      "reconcile_total",
+		Help:      "Total number of reconciliations per controller and result.",
+	}, []string{"controller", "result"})
+
+	reconcileErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: subsystem,
+		Name:      "reconcile_errors_total",
+		Help:      "Total number of reconciliation errors per controller and reason.",
+	}, []string{"controller", "reason"})
+
+	reconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Subsystem: subsystem,
+		Name:      "reconcile_duration_seconds",
+		Help:      "Time taken to sync a service per controller.",
+		Buckets:   []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	}, []string{"controller"})
+
+	activeStrategyServices = prometheus.NewGauge(prometheus.GaugeOpts{
+		Subsystem: subsystem,
+		Name:      "active_strategy_services",
+		Help:      "Number of services with at least one strategy applied.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(reconcileTotal, reconcileErrors, reconcileDuration, activeStrategyServices)
+}
+
+// reasonError is an error labeled with the reason it is recorded by
+type reasonError struct {
+	reason string
+	err    error
+}
+
+func (e *reasonError) Error() string {
+	return e.err.Error()
+}
+
+func (e *reasonError) Unwrap() error {
+	return e.err
+}
+
+// WithReason labels err with reason for error metrics, nil err stays nil
+func WithReason(reason string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &reasonError{reason: reason, err: err}
+}
+
+// ReasonForError returns reason err is labeled with, or reason of api status
+// error, or ReasonUnknown.
+func ReasonForError(err error) string {
+	var re *reasonError
+	if errors.As(err, &re) {
+		return re.reason
+	}
+
+	if reason := apierrors.ReasonForError(err); len(reason) > 0 {
+		return string(reason)
+	}
+
+	return ReasonUnknown
+}
+
+// ObserveReconcile records result and duration of a reconciliation started at startTime
+func ObserveReconcile(controller string, startTime time.Time, err error) {
+	reconcileDuration.WithLabelValues(controller).Observe(time.Since(startTime).Seconds())
+
+	if err != nil {
+		reconcileTotal.WithLabelValues(controller, ResultError).Inc()
+		reconcileErrors.WithLabelValues(controller, ReasonForError(err)).Inc()
+		return
+	}
+
+	reconcileTotal.WithLabelValues(controller, ResultSuccess).Inc()
+}
+
+var activeServices = struct {
+	sync.Mutex
+	keys sets.String
+}{keys: sets.NewString()}
+
+// SetStrategiesActive tells whether service of key has any strategy applied
+func SetStrategiesActive(key string, active bool) {
+	activeServices.Lock()
+	defer activeServices.Unlock()
+
+	if active {
+		activeServices.keys.Insert(key)
+	} else {
+		activeServices.keys.Delete(key)
+	}
+	activeStrategyServices.Set(float64(activeServices.keys.Len()))
+}
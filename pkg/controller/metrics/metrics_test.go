@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestReasonForError(t *testing.T) {
+	notFound := apierrors.NewNotFound(schema.GroupResource{Resource: "services"}, "reviews")
+
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "labeled", err: WithReason("FailedToDeliver", errors.New("conflict")), want: "FailedToDeliver"},
+		{name: "labeled and wrapped", err: fmt.Errorf("sync: %w", WithReason("FailedToDeliver", errors.New("conflict"))), want: "FailedToDeliver"},
+		{name: "labeled api status error", err: WithReason("FailedToDeliver", notFound), want: "FailedToDeliver"},
+		{name: "api status error", err: notFound, want: string(apierrors.ReasonForError(notFound))},
+		{name: "plain error", err: errors.New("conflict"), want: ReasonUnknown},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := ReasonForError(test.err); got != test.want {
+				t.Errorf("reason %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWithReason(t *testing.T) {
+	if err := WithReason("FailedToDeliver", nil); err != nil {
+		t.Errorf("nil error labeled as %v", err)
+	}
+
+	cause := errors.New("conflict")
+	err := WithReason("FailedToDeliver", cause)
+	if !errors.Is(err, cause) || err.Error() != cause.Error() {
+		t.Errorf("error %v doesn't wrap %v", err, cause)
+	}
+}
+
+func TestObserveReconcile(t *testing.T) {
+	tests := []struct {
+		name       string
+		controller string
+		err        error
+
+		wantSuccess float64
+		wantError   float64
+		wantReason  string
+	}{
+		{name: "success", controller: "test-success", wantSuccess: 1},
+		{name: "labeled error", controller: "test-labeled", err: WithReason("FailedToDeliver", errors.New("conflict")), wantError: 1, wantReason: "FailedToDeliver"},
+		{name: "unknown error", controller: "test-unknown", err: errors.New("conflict"), wantError: 1, wantReason: ReasonUnknown},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ObserveReconcile(test.controller, time.Now(), test.err)
+
+			if got := testutil.ToFloat64(reconcileTotal.WithLabelValues(test.controller, ResultSuccess)); got != test.wantSuccess {
+				t.Errorf("successful reconciliations %v, want %v", got, test.wantSuccess)
+			}
+			if got := testutil.ToFloat64(reconcileTotal.WithLabelValues(test.controller, ResultError)); got != test.wantError {
+				t.Errorf("failed reconciliations %v, want %v", got, test.wantError)
+			}
+			if len(test.wantReason) > 0 {
+				if got := testutil.ToFloat64(reconcileErrors.WithLabelValues(test.controller, test.wantReason)); got != 1 {
+					t.Errorf("errors of reason %s %v, want 1", test.wantReason, got)
+				}
+			}
+			if got := testutil.CollectAndCount(reconcileDuration); got == 0 {
+				t.Errorf("reconcile duration not observed")
+			}
+		})
+	}
+}
+
+func TestSetStrategiesActive(t *testing.T) {
+	tests := []struct {
+		name   string
+		key    string
+		active bool
+		want   float64
+	}{
+		{name: "first service active", key: "default/reviews", active: true, want: 1},
+		{name: "same service again", key: "default/reviews", active: true, want: 1},
+		{name: "second service active", key: "default/ratings", active: true, want: 2},
+		{name: "service inactive", key: "default/reviews", active: false, want: 1},
+		{name: "unknown service inactive", key: "default/details", active: false, want: 1},
+		{name: "last service inactive", key: "default/ratings", active: false, want: 0},
+	}
+
+	// steps run in order, every one starts from where the last one left
+	for _, test := range tests {
+		SetStrategiesActive(test.key, test.active)
+		if got := testutil.ToFloat64(activeStrategyServices); got != test.want {
+			t.Errorf("%s: active services %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package scope
+
+import (
+	"fmt"
+	"hash/fnv"
+	"reflect"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Scope decides which namespaces controllers reconcile. A namespace is in scope
+// if it is listed, or no namespace is listed, if its labels match the selector,
+// and if it is hashed into the shard. Objects of namespaces out of scope are
+// left as they are, so they can be reconciled by other controller-managers.
+type Scope struct {
+	namespaces sets.String
+	selector   labels.Selector
+
+	namespaceInformer coreinformers.NamespaceInformer
+	namespaceLister   corelisters.NamespaceLister
+
+	shards int
+	shard  int
+}
+
+// NewScope creates a scope, namespaceInformer is watched only if selector selects anything
+// other than everything, and namespaces are split into shards if shards is greater than 1.
+func NewScope(namespaces []string, selector labels.Selector, namespaceInformer coreinformers.NamespaceInformer, shards, shard int) *Scope {
+	s := &Scope{
+		namespaces: sets.NewString(namespaces...),
+		selector:   selector,
+		shards:     shards,
+		shard:      shard,
+	}
+
+	if s.selector == nil {
+		s.selector = labels.Everything()
+	}
+
+	if !s.selector.Empty() {
+		s.namespaceInformer = namespaceInformer
+		s.namespaceLister = namespaceInformer.Lister()
+	}
+
+	return s
+}
+
+// Contains tells if objects of namespace are reconciled, a nil scope contains every namespace
+func (s *Scope) Contains(namespace string) bool {
+	if s == nil {
+		return true
+	}
+
+	if s.namespaces.Len() > 0 && !s.namespaces.Has(namespace) {
+		return false
+	}
+
+	if s.namespaceLister != nil {
+		ns, err := s.namespaceLister.Get(namespace)
+		if err != nil || !s.selector.Matches(labels.Set(ns.Labels)) {
+			return false
+		}
+	}
+
+	return s.shards <= 1 || Shard(namespace, s.shards) == s.shard
+}
+
+// HasSynced tells if namespaces are cached when they are selected by labels
+func (s *Scope) HasSynced() bool {
+	if s == nil || s.namespaceInformer == nil {
+		return true
+	}
+	return s.namespaceInformer.Informer().HasSynced()
+}
+
+// AddNamespaceHandler calls handler with name of namespace whose labels are changed,
+// so objects of namespace moved into scope are reconciled at once.
+func (s *Scope) AddNamespaceHandler(handler func(namespace string)) {
+	if s == nil || s.namespaceInformer == nil {
+		return
+	}
+
+	s.namespaceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(old, cur interface{}) {
+			oldNamespace := old.(*v1.Namespace)
+			curNamespace := cur.(*v1.Namespace)
+			if !reflect.DeepEqual(oldNamespace.Labels, curNamespace.Labels) {
+				handler(curNamespace.Name)
+			}
+		},
+	})
+}
+
+func (s *Scope) String() string {
+	if s == nil {
+		return "all namespaces"
+	}
+	return fmt.Sprintf("namespaces %v, selector %q, shard %d of %d", s.namespaces.List(), s.selector.String(), s.shard, s.shards)
+}
+
+// Shard returns index of the shard namespace is hashed into
+func Shard(namespace string, shards int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(namespace))
+	return int(h.Sum32() % uint32(shards))
+}
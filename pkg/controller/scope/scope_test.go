@@ -0,0 +1,130 @@
+package scope
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// shardOf returns a namespace hashed into shard of shards
+func shardOf(t *testing.T, shard, shards int) string {
+	for _, namespace := range []string{"default", "staging", "production", "bookinfo", "istio-system", "team-a", "team-b", "team-c"} {
+		if Shard(namespace, shards) == shard {
+			return namespace
+		}
+	}
+	t.Fatalf("no namespace is hashed into shard %d of %d", shard, shards)
+	return ""
+}
+
+func TestContains(t *testing.T) {
+	factory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	namespaceInformer := factory.Core().V1().Namespaces()
+	for name, env := range map[string]string{"staging": "staging", "production": "production"} {
+		_ = namespaceInformer.Informer().GetIndexer().Add(&v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"env": env}},
+		})
+	}
+
+	staging := labels.SelectorFromSet(map[string]string{"env": "staging"})
+	inShard := shardOf(t, 1, 2)
+	outOfShard := shardOf(t, 0, 2)
+
+	tests := []struct {
+		name       string
+		namespaces []string
+		selector   labels.Selector
+		shards     int
+		shard      int
+
+		namespace string
+		want      bool
+	}{
+		{name: "everything", shards: 1, namespace: "default", want: true},
+		{name: "listed", namespaces: []string{"default", "staging"}, shards: 1, namespace: "staging", want: true},
+		{name: "not listed", namespaces: []string{"default"}, shards: 1, namespace: "staging", want: false},
+		{name: "selected", selector: staging, shards: 1, namespace: "staging", want: true},
+		{name: "not selected", selector: staging, shards: 1, namespace: "production", want: false},
+		{name: "unknown namespace not selected", selector: staging, shards: 1, namespace: "default", want: false},
+		{name: "listed but not selected", namespaces: []string{"production"}, selector: staging, shards: 1, namespace: "production", want: false},
+		{name: "in shard", shards: 2, shard: 1, namespace: inShard, want: true},
+		{name: "out of shard", shards: 2, shard: 1, namespace: outOfShard, want: false},
+		{name: "listed but out of shard", namespaces: []string{outOfShard}, shards: 2, shard: 1, namespace: outOfShard, want: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := NewScope(test.namespaces, test.selector, namespaceInformer, test.shards, test.shard)
+			if got := s.Contains(test.namespace); got != test.want {
+				t.Errorf("%s contains %s %v, want %v", s, test.namespace, got, test.want)
+			}
+		})
+	}
+}
+
+func TestNilScope(t *testing.T) {
+	var s *Scope
+	if !s.Contains("default") {
+		t.Errorf("nil scope doesn't contain default")
+	}
+	if !s.HasSynced() {
+		t.Errorf("nil scope is not synced")
+	}
+	// no namespace is watched
+	s.AddNamespaceHandler(func(string) { t.Errorf("unexpected call") })
+}
+
+func TestNamespaceInformerWatched(t *testing.T) {
+	factory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	namespaceInformer := factory.Core().V1().Namespaces()
+
+	tests := []struct {
+		name        string
+		selector    labels.Selector
+		wantWatched bool
+	}{
+		{name: "nil selector"},
+		{name: "everything", selector: labels.Everything()},
+		{name: "selector", selector: labels.SelectorFromSet(map[string]string{"env": "staging"}), wantWatched: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := NewScope(nil, test.selector, namespaceInformer, 1, 0)
+			if watched := s.namespaceInformer != nil; watched != test.wantWatched {
+				t.Errorf("namespaces watched %v, want %v", watched, test.wantWatched)
+			}
+			// namespaces are never synced as the informer doesn't run
+			if synced := s.HasSynced(); synced != !test.wantWatched {
+				t.Errorf("synced %v, want %v", synced, !test.wantWatched)
+			}
+		})
+	}
+}
+
+func TestShard(t *testing.T) {
+	tests := []struct {
+		namespace string
+		shards    int
+	}{
+		{namespace: "default", shards: 1},
+		{namespace: "default", shards: 2},
+		{namespace: "staging", shards: 3},
+		{namespace: "production", shards: 7},
+	}
+
+	for _, test := range tests {
+		shard := Shard(test.namespace, test.shards)
+		if shard < 0 || shard >= test.shards {
+			t.Errorf("shard %d of %s out of [0, %d)", shard, test.namespace, test.shards)
+		}
+		// every controller-manager computes the same shard
+		if again := Shard(test.namespace, test.shards); again != shard {
+			t.Errorf("shard of %s changed from %d to %d", test.namespace, shard, again)
+		}
+	}
+}
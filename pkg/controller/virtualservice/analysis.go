@@ -0,0 +1,98 @@
+package virtualservice
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/prometheus/common/model"
+	v1 "k8s.io/api/core/v1"
+
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+	"zmc.io/oasis/pkg/simple/client/prometheus"
+)
+
+const (
+	// default time range canary metrics evaluated over
+	defaultAnalysisInterval = time.Minute
+
+	// reason of a strategy failed because canary breached its thresholds
+	ReasonAnalysisFailed = "AnalysisFailed"
+
+	// reasons of canary analysis unavailable
+	ReasonPrometheusNotConfigured = "PrometheusNotConfigured"
+	ReasonMetricsQueryFailed      = "MetricsQueryFailed"
+
+	errorRateQuery = `sum(rate(istio_requests_total{reporter="destination",destination_workload_namespace="%[1]s",destination_service_name="%[2]s",destination_version="%[3]s",response_code=~"5.*"}[%[4]s])) ` +
+		`/ sum(rate(istio_requests_total{reporter="destination",destination_workload_namespace="%[1]s",destination_service_name="%[2]s",destination_version="%[3]s"}[%[4]s])) * 100`
+
+	latencyQuery = `histogram_quantile(0.99, sum(rate(istio_request_duration_milliseconds_bucket{reporter="destination",destination_workload_namespace="%[1]s",destination_service_name="%[2]s",destination_version="%[3]s"}[%[4]s])) by (le))`
+)
+
+func analysisInterval(strategy *servicemeshv1alpha1.Strategy) time.Duration {
+	if strategy.Spec.Analysis == nil || strategy.Spec.Analysis.Interval.Duration <= 0 {
+		return defaultAnalysisInterval
+	}
+	return strategy.Spec.Analysis.Interval.Duration
+}
+
+// analyzeCanary queries canary version metrics of service, returns a message
+// describing the breached threshold, or empty if canary is healthy.
+func (v *VirtualServiceController) analyzeCanary(strategy *servicemeshv1alpha1.Strategy, service *v1.Service) (string, error) {
+	analysis := strategy.Spec.Analysis
+	interval := model.Duration(analysisInterval(strategy)).String()
+
+	if analysis.MaxErrorRate != nil {
+		query := fmt.Sprintf(errorRateQuery, service.Namespace, service.Name, strategy.Spec.CanaryVersion, interval)
+		errorRate, err := v.queryMetric(query)
+		if err != nil {
+			return "", err
+		}
+
+		if errorRate > float64(*analysis.MaxErrorRate) {
+			return fmt.Sprintf("error rate %.2f%% of version %s exceeds %d%%", errorRate, strategy.Spec.CanaryVersion, *analysis.MaxErrorRate), nil
+		}
+	}
+
+	if analysis.MaxLatency != nil {
+		query := fmt.Sprintf(latencyQuery, service.Namespace, service.Name, strategy.Spec.CanaryVersion, interval)
+		latency, err := v.queryMetric(query)
+		if err != nil {
+			return "", err
+		}
+
+		if time.Duration(latency*float64(time.Millisecond)) > analysis.MaxLatency.Duration {
+			return fmt.Sprintf("p99 latency %.0fms of version %s exceeds %s", latency, strategy.Spec.CanaryVersion, analysis.MaxLatency.Duration), nil
+		}
+	}
+
+	return "", nil
+}
+
+// queryMetric returns 0 when there is no traffic at all
+func (v *VirtualServiceController) queryMetric(query string) (float64, error) {
+	value, err := v.prometheusClient.Query(context.TODO(), query)
+	if err != nil {
+		if err == prometheus.ErrNoData {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	if math.IsNaN(value) {
+		return 0, nil
+	}
+
+	return value, nil
+}
+
+// isCanaryRolledBack tells if canary has been rolled back to principal version
+func isCanaryRolledBack(status *servicemeshv1alpha1.StrategyStatus) bool {
+	for _, c := range status.Conditions {
+		if c.Type == servicemeshv1alpha1.StrategyFailed && c.Status == v1.ConditionTrue && c.Reason == ReasonAnalysisFailed {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,204 @@
+package virtualservice
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+	"zmc.io/oasis/pkg/controller/virtualservice/util"
+	"zmc.io/oasis/pkg/simple/client/prometheus"
+)
+
+// stubPrometheus answers error rate and latency queries with fixed values
+type stubPrometheus struct {
+	errorRate float64
+	latency   float64
+	err       error
+	queries   int
+}
+
+func (p *stubPrometheus) Query(ctx context.Context, query string) (float64, error) {
+	p.queries++
+	if p.err != nil {
+		return 0, p.err
+	}
+	if strings.Contains(query, "histogram_quantile") {
+		return p.latency, nil
+	}
+	return p.errorRate, nil
+}
+
+func analyzedCanarySpec() servicemeshv1alpha1.StrategySpec {
+	spec := canarySpec()
+	maxErrorRate := int32(5)
+	spec.Analysis = &servicemeshv1alpha1.CanaryAnalysis{
+		MaxErrorRate: &maxErrorRate,
+		MaxLatency:   &metav1.Duration{Duration: 500 * time.Millisecond},
+		Interval:     metav1.Duration{Duration: 30 * time.Second},
+	}
+	return spec
+}
+
+func TestSyncCanaryStepAnalysis(t *testing.T) {
+	tests := []struct {
+		name       string
+		prometheus *stubPrometheus
+		status     servicemeshv1alpha1.StrategyStatus
+		// nil prometheus client
+		noPrometheus bool
+
+		wantStep        int32
+		wantNext        time.Duration
+		wantRolledBack  bool
+		wantUnavailable string
+		wantQueries     bool
+	}{
+		{
+			name:        "healthy canary is checked again after interval",
+			prometheus:  &stubPrometheus{errorRate: 1, latency: 100},
+			status:      stepStatus(0, 10*time.Second, 1),
+			wantStep:    0,
+			wantNext:    30 * time.Second,
+			wantQueries: true,
+		},
+		{
+			name:        "pause shorter than interval",
+			prometheus:  &stubPrometheus{errorRate: 1, latency: 100},
+			status:      stepStatus(0, 50*time.Second, 1),
+			wantStep:    0,
+			wantNext:    10 * time.Second,
+			wantQueries: true,
+		},
+		{
+			name:           "error rate breached",
+			prometheus:     &stubPrometheus{errorRate: 12, latency: 100},
+			status:         stepStatus(0, 10*time.Second, 1),
+			wantStep:       0,
+			wantRolledBack: true,
+			wantQueries:    true,
+		},
+		{
+			name:           "latency breached",
+			prometheus:     &stubPrometheus{errorRate: 1, latency: 800},
+			status:         stepStatus(1, 10*time.Second, 1),
+			wantStep:       1,
+			wantRolledBack: true,
+			wantQueries:    true,
+		},
+		{
+			name:        "no traffic is healthy",
+			prometheus:  &stubPrometheus{err: prometheus.ErrNoData},
+			status:      stepStatus(0, 10*time.Second, 1),
+			wantStep:    0,
+			wantNext:    30 * time.Second,
+			wantQueries: true,
+		},
+		{
+			name:            "query failure keeps walking steps and retries after interval",
+			prometheus:      &stubPrometheus{err: errors.New("connection refused")},
+			status:          stepStatus(0, 70*time.Second, 1),
+			wantStep:        1,
+			wantNext:        30 * time.Second,
+			wantUnavailable: ReasonMetricsQueryFailed,
+			wantQueries:     true,
+		},
+		{
+			name:            "prometheus not configured",
+			noPrometheus:    true,
+			status:          stepStatus(0, 10*time.Second, 1),
+			wantStep:        0,
+			wantNext:        50 * time.Second,
+			wantUnavailable: ReasonPrometheusNotConfigured,
+		},
+		{
+			name:        "last step analyzed for an interval",
+			prometheus:  &stubPrometheus{errorRate: 50},
+			status:      stepStatus(2, time.Minute, 1),
+			wantStep:    2,
+			wantQueries: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			strategy := newTestStrategy("canary", analyzedCanarySpec())
+			strategy.Status = test.status
+
+			v := newTestController(nil, strategy)
+			if !test.noPrometheus {
+				v.prometheusClient = test.prometheus
+			}
+
+			got, next, err := v.syncCanaryStep(strategy, newTestService("reviews"))
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			if *got.Status.CurrentStep != test.wantStep {
+				t.Errorf("current step %d, want %d", *got.Status.CurrentStep, test.wantStep)
+			}
+
+			if next > test.wantNext || next <= test.wantNext-time.Second {
+				t.Errorf("requeue after %s, want %s", next, test.wantNext)
+			}
+
+			if rolledBack := isCanaryRolledBack(&got.Status); rolledBack != test.wantRolledBack {
+				t.Errorf("rolled back %v, want %v", rolledBack, test.wantRolledBack)
+			}
+
+			unavailable := util.GetStrategyCondition(got.Status, servicemeshv1alpha1.StrategyAnalysisUnavailable)
+			if len(test.wantUnavailable) == 0 && unavailable != nil {
+				t.Errorf("unexpected condition %v", unavailable)
+			}
+			if len(test.wantUnavailable) > 0 && (unavailable == nil || unavailable.Status != v1.ConditionTrue || unavailable.Reason != test.wantUnavailable) {
+				t.Errorf("condition %v, want reason %s", unavailable, test.wantUnavailable)
+			}
+
+			if test.prometheus != nil && (test.prometheus.queries > 0) != test.wantQueries {
+				t.Errorf("queried prometheus %d times, want queries %v", test.prometheus.queries, test.wantQueries)
+			}
+		})
+	}
+}
+
+func TestSyncCanaryStepAnalysisRecovered(t *testing.T) {
+	strategy := newTestStrategy("canary", analyzedCanarySpec())
+	strategy.Status = stepStatus(0, 10*time.Second, 1)
+	util.SetStrategyCondition(&strategy.Status, *util.NewStrategyCondition(servicemeshv1alpha1.StrategyAnalysisUnavailable, v1.ConditionTrue, ReasonMetricsQueryFailed, "timeout"))
+
+	v := newTestController(nil, strategy)
+	v.prometheusClient = &stubPrometheus{errorRate: 1, latency: 100}
+
+	got, _, err := v.syncCanaryStep(strategy, newTestService("reviews"))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if c := util.GetStrategyCondition(got.Status, servicemeshv1alpha1.StrategyAnalysisUnavailable); c != nil {
+		t.Errorf("condition %v is kept after metrics are available", c)
+	}
+}
+
+func TestAnalysisInterval(t *testing.T) {
+	tests := []struct {
+		analysis *servicemeshv1alpha1.CanaryAnalysis
+		want     time.Duration
+	}{
+		{analysis: nil, want: defaultAnalysisInterval},
+		{analysis: &servicemeshv1alpha1.CanaryAnalysis{}, want: defaultAnalysisInterval},
+		{analysis: &servicemeshv1alpha1.CanaryAnalysis{Interval: metav1.Duration{Duration: 5 * time.Minute}}, want: 5 * time.Minute},
+	}
+
+	for _, test := range tests {
+		strategy := newTestStrategy("canary", servicemeshv1alpha1.StrategySpec{Analysis: test.analysis})
+		if got := analysisInterval(strategy); got != test.want {
+			t.Errorf("interval of %v is %s, want %s", test.analysis, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package virtualservice
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	networkingv1beta1 "istio.io/client-go/pkg/apis/networking/v1beta1"
+
+	networkingv1beta1api "istio.io/api/networking/v1beta1"
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+	"zmc.io/oasis/pkg/controller/virtualservice/util"
+)
+
+func isBlueGreen(strategy *servicemeshv1alpha1.Strategy) bool {
+	return strategy.Spec.Type == servicemeshv1alpha1.BlueGreenType && strategy.Spec.BlueGreen != nil
+}
+
+// syncBlueGreen starts previewing a new version of blue/green strategy, the
+// phase is moved forward by promote and abort actions afterwards.
+func (v *VirtualServiceController) syncBlueGreen(strategy *servicemeshv1alpha1.Strategy) (*servicemeshv1alpha1.Strategy, error) {
+	if !isBlueGreen(strategy) {
+		return strategy, nil
+	}
+
+	if bg := strategy.Status.BlueGreen; bg != nil && bg.PreviewVersion == strategy.Spec.BlueGreen.PreviewVersion {
+		return strategy, nil
+	}
+
+	now := metav1.Now()
+	status := strategy.Status.DeepCopy()
+	status.BlueGreen = &servicemeshv1alpha1.BlueGreenStatus{
+		Phase:              servicemeshv1alpha1.BlueGreenPreviewing,
+		PreviewVersion:     strategy.Spec.BlueGreen.PreviewVersion,
+		ActiveVersion:      strategy.Spec.PrincipalVersion,
+		LastTransitionTime: &now,
+	}
+
+	newStrategy, err := v.writeStrategyStatus(strategy, status)
+	if err != nil {
+		return strategy, err
+	}
+
+	v.eventRecorder.Event(newStrategy, v1.EventTypeNormal, string(servicemeshv1alpha1.BlueGreenPreviewing),
+		fmt.Sprintf("Previewing version %s, version %s stays active", strategy.Spec.BlueGreen.PreviewVersion, strategy.Spec.PrincipalVersion))
+
+	return newStrategy, nil
+}
+
+// applyBlueGreen routes all traffic to active version, and preview host to
+// preview version unless blue/green is aborted.
+func applyBlueGreen(vs *networkingv1beta1.VirtualService, strategy *servicemeshv1alpha1.Strategy, service *v1.Service) {
+	phase := servicemeshv1alpha1.BlueGreenPreviewing
+	active := strategy.Spec.PrincipalVersion
+	if bg := strategy.Status.BlueGreen; bg != nil && bg.PreviewVersion == strategy.Spec.BlueGreen.PreviewVersion {
+		phase = bg.Phase
+		active = bg.ActiveVersion
+	}
+
+	ensureDefaultRoutes(vs, service)
+	setDefaultDestinations(vs, versionDestinations(util.ServiceFQDN(service), util.NormalizeVersionName(active)))
+
+	previewHost := strategy.Spec.BlueGreen.PreviewHost
+	if phase == servicemeshv1alpha1.BlueGreenAborted || len(previewHost) == 0 || len(vs.Spec.Http) == 0 {
+		return
+	}
+
+	vs.Spec.Hosts = append(vs.Spec.Hosts, previewHost)
+	previewRoute := &networkingv1beta1api.HTTPRoute{
+		Name: "preview",
+		Match: []*networkingv1beta1api.HTTPMatchRequest{
+			{
+				Authority: &networkingv1beta1api.StringMatch{
+					MatchType: &networkingv1beta1api.StringMatch_Exact{Exact: previewHost},
+				},
+			},
+		},
+		Route: versionDestinations(util.ServiceFQDN(service), util.NormalizeVersionName(strategy.Spec.BlueGreen.PreviewVersion)),
+	}
+	vs.Spec.Http = append([]*networkingv1beta1api.HTTPRoute{previewRoute}, vs.Spec.Http...)
+}
@@ -0,0 +1,155 @@
+package virtualservice
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+)
+
+func blueGreenSpec() servicemeshv1alpha1.StrategySpec {
+	return servicemeshv1alpha1.StrategySpec{
+		Type:             servicemeshv1alpha1.BlueGreenType,
+		PrincipalVersion: "v1",
+		BlueGreen: &servicemeshv1alpha1.BlueGreenStrategy{
+			PreviewVersion: "v2",
+			PreviewHost:    "preview.reviews.example.com",
+		},
+	}
+}
+
+func TestApplyBlueGreen(t *testing.T) {
+	host := "reviews.default.svc.cluster.local"
+
+	tests := []struct {
+		name   string
+		status *servicemeshv1alpha1.BlueGreenStatus
+
+		wantHosts   []string
+		wantRoutes  []string
+		wantSubsets []string
+	}{
+		{
+			name:        "not previewed yet",
+			wantHosts:   []string{host, "preview.reviews.example.com"},
+			wantRoutes:  []string{"preview", ""},
+			wantSubsets: []string{"v2", "v1"},
+		},
+		{
+			name:        "previewing",
+			status:      &servicemeshv1alpha1.BlueGreenStatus{Phase: servicemeshv1alpha1.BlueGreenPreviewing, PreviewVersion: "v2", ActiveVersion: "v1"},
+			wantHosts:   []string{host, "preview.reviews.example.com"},
+			wantRoutes:  []string{"preview", ""},
+			wantSubsets: []string{"v2", "v1"},
+		},
+		{
+			name:        "promoted",
+			status:      &servicemeshv1alpha1.BlueGreenStatus{Phase: servicemeshv1alpha1.BlueGreenPromoted, PreviewVersion: "v2", ActiveVersion: "v2"},
+			wantHosts:   []string{host, "preview.reviews.example.com"},
+			wantRoutes:  []string{"preview", ""},
+			wantSubsets: []string{"v2", "v2"},
+		},
+		{
+			name:        "aborted",
+			status:      &servicemeshv1alpha1.BlueGreenStatus{Phase: servicemeshv1alpha1.BlueGreenAborted, PreviewVersion: "v2", ActiveVersion: "v1"},
+			wantHosts:   []string{host},
+			wantRoutes:  []string{""},
+			wantSubsets: []string{"v1"},
+		},
+		{
+			name:        "status of previous preview version",
+			status:      &servicemeshv1alpha1.BlueGreenStatus{Phase: servicemeshv1alpha1.BlueGreenAborted, PreviewVersion: "v0", ActiveVersion: "v0"},
+			wantHosts:   []string{host, "preview.reviews.example.com"},
+			wantRoutes:  []string{"preview", ""},
+			wantSubsets: []string{"v2", "v1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			strategy := newTestStrategy("bluegreen", blueGreenSpec())
+			strategy.Status.BlueGreen = test.status
+
+			vs := GenerateVirtualServiceSpec(strategy, newTestService("reviews"))
+
+			if !reflect.DeepEqual(vs.Spec.Hosts, test.wantHosts) {
+				t.Errorf("hosts %v, want %v", vs.Spec.Hosts, test.wantHosts)
+			}
+			if names := routeNames(vs.Spec.Http); !reflect.DeepEqual(names, test.wantRoutes) {
+				t.Errorf("routes %v, want %v", names, test.wantRoutes)
+			}
+			if subsets := routeSubsets(vs.Spec.Http); !reflect.DeepEqual(subsets, test.wantSubsets) {
+				t.Errorf("subsets %v, want %v", subsets, test.wantSubsets)
+			}
+		})
+	}
+}
+
+func TestSyncBlueGreen(t *testing.T) {
+	tests := []struct {
+		name   string
+		spec   servicemeshv1alpha1.StrategySpec
+		status *servicemeshv1alpha1.BlueGreenStatus
+
+		want      *servicemeshv1alpha1.BlueGreenStatus
+		wantEvent bool
+	}{
+		{
+			name: "not blue/green",
+			spec: canarySpec(),
+		},
+		{
+			name:      "start previewing",
+			spec:      blueGreenSpec(),
+			want:      &servicemeshv1alpha1.BlueGreenStatus{Phase: servicemeshv1alpha1.BlueGreenPreviewing, PreviewVersion: "v2", ActiveVersion: "v1"},
+			wantEvent: true,
+		},
+		{
+			name:   "promoted stays",
+			spec:   blueGreenSpec(),
+			status: &servicemeshv1alpha1.BlueGreenStatus{Phase: servicemeshv1alpha1.BlueGreenPromoted, PreviewVersion: "v2", ActiveVersion: "v2"},
+			want:   &servicemeshv1alpha1.BlueGreenStatus{Phase: servicemeshv1alpha1.BlueGreenPromoted, PreviewVersion: "v2", ActiveVersion: "v2"},
+		},
+		{
+			name:      "new preview version restarts previewing",
+			spec:      blueGreenSpec(),
+			status:    &servicemeshv1alpha1.BlueGreenStatus{Phase: servicemeshv1alpha1.BlueGreenPromoted, PreviewVersion: "v0", ActiveVersion: "v0"},
+			want:      &servicemeshv1alpha1.BlueGreenStatus{Phase: servicemeshv1alpha1.BlueGreenPreviewing, PreviewVersion: "v2", ActiveVersion: "v1"},
+			wantEvent: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			strategy := newTestStrategy("bluegreen", test.spec)
+			strategy.Status.BlueGreen = test.status
+			v := newTestController(nil, strategy)
+
+			got, err := v.syncBlueGreen(strategy)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			persisted, err := v.servicemeshClient.ServicemeshV1alpha1().Strategies(testNamespace).Get(context.TODO(), strategy.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			for _, bg := range []*servicemeshv1alpha1.BlueGreenStatus{got.Status.BlueGreen, persisted.Status.BlueGreen} {
+				if (bg == nil) != (test.want == nil) {
+					t.Fatalf("blue/green status %v, want %v", bg, test.want)
+				}
+				if bg != nil && (bg.Phase != test.want.Phase || bg.PreviewVersion != test.want.PreviewVersion || bg.ActiveVersion != test.want.ActiveVersion) {
+					t.Errorf("blue/green status %v, want %v", bg, test.want)
+				}
+			}
+
+			if events := drainEvents(v.eventRecorder); (len(events) > 0) != test.wantEvent {
+				t.Errorf("events %v, want event %v", events, test.wantEvent)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package virtualservice
+
+import (
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	log "k8s.io/klog"
+
+	networkingv1beta1 "istio.io/client-go/pkg/apis/networking/v1beta1"
+
+	networkingv1beta1api "istio.io/api/networking/v1beta1"
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+	"zmc.io/oasis/pkg/controller/virtualservice/util"
+)
+
+// syncCanaryStep walks a strategy through its canary steps, the step currently
+// applied is recorded in strategy status. Canary version is checked against
+// analysis thresholds at every step, and rolled back once they are breached.
+// It returns the strategy with the latest status, and how long it takes until
+// the strategy needs to be checked again.
+func (v *VirtualServiceController) syncCanaryStep(strategy *servicemeshv1alpha1.Strategy, service *v1.Service) (*servicemeshv1alpha1.Strategy, time.Duration, error) {
+	steps := strategy.Spec.Steps
+	if len(steps) == 0 {
+		return strategy, 0, nil
+	}
+
+	now := metav1.Now()
+	status := strategy.Status.DeepCopy()
+
+	// strategy spec changed since last time, start over from the first step
+	if status.CurrentStep == nil || status.CurrentStepTime == nil ||
+		status.ObservedGeneration != strategy.Generation ||
+		int(*status.CurrentStep) >= len(steps) {
+		step := int32(0)
+		status.CurrentStep = &step
+		status.CurrentStepTime = &now
+		status.ObservedGeneration = strategy.Generation
+		status.StartTime = &now
+		status.CompletionTime = nil
+		util.RemoveStrategyCondition(status, servicemeshv1alpha1.StrategyFailed)
+	}
+
+	// canary has been rolled back, stays there until strategy changes
+	if isCanaryRolledBack(status) {
+		return v.updateCanaryStatus(strategy, status, 0)
+	}
+
+	previousStep := *status.CurrentStep
+	var next time.Duration
+	for int(*status.CurrentStep) < len(steps)-1 {
+		pause := steps[*status.CurrentStep].Pause.Duration
+		elapsed := now.Sub(status.CurrentStepTime.Time)
+		if elapsed < pause {
+			next = pause - elapsed
+			break
+		}
+
+		step := *status.CurrentStep + 1
+		stepTime := metav1.NewTime(status.CurrentStepTime.Add(pause))
+		status.CurrentStep = &step
+		status.CurrentStepTime = &stepTime
+	}
+
+	// check canary metrics until the last step lasted for an analysis interval
+	interval := analysisInterval(strategy)
+	lastStep := int(*status.CurrentStep) == len(steps)-1
+	if strategy.Spec.Analysis == nil || steps[*status.CurrentStep].Weight == 0 ||
+		(lastStep && now.Sub(status.CurrentStepTime.Time) >= interval) {
+		util.RemoveStrategyCondition(status, servicemeshv1alpha1.StrategyAnalysisUnavailable)
+	} else if v.prometheusClient == nil {
+		util.SetStrategyCondition(status, *util.NewStrategyCondition(servicemeshv1alpha1.StrategyAnalysisUnavailable, v1.ConditionTrue,
+			ReasonPrometheusNotConfigured, "prometheus is not configured, canary steps go on without analysis"))
+	} else {
+		// steps already walked are kept when metrics are unavailable, analysis
+		// is retried in the next interval
+		message, err := v.analyzeCanary(strategy, service)
+		if err != nil {
+			log.Errorf("analyze canary of strategy %s/%s failed, %v", strategy.Namespace, strategy.Name, err)
+			util.SetStrategyCondition(status, *util.NewStrategyCondition(servicemeshv1alpha1.StrategyAnalysisUnavailable, v1.ConditionTrue,
+				ReasonMetricsQueryFailed, fmt.Sprintf("query canary metrics failed, %v", err)))
+		} else {
+			util.RemoveStrategyCondition(status, servicemeshv1alpha1.StrategyAnalysisUnavailable)
+		}
+
+		if err == nil && len(message) > 0 {
+			util.SetStrategyCondition(status, *util.NewStrategyCondition(servicemeshv1alpha1.StrategyFailed, v1.ConditionTrue, ReasonAnalysisFailed, message))
+			newStrategy, _, err := v.updateCanaryStatus(strategy, status, 0)
+			if err != nil {
+				return strategy, 0, err
+			}
+
+			v.eventRecorder.Event(newStrategy, v1.EventTypeWarning, ReasonAnalysisFailed,
+				fmt.Sprintf("Canary rolled back to version %s, %s", strategy.Spec.PrincipalVersion, message))
+			return newStrategy, 0, nil
+		}
+
+		if next == 0 || interval < next {
+			next = interval
+		}
+	}
+
+	newStrategy, next, err := v.updateCanaryStatus(strategy, status, next)
+	if err != nil {
+		return strategy, next, err
+	}
+
+	if *status.CurrentStep != previousStep {
+		v.eventRecorder.Event(newStrategy, v1.EventTypeNormal, "CanaryStep",
+			fmt.Sprintf("Canary step %d applied, %d%% traffic routed to version %s", *status.CurrentStep, steps[*status.CurrentStep].Weight, strategy.Spec.CanaryVersion))
+	}
+
+	return newStrategy, next, nil
+}
+
+// updateCanaryStatus writes status to strategy if it changes
+func (v *VirtualServiceController) updateCanaryStatus(strategy *servicemeshv1alpha1.Strategy, status *servicemeshv1alpha1.StrategyStatus, next time.Duration) (*servicemeshv1alpha1.Strategy, time.Duration, error) {
+	if equality.Semantic.DeepEqual(status, &strategy.Status) {
+		return strategy, next, nil
+	}
+
+	newStrategy, err := v.writeStrategyStatus(strategy, status)
+	return newStrategy, next, err
+}
+
+// currentCanaryWeight returns traffic weight of the canary step currently applied
+func currentCanaryWeight(strategy *servicemeshv1alpha1.Strategy) int32 {
+	if isCanaryRolledBack(&strategy.Status) {
+		return 0
+	}
+
+	step := int32(0)
+	if strategy.Status.CurrentStep != nil && int(*strategy.Status.CurrentStep) < len(strategy.Spec.Steps) {
+		step = *strategy.Status.CurrentStep
+	}
+
+	return strategy.Spec.Steps[step].Weight
+}
+
+// canaryDestinations splits traffic between principal and canary subset by weight
+func canaryDestinations(host string, principal string, canary string, weight int32) []*networkingv1beta1api.HTTPRouteDestination {
+	if weight <= 0 {
+		return versionDestinations(host, principal)
+	}
+
+	if weight >= 100 {
+		return versionDestinations(host, canary)
+	}
+
+	return []*networkingv1beta1api.HTTPRouteDestination{
+		{Destination: &networkingv1beta1api.Destination{Host: host, Subset: principal}, Weight: 100 - weight},
+		{Destination: &networkingv1beta1api.Destination{Host: host, Subset: canary}, Weight: weight},
+	}
+}
+
+// versionDestinations routes all traffic to subset
+func versionDestinations(host string, subset string) []*networkingv1beta1api.HTTPRouteDestination {
+	return []*networkingv1beta1api.HTTPRouteDestination{
+		{Destination: &networkingv1beta1api.Destination{Host: host, Subset: subset}, Weight: 100},
+	}
+}
+
+// ensureDefaultRoutes creates a route for http and tcp ports of service if there is none
+func ensureDefaultRoutes(vs *networkingv1beta1.VirtualService, service *v1.Service) {
+	if len(vs.Spec.Http) == 0 && util.HasHTTPPort(service) {
+		vs.Spec.Http = []*networkingv1beta1api.HTTPRoute{{}}
+	}
+
+	if len(vs.Spec.Tcp) == 0 && len(util.GetPortsByProtocol(service, util.PortProtocolTCP)) > 0 {
+		vs.Spec.Tcp = []*networkingv1beta1api.TCPRoute{{}}
+	}
+}
+
+// setDefaultDestinations replaces destinations of routes without match conditions
+func setDefaultDestinations(vs *networkingv1beta1.VirtualService, destinations []*networkingv1beta1api.HTTPRouteDestination) {
+	for _, httpRoute := range vs.Spec.Http {
+		if len(httpRoute.Match) == 0 {
+			httpRoute.Route = destinations
+		}
+	}
+
+	for _, tcpRoute := range vs.Spec.Tcp {
+		if len(tcpRoute.Match) == 0 {
+			tcpRoute.Route = tcpDestinations(destinations)
+		}
+	}
+}
+
+// tcpDestinations converts http destinations to the ones of tcp and tls routes
+func tcpDestinations(destinations []*networkingv1beta1api.HTTPRouteDestination) []*networkingv1beta1api.RouteDestination {
+	routes := make([]*networkingv1beta1api.RouteDestination, 0, len(destinations))
+	for _, dw := range destinations {
+		routes = append(routes, &networkingv1beta1api.RouteDestination{
+			Destination: dw.Destination.DeepCopy(),
+			Weight:      dw.Weight,
+		})
+	}
+	return routes
+}
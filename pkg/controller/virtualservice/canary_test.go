@@ -0,0 +1,233 @@
+package virtualservice
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	networkingv1beta1api "istio.io/api/networking/v1beta1"
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+	"zmc.io/oasis/pkg/controller/virtualservice/util"
+)
+
+func canarySpec() servicemeshv1alpha1.StrategySpec {
+	return servicemeshv1alpha1.StrategySpec{
+		Type:             servicemeshv1alpha1.CanaryType,
+		PrincipalVersion: "v1",
+		CanaryVersion:    "v2",
+		Steps: []servicemeshv1alpha1.CanaryStep{
+			{Weight: 10, Pause: metav1.Duration{Duration: time.Minute}},
+			{Weight: 50, Pause: metav1.Duration{Duration: time.Minute}},
+			{Weight: 100},
+		},
+	}
+}
+
+func stepStatus(step int32, since time.Duration, generation int64) servicemeshv1alpha1.StrategyStatus {
+	stepTime := metav1.NewTime(time.Now().Add(-since))
+	return servicemeshv1alpha1.StrategyStatus{
+		CurrentStep:        &step,
+		CurrentStepTime:    &stepTime,
+		StartTime:          &stepTime,
+		ObservedGeneration: generation,
+	}
+}
+
+func TestSyncCanaryStep(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   servicemeshv1alpha1.StrategyStatus
+		wantStep int32
+		// requeue is expected within (wantNext-time.Second, wantNext]
+		wantNext time.Duration
+	}{
+		{
+			name:     "first sync starts from the first step",
+			wantStep: 0,
+			wantNext: time.Minute,
+		},
+		{
+			name:     "pause not elapsed",
+			status:   stepStatus(0, 20*time.Second, 1),
+			wantStep: 0,
+			wantNext: 40 * time.Second,
+		},
+		{
+			name:     "pause elapsed",
+			status:   stepStatus(0, 70*time.Second, 1),
+			wantStep: 1,
+			wantNext: 50 * time.Second,
+		},
+		{
+			name:     "several pauses elapsed",
+			status:   stepStatus(0, 3*time.Minute, 1),
+			wantStep: 2,
+		},
+		{
+			name:     "last step stays",
+			status:   stepStatus(2, time.Hour, 1),
+			wantStep: 2,
+		},
+		{
+			name:     "spec changed starts over",
+			status:   stepStatus(2, time.Hour, 0),
+			wantStep: 0,
+			wantNext: time.Minute,
+		},
+		{
+			name:     "steps removed starts over",
+			status:   stepStatus(5, time.Hour, 1),
+			wantStep: 0,
+			wantNext: time.Minute,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			strategy := newTestStrategy("canary", canarySpec())
+			strategy.Status = test.status
+
+			v := newTestController(nil, strategy)
+			got, next, err := v.syncCanaryStep(strategy, newTestService("reviews"))
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			if got.Status.CurrentStep == nil || *got.Status.CurrentStep != test.wantStep {
+				t.Errorf("current step %v, want %d", got.Status.CurrentStep, test.wantStep)
+			}
+
+			if next > test.wantNext || next <= test.wantNext-time.Second {
+				t.Errorf("requeue after %s, want %s", next, test.wantNext)
+			}
+
+			// status is persisted through the status subresource
+			persisted, err := v.servicemeshClient.ServicemeshV1alpha1().Strategies(testNamespace).Get(context.TODO(), "canary", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if !reflect.DeepEqual(persisted.Status.CurrentStep, got.Status.CurrentStep) {
+				t.Errorf("persisted step %v, want %v", persisted.Status.CurrentStep, got.Status.CurrentStep)
+			}
+		})
+	}
+}
+
+func TestSyncCanaryStepRolledBack(t *testing.T) {
+	strategy := newTestStrategy("canary", canarySpec())
+	strategy.Status = stepStatus(0, time.Hour, 1)
+	util.SetStrategyCondition(&strategy.Status, *util.NewStrategyCondition(servicemeshv1alpha1.StrategyFailed, v1.ConditionTrue, ReasonAnalysisFailed, "error rate exceeds"))
+
+	v := newTestController(nil, strategy)
+	got, next, err := v.syncCanaryStep(strategy, newTestService("reviews"))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if *got.Status.CurrentStep != 0 || next != 0 {
+		t.Errorf("rolled back canary moved to step %d, requeue after %s", *got.Status.CurrentStep, next)
+	}
+	if weight := currentCanaryWeight(got); weight != 0 {
+		t.Errorf("rolled back canary has weight %d, want 0", weight)
+	}
+}
+
+func TestSyncServiceCanaryEditedOutOfWindow(t *testing.T) {
+	tests := []struct {
+		name       string
+		rolledBack bool
+	}{
+		{name: "progressed canary"},
+		{name: "rolled back canary", rolledBack: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ended := metav1.NewTime(time.Now().Add(-time.Hour))
+			spec := canarySpec()
+			spec.Schedule = &servicemeshv1alpha1.StrategySchedule{EndTime: &ended}
+
+			// canary walked to its last step, then edited while out of its window
+			strategy := newTestStrategy("canary", spec)
+			strategy.Generation = 2
+			strategy.Status = stepStatus(2, time.Hour, 1)
+			if test.rolledBack {
+				util.SetStrategyCondition(&strategy.Status, *util.NewStrategyCondition(servicemeshv1alpha1.StrategyFailed, v1.ConditionTrue, ReasonAnalysisFailed, "error rate exceeds"))
+			}
+
+			f := newFixture(t, newTestService("reviews"), newTestDestinationRule("reviews", "v1", "v2"), strategy)
+			vs := f.sync("reviews")
+			vs.ResourceVersion = "1"
+			f.addToCache(vs)
+
+			pending := f.strategy("canary")
+			if pending.Status.ObservedGeneration != 2 || pending.Status.CurrentStep != nil || pending.Status.CurrentStepTime != nil {
+				t.Errorf("canary progress of old spec is kept, status %v", pending.Status)
+			}
+			if failed := util.GetStrategyCondition(pending.Status, servicemeshv1alpha1.StrategyFailed); failed != nil {
+				t.Errorf("unexpected condition %v", failed)
+			}
+
+			// window opens again without any further edit
+			pending.Spec.Schedule = nil
+			f.addToCache(pending)
+			f.sync("reviews")
+
+			got := f.strategy("canary")
+			if got.Status.CurrentStep == nil || *got.Status.CurrentStep != 0 {
+				t.Errorf("current step %v, want 0", got.Status.CurrentStep)
+			}
+			if weight := currentCanaryWeight(got); weight != 10 {
+				t.Errorf("canary weight %d, want 10", weight)
+			}
+		})
+	}
+}
+
+func TestCurrentCanaryWeight(t *testing.T) {
+	tests := []struct {
+		name   string
+		status servicemeshv1alpha1.StrategyStatus
+		want   int32
+	}{
+		{name: "no status", want: 10},
+		{name: "second step", status: stepStatus(1, 0, 1), want: 50},
+		{name: "step out of range", status: stepStatus(7, 0, 1), want: 10},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			strategy := newTestStrategy("canary", canarySpec())
+			strategy.Status = test.status
+			if got := currentCanaryWeight(strategy); got != test.want {
+				t.Errorf("weight %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCanaryDestinations(t *testing.T) {
+	host := "reviews.default.svc.cluster.local"
+	destination := func(subset string, weight int32) *networkingv1beta1api.HTTPRouteDestination {
+		return &networkingv1beta1api.HTTPRouteDestination{Destination: &networkingv1beta1api.Destination{Host: host, Subset: subset}, Weight: weight}
+	}
+
+	tests := []struct {
+		weight int32
+		want   []*networkingv1beta1api.HTTPRouteDestination
+	}{
+		{weight: 0, want: []*networkingv1beta1api.HTTPRouteDestination{destination("v1", 100)}},
+		{weight: 30, want: []*networkingv1beta1api.HTTPRouteDestination{destination("v1", 70), destination("v2", 30)}},
+		{weight: 100, want: []*networkingv1beta1api.HTTPRouteDestination{destination("v2", 100)}},
+	}
+
+	for _, test := range tests {
+		if got := canaryDestinations(host, "v1", "v2", test.weight); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("weight %d: destinations %v, want %v", test.weight, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package virtualservice
+
+import (
+	"reflect"
+	"testing"
+
+	networkingv1beta1 "istio.io/client-go/pkg/apis/networking/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	networkingv1beta1api "istio.io/api/networking/v1beta1"
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+	"zmc.io/oasis/pkg/controller/virtualservice/util"
+)
+
+// newGeneratedVirtualService returns virtualservice generated for service, edited by others since
+func newGeneratedVirtualService(service *v1.Service, resourceVersion string) *networkingv1beta1.VirtualService {
+	return &networkingv1beta1.VirtualService{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            testApp,
+			Namespace:       testNamespace,
+			ResourceVersion: resourceVersion,
+			Labels:          util.ManagedLabels(service),
+			OwnerReferences: []metav1.OwnerReference{*util.NewServiceControllerRef(service)},
+		},
+		Spec: networkingv1beta1api.VirtualService{
+			Hosts: []string{"reviews.default.svc.cluster.local"},
+			Http:  []*networkingv1beta1api.HTTPRoute{{Route: versionDestinations("reviews.default.svc.cluster.local", "v2")}},
+		},
+	}
+}
+
+func TestSyncServiceDrift(t *testing.T) {
+	service := newTestService("reviews")
+
+	tests := []struct {
+		name       string
+		overridden bool
+
+		wantReverted bool
+		wantDrift    string
+	}{
+		{
+			name:         "edited by others",
+			wantReverted: true,
+		},
+		{
+			name:       "overridden on purpose",
+			overridden: true,
+			wantDrift:  "virtualservice default/reviews is overridden, generated routes are not applied",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			current := newGeneratedVirtualService(service, "1")
+			if test.overridden {
+				current.Annotations = map[string]string{util.OverrideAnnotation: "true"}
+			}
+			spec := servicemeshv1alpha1.StrategySpec{PrincipalVersion: "v1", GovernorVersion: "v1"}
+			f := newFixture(t, service, newTestDestinationRule("reviews", "v1", "v2"), newTestStrategy("governor", spec), current)
+
+			vs := f.sync("reviews")
+			if reverted := !reflect.DeepEqual(vs.Spec, current.Spec); reverted != test.wantReverted {
+				t.Errorf("reverted %v, want %v, routes %v", reverted, test.wantReverted, vs.Spec.Http)
+			}
+			if test.wantReverted && !reflect.DeepEqual(routeSubsets(vs.Spec.Http), []string{"v1"}) {
+				t.Errorf("route subsets %v, want [v1]", routeSubsets(vs.Spec.Http))
+			}
+
+			status := f.strategy("governor").Status
+			if complete := util.GetStrategyCondition(status, servicemeshv1alpha1.StrategyComplete); complete == nil || complete.Status != v1.ConditionTrue {
+				t.Errorf("complete condition %v, want delivered", complete)
+			}
+
+			drifted := util.GetStrategyCondition(status, servicemeshv1alpha1.StrategyDrifted)
+			if len(test.wantDrift) == 0 && drifted != nil {
+				t.Errorf("unexpected condition %v", drifted)
+			}
+			if len(test.wantDrift) > 0 && (drifted == nil || drifted.Message != test.wantDrift) {
+				t.Errorf("drifted condition %v, want message %q", drifted, test.wantDrift)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package virtualservice
+
+import (
+	"time"
+
+	"github.com/gogo/protobuf/types"
+	v1 "k8s.io/api/core/v1"
+
+	networkingv1beta1 "istio.io/client-go/pkg/apis/networking/v1beta1"
+
+	networkingv1beta1api "istio.io/api/networking/v1beta1"
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+	"zmc.io/oasis/pkg/controller/virtualservice/util"
+)
+
+func isFaultInjection(strategy *servicemeshv1alpha1.Strategy) bool {
+	return strategy.Spec.Type == servicemeshv1alpha1.FaultInjectionType && strategy.Spec.FaultInjection != nil
+}
+
+// isFaultInjectionExpired tells if fault injection experiment of strategy is over at now,
+// and when it expires if not yet, which is zero if it never expires.
+func isFaultInjectionExpired(strategy *servicemeshv1alpha1.Strategy, now time.Time) (bool, time.Time) {
+	if !isFaultInjection(strategy) || strategy.Spec.FaultInjection.ExpireTime == nil {
+		return false, time.Time{}
+	}
+
+	expireTime := strategy.Spec.FaultInjection.ExpireTime.Time
+	if !now.Before(expireTime) {
+		return true, time.Time{}
+	}
+
+	return false, expireTime
+}
+
+// applyFaultInjection injects faults into http routes to the version or the segment
+// of fault injection, routes without any destination are served by the version,
+// or principal version if version is not specified.
+func applyFaultInjection(vs *networkingv1beta1.VirtualService, strategy *servicemeshv1alpha1.Strategy, service *v1.Service) {
+	faultInjection := strategy.Spec.FaultInjection
+
+	if len(vs.Spec.Http) == 0 && util.HasHTTPPort(service) {
+		vs.Spec.Http = []*networkingv1beta1api.HTTPRoute{{}}
+	}
+
+	version := faultInjection.Version
+	if len(version) == 0 {
+		version = strategy.Spec.PrincipalVersion
+	}
+
+	for _, httpRoute := range vs.Spec.Http {
+		if len(httpRoute.Route) == 0 && len(version) > 0 {
+			httpRoute.Route = versionDestinations(util.ServiceFQDN(service), util.NormalizeVersionName(version))
+		}
+
+		if isFaultTarget(httpRoute, faultInjection) {
+			httpRoute.Fault = httpFault(faultInjection)
+		}
+	}
+}
+
+// isFaultTarget tells if faults are injected into route, a route is targeted by
+// segment name, or by sending all its traffic to the version.
+func isFaultTarget(httpRoute *networkingv1beta1api.HTTPRoute, faultInjection *servicemeshv1alpha1.FaultInjectionStrategy) bool {
+	if len(faultInjection.Segment) > 0 && httpRoute.Name != faultInjection.Segment {
+		return false
+	}
+
+	if len(faultInjection.Version) == 0 {
+		return true
+	}
+
+	if len(httpRoute.Route) == 0 {
+		return false
+	}
+
+	subset := util.NormalizeVersionName(faultInjection.Version)
+	for _, dw := range httpRoute.Route {
+		if dw.Destination == nil || dw.Destination.Subset != subset {
+			return false
+		}
+	}
+
+	return true
+}
+
+func httpFault(faultInjection *servicemeshv1alpha1.FaultInjectionStrategy) *networkingv1beta1api.HTTPFaultInjection {
+	fault := &networkingv1beta1api.HTTPFaultInjection{}
+
+	if delay := faultInjection.Delay; delay != nil {
+		fault.Delay = &networkingv1beta1api.HTTPFaultInjection_Delay{
+			HttpDelayType: &networkingv1beta1api.HTTPFaultInjection_Delay_FixedDelay{
+				FixedDelay: types.DurationProto(delay.FixedDelay.Duration),
+			},
+			Percentage: &networkingv1beta1api.Percent{Value: float64(delay.Percentage)},
+		}
+	}
+
+	if abort := faultInjection.Abort; abort != nil {
+		fault.Abort = &networkingv1beta1api.HTTPFaultInjection_Abort{
+			ErrorType: &networkingv1beta1api.HTTPFaultInjection_Abort_HttpStatus{
+				HttpStatus: abort.HTTPStatus,
+			},
+			Percentage: &networkingv1beta1api.Percent{Value: float64(abort.Percentage)},
+		}
+	}
+
+	return fault
+}
@@ -0,0 +1,184 @@
+package virtualservice
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/types"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	networkingv1beta1api "istio.io/api/networking/v1beta1"
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+)
+
+func faultInjectionSpec(faultInjection *servicemeshv1alpha1.FaultInjectionStrategy) servicemeshv1alpha1.StrategySpec {
+	return servicemeshv1alpha1.StrategySpec{
+		Type:             servicemeshv1alpha1.FaultInjectionType,
+		PrincipalVersion: "v1",
+		FaultInjection:   faultInjection,
+	}
+}
+
+func TestIsFaultInjectionExpired(t *testing.T) {
+	now := time.Date(2020, time.February, 1, 12, 0, 0, 0, time.UTC)
+	abort := &servicemeshv1alpha1.FaultAbort{HTTPStatus: 503, Percentage: 10}
+
+	tests := []struct {
+		name        string
+		spec        servicemeshv1alpha1.StrategySpec
+		wantExpired bool
+		wantExpire  time.Time
+	}{
+		{
+			name: "never expires",
+			spec: faultInjectionSpec(&servicemeshv1alpha1.FaultInjectionStrategy{Abort: abort}),
+		},
+		{
+			name:       "expires later",
+			spec:       faultInjectionSpec(&servicemeshv1alpha1.FaultInjectionStrategy{Abort: abort, ExpireTime: &metav1.Time{Time: now.Add(time.Hour)}}),
+			wantExpire: now.Add(time.Hour),
+		},
+		{
+			name:        "expires now",
+			spec:        faultInjectionSpec(&servicemeshv1alpha1.FaultInjectionStrategy{Abort: abort, ExpireTime: &metav1.Time{Time: now}}),
+			wantExpired: true,
+		},
+		{
+			name: "not a fault injection",
+			spec: servicemeshv1alpha1.StrategySpec{
+				Type:           servicemeshv1alpha1.CanaryType,
+				FaultInjection: &servicemeshv1alpha1.FaultInjectionStrategy{Abort: abort, ExpireTime: &metav1.Time{Time: now.Add(-time.Hour)}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			strategy := newTestStrategy("fault", test.spec)
+
+			expired, expireTime := isFaultInjectionExpired(strategy, now)
+			if expired != test.wantExpired || !expireTime.Equal(test.wantExpire) {
+				t.Errorf("expired %v at %v, want %v at %v", expired, expireTime, test.wantExpired, test.wantExpire)
+			}
+
+			reason, _ := StrategyPendingReason(strategy, newTestDestinationRule("reviews", "v1").Spec.Subsets, now)
+			if expired := reason == ReasonFaultInjectionExpired; expired != test.wantExpired {
+				t.Errorf("pending reason %q, want expired %v", reason, test.wantExpired)
+			}
+
+			if next := nextStrategyTransition(strategy, now); !next.Equal(test.wantExpire) {
+				t.Errorf("next transition %v, want %v", next, test.wantExpire)
+			}
+		})
+	}
+}
+
+func TestHTTPFault(t *testing.T) {
+	fault := httpFault(&servicemeshv1alpha1.FaultInjectionStrategy{
+		Delay: &servicemeshv1alpha1.FaultDelay{FixedDelay: metav1.Duration{Duration: 5 * time.Second}, Percentage: 10},
+		Abort: &servicemeshv1alpha1.FaultAbort{HTTPStatus: 503, Percentage: 20},
+	})
+
+	want := &networkingv1beta1api.HTTPFaultInjection{
+		Delay: &networkingv1beta1api.HTTPFaultInjection_Delay{
+			HttpDelayType: &networkingv1beta1api.HTTPFaultInjection_Delay_FixedDelay{FixedDelay: types.DurationProto(5 * time.Second)},
+			Percentage:    &networkingv1beta1api.Percent{Value: 10},
+		},
+		Abort: &networkingv1beta1api.HTTPFaultInjection_Abort{
+			ErrorType:  &networkingv1beta1api.HTTPFaultInjection_Abort_HttpStatus{HttpStatus: 503},
+			Percentage: &networkingv1beta1api.Percent{Value: 20},
+		},
+	}
+	if !reflect.DeepEqual(fault, want) {
+		t.Errorf("fault %v, want %v", fault, want)
+	}
+}
+
+func TestApplyFaultInjection(t *testing.T) {
+	abort := &servicemeshv1alpha1.FaultAbort{HTTPStatus: 503, Percentage: 10}
+	segments := []servicemeshv1alpha1.UserSegment{{Name: "testers", Version: "v2", Headers: map[string]string{"x-user": "tester"}}}
+
+	tests := []struct {
+		name           string
+		spec           servicemeshv1alpha1.StrategySpec
+		wantSubsets    []string
+		wantFaultRoute []bool
+	}{
+		{
+			name:           "principal version by default",
+			spec:           faultInjectionSpec(&servicemeshv1alpha1.FaultInjectionStrategy{Abort: abort}),
+			wantSubsets:    []string{"v1"},
+			wantFaultRoute: []bool{true},
+		},
+		{
+			name:           "version",
+			spec:           faultInjectionSpec(&servicemeshv1alpha1.FaultInjectionStrategy{Version: "v2", Abort: abort}),
+			wantSubsets:    []string{"v2"},
+			wantFaultRoute: []bool{true},
+		},
+		{
+			name: "routes to version only",
+			spec: func() servicemeshv1alpha1.StrategySpec {
+				spec := faultInjectionSpec(&servicemeshv1alpha1.FaultInjectionStrategy{Version: "v2", Abort: abort})
+				spec.Segments = segments
+				return spec
+			}(),
+			wantSubsets:    []string{"v2", "v1"},
+			wantFaultRoute: []bool{true, false},
+		},
+		{
+			name: "segment",
+			spec: func() servicemeshv1alpha1.StrategySpec {
+				spec := faultInjectionSpec(&servicemeshv1alpha1.FaultInjectionStrategy{Segment: "testers", Abort: abort})
+				spec.Segments = segments
+				return spec
+			}(),
+			wantSubsets:    []string{"v2", "v1"},
+			wantFaultRoute: []bool{true, false},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vs := GenerateVirtualServiceSpec(newTestStrategy("fault", test.spec), newTestService("reviews"))
+
+			if subsets := routeSubsets(vs.Spec.Http); !reflect.DeepEqual(subsets, test.wantSubsets) {
+				t.Fatalf("route subsets %v, want %v", subsets, test.wantSubsets)
+			}
+			for i, route := range vs.Spec.Http {
+				if faulted := route.Fault != nil; faulted != test.wantFaultRoute[i] {
+					t.Errorf("route %d to %s faulted %v, want %v", i, test.wantSubsets[i], faulted, test.wantFaultRoute[i])
+				}
+			}
+		})
+	}
+}
+
+func TestIsFaultTarget(t *testing.T) {
+	split := &networkingv1beta1api.HTTPRoute{Name: "default", Route: canaryDestinations("reviews", "v1", "v2", 20)}
+	v2 := &networkingv1beta1api.HTTPRoute{Name: "testers", Route: versionDestinations("reviews", "v2")}
+
+	tests := []struct {
+		name           string
+		route          *networkingv1beta1api.HTTPRoute
+		faultInjection *servicemeshv1alpha1.FaultInjectionStrategy
+		want           bool
+	}{
+		{name: "any route", route: split, faultInjection: &servicemeshv1alpha1.FaultInjectionStrategy{}, want: true},
+		{name: "all traffic to version", route: v2, faultInjection: &servicemeshv1alpha1.FaultInjectionStrategy{Version: "v2"}, want: true},
+		{name: "part of traffic to version", route: split, faultInjection: &servicemeshv1alpha1.FaultInjectionStrategy{Version: "v2"}},
+		{name: "no destination", route: &networkingv1beta1api.HTTPRoute{}, faultInjection: &servicemeshv1alpha1.FaultInjectionStrategy{Version: "v2"}},
+		{name: "segment", route: v2, faultInjection: &servicemeshv1alpha1.FaultInjectionStrategy{Segment: "testers"}, want: true},
+		{name: "another segment", route: split, faultInjection: &servicemeshv1alpha1.FaultInjectionStrategy{Segment: "testers"}},
+		{name: "segment of another version", route: v2, faultInjection: &servicemeshv1alpha1.FaultInjectionStrategy{Segment: "testers", Version: "v1"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := isFaultTarget(test.route, test.faultInjection); got != test.want {
+				t.Errorf("fault target %v, want %v", got, test.want)
+			}
+		})
+	}
+}
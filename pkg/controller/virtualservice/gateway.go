@@ -0,0 +1,119 @@
+package virtualservice
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	networkingv1beta1api "istio.io/api/networking/v1beta1"
+	networkingv1beta1 "istio.io/client-go/pkg/apis/networking/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	log "k8s.io/klog"
+
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+	"zmc.io/oasis/pkg/controller/virtualservice/util"
+)
+
+// GatewayVirtualServiceName returns name of the virtualservice routing traffic from gateways
+func GatewayVirtualServiceName(appName string) string {
+	return appName + "-gateway"
+}
+
+// generateGatewayVirtualServiceSpec binds routes of mesh internal virtualservice to gateways
+// declared by strategies, so traffic from outside of mesh shares the same weights.
+// It returns nil if no strategy serves traffic from gateways.
+func generateGatewayVirtualServiceSpec(spec *networkingv1beta1api.VirtualService, strategies []*servicemeshv1alpha1.Strategy) *networkingv1beta1api.VirtualService {
+	var hosts, gateways []string
+	for _, strategy := range strategies {
+		if strategy.Spec.Ingress != nil {
+			hosts = appendUnique(hosts, strategy.Spec.Ingress.Hosts...)
+			gateways = appendUnique(gateways, strategy.Spec.Ingress.Gateways...)
+		}
+	}
+
+	if len(hosts) == 0 || len(gateways) == 0 {
+		return nil
+	}
+
+	gatewaySpec := spec.DeepCopy()
+	gatewaySpec.Hosts = hosts
+	gatewaySpec.Gateways = gateways
+
+	return gatewaySpec
+}
+
+// syncGatewayVirtualService creates or updates the virtualservice routing traffic from gateways,
+// or deletes it if spec is nil. It returns a drift message if the virtualservice is overridden
+// on purpose and left alone.
+func (v *VirtualServiceController) syncGatewayVirtualService(service *v1.Service, appName string, spec *networkingv1beta1api.VirtualService) (string, error) {
+	name := GatewayVirtualServiceName(appName)
+
+	current, err := v.virtualServiceLister.VirtualServices(service.Namespace).Get(name)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "cannot get gateway virtualservice ", "namespace", service.Namespace, "name", name)
+		return "", err
+	}
+
+	// never touch a virtualservice created by others
+	if current != nil && !util.CanManage(current, service) {
+		if spec == nil {
+			return "", nil
+		}
+		return "", fmt.Errorf("gateway virtualservice %s/%s is not managed by oasis", service.Namespace, name)
+	}
+
+	if spec == nil {
+		// overridden ones are kept as they are
+		if current == nil || util.IsOverridden(current) {
+			return "", nil
+		}
+
+		err = v.virtualServiceClient.NetworkingV1beta1().VirtualServices(service.Namespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			log.Error(err, "delete gateway virtualservice failed", "namespace", service.Namespace, "name", name)
+			return "", err
+		}
+		return "", nil
+	}
+
+	if current == nil {
+		vs := &networkingv1beta1.VirtualService{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            name,
+				Namespace:       service.Namespace,
+				Labels:          util.ManagedLabels(service),
+				OwnerReferences: []metav1.OwnerReference{*util.NewServiceControllerRef(service)},
+			},
+			Spec: *spec,
+		}
+
+		_, err = v.virtualServiceClient.NetworkingV1beta1().VirtualServices(service.Namespace).Create(context.TODO(), vs, metav1.CreateOptions{})
+		if err != nil {
+			v.eventRecorder.Event(vs, v1.EventTypeWarning, "FailedToCreateVirtualService", fmt.Sprintf("Failed to create gateway virtualservice for service %v/%v: %v", service.Namespace, service.Name, err))
+		}
+		return "", err
+	}
+
+	managedLabels := util.ManagedLabels(service)
+	if reflect.DeepEqual(current.Spec, *spec) && reflect.DeepEqual(current.Labels, managedLabels) && metav1.IsControlledBy(current, service) {
+		return "", nil
+	}
+
+	// changes made on purpose are kept
+	if util.IsOverridden(current) {
+		return fmt.Sprintf("gateway virtualservice %s/%s is overridden, generated routes are not applied", service.Namespace, name), nil
+	}
+
+	vs := current.DeepCopy()
+	vs.Labels = managedLabels
+	util.SetServiceControllerRef(vs, service)
+	vs.Spec = *spec
+
+	_, err = v.virtualServiceClient.NetworkingV1beta1().VirtualServices(service.Namespace).Update(context.TODO(), vs, metav1.UpdateOptions{})
+	if err != nil {
+		v.eventRecorder.Event(vs, v1.EventTypeWarning, "FailedToUpdateVirtualService", fmt.Sprintf("Failed to update gateway virtualservice for service %v/%v: %v", service.Namespace, service.Name, err))
+	}
+	return "", err
+}
@@ -0,0 +1,206 @@
+package virtualservice
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	networkingv1beta1api "istio.io/api/networking/v1beta1"
+	networkingv1beta1 "istio.io/client-go/pkg/apis/networking/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+	"zmc.io/oasis/pkg/controller/virtualservice/util"
+)
+
+func TestGenerateGatewayVirtualServiceSpec(t *testing.T) {
+	spec := &networkingv1beta1api.VirtualService{
+		Hosts: []string{"reviews.default.svc.cluster.local"},
+		Http:  []*networkingv1beta1api.HTTPRoute{{Route: canaryDestinations("reviews", "v1", "v2", 20)}},
+	}
+
+	withIngress := func(name string, gateways, hosts []string) *servicemeshv1alpha1.Strategy {
+		return newTestStrategy(name, servicemeshv1alpha1.StrategySpec{
+			Ingress: &servicemeshv1alpha1.IngressStrategy{Gateways: gateways, Hosts: hosts},
+		})
+	}
+
+	tests := []struct {
+		name       string
+		strategies []*servicemeshv1alpha1.Strategy
+
+		wantHosts    []string
+		wantGateways []string
+	}{
+		{
+			name:       "no ingress",
+			strategies: []*servicemeshv1alpha1.Strategy{newTestStrategy("canary", canarySpec())},
+		},
+		{
+			name:         "ingress",
+			strategies:   []*servicemeshv1alpha1.Strategy{withIngress("canary", []string{"istio-system/public"}, []string{"reviews.example.com"})},
+			wantHosts:    []string{"reviews.example.com"},
+			wantGateways: []string{"istio-system/public"},
+		},
+		{
+			name: "ingresses of strategies joined",
+			strategies: []*servicemeshv1alpha1.Strategy{
+				withIngress("a", []string{"istio-system/public"}, []string{"reviews.example.com"}),
+				withIngress("b", []string{"istio-system/public", "internal"}, []string{"reviews.example.com", "reviews.internal"}),
+			},
+			wantHosts:    []string{"reviews.example.com", "reviews.internal"},
+			wantGateways: []string{"istio-system/public", "internal"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gatewaySpec := generateGatewayVirtualServiceSpec(spec, test.strategies)
+			if len(test.wantHosts) == 0 {
+				if gatewaySpec != nil {
+					t.Errorf("unexpected gateway virtualservice %v", gatewaySpec)
+				}
+				return
+			}
+
+			if gatewaySpec == nil {
+				t.Fatalf("gateway virtualservice is not generated")
+			}
+			if !reflect.DeepEqual(gatewaySpec.Hosts, test.wantHosts) || !reflect.DeepEqual(gatewaySpec.Gateways, test.wantGateways) {
+				t.Errorf("hosts %v gateways %v, want %v %v", gatewaySpec.Hosts, gatewaySpec.Gateways, test.wantHosts, test.wantGateways)
+			}
+			if !reflect.DeepEqual(gatewaySpec.Http, spec.Http) {
+				t.Errorf("routes %v, want the same as mesh internal ones", gatewaySpec.Http)
+			}
+			if !reflect.DeepEqual(spec.Hosts, []string{"reviews.default.svc.cluster.local"}) {
+				t.Errorf("mesh internal virtualservice is changed")
+			}
+		})
+	}
+}
+
+func TestSyncServiceGateway(t *testing.T) {
+	ingress := &servicemeshv1alpha1.IngressStrategy{Gateways: []string{"istio-system/public"}, Hosts: []string{"reviews.example.com"}}
+	gatewayName := GatewayVirtualServiceName(testApp)
+
+	newGatewayVirtualService := func(managed bool) *networkingv1beta1.VirtualService {
+		vs := &networkingv1beta1.VirtualService{
+			ObjectMeta: metav1.ObjectMeta{Name: gatewayName, Namespace: testNamespace, ResourceVersion: "1"},
+			Spec:       networkingv1beta1api.VirtualService{Hosts: []string{"reviews.example.com"}},
+		}
+		if managed {
+			service := newTestService("reviews")
+			vs.Labels = util.ManagedLabels(service)
+			vs.OwnerReferences = []metav1.OwnerReference{*util.NewServiceControllerRef(service)}
+		}
+		return vs
+	}
+
+	tests := []struct {
+		name    string
+		ingress *servicemeshv1alpha1.IngressStrategy
+		current *networkingv1beta1.VirtualService
+
+		wantGateway  bool
+		wantComplete v1.ConditionStatus
+		wantErr      bool
+	}{
+		{
+			name:         "created",
+			ingress:      ingress,
+			wantGateway:  true,
+			wantComplete: v1.ConditionTrue,
+		},
+		{
+			name:         "updated",
+			ingress:      ingress,
+			current:      newGatewayVirtualService(true),
+			wantGateway:  true,
+			wantComplete: v1.ConditionTrue,
+		},
+		{
+			name:         "deleted without ingress",
+			current:      newGatewayVirtualService(true),
+			wantComplete: v1.ConditionTrue,
+		},
+		{
+			name:         "not managed",
+			ingress:      ingress,
+			current:      newGatewayVirtualService(false),
+			wantGateway:  true,
+			wantComplete: v1.ConditionFalse,
+			wantErr:      true,
+		},
+		{
+			name:         "not managed left alone without ingress",
+			current:      newGatewayVirtualService(false),
+			wantGateway:  true,
+			wantComplete: v1.ConditionTrue,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			spec := canarySpec()
+			spec.Ingress = test.ingress
+
+			objects := []runtime.Object{newTestService("reviews"), newTestDestinationRule("reviews", "v1", "v2"), newTestStrategy("canary", spec)}
+			if test.current != nil {
+				objects = append(objects, test.current)
+			}
+			f := newFixture(t, objects...)
+			if test.wantErr {
+				if err := f.controller.syncService(testNamespace + "/reviews"); err == nil {
+					t.Fatalf("expected error")
+				}
+			} else {
+				f.sync("reviews")
+			}
+
+			gateway, err := f.istioClient.NetworkingV1beta1().VirtualServices(testNamespace).Get(context.TODO(), gatewayName, metav1.GetOptions{})
+			if err != nil && !errors.IsNotFound(err) {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if found := err == nil; found != test.wantGateway {
+				t.Fatalf("gateway virtualservice found %v, want %v", found, test.wantGateway)
+			}
+
+			complete := util.GetStrategyCondition(f.strategy("canary").Status, servicemeshv1alpha1.StrategyComplete)
+			if complete == nil || complete.Status != test.wantComplete {
+				t.Errorf("complete condition %v, want %s", complete, test.wantComplete)
+			}
+
+			if test.ingress == nil || test.wantErr {
+				return
+			}
+
+			if !reflect.DeepEqual(gateway.Spec.Gateways, ingress.Gateways) || !reflect.DeepEqual(gateway.Spec.Hosts, ingress.Hosts) {
+				t.Errorf("gateways %v hosts %v, want %v %v", gateway.Spec.Gateways, gateway.Spec.Hosts, ingress.Gateways, ingress.Hosts)
+			}
+			if !util.IsManaged(gateway) || !metav1.IsControlledBy(gateway, newTestService("reviews")) {
+				t.Errorf("gateway virtualservice is not managed, labels %v owners %v", gateway.Labels, gateway.OwnerReferences)
+			}
+
+			vs, err := f.istioClient.NetworkingV1beta1().VirtualServices(testNamespace).Get(context.TODO(), testApp, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			// traffic from gateways shares the weights, to the service port
+			for i, route := range gateway.Spec.Http {
+				for j, destination := range route.Route {
+					internal := vs.Spec.Http[i].Route[j]
+					if destination.Destination.Subset != internal.Destination.Subset || destination.Weight != internal.Weight {
+						t.Errorf("route %d destination %v, want the same as %v", i, destination, internal)
+					}
+					if destination.Destination.Port == nil || destination.Destination.Port.Number != 80 {
+						t.Errorf("route %d destination port %v, want 80", i, destination.Destination.Port)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package virtualservice
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	networkingv1beta1api "istio.io/api/networking/v1beta1"
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+)
+
+// strategyDelivery tracks how a strategy is delivered to virtualservice
+type strategyDelivery struct {
+	strategy *servicemeshv1alpha1.Strategy
+
+	// time strategy is evaluated at, against its schedule window among others
+	now time.Time
+
+	// reason and message why strategy is not delivered yet
+	pendingReason  string
+	pendingMessage string
+
+	// routes of strategy overridden by strategies with higher priority
+	conflictMessage string
+
+	// virtualservices overridden on purpose, differing from what strategies generate
+	driftMessage string
+}
+
+// SortStrategies orders strategies by priority from high to low, and by name
+// when priorities are the same, so merge result is deterministic.
+func SortStrategies(strategies []*servicemeshv1alpha1.Strategy) {
+	sort.SliceStable(strategies, func(i, j int) bool {
+		if strategies[i].Spec.Priority != strategies[j].Spec.Priority {
+			return strategies[i].Spec.Priority > strategies[j].Spec.Priority
+		}
+		return strategies[i].Name < strategies[j].Name
+	})
+}
+
+// mergeVirtualServiceSpecs composes virtualservice specs generated by sorted strategies.
+// Routes with match conditions are kept in priority order, while default routes
+// catching all the rest traffic come from the strategy with highest priority only.
+// It returns the merged spec, and conflict messages for each strategy.
+func mergeVirtualServiceSpecs(strategies []*servicemeshv1alpha1.Strategy, specs []*networkingv1beta1api.VirtualService) (*networkingv1beta1api.VirtualService, []string) {
+	merged := &networkingv1beta1api.VirtualService{}
+	conflicts := make([]string, len(specs))
+
+	var httpDefaults []*networkingv1beta1api.HTTPRoute
+	var tcpDefaults []*networkingv1beta1api.TCPRoute
+	var httpOwner, tcpOwner string
+
+	for i, spec := range specs {
+		name := strategies[i].Name
+		overridden := make([]string, 0)
+
+		merged.Hosts = appendUnique(merged.Hosts, spec.Hosts...)
+		merged.Gateways = appendUnique(merged.Gateways, spec.Gateways...)
+		if len(merged.ExportTo) == 0 {
+			merged.ExportTo = spec.ExportTo
+		}
+
+		defaults := make([]*networkingv1beta1api.HTTPRoute, 0)
+		for _, route := range spec.Http {
+			if len(route.Match) == 0 {
+				defaults = append(defaults, route)
+			} else {
+				merged.Http = append(merged.Http, route)
+			}
+		}
+		if len(defaults) > 0 {
+			if len(httpOwner) == 0 {
+				httpDefaults, httpOwner = defaults, name
+			} else {
+				overridden = append(overridden, fmt.Sprintf("default http route is overridden by strategy %s", httpOwner))
+			}
+		}
+
+		tcpDefault := make([]*networkingv1beta1api.TCPRoute, 0)
+		for _, route := range spec.Tcp {
+			if len(route.Match) == 0 {
+				tcpDefault = append(tcpDefault, route)
+			} else {
+				merged.Tcp = append(merged.Tcp, route)
+			}
+		}
+		if len(tcpDefault) > 0 {
+			if len(tcpOwner) == 0 {
+				tcpDefaults, tcpOwner = tcpDefault, name
+			} else {
+				overridden = append(overridden, fmt.Sprintf("default tcp route is overridden by strategy %s", tcpOwner))
+			}
+		}
+
+		merged.Tls = append(merged.Tls, spec.Tls...)
+		conflicts[i] = strings.Join(overridden, ", ")
+	}
+
+	merged.Http = append(merged.Http, httpDefaults...)
+	merged.Tcp = append(merged.Tcp, tcpDefaults...)
+
+	return merged, conflicts
+}
+
+// appendUnique appends items not in list yet
+func appendUnique(list []string, items ...string) []string {
+	for _, item := range items {
+		found := false
+		for _, existing := range list {
+			if existing == item {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,193 @@
+package virtualservice
+
+import (
+	"reflect"
+	"testing"
+
+	networkingv1beta1api "istio.io/api/networking/v1beta1"
+	v1 "k8s.io/api/core/v1"
+
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+	"zmc.io/oasis/pkg/controller/virtualservice/util"
+)
+
+func TestSortStrategies(t *testing.T) {
+	newStrategy := func(name string, priority int32) *servicemeshv1alpha1.Strategy {
+		return newTestStrategy(name, servicemeshv1alpha1.StrategySpec{Priority: priority})
+	}
+
+	strategies := []*servicemeshv1alpha1.Strategy{
+		newStrategy("c", 0),
+		newStrategy("b", 10),
+		newStrategy("a", 0),
+		newStrategy("d", 10),
+	}
+
+	SortStrategies(strategies)
+
+	names := make([]string, 0, len(strategies))
+	for _, strategy := range strategies {
+		names = append(names, strategy.Name)
+	}
+
+	if want := []string{"b", "d", "a", "c"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("sorted %v, want %v", names, want)
+	}
+}
+
+func TestMergeVirtualServiceSpecs(t *testing.T) {
+	host := "reviews.default.svc.cluster.local"
+
+	headerRoute := func(name, subset string) *networkingv1beta1api.HTTPRoute {
+		return &networkingv1beta1api.HTTPRoute{
+			Name: name,
+			Match: []*networkingv1beta1api.HTTPMatchRequest{{
+				Headers: map[string]*networkingv1beta1api.StringMatch{
+					"x-team": {MatchType: &networkingv1beta1api.StringMatch_Exact{Exact: name}},
+				},
+			}},
+			Route: versionDestinations(host, subset),
+		}
+	}
+	defaultRoute := func(name, subset string) *networkingv1beta1api.HTTPRoute {
+		return &networkingv1beta1api.HTTPRoute{Name: name, Route: versionDestinations(host, subset)}
+	}
+	tcpRoute := func(subset string) *networkingv1beta1api.TCPRoute {
+		return &networkingv1beta1api.TCPRoute{Route: tcpDestinations(versionDestinations(host, subset))}
+	}
+
+	tests := []struct {
+		name  string
+		specs []*networkingv1beta1api.VirtualService
+
+		wantRoutes    []string
+		wantTCP       []string
+		wantHosts     []string
+		wantConflicts []string
+	}{
+		{
+			name: "header routes composed with a canary",
+			specs: []*networkingv1beta1api.VirtualService{
+				{Hosts: []string{host}, Http: []*networkingv1beta1api.HTTPRoute{headerRoute("testers", "v2")}},
+				{Hosts: []string{host}, Http: []*networkingv1beta1api.HTTPRoute{defaultRoute("canary", "v1")}},
+			},
+			wantRoutes:    []string{"testers", "canary"},
+			wantHosts:     []string{host},
+			wantConflicts: []string{"", ""},
+		},
+		{
+			name: "match routes kept in priority order ahead of default",
+			specs: []*networkingv1beta1api.VirtualService{
+				{Hosts: []string{host}, Http: []*networkingv1beta1api.HTTPRoute{defaultRoute("high", "v1"), headerRoute("a", "v2")}},
+				{Hosts: []string{host, "reviews.example.com"}, Http: []*networkingv1beta1api.HTTPRoute{headerRoute("b", "v3")}},
+			},
+			wantRoutes:    []string{"a", "b", "high"},
+			wantHosts:     []string{host, "reviews.example.com"},
+			wantConflicts: []string{"", ""},
+		},
+		{
+			name: "default routes of lower priority overridden",
+			specs: []*networkingv1beta1api.VirtualService{
+				{Hosts: []string{host}, Http: []*networkingv1beta1api.HTTPRoute{defaultRoute("high", "v1")}, Tcp: []*networkingv1beta1api.TCPRoute{tcpRoute("v1")}},
+				{Hosts: []string{host}, Http: []*networkingv1beta1api.HTTPRoute{defaultRoute("low", "v2")}, Tcp: []*networkingv1beta1api.TCPRoute{tcpRoute("v2")}},
+			},
+			wantRoutes: []string{"high"},
+			wantTCP:    []string{"v1"},
+			wantHosts:  []string{host},
+			wantConflicts: []string{
+				"",
+				"default http route is overridden by strategy high, default tcp route is overridden by strategy high",
+			},
+		},
+		{
+			name: "tcp default from the only strategy routing tcp",
+			specs: []*networkingv1beta1api.VirtualService{
+				{Hosts: []string{host}, Http: []*networkingv1beta1api.HTTPRoute{defaultRoute("high", "v1")}},
+				{Hosts: []string{host}, Tcp: []*networkingv1beta1api.TCPRoute{tcpRoute("v2")}},
+			},
+			wantRoutes:    []string{"high"},
+			wantTCP:       []string{"v2"},
+			wantHosts:     []string{host},
+			wantConflicts: []string{"", ""},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			strategies := []*servicemeshv1alpha1.Strategy{
+				newTestStrategy("high", servicemeshv1alpha1.StrategySpec{Priority: 10}),
+				newTestStrategy("low", servicemeshv1alpha1.StrategySpec{}),
+			}
+
+			merged, conflicts := mergeVirtualServiceSpecs(strategies, test.specs)
+
+			if names := routeNames(merged.Http); !reflect.DeepEqual(names, test.wantRoutes) {
+				t.Errorf("http routes %v, want %v", names, test.wantRoutes)
+			}
+
+			tcp := make([]string, 0, len(merged.Tcp))
+			for _, route := range merged.Tcp {
+				tcp = append(tcp, route.Route[0].Destination.Subset)
+			}
+			if len(tcp) > 0 || len(test.wantTCP) > 0 {
+				if !reflect.DeepEqual(tcp, test.wantTCP) {
+					t.Errorf("tcp routes %v, want %v", tcp, test.wantTCP)
+				}
+			}
+
+			if !reflect.DeepEqual(merged.Hosts, test.wantHosts) {
+				t.Errorf("hosts %v, want %v", merged.Hosts, test.wantHosts)
+			}
+
+			if !reflect.DeepEqual(conflicts, test.wantConflicts) {
+				t.Errorf("conflicts %q, want %q", conflicts, test.wantConflicts)
+			}
+		})
+	}
+}
+
+func TestSyncServiceMultipleStrategies(t *testing.T) {
+	testers := newTestStrategy("testers", servicemeshv1alpha1.StrategySpec{
+		Priority:         10,
+		PrincipalVersion: "v1",
+		Segments:         []servicemeshv1alpha1.UserSegment{{Name: "testers", Version: "v2", Headers: map[string]string{"x-user": "tester"}}},
+	})
+	canary := newTestStrategy("canary", canarySpec())
+
+	f := newFixture(t, newTestService("reviews"), newTestDestinationRule("reviews", "v1", "v2"), testers, canary)
+	vs := f.sync("reviews")
+
+	if names := routeNames(vs.Spec.Http); !reflect.DeepEqual(names, []string{"testers", ""}) {
+		t.Errorf("http routes %v, want segment route ahead of default", names)
+	}
+	if subsets := routeSubsets(vs.Spec.Http); !reflect.DeepEqual(subsets, []string{"v2", "v1"}) {
+		t.Errorf("subsets %v, want default route of strategy with higher priority", subsets)
+	}
+
+	tests := []struct {
+		name         string
+		wantConflict string
+	}{
+		{name: "testers"},
+		{name: "canary", wantConflict: "default http route is overridden by strategy testers"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			strategy := f.strategy(test.name)
+
+			complete := util.GetStrategyCondition(strategy.Status, servicemeshv1alpha1.StrategyComplete)
+			if complete == nil || complete.Status != v1.ConditionTrue {
+				t.Errorf("complete condition %v, want delivered", complete)
+			}
+
+			conflicted := util.GetStrategyCondition(strategy.Status, servicemeshv1alpha1.StrategyConflicted)
+			if len(test.wantConflict) == 0 && conflicted != nil {
+				t.Errorf("unexpected condition %v", conflicted)
+			}
+			if len(test.wantConflict) > 0 && (conflicted == nil || conflicted.Message != test.wantConflict) {
+				t.Errorf("conflicted condition %v, want message %q", conflicted, test.wantConflict)
+			}
+		})
+	}
+}
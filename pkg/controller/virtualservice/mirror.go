@@ -0,0 +1,52 @@
+package virtualservice
+
+import (
+	v1 "k8s.io/api/core/v1"
+
+	networkingv1beta1 "istio.io/client-go/pkg/apis/networking/v1beta1"
+
+	networkingv1beta1api "istio.io/api/networking/v1beta1"
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+	"zmc.io/oasis/pkg/controller/virtualservice/util"
+)
+
+func isMirror(strategy *servicemeshv1alpha1.Strategy) bool {
+	return strategy.Spec.Type == servicemeshv1alpha1.Mirror && strategy.Spec.Mirror != nil
+}
+
+// applyMirror mirrors a percentage of http traffic to mirror version, routes
+// without any destination are served by principal version.
+func applyMirror(vs *networkingv1beta1.VirtualService, strategy *servicemeshv1alpha1.Strategy, service *v1.Service) {
+	percentage := int32(100)
+	if strategy.Spec.Mirror.Percentage != nil {
+		percentage = *strategy.Spec.Mirror.Percentage
+	}
+
+	ensureDefaultRoutes(vs, service)
+
+	for _, httpRoute := range vs.Spec.Http {
+		if len(httpRoute.Route) == 0 && len(strategy.Spec.PrincipalVersion) > 0 {
+			httpRoute.Route = versionDestinations(util.ServiceFQDN(service), util.NormalizeVersionName(strategy.Spec.PrincipalVersion))
+		}
+
+		httpRoute.Mirror = &networkingv1beta1api.Destination{
+			Host:   util.ServiceFQDN(service),
+			Subset: util.NormalizeVersionName(strategy.Spec.Mirror.Version),
+		}
+		httpRoute.MirrorPercentage = &networkingv1beta1api.Percent{Value: float64(percentage)}
+	}
+
+	for _, tcpRoute := range vs.Spec.Tcp {
+		if len(tcpRoute.Route) == 0 && len(strategy.Spec.PrincipalVersion) > 0 {
+			tcpRoute.Route = []*networkingv1beta1api.RouteDestination{
+				{
+					Destination: &networkingv1beta1api.Destination{
+						Host:   util.ServiceFQDN(service),
+						Subset: util.NormalizeVersionName(strategy.Spec.PrincipalVersion),
+					},
+					Weight: 100,
+				},
+			}
+		}
+	}
+}
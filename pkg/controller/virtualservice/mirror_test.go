@@ -0,0 +1,97 @@
+package virtualservice
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+)
+
+func TestGenerateVirtualServiceSpecMirror(t *testing.T) {
+	percentage := int32(20)
+
+	tests := []struct {
+		name     string
+		spec     servicemeshv1alpha1.StrategySpec
+		ports    []v1.ServicePort
+		wantHTTP int
+		wantTCP  int
+		want     float64
+	}{
+		{
+			name: "default percentage",
+			spec: servicemeshv1alpha1.StrategySpec{
+				Type:             servicemeshv1alpha1.Mirror,
+				PrincipalVersion: "v1",
+				Mirror:           &servicemeshv1alpha1.MirrorStrategy{Version: "v2"},
+			},
+			wantHTTP: 1,
+			want:     100,
+		},
+		{
+			name: "percentage",
+			spec: servicemeshv1alpha1.StrategySpec{
+				Type:             servicemeshv1alpha1.Mirror,
+				PrincipalVersion: "v1",
+				Mirror:           &servicemeshv1alpha1.MirrorStrategy{Version: "v2", Percentage: &percentage},
+			},
+			wantHTTP: 1,
+			want:     20,
+		},
+		{
+			name: "segment routes mirrored",
+			spec: servicemeshv1alpha1.StrategySpec{
+				Type:             servicemeshv1alpha1.Mirror,
+				PrincipalVersion: "v1",
+				Mirror:           &servicemeshv1alpha1.MirrorStrategy{Version: "v2", Percentage: &percentage},
+				Segments: []servicemeshv1alpha1.UserSegment{
+					{Name: "testers", Version: "v1", Headers: map[string]string{"x-user": "tester"}},
+					{Name: "api", Version: "v1", URIPrefix: "/api"},
+				},
+			},
+			wantHTTP: 3,
+			want:     20,
+		},
+		{
+			name: "tcp routes served by principal version",
+			spec: servicemeshv1alpha1.StrategySpec{
+				Type:             servicemeshv1alpha1.Mirror,
+				PrincipalVersion: "v1",
+				Mirror:           &servicemeshv1alpha1.MirrorStrategy{Version: "v2"},
+			},
+			ports:   []v1.ServicePort{{Name: "tcp-db", Port: 3306}},
+			wantTCP: 1,
+			want:    100,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			strategy := newTestStrategy("mirror", test.spec)
+			vs := GenerateVirtualServiceSpec(strategy, newTestService("reviews", test.ports...))
+
+			if len(vs.Spec.Http) != test.wantHTTP || len(vs.Spec.Tcp) != test.wantTCP {
+				t.Fatalf("got %d http and %d tcp routes, want %d and %d", len(vs.Spec.Http), len(vs.Spec.Tcp), test.wantHTTP, test.wantTCP)
+			}
+
+			for i, route := range vs.Spec.Http {
+				if route.Mirror == nil || route.Mirror.Subset != "v2" {
+					t.Errorf("route %d mirror %v, want to v2", i, route.Mirror)
+				}
+				if route.MirrorPercentage == nil || route.MirrorPercentage.Value != test.want {
+					t.Errorf("route %d mirror percentage %v, want %v", i, route.MirrorPercentage, test.want)
+				}
+				if len(route.Route) == 0 || route.Route[0].Destination.Subset != "v1" {
+					t.Errorf("route %d destinations %v, want to v1", i, route.Route)
+				}
+			}
+
+			for i, route := range vs.Spec.Tcp {
+				if len(route.Route) == 0 || route.Route[0].Destination.Subset != "v1" {
+					t.Errorf("tcp route %d destinations %v, want to v1", i, route.Route)
+				}
+			}
+		})
+	}
+}
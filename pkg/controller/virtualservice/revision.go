@@ -0,0 +1,156 @@
+package virtualservice
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	log "k8s.io/klog"
+
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+	"zmc.io/oasis/pkg/controller/virtualservice/util"
+)
+
+// revisionHistoryLimit is the number of old revisions kept for each strategy
+const revisionHistoryLimit = 10
+
+// snapshotStrategy records spec of a delivered strategy as a controllerrevision.
+// A spec delivered before is moved to the latest revision instead of creating a new one.
+// Revisions are told apart by their snapshots rather than names, since names of the same
+// spec change once a hash collision is counted.
+func (v *VirtualServiceController) snapshotStrategy(strategy *servicemeshv1alpha1.Strategy) error {
+	name, data, err := util.StrategyRevisionName(strategy)
+	if err != nil {
+		return err
+	}
+
+	revisions, err := v.listStrategyRevisions(strategy)
+	if err != nil {
+		return err
+	}
+
+	next := int64(1)
+	current := -1
+	for i, revision := range revisions {
+		if revision.Revision >= next {
+			next = revision.Revision + 1
+		}
+		if bytes.Equal(revision.Data.Raw, data) {
+			current = i
+		}
+	}
+
+	switch {
+	case current >= 0 && revisions[current].Revision == next-1:
+		// spec is already the latest revision
+		return nil
+	case current >= 0:
+		// spec rolled back to a previous revision, which becomes the latest one
+		newRevision := revisions[current].DeepCopy()
+		newRevision.Revision = next
+		_, err = v.client.AppsV1().ControllerRevisions(strategy.Namespace).Update(context.TODO(), newRevision, metav1.UpdateOptions{})
+		revisions = append(revisions[:current], revisions[current+1:]...)
+		revisions = append(revisions, newRevision)
+	default:
+		revision := &appsv1.ControllerRevision{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            name,
+				Namespace:       strategy.Namespace,
+				Labels:          map[string]string{util.StrategyRevisionLabel: strategy.Name},
+				OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(strategy, servicemeshv1alpha1.SchemeGroupVersion.WithKind(servicemeshv1alpha1.ResourceKindStrategy))},
+			},
+			Data:     runtime.RawExtension{Raw: data},
+			Revision: next,
+		}
+		_, err = v.client.AppsV1().ControllerRevisions(strategy.Namespace).Create(context.TODO(), revision, metav1.CreateOptions{})
+		if errors.IsAlreadyExists(err) {
+			existing, err := v.client.AppsV1().ControllerRevisions(strategy.Namespace).Get(context.TODO(), name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+
+			// revision created already, but not in cache yet
+			if metav1.IsControlledBy(existing, strategy) && bytes.Equal(existing.Data.Raw, data) {
+				return nil
+			}
+
+			// name is taken by another spec, it is retried with another name
+			return v.countStrategyCollision(strategy, name)
+		}
+		revisions = append(revisions, revision)
+	}
+
+	if err != nil {
+		log.Errorf("snapshot strategy %s/%s failed, %v", strategy.Namespace, strategy.Name, err)
+		return err
+	}
+
+	return v.pruneStrategyRevisions(strategy, revisions)
+}
+
+// countStrategyCollision bumps collision count of strategy, so the next revision is named
+// differently from the one colliding with it. An error is returned to get strategy retried.
+func (v *VirtualServiceController) countStrategyCollision(strategy *servicemeshv1alpha1.Strategy, name string) error {
+	latest, err := v.servicemeshClient.ServicemeshV1alpha1().Strategies(strategy.Namespace).Get(context.TODO(), strategy.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	status := latest.Status.DeepCopy()
+	collisionCount := int32(1)
+	if status.CollisionCount != nil {
+		collisionCount = *status.CollisionCount + 1
+	}
+	status.CollisionCount = &collisionCount
+
+	if _, err = v.writeStrategyStatus(latest, status); err != nil {
+		return err
+	}
+
+	return fmt.Errorf("revision %s/%s of strategy %s collides with another one, retry with collision count %d",
+		strategy.Namespace, name, strategy.Name, collisionCount)
+}
+
+// listStrategyRevisions returns revisions owned by strategy, sorted from old to new
+func (v *VirtualServiceController) listStrategyRevisions(strategy *servicemeshv1alpha1.Strategy) ([]*appsv1.ControllerRevision, error) {
+	revisions, err := v.revisionLister.ControllerRevisions(strategy.Namespace).List(labels.SelectorFromSet(map[string]string{util.StrategyRevisionLabel: strategy.Name}))
+	if err != nil {
+		return nil, err
+	}
+
+	owned := make([]*appsv1.ControllerRevision, 0, len(revisions))
+	for _, revision := range revisions {
+		if metav1.IsControlledBy(revision, strategy) {
+			owned = append(owned, revision)
+		}
+	}
+
+	sort.Slice(owned, func(i, j int) bool {
+		return owned[i].Revision < owned[j].Revision
+	})
+
+	return owned, nil
+}
+
+// pruneStrategyRevisions deletes the oldest revisions beyond history limit
+func (v *VirtualServiceController) pruneStrategyRevisions(strategy *servicemeshv1alpha1.Strategy, revisions []*appsv1.ControllerRevision) error {
+	if len(revisions) <= revisionHistoryLimit+1 {
+		return nil
+	}
+
+	for _, revision := range revisions[:len(revisions)-revisionHistoryLimit-1] {
+		err := v.client.AppsV1().ControllerRevisions(strategy.Namespace).Delete(context.TODO(), revision.Name, metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			log.Errorf("delete revision %s/%s of strategy failed, %v", revision.Namespace, revision.Name, err)
+			return err
+		}
+	}
+
+	return nil
+}
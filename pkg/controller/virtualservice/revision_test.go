@@ -0,0 +1,198 @@
+package virtualservice
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+	"zmc.io/oasis/pkg/controller/virtualservice/util"
+)
+
+// revisions returns numbers of revisions persisted for each spec, the specs are
+// told apart by their principal version
+func (f *fixture) revisions(strategy *servicemeshv1alpha1.Strategy) map[string]int64 {
+	list, err := f.k8sClient.AppsV1().ControllerRevisions(testNamespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		f.t.Fatalf("list revisions failed, %v", err)
+	}
+
+	revisions := make(map[string]int64, len(list.Items))
+	for i := range list.Items {
+		revision := &list.Items[i]
+		if !metav1.IsControlledBy(revision, strategy) || revision.Labels[util.StrategyRevisionLabel] != strategy.Name {
+			f.t.Errorf("revision %s is not owned by strategy", revision.Name)
+		}
+
+		// cache catches up with what is persisted
+		f.addToCache(revision)
+
+		for _, version := range []string{"v1", "v2", "v3"} {
+			snapshot := strategy.DeepCopy()
+			snapshot.Spec.PrincipalVersion = version
+			if name, _, _ := util.StrategyRevisionName(snapshot); name == revision.Name {
+				revisions[version] = revision.Revision
+			}
+		}
+	}
+
+	return revisions
+}
+
+func TestSnapshotStrategy(t *testing.T) {
+	tests := []struct {
+		name string
+		// principal versions delivered one after another
+		versions []string
+		want     map[string]int64
+	}{
+		{
+			name:     "first delivery",
+			versions: []string{"v1"},
+			want:     map[string]int64{"v1": 1},
+		},
+		{
+			name:     "same spec delivered again",
+			versions: []string{"v1", "v1"},
+			want:     map[string]int64{"v1": 1},
+		},
+		{
+			name:     "spec changed",
+			versions: []string{"v1", "v2", "v3"},
+			want:     map[string]int64{"v1": 1, "v2": 2, "v3": 3},
+		},
+		{
+			name:     "rolled back to previous spec",
+			versions: []string{"v1", "v2", "v1"},
+			want:     map[string]int64{"v1": 3, "v2": 2},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			strategy := newTestStrategy("canary", servicemeshv1alpha1.StrategySpec{})
+			strategy.UID = types.UID("uid-canary")
+			f := newFixture(t)
+
+			var got map[string]int64
+			for _, version := range test.versions {
+				strategy.Spec.PrincipalVersion = version
+				if err := f.controller.snapshotStrategy(strategy); err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				got = f.revisions(strategy)
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("revisions %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPruneStrategyRevisions(t *testing.T) {
+	strategy := newTestStrategy("canary", servicemeshv1alpha1.StrategySpec{})
+	strategy.UID = types.UID("uid-canary")
+	f := newFixture(t)
+
+	for i := 0; i < revisionHistoryLimit+3; i++ {
+		strategy.Spec.Priority = int32(i)
+		if err := f.controller.snapshotStrategy(strategy); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		f.revisions(strategy)
+	}
+
+	revisions, err := f.k8sClient.AppsV1().ControllerRevisions(testNamespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// the current revision is kept besides the history
+	if got := len(revisions.Items); got != revisionHistoryLimit+1 {
+		t.Errorf("kept %d revisions, want %d", got, revisionHistoryLimit+1)
+	}
+
+	for _, revision := range revisions.Items {
+		if revision.Revision <= 2 {
+			t.Errorf("oldest revision %d is kept", revision.Revision)
+		}
+	}
+}
+
+func TestSnapshotStrategyNameTaken(t *testing.T) {
+	tests := []struct {
+		name string
+		// principal version snapshotted by the revision taking the name
+		takenBy string
+
+		wantCollisionCount int32
+	}{
+		{
+			name:    "created already but not in cache",
+			takenBy: "v1",
+		},
+		{
+			name:               "taken by another spec",
+			takenBy:            "v2",
+			wantCollisionCount: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			strategy := newTestStrategy("canary", servicemeshv1alpha1.StrategySpec{PrincipalVersion: "v1"})
+			strategy.UID = types.UID("uid-canary")
+			f := newFixture(t, strategy)
+
+			name, _, _ := util.StrategyRevisionName(strategy)
+			taken := strategy.DeepCopy()
+			taken.Spec.PrincipalVersion = test.takenBy
+			_, data, _ := util.StrategyRevisionName(taken)
+			revision := &appsv1.ControllerRevision{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            name,
+					Namespace:       testNamespace,
+					Labels:          map[string]string{util.StrategyRevisionLabel: strategy.Name},
+					OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(strategy, servicemeshv1alpha1.SchemeGroupVersion.WithKind(servicemeshv1alpha1.ResourceKindStrategy))},
+				},
+				Data:     runtime.RawExtension{Raw: data},
+				Revision: 1,
+			}
+			if _, err := f.k8sClient.AppsV1().ControllerRevisions(testNamespace).Create(context.TODO(), revision, metav1.CreateOptions{}); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			err := f.controller.snapshotStrategy(strategy)
+			if (err != nil) != (test.wantCollisionCount > 0) {
+				t.Fatalf("error %v, want collision %v", err, test.wantCollisionCount > 0)
+			}
+
+			persisted := f.strategy("canary")
+			if test.wantCollisionCount == 0 {
+				if persisted.Status.CollisionCount != nil {
+					t.Errorf("collision count %d, want none", *persisted.Status.CollisionCount)
+				}
+				return
+			}
+
+			if persisted.Status.CollisionCount == nil || *persisted.Status.CollisionCount != test.wantCollisionCount {
+				t.Fatalf("collision count %v, want %d", persisted.Status.CollisionCount, test.wantCollisionCount)
+			}
+
+			// retried with collision count, the spec is snapshotted under another name
+			f.addToCache(revision)
+			if err := f.controller.snapshotStrategy(persisted); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if got := f.revisions(persisted); !reflect.DeepEqual(got, map[string]int64{"v1": 2}) {
+				t.Errorf("revisions %v, want v1 snapshotted as revision 2", got)
+			}
+		})
+	}
+}
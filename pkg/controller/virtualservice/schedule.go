@@ -0,0 +1,98 @@
+package virtualservice
+
+import (
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+	"zmc.io/oasis/pkg/controller/virtualservice/util"
+)
+
+// windows joined beyond this are not looked up, the strategy is checked again then
+const maxScheduleLookahead = 7 * 24 * time.Hour
+
+// isStrategyActive tells if strategy is in its schedule window at now, and when
+// it turns on or off next time, which is zero if it never changes again.
+func isStrategyActive(strategy *servicemeshv1alpha1.Strategy, now time.Time) (bool, time.Time, error) {
+	schedule := strategy.Spec.Schedule
+	if schedule == nil {
+		return true, time.Time{}, nil
+	}
+
+	if schedule.EndTime != nil && !now.Before(schedule.EndTime.Time) {
+		return false, time.Time{}, nil
+	}
+
+	if schedule.StartTime != nil && now.Before(schedule.StartTime.Time) {
+		return false, schedule.StartTime.Time, nil
+	}
+
+	var end time.Time
+	if schedule.EndTime != nil {
+		end = schedule.EndTime.Time
+	}
+
+	if len(schedule.Cron) == 0 {
+		return true, end, nil
+	}
+
+	cron, err := util.ParseCron(schedule.Cron)
+	if err != nil {
+		return false, time.Time{}, err
+	}
+
+	if schedule.Duration == nil || schedule.Duration.Duration <= 0 {
+		return false, time.Time{}, fmt.Errorf("duration of cron window is required")
+	}
+
+	active, next := cronWindow(cron, schedule.Duration.Duration, now)
+	if !end.IsZero() && (next.IsZero() || end.Before(next)) {
+		next = end
+	}
+
+	return active, next, nil
+}
+
+// cronWindow tells if now is in a window opened by cron, and when the window closes,
+// or when next window opens if not in any. Overlapped windows are joined together.
+func cronWindow(cron *util.CronSchedule, duration time.Duration, now time.Time) (bool, time.Time) {
+	fire := cron.Next(now.Add(-duration))
+	if fire.IsZero() {
+		return false, time.Time{}
+	}
+
+	if fire.After(now) {
+		return false, fire
+	}
+
+	end := fire.Add(duration)
+	for end.Sub(now) < maxScheduleLookahead {
+		fire = cron.Next(fire)
+		if fire.IsZero() || fire.After(end) {
+			return true, end
+		}
+		end = fire.Add(duration)
+	}
+
+	return true, end
+}
+
+// setActiveCondition records if a scheduled strategy is in its window at now
+func setActiveCondition(strategy *servicemeshv1alpha1.Strategy, status *servicemeshv1alpha1.StrategyStatus, now time.Time) {
+	if strategy.Spec.Schedule == nil {
+		util.RemoveStrategyCondition(status, servicemeshv1alpha1.StrategyActive)
+		return
+	}
+
+	active, _, err := isStrategyActive(strategy, now)
+	switch {
+	case err != nil:
+		util.SetStrategyCondition(status, *util.NewStrategyCondition(servicemeshv1alpha1.StrategyActive, v1.ConditionFalse, ReasonInvalidSchedule, err.Error()))
+	case active:
+		util.SetStrategyCondition(status, *util.NewStrategyCondition(servicemeshv1alpha1.StrategyActive, v1.ConditionTrue, ReasonInScheduleWindow, "strategy is in its schedule window"))
+	default:
+		util.SetStrategyCondition(status, *util.NewStrategyCondition(servicemeshv1alpha1.StrategyActive, v1.ConditionFalse, ReasonOutOfScheduleWindow, "strategy is out of its schedule window"))
+	}
+}
@@ -0,0 +1,252 @@
+package virtualservice
+
+import (
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+	"zmc.io/oasis/pkg/controller/virtualservice/util"
+)
+
+func TestCronWindow(t *testing.T) {
+	day := time.Date(2020, time.February, 1, 0, 0, 0, 0, time.UTC)
+	at := func(hour, minute int) time.Time {
+		return day.Add(time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute)
+	}
+
+	tests := []struct {
+		name     string
+		expr     string
+		duration time.Duration
+		now      time.Time
+
+		wantActive bool
+		wantNext   time.Time
+	}{
+		{
+			name:     "before window",
+			expr:     "0 2 * * *",
+			duration: 2 * time.Hour,
+			now:      at(1, 0),
+			wantNext: at(2, 0),
+		},
+		{
+			name:       "window opens",
+			expr:       "0 2 * * *",
+			duration:   2 * time.Hour,
+			now:        at(2, 0),
+			wantActive: true,
+			wantNext:   at(4, 0),
+		},
+		{
+			name:       "in window",
+			expr:       "0 2 * * *",
+			duration:   2 * time.Hour,
+			now:        at(3, 59),
+			wantActive: true,
+			wantNext:   at(4, 0),
+		},
+		{
+			name:     "window closes",
+			expr:     "0 2 * * *",
+			duration: 2 * time.Hour,
+			now:      at(4, 0),
+			wantNext: at(24+2, 0),
+		},
+		{
+			name:       "overlapped windows joined",
+			expr:       "0 * * * *",
+			duration:   90 * time.Minute,
+			now:        at(2, 30),
+			wantActive: true,
+			wantNext:   day.Add(maxScheduleLookahead + 2*time.Hour + 30*time.Minute),
+		},
+		{
+			name:       "adjacent windows joined",
+			expr:       "0 2,3 * * *",
+			duration:   time.Hour,
+			now:        at(2, 30),
+			wantActive: true,
+			wantNext:   at(4, 0),
+		},
+		{
+			name:       "separated windows not joined",
+			expr:       "0 2,4 * * *",
+			duration:   time.Hour,
+			now:        at(2, 30),
+			wantActive: true,
+			wantNext:   at(3, 0),
+		},
+		{
+			name:     "never fires",
+			expr:     "0 0 31 2 *",
+			duration: time.Hour,
+			now:      at(2, 30),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cron, err := util.ParseCron(test.expr)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			active, next := cronWindow(cron, test.duration, test.now)
+			if active != test.wantActive {
+				t.Errorf("active %v, want %v", active, test.wantActive)
+			}
+			if !next.Equal(test.wantNext) {
+				t.Errorf("next %v, want %v", next, test.wantNext)
+			}
+		})
+	}
+}
+
+func TestIsStrategyActive(t *testing.T) {
+	now := time.Date(2020, time.February, 1, 3, 0, 0, 0, time.UTC)
+	timeAt := func(d time.Duration) *metav1.Time {
+		t := metav1.NewTime(now.Add(d))
+		return &t
+	}
+
+	tests := []struct {
+		name     string
+		schedule *servicemeshv1alpha1.StrategySchedule
+
+		wantActive bool
+		wantNext   time.Time
+		wantErr    bool
+	}{
+		{
+			name:       "no schedule",
+			wantActive: true,
+		},
+		{
+			name:     "before start",
+			schedule: &servicemeshv1alpha1.StrategySchedule{StartTime: timeAt(time.Hour)},
+			wantNext: now.Add(time.Hour),
+		},
+		{
+			name:       "between start and end",
+			schedule:   &servicemeshv1alpha1.StrategySchedule{StartTime: timeAt(-time.Hour), EndTime: timeAt(time.Hour)},
+			wantActive: true,
+			wantNext:   now.Add(time.Hour),
+		},
+		{
+			name:     "after end",
+			schedule: &servicemeshv1alpha1.StrategySchedule{EndTime: timeAt(0)},
+		},
+		{
+			name:       "in cron window",
+			schedule:   &servicemeshv1alpha1.StrategySchedule{Cron: "0 2 * * *", Duration: &metav1.Duration{Duration: 2 * time.Hour}},
+			wantActive: true,
+			wantNext:   now.Add(time.Hour),
+		},
+		{
+			name: "cron window cut by end",
+			schedule: &servicemeshv1alpha1.StrategySchedule{
+				Cron: "0 2 * * *", Duration: &metav1.Duration{Duration: 2 * time.Hour}, EndTime: timeAt(30 * time.Minute),
+			},
+			wantActive: true,
+			wantNext:   now.Add(30 * time.Minute),
+		},
+		{
+			name:     "out of cron window",
+			schedule: &servicemeshv1alpha1.StrategySchedule{Cron: "0 5 * * *", Duration: &metav1.Duration{Duration: time.Hour}},
+			wantNext: now.Add(2 * time.Hour),
+		},
+		{
+			name:     "cron without duration",
+			schedule: &servicemeshv1alpha1.StrategySchedule{Cron: "0 2 * * *"},
+			wantErr:  true,
+		},
+		{
+			name:     "invalid cron",
+			schedule: &servicemeshv1alpha1.StrategySchedule{Cron: "0 2 * *", Duration: &metav1.Duration{Duration: time.Hour}},
+			wantErr:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			strategy := newTestStrategy("scheduled", servicemeshv1alpha1.StrategySpec{Schedule: test.schedule})
+
+			active, next, err := isStrategyActive(strategy, now)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("error %v, want error %v", err, test.wantErr)
+			}
+			if active != test.wantActive {
+				t.Errorf("active %v, want %v", active, test.wantActive)
+			}
+			if !next.Equal(test.wantNext) {
+				t.Errorf("next %v, want %v", next, test.wantNext)
+			}
+		})
+	}
+}
+
+func TestSetActiveCondition(t *testing.T) {
+	// window is long gone by wall clock, condition follows the time strategy is evaluated at
+	now := time.Date(2020, time.February, 1, 3, 0, 0, 0, time.UTC)
+	endTime := metav1.NewTime(now.Add(time.Hour))
+
+	tests := []struct {
+		name     string
+		schedule *servicemeshv1alpha1.StrategySchedule
+		now      time.Time
+
+		wantStatus v1.ConditionStatus
+		wantReason string
+	}{
+		{
+			name: "no schedule",
+			now:  now,
+		},
+		{
+			name:       "in window",
+			schedule:   &servicemeshv1alpha1.StrategySchedule{EndTime: &endTime},
+			now:        now,
+			wantStatus: v1.ConditionTrue,
+			wantReason: ReasonInScheduleWindow,
+		},
+		{
+			name:       "out of window",
+			schedule:   &servicemeshv1alpha1.StrategySchedule{EndTime: &endTime},
+			now:        endTime.Time,
+			wantStatus: v1.ConditionFalse,
+			wantReason: ReasonOutOfScheduleWindow,
+		},
+		{
+			name:       "invalid schedule",
+			schedule:   &servicemeshv1alpha1.StrategySchedule{Cron: "0 2 * * *"},
+			now:        now,
+			wantStatus: v1.ConditionFalse,
+			wantReason: ReasonInvalidSchedule,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			strategy := newTestStrategy("scheduled", servicemeshv1alpha1.StrategySpec{Schedule: test.schedule})
+			// condition left over from last time is removed once schedule is gone
+			util.SetStrategyCondition(&strategy.Status, *util.NewStrategyCondition(servicemeshv1alpha1.StrategyActive, v1.ConditionTrue, ReasonInScheduleWindow, ""))
+
+			setActiveCondition(strategy, &strategy.Status, test.now)
+
+			active := util.GetStrategyCondition(strategy.Status, servicemeshv1alpha1.StrategyActive)
+			if len(test.wantStatus) == 0 {
+				if active != nil {
+					t.Errorf("unexpected condition %v", active)
+				}
+				return
+			}
+			if active == nil || active.Status != test.wantStatus || active.Reason != test.wantReason {
+				t.Errorf("active condition %v, want %s %s", active, test.wantStatus, test.wantReason)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package virtualservice
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	networkingv1beta1api "istio.io/api/networking/v1beta1"
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+	"zmc.io/oasis/pkg/controller/virtualservice/util"
+)
+
+// segmentRoutes compiles user segments of strategy into http routes, in the
+// same order as they are declared.
+func segmentRoutes(strategy *servicemeshv1alpha1.Strategy, host string) []*networkingv1beta1api.HTTPRoute {
+	routes := make([]*networkingv1beta1api.HTTPRoute, 0, len(strategy.Spec.Segments))
+
+	for i, segment := range strategy.Spec.Segments {
+		match := &networkingv1beta1api.HTTPMatchRequest{
+			SourceLabels: segment.SourceLabels,
+		}
+
+		if len(segment.Headers) > 0 || segment.Cookie != nil {
+			match.Headers = make(map[string]*networkingv1beta1api.StringMatch, len(segment.Headers)+1)
+			// envoy matches header names in lower case
+			for k, v := range segment.Headers {
+				match.Headers[strings.ToLower(k)] = &networkingv1beta1api.StringMatch{
+					MatchType: &networkingv1beta1api.StringMatch_Exact{Exact: v},
+				}
+			}
+
+			if segment.Cookie != nil {
+				match.Headers["cookie"] = &networkingv1beta1api.StringMatch{
+					MatchType: &networkingv1beta1api.StringMatch_Regex{Regex: cookieRegex(segment.Cookie)},
+				}
+			}
+		}
+
+		if len(segment.URIPrefix) > 0 {
+			match.Uri = &networkingv1beta1api.StringMatch{
+				MatchType: &networkingv1beta1api.StringMatch_Prefix{Prefix: segment.URIPrefix},
+			}
+		}
+
+		name := segment.Name
+		if len(name) == 0 {
+			name = fmt.Sprintf("segment-%d", i)
+		}
+
+		routes = append(routes, &networkingv1beta1api.HTTPRoute{
+			Name:  name,
+			Match: []*networkingv1beta1api.HTTPMatchRequest{match},
+			Route: []*networkingv1beta1api.HTTPRouteDestination{
+				{
+					Destination: &networkingv1beta1api.Destination{
+						Host:   host,
+						Subset: util.NormalizeVersionName(segment.Version),
+					},
+					Weight: 100,
+				},
+			},
+		})
+	}
+
+	return routes
+}
+
+// cookieRegex matches a cookie anywhere in the cookie header
+func cookieRegex(cookie *servicemeshv1alpha1.CookieMatch) string {
+	return fmt.Sprintf(`^(.*?;\s*)?(%s=%s)(;.*)?$`, regexp.QuoteMeta(cookie.Name), regexp.QuoteMeta(cookie.Value))
+}
+
+// orderHTTPRoutes puts routes with match conditions ahead of the default ones,
+// so that no route is shadowed by a catch-all route.
+func orderHTTPRoutes(routes []*networkingv1beta1api.HTTPRoute) []*networkingv1beta1api.HTTPRoute {
+	ordered := make([]*networkingv1beta1api.HTTPRoute, 0, len(routes))
+	defaults := make([]*networkingv1beta1api.HTTPRoute, 0)
+
+	for _, route := range routes {
+		if len(route.Match) == 0 {
+			defaults = append(defaults, route)
+		} else {
+			ordered = append(ordered, route)
+		}
+	}
+
+	return append(ordered, defaults...)
+}
+
+// hasDefaultHTTPRoute tells if any route catches all requests
+func hasDefaultHTTPRoute(routes []*networkingv1beta1api.HTTPRoute) bool {
+	for _, route := range routes {
+		if len(route.Match) == 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,192 @@
+package virtualservice
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	networkingv1beta1api "istio.io/api/networking/v1beta1"
+
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+)
+
+func routeNames(routes []*networkingv1beta1api.HTTPRoute) []string {
+	names := make([]string, 0, len(routes))
+	for _, route := range routes {
+		names = append(names, route.Name)
+	}
+	return names
+}
+
+func routeSubsets(routes []*networkingv1beta1api.HTTPRoute) []string {
+	subsets := make([]string, 0, len(routes))
+	for _, route := range routes {
+		subsets = append(subsets, route.Route[0].Destination.Subset)
+	}
+	return subsets
+}
+
+func TestSegmentRoutes(t *testing.T) {
+	host := "reviews.default.svc.cluster.local"
+
+	tests := []struct {
+		name     string
+		segments []servicemeshv1alpha1.UserSegment
+		want     []*networkingv1beta1api.HTTPMatchRequest
+		names    []string
+	}{
+		{
+			name:     "header",
+			segments: []servicemeshv1alpha1.UserSegment{{Name: "testers", Version: "v2", Headers: map[string]string{"x-user": "tester"}}},
+			want: []*networkingv1beta1api.HTTPMatchRequest{{
+				Headers: map[string]*networkingv1beta1api.StringMatch{
+					"x-user": {MatchType: &networkingv1beta1api.StringMatch_Exact{Exact: "tester"}},
+				},
+			}},
+			names: []string{"testers"},
+		},
+		{
+			name:     "header names in lower case",
+			segments: []servicemeshv1alpha1.UserSegment{{Name: "testers", Version: "v2", Headers: map[string]string{"X-User": "Tester"}}},
+			want: []*networkingv1beta1api.HTTPMatchRequest{{
+				Headers: map[string]*networkingv1beta1api.StringMatch{
+					"x-user": {MatchType: &networkingv1beta1api.StringMatch_Exact{Exact: "Tester"}},
+				},
+			}},
+			names: []string{"testers"},
+		},
+		{
+			name:     "cookie",
+			segments: []servicemeshv1alpha1.UserSegment{{Version: "v2", Cookie: &servicemeshv1alpha1.CookieMatch{Name: "group", Value: "beta"}}},
+			want: []*networkingv1beta1api.HTTPMatchRequest{{
+				Headers: map[string]*networkingv1beta1api.StringMatch{
+					"cookie": {MatchType: &networkingv1beta1api.StringMatch_Regex{Regex: `^(.*?;\s*)?(group=beta)(;.*)?$`}},
+				},
+			}},
+			names: []string{"segment-0"},
+		},
+		{
+			name:     "source labels and uri prefix",
+			segments: []servicemeshv1alpha1.UserSegment{{Version: "v2", SourceLabels: map[string]string{"app": "productpage"}, URIPrefix: "/api"}},
+			want: []*networkingv1beta1api.HTTPMatchRequest{{
+				SourceLabels: map[string]string{"app": "productpage"},
+				Uri:          &networkingv1beta1api.StringMatch{MatchType: &networkingv1beta1api.StringMatch_Prefix{Prefix: "/api"}},
+			}},
+			names: []string{"segment-0"},
+		},
+		{
+			name: "declared order",
+			segments: []servicemeshv1alpha1.UserSegment{
+				{Name: "first", Version: "v2", URIPrefix: "/a"},
+				{Name: "second", Version: "v3", URIPrefix: "/b"},
+			},
+			want: []*networkingv1beta1api.HTTPMatchRequest{
+				{Uri: &networkingv1beta1api.StringMatch{MatchType: &networkingv1beta1api.StringMatch_Prefix{Prefix: "/a"}}},
+				{Uri: &networkingv1beta1api.StringMatch{MatchType: &networkingv1beta1api.StringMatch_Prefix{Prefix: "/b"}}},
+			},
+			names: []string{"first", "second"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			strategy := newTestStrategy("segments", servicemeshv1alpha1.StrategySpec{Segments: test.segments})
+			routes := segmentRoutes(strategy, host)
+
+			if len(routes) != len(test.want) {
+				t.Fatalf("got %d routes, want %d", len(routes), len(test.want))
+			}
+			if names := routeNames(routes); !reflect.DeepEqual(names, test.names) {
+				t.Errorf("route names %v, want %v", names, test.names)
+			}
+			for i, route := range routes {
+				if !reflect.DeepEqual(route.Match, []*networkingv1beta1api.HTTPMatchRequest{test.want[i]}) {
+					t.Errorf("route %d match %v, want %v", i, route.Match, test.want[i])
+				}
+				destination := route.Route[0].Destination
+				if destination.Host != host || destination.Subset != test.segments[i].Version || route.Route[0].Weight != 100 {
+					t.Errorf("route %d destination %v, want all to %s", i, route.Route[0], test.segments[i].Version)
+				}
+			}
+		})
+	}
+}
+
+func TestCookieRegex(t *testing.T) {
+	regex := regexp.MustCompile(cookieRegex(&servicemeshv1alpha1.CookieMatch{Name: "group", Value: "beta.1"}))
+
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{header: "group=beta.1", want: true},
+		{header: "session=abc; group=beta.1", want: true},
+		{header: "group=beta.1; session=abc", want: true},
+		{header: "group=beta-1", want: false},
+		{header: "group=beta.10", want: false},
+		{header: "subgroup=beta.1", want: false},
+		{header: "session=abc", want: false},
+	}
+
+	for _, test := range tests {
+		if got := regex.MatchString(test.header); got != test.want {
+			t.Errorf("cookie %q matched %v, want %v", test.header, got, test.want)
+		}
+	}
+}
+
+func TestGenerateVirtualServiceSpecSegments(t *testing.T) {
+	tests := []struct {
+		name     string
+		template []*networkingv1beta1api.HTTPRoute
+		spec     servicemeshv1alpha1.StrategySpec
+		names    []string
+		subsets  []string
+	}{
+		{
+			name: "default route to principal version",
+			spec: servicemeshv1alpha1.StrategySpec{
+				PrincipalVersion: "v1",
+				Segments:         []servicemeshv1alpha1.UserSegment{{Name: "testers", Version: "v2", Headers: map[string]string{"x-user": "tester"}}},
+			},
+			names:   []string{"testers", ""},
+			subsets: []string{"v2", "v1"},
+		},
+		{
+			name: "template routes keep behind segments, default last",
+			template: []*networkingv1beta1api.HTTPRoute{
+				{Name: "default", Route: []*networkingv1beta1api.HTTPRouteDestination{{Destination: &networkingv1beta1api.Destination{Host: "reviews", Subset: "v1"}}}},
+				{
+					Name:  "api",
+					Match: []*networkingv1beta1api.HTTPMatchRequest{{Uri: &networkingv1beta1api.StringMatch{MatchType: &networkingv1beta1api.StringMatch_Prefix{Prefix: "/api"}}}},
+					Route: []*networkingv1beta1api.HTTPRouteDestination{{Destination: &networkingv1beta1api.Destination{Host: "reviews", Subset: "v3"}}},
+				},
+			},
+			spec: servicemeshv1alpha1.StrategySpec{
+				PrincipalVersion: "v1",
+				Segments:         []servicemeshv1alpha1.UserSegment{{Name: "testers", Version: "v2", URIPrefix: "/test"}},
+			},
+			names:   []string{"testers", "api", "default"},
+			subsets: []string{"v2", "v3", "v1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.spec.Template.Spec.Http = test.template
+			strategy := newTestStrategy("segments", test.spec)
+
+			vs := GenerateVirtualServiceSpec(strategy, newTestService("reviews"))
+
+			if names := routeNames(vs.Spec.Http); !reflect.DeepEqual(names, test.names) {
+				t.Errorf("route names %v, want %v", names, test.names)
+			}
+			if subsets := routeSubsets(vs.Spec.Http); !reflect.DeepEqual(subsets, test.subsets) {
+				t.Errorf("route subsets %v, want %v", subsets, test.subsets)
+			}
+			if !hasDefaultHTTPRoute(vs.Spec.Http) || len(vs.Spec.Http[len(vs.Spec.Http)-1].Match) > 0 {
+				t.Errorf("default route is not the last one, routes %v", vs.Spec.Http)
+			}
+		})
+	}
+}
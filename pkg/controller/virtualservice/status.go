@@ -0,0 +1,187 @@
+package virtualservice
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	log "k8s.io/klog"
+
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+	"zmc.io/oasis/pkg/controller/metrics"
+	"zmc.io/oasis/pkg/controller/virtualservice/util"
+)
+
+// reasons of strategy conditions
+const (
+	ReasonDelivered             = "Delivered"
+	ReasonPaused                = "Paused"
+	ReasonWaitingForWorkload    = "WaitingForWorkload"
+	ReasonMirrorNotReady        = "MirrorNotReady"
+	ReasonInScheduleWindow      = "InScheduleWindow"
+	ReasonOutOfScheduleWindow   = "OutOfScheduleWindow"
+	ReasonInvalidSchedule       = "InvalidSchedule"
+	ReasonFaultInjectionExpired = "FaultInjectionExpired"
+	ReasonConflict              = "Conflict"
+	ReasonInvalidPortSpec       = "InvalidPortSpec"
+	ReasonNotManaged            = "NotManaged"
+	ReasonOverridden            = "Overridden"
+	ReasonFailedToDeliver       = "FailedToDeliver"
+)
+
+// strategiesDelivered records delivery result of every strategy applied to service
+func (v *VirtualServiceController) strategiesDelivered(deliveries []*strategyDelivery) error {
+	var errs []error
+	for _, delivery := range deliveries {
+		var err error
+		if len(delivery.pendingReason) > 0 {
+			err = v.strategyPending(delivery.strategy, delivery.now, delivery.pendingReason, delivery.pendingMessage)
+		} else {
+			err = v.strategyDelivered(delivery.strategy, delivery.now, delivery.conflictMessage, delivery.driftMessage)
+			if err == nil {
+				err = v.snapshotStrategy(delivery.strategy)
+			}
+		}
+
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
+
+// strategiesFailed records every strategy applied to service failed its delivery,
+// and returns err labeled with reason.
+func (v *VirtualServiceController) strategiesFailed(deliveries []*strategyDelivery, reason string, err error) error {
+	for _, delivery := range deliveries {
+		_ = v.strategyFailed(delivery.strategy, delivery.now, reason, err)
+	}
+	return metrics.WithReason(reason, err)
+}
+
+// strategyDelivered records strategy evaluated at now has been delivered to istio,
+// conflict is not empty when part of strategy is overridden by higher priority
+// strategies, drift is not empty when virtualservice is overridden on purpose.
+func (v *VirtualServiceController) strategyDelivered(strategy *servicemeshv1alpha1.Strategy, now time.Time, conflict, drift string) error {
+	if strategy == nil {
+		return nil
+	}
+
+	return v.updateStrategyStatus(strategy, now, func(status *servicemeshv1alpha1.StrategyStatus) {
+		util.SetStrategyCondition(status, *util.NewStrategyCondition(servicemeshv1alpha1.StrategyComplete, v1.ConditionTrue,
+			ReasonDelivered, "strategy has been delivered to virtualservice"))
+
+		if len(conflict) > 0 {
+			util.SetStrategyCondition(status, *util.NewStrategyCondition(servicemeshv1alpha1.StrategyConflicted, v1.ConditionTrue, ReasonConflict, conflict))
+		} else {
+			util.RemoveStrategyCondition(status, servicemeshv1alpha1.StrategyConflicted)
+		}
+
+		if len(drift) > 0 {
+			util.SetStrategyCondition(status, *util.NewStrategyCondition(servicemeshv1alpha1.StrategyDrifted, v1.ConditionTrue, ReasonOverridden, drift))
+		} else {
+			util.RemoveStrategyCondition(status, servicemeshv1alpha1.StrategyDrifted)
+		}
+
+		// a rolled back canary stays failed until strategy changes
+		if !isCanaryRolledBack(status) {
+			util.RemoveStrategyCondition(status, servicemeshv1alpha1.StrategyFailed)
+		}
+
+		// canary is completed once its last step applied
+		if status.CompletionTime == nil &&
+			(len(strategy.Spec.Steps) == 0 || (status.CurrentStep != nil && int(*status.CurrentStep) == len(strategy.Spec.Steps)-1)) {
+			now := metav1.Now()
+			status.CompletionTime = &now
+		}
+	})
+}
+
+// strategyPending records strategy evaluated at now is deliberately not delivered yet
+func (v *VirtualServiceController) strategyPending(strategy *servicemeshv1alpha1.Strategy, now time.Time, reason, message string) error {
+	if strategy == nil {
+		return nil
+	}
+
+	return v.updateStrategyStatus(strategy, now, func(status *servicemeshv1alpha1.StrategyStatus) {
+		util.SetStrategyCondition(status, *util.NewStrategyCondition(servicemeshv1alpha1.StrategyComplete, v1.ConditionFalse, reason, message))
+		util.RemoveStrategyCondition(status, servicemeshv1alpha1.StrategyConflicted)
+		util.RemoveStrategyCondition(status, servicemeshv1alpha1.StrategyDrifted)
+		status.CompletionTime = nil
+	})
+}
+
+// strategyFailed records strategy evaluated at now failed its delivery to istio
+func (v *VirtualServiceController) strategyFailed(strategy *servicemeshv1alpha1.Strategy, now time.Time, reason string, err error) error {
+	if strategy == nil {
+		return nil
+	}
+
+	return v.updateStrategyStatus(strategy, now, func(status *servicemeshv1alpha1.StrategyStatus) {
+		util.SetStrategyCondition(status, *util.NewStrategyCondition(servicemeshv1alpha1.StrategyFailed, v1.ConditionTrue, reason, err.Error()))
+		util.SetStrategyCondition(status, *util.NewStrategyCondition(servicemeshv1alpha1.StrategyComplete, v1.ConditionFalse, reason, err.Error()))
+		status.CompletionTime = nil
+	})
+}
+
+// updateStrategyStatus mutates a copy of strategy status, writes it through status
+// subresource if anything changes, and records an event when conditions transit.
+// Active condition tells if strategy is in its schedule window at now, the time
+// strategy is evaluated at, so it agrees with whether strategy is pending.
+func (v *VirtualServiceController) updateStrategyStatus(strategy *servicemeshv1alpha1.Strategy, now time.Time,
+	mutate func(status *servicemeshv1alpha1.StrategyStatus)) error {
+	status := strategy.Status.DeepCopy()
+
+	// strategy acknowledged for the first time, or changed since last time. Canary
+	// progress of the old spec is dropped here too, even if the strategy is pending,
+	// so the canary starts over from the first step once it is applied again.
+	if status.StartTime == nil || status.ObservedGeneration != strategy.Generation {
+		now := metav1.Now()
+		status.StartTime = &now
+		status.CompletionTime = nil
+		status.ObservedGeneration = strategy.Generation
+		status.CurrentStep = nil
+		status.CurrentStepTime = nil
+		util.RemoveStrategyCondition(status, servicemeshv1alpha1.StrategyFailed)
+	}
+
+	setActiveCondition(strategy, status, now)
+	mutate(status)
+
+	if equality.Semantic.DeepEqual(status, &strategy.Status) {
+		return nil
+	}
+
+	newStrategy, err := v.writeStrategyStatus(strategy, status)
+	if err != nil {
+		return err
+	}
+
+	oldCond := util.GetStrategyCondition(strategy.Status, servicemeshv1alpha1.StrategyComplete)
+	newCond := util.GetStrategyCondition(*status, servicemeshv1alpha1.StrategyComplete)
+	if newCond != nil && (oldCond == nil || oldCond.Status != newCond.Status || oldCond.Reason != newCond.Reason) {
+		failed := util.GetStrategyCondition(*status, servicemeshv1alpha1.StrategyFailed)
+		eventType := util.ConditionEventType(failed != nil && failed.Status == v1.ConditionTrue)
+		v.eventRecorder.Event(newStrategy, eventType, newCond.Reason, fmt.Sprintf("Strategy %s/%s: %s", strategy.Namespace, strategy.Name, newCond.Message))
+	}
+
+	return nil
+}
+
+// writeStrategyStatus updates strategy with the given status
+func (v *VirtualServiceController) writeStrategyStatus(strategy *servicemeshv1alpha1.Strategy, status *servicemeshv1alpha1.StrategyStatus) (*servicemeshv1alpha1.Strategy, error) {
+	newStrategy := strategy.DeepCopy()
+	newStrategy.Status = *status
+	newStrategy, err := v.servicemeshClient.ServicemeshV1alpha1().Strategies(strategy.Namespace).UpdateStatus(context.TODO(), newStrategy, metav1.UpdateOptions{})
+	if err != nil {
+		log.Errorf("update strategy %s/%s status failed, %v", strategy.Namespace, strategy.Name, err)
+		return strategy, err
+	}
+
+	return newStrategy, nil
+}
@@ -0,0 +1,212 @@
+package virtualservice
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+	"zmc.io/oasis/pkg/controller/virtualservice/util"
+)
+
+func drainEvents(recorder record.EventRecorder) []string {
+	var events []string
+	for {
+		select {
+		case event := <-recorder.(*record.FakeRecorder).Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestStrategyStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		// status written before
+		prepare func(v *VirtualServiceController, strategy *servicemeshv1alpha1.Strategy) error
+		update  func(v *VirtualServiceController, strategy *servicemeshv1alpha1.Strategy) error
+
+		wantComplete   v1.ConditionStatus
+		wantReason     string
+		wantMessage    string
+		wantConflict   string
+		wantDrift      string
+		wantFailed     bool
+		wantCompletion bool
+		wantEvent      string
+	}{
+		{
+			name: "delivered",
+			update: func(v *VirtualServiceController, strategy *servicemeshv1alpha1.Strategy) error {
+				return v.strategyDelivered(strategy, time.Now(), "", "")
+			},
+			wantComplete:   v1.ConditionTrue,
+			wantReason:     ReasonDelivered,
+			wantCompletion: true,
+			wantEvent:      "Normal Delivered",
+		},
+		{
+			name: "delivered with conflict",
+			update: func(v *VirtualServiceController, strategy *servicemeshv1alpha1.Strategy) error {
+				return v.strategyDelivered(strategy, time.Now(), "route is overridden by strategy a", "")
+			},
+			wantComplete:   v1.ConditionTrue,
+			wantReason:     ReasonDelivered,
+			wantConflict:   "route is overridden by strategy a",
+			wantCompletion: true,
+			wantEvent:      "Normal Delivered",
+		},
+		{
+			name: "conflict message changed",
+			prepare: func(v *VirtualServiceController, strategy *servicemeshv1alpha1.Strategy) error {
+				return v.strategyDelivered(strategy, time.Now(), "route is overridden by strategy a", "")
+			},
+			update: func(v *VirtualServiceController, strategy *servicemeshv1alpha1.Strategy) error {
+				return v.strategyDelivered(strategy, time.Now(), "route is overridden by strategy b", "")
+			},
+			wantComplete:   v1.ConditionTrue,
+			wantReason:     ReasonDelivered,
+			wantConflict:   "route is overridden by strategy b",
+			wantCompletion: true,
+		},
+		{
+			name: "drift message changed",
+			prepare: func(v *VirtualServiceController, strategy *servicemeshv1alpha1.Strategy) error {
+				return v.strategyDelivered(strategy, time.Now(), "", "routes differ")
+			},
+			update: func(v *VirtualServiceController, strategy *servicemeshv1alpha1.Strategy) error {
+				return v.strategyDelivered(strategy, time.Now(), "", "hosts differ")
+			},
+			wantComplete:   v1.ConditionTrue,
+			wantReason:     ReasonDelivered,
+			wantDrift:      "hosts differ",
+			wantCompletion: true,
+		},
+		{
+			name: "pending",
+			update: func(v *VirtualServiceController, strategy *servicemeshv1alpha1.Strategy) error {
+				return v.strategyPending(strategy, time.Now(), ReasonWaitingForWorkload, "subset v2 is not ready")
+			},
+			wantComplete: v1.ConditionFalse,
+			wantReason:   ReasonWaitingForWorkload,
+			wantMessage:  "subset v2 is not ready",
+			wantEvent:    "Normal WaitingForWorkload",
+		},
+		{
+			name: "pending message changed",
+			prepare: func(v *VirtualServiceController, strategy *servicemeshv1alpha1.Strategy) error {
+				return v.strategyPending(strategy, time.Now(), ReasonWaitingForWorkload, "subset v2 is not ready")
+			},
+			update: func(v *VirtualServiceController, strategy *servicemeshv1alpha1.Strategy) error {
+				return v.strategyPending(strategy, time.Now(), ReasonWaitingForWorkload, "subset v3 is not ready")
+			},
+			wantComplete: v1.ConditionFalse,
+			wantReason:   ReasonWaitingForWorkload,
+			wantMessage:  "subset v3 is not ready",
+		},
+		{
+			name: "failed",
+			update: func(v *VirtualServiceController, strategy *servicemeshv1alpha1.Strategy) error {
+				return v.strategyFailed(strategy, time.Now(), ReasonFailedToDeliver, errors.New("conflict"))
+			},
+			wantComplete: v1.ConditionFalse,
+			wantReason:   ReasonFailedToDeliver,
+			wantFailed:   true,
+			wantEvent:    "Warning FailedToDeliver",
+		},
+		{
+			name: "delivered after failure",
+			prepare: func(v *VirtualServiceController, strategy *servicemeshv1alpha1.Strategy) error {
+				return v.strategyFailed(strategy, time.Now(), ReasonFailedToDeliver, errors.New("conflict"))
+			},
+			update: func(v *VirtualServiceController, strategy *servicemeshv1alpha1.Strategy) error {
+				return v.strategyDelivered(strategy, time.Now(), "", "")
+			},
+			wantComplete:   v1.ConditionTrue,
+			wantReason:     ReasonDelivered,
+			wantCompletion: true,
+			wantEvent:      "Normal Delivered",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			strategy := newTestStrategy("canary", servicemeshv1alpha1.StrategySpec{PrincipalVersion: "v1"})
+			v := newTestController(nil, strategy)
+
+			get := func() *servicemeshv1alpha1.Strategy {
+				got, err := v.servicemeshClient.ServicemeshV1alpha1().Strategies(testNamespace).Get(context.TODO(), strategy.Name, metav1.GetOptions{})
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				return got
+			}
+
+			if test.prepare != nil {
+				if err := test.prepare(v, strategy); err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				strategy = get()
+				drainEvents(v.eventRecorder)
+			}
+
+			if err := test.update(v, strategy); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			got := get()
+
+			complete := util.GetStrategyCondition(got.Status, servicemeshv1alpha1.StrategyComplete)
+			if complete == nil || complete.Status != test.wantComplete || complete.Reason != test.wantReason {
+				t.Errorf("complete condition %v, want %s %s", complete, test.wantComplete, test.wantReason)
+			}
+
+			if len(test.wantMessage) > 0 && complete.Message != test.wantMessage {
+				t.Errorf("complete condition message %q, want %q", complete.Message, test.wantMessage)
+			}
+
+			conflicted := util.GetStrategyCondition(got.Status, servicemeshv1alpha1.StrategyConflicted)
+			if len(test.wantConflict) == 0 && conflicted != nil {
+				t.Errorf("unexpected condition %v", conflicted)
+			}
+			if len(test.wantConflict) > 0 && (conflicted == nil || conflicted.Message != test.wantConflict) {
+				t.Errorf("conflicted condition %v, want message %q", conflicted, test.wantConflict)
+			}
+
+			drifted := util.GetStrategyCondition(got.Status, servicemeshv1alpha1.StrategyDrifted)
+			if len(test.wantDrift) == 0 && drifted != nil {
+				t.Errorf("unexpected condition %v", drifted)
+			}
+			if len(test.wantDrift) > 0 && (drifted == nil || drifted.Message != test.wantDrift) {
+				t.Errorf("drifted condition %v, want message %q", drifted, test.wantDrift)
+			}
+
+			if failed := util.GetStrategyCondition(got.Status, servicemeshv1alpha1.StrategyFailed) != nil; failed != test.wantFailed {
+				t.Errorf("failed %v, want %v", failed, test.wantFailed)
+			}
+
+			if got.Status.StartTime == nil || got.Status.ObservedGeneration != strategy.Generation {
+				t.Errorf("strategy is not acknowledged, status %v", got.Status)
+			}
+
+			if (got.Status.CompletionTime != nil) != test.wantCompletion {
+				t.Errorf("completion time %v, want completed %v", got.Status.CompletionTime, test.wantCompletion)
+			}
+
+			events := drainEvents(v.eventRecorder)
+			if len(test.wantEvent) == 0 && len(events) > 0 {
+				t.Errorf("unexpected events %v", events)
+			}
+			if len(test.wantEvent) > 0 && (len(events) != 1 || !strings.HasPrefix(events[0], test.wantEvent)) {
+				t.Errorf("events %v, want %s", events, test.wantEvent)
+			}
+		})
+	}
+}
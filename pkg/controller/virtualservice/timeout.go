@@ -0,0 +1,52 @@
+package virtualservice
+
+import (
+	"strings"
+
+	"github.com/gogo/protobuf/types"
+
+	networkingv1beta1api "istio.io/api/networking/v1beta1"
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+)
+
+// applyRequestPolicies sets timeout and retries of strategy on http routes,
+// routes already having their own are left alone.
+func applyRequestPolicies(spec *networkingv1beta1api.VirtualService, strategy *servicemeshv1alpha1.Strategy) {
+	for _, httpRoute := range spec.Http {
+		if strategy.Spec.Timeout != nil && httpRoute.Timeout == nil {
+			httpRoute.Timeout = types.DurationProto(strategy.Spec.Timeout.Duration)
+		}
+
+		if strategy.Spec.Retries != nil && httpRoute.Retries == nil {
+			httpRoute.Retries = httpRetry(strategy.Spec.Retries)
+		}
+	}
+}
+
+func httpRetry(retries *servicemeshv1alpha1.RetryPolicy) *networkingv1beta1api.HTTPRetry {
+	retry := &networkingv1beta1api.HTTPRetry{
+		Attempts: retries.Attempts,
+		RetryOn:  strings.Join(retries.RetryOn, ","),
+	}
+
+	if retries.PerTryTimeout != nil {
+		retry.PerTryTimeout = types.DurationProto(retries.PerTryTimeout.Duration)
+	}
+
+	return retry
+}
+
+// defaultHTTPRoute returns the route catching all requests, or the first
+// route if there is none.
+func defaultHTTPRoute(routes []*networkingv1beta1api.HTTPRoute) *networkingv1beta1api.HTTPRoute {
+	for _, route := range routes {
+		if len(route.Match) == 0 {
+			return route
+		}
+	}
+
+	if len(routes) > 0 {
+		return routes[0]
+	}
+	return nil
+}
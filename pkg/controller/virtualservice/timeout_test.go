@@ -0,0 +1,148 @@
+package virtualservice
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/types"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	networkingv1beta1api "istio.io/api/networking/v1beta1"
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+)
+
+func TestHTTPRetry(t *testing.T) {
+	tests := []struct {
+		name    string
+		retries *servicemeshv1alpha1.RetryPolicy
+		want    *networkingv1beta1api.HTTPRetry
+	}{
+		{
+			name:    "attempts",
+			retries: &servicemeshv1alpha1.RetryPolicy{Attempts: 3},
+			want:    &networkingv1beta1api.HTTPRetry{Attempts: 3},
+		},
+		{
+			name: "per try timeout and conditions",
+			retries: &servicemeshv1alpha1.RetryPolicy{
+				Attempts:      2,
+				PerTryTimeout: &metav1.Duration{Duration: time.Second},
+				RetryOn:       []string{"5xx", "connect-failure"},
+			},
+			want: &networkingv1beta1api.HTTPRetry{Attempts: 2, PerTryTimeout: types.DurationProto(time.Second), RetryOn: "5xx,connect-failure"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := httpRetry(test.retries); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("retry %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSyncServiceRequestPolicies(t *testing.T) {
+	service := newTestService("reviews")
+	retries := &servicemeshv1alpha1.RetryPolicy{Attempts: 3}
+
+	newStrategy := func(name string, priority int32, spec servicemeshv1alpha1.StrategySpec) *servicemeshv1alpha1.Strategy {
+		spec.Priority = priority
+		return newTestStrategy(name, spec)
+	}
+
+	tests := []struct {
+		name       string
+		strategies []*servicemeshv1alpha1.Strategy
+
+		wantTimeouts []time.Duration
+		wantRetries  []bool
+	}{
+		{
+			name: "timeout only",
+			strategies: []*servicemeshv1alpha1.Strategy{
+				newStrategy("timeout", 0, servicemeshv1alpha1.StrategySpec{Timeout: &metav1.Duration{Duration: 2 * time.Second}}),
+			},
+			wantTimeouts: []time.Duration{2 * time.Second},
+			wantRetries:  []bool{false},
+		},
+		{
+			name: "retries only",
+			strategies: []*servicemeshv1alpha1.Strategy{
+				newStrategy("retries", 0, servicemeshv1alpha1.StrategySpec{Retries: retries}),
+			},
+			wantTimeouts: []time.Duration{0},
+			wantRetries:  []bool{true},
+		},
+		{
+			name: "segment routes and default route",
+			strategies: []*servicemeshv1alpha1.Strategy{
+				newStrategy("segments", 0, servicemeshv1alpha1.StrategySpec{
+					PrincipalVersion: "v1",
+					Segments:         []servicemeshv1alpha1.UserSegment{{Name: "testers", Version: "v2", URIPrefix: "/test"}},
+					Timeout:          &metav1.Duration{Duration: 2 * time.Second},
+				}),
+			},
+			wantTimeouts: []time.Duration{2 * time.Second, 2 * time.Second},
+			wantRetries:  []bool{false, false},
+		},
+		{
+			name: "route timeout in template kept",
+			strategies: []*servicemeshv1alpha1.Strategy{
+				newStrategy("template", 0, servicemeshv1alpha1.StrategySpec{
+					Template: servicemeshv1alpha1.VirtualServiceTemplateSpec{Spec: networkingv1beta1api.VirtualService{
+						Http: []*networkingv1beta1api.HTTPRoute{{Route: versionDestinations("reviews", "v1"), Timeout: types.DurationProto(time.Second)}},
+					}},
+					Timeout: &metav1.Duration{Duration: 2 * time.Second},
+					Retries: retries,
+				}),
+			},
+			wantTimeouts: []time.Duration{time.Second},
+			wantRetries:  []bool{true},
+		},
+		{
+			name: "default route of strategy with higher priority",
+			strategies: []*servicemeshv1alpha1.Strategy{
+				newStrategy("high", 10, servicemeshv1alpha1.StrategySpec{Timeout: &metav1.Duration{Duration: time.Second}}),
+				newStrategy("low", 0, servicemeshv1alpha1.StrategySpec{
+					PrincipalVersion: "v1",
+					Segments:         []servicemeshv1alpha1.UserSegment{{Name: "testers", Version: "v2", URIPrefix: "/test"}},
+					Timeout:          &metav1.Duration{Duration: 2 * time.Second},
+					Retries:          retries,
+				}),
+			},
+			wantTimeouts: []time.Duration{2 * time.Second, 2 * time.Second},
+			wantRetries:  []bool{true, true},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			objects := []runtime.Object{service, newTestDestinationRule("reviews", "v1", "v2")}
+			for _, strategy := range test.strategies {
+				objects = append(objects, strategy)
+			}
+			spec := newFixture(t, objects...).sync("reviews").Spec
+
+			timeouts := make([]time.Duration, 0, len(spec.Http))
+			retried := make([]bool, 0, len(spec.Http))
+			for _, route := range spec.Http {
+				var timeout time.Duration
+				if route.Timeout != nil {
+					timeout, _ = types.DurationFromProto(route.Timeout)
+				}
+				timeouts = append(timeouts, timeout)
+				retried = append(retried, route.Retries != nil)
+			}
+
+			if !reflect.DeepEqual(timeouts, test.wantTimeouts) {
+				t.Errorf("route timeouts %v, want %v", timeouts, test.wantTimeouts)
+			}
+			if !reflect.DeepEqual(retried, test.wantRetries) {
+				t.Errorf("routes retried %v, want %v", retried, test.wantRetries)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSchedule is a parsed cron expression, with fields of minute, hour,
+// day of month, month and day of week. Each field is a set of values it matches.
+// Schedules are always evaluated in UTC, time zones are not supported.
+type CronSchedule struct {
+	minute, hour, dom, month, dow uint64
+
+	// day of month or day of week field starts with a star, i.e. * or */n,
+	// so it is not taken as a restriction of days
+	domStar, dowStar bool
+}
+
+type cronBounds struct {
+	min, max int
+}
+
+var (
+	minuteBounds = cronBounds{0, 59}
+	hourBounds   = cronBounds{0, 23}
+	domBounds    = cronBounds{1, 31}
+	monthBounds  = cronBounds{1, 12}
+	// both 0 and 7 stand for sunday
+	dowBounds = cronBounds{0, 7}
+)
+
+// ParseCron parses a standard cron expression of 5 fields, each field may be
+// a star, a value, a range or a list of them, optionally followed by a step.
+// Day of week ranges from 0 to 7, both 0 and 7 are sunday.
+func ParseCron(expr string) (*CronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q should have 5 fields, got %d", expr, len(fields))
+	}
+
+	var err error
+	schedule := &CronSchedule{
+		domStar: strings.HasPrefix(fields[2], "*"),
+		dowStar: strings.HasPrefix(fields[4], "*"),
+	}
+
+	if schedule.minute, err = parseCronField(fields[0], minuteBounds); err != nil {
+		return nil, err
+	}
+	if schedule.hour, err = parseCronField(fields[1], hourBounds); err != nil {
+		return nil, err
+	}
+	if schedule.dom, err = parseCronField(fields[2], domBounds); err != nil {
+		return nil, err
+	}
+	if schedule.month, err = parseCronField(fields[3], monthBounds); err != nil {
+		return nil, err
+	}
+	if schedule.dow, err = parseCronField(fields[4], dowBounds); err != nil {
+		return nil, err
+	}
+	if schedule.dow&(1<<7) != 0 {
+		schedule.dow = schedule.dow&^(1<<7) | 1<<0
+	}
+
+	return schedule, nil
+}
+
+// parseCronField returns bits of values field matches
+func parseCronField(field string, bounds cronBounds) (uint64, error) {
+	var bits uint64
+
+	for _, expr := range strings.Split(field, ",") {
+		rangeExpr, step := expr, 1
+		if i := strings.Index(expr, "/"); i >= 0 {
+			var err error
+			rangeExpr = expr[:i]
+			step, err = strconv.Atoi(expr[i+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in cron field %q", expr)
+			}
+		}
+
+		start, end := bounds.min, bounds.max
+		switch {
+		case rangeExpr == "*":
+		case strings.Contains(rangeExpr, "-"):
+			parts := strings.SplitN(rangeExpr, "-", 2)
+			var err1, err2 error
+			start, err1 = strconv.Atoi(parts[0])
+			end, err2 = strconv.Atoi(parts[1])
+			if err1 != nil || err2 != nil {
+				return 0, fmt.Errorf("invalid range in cron field %q", expr)
+			}
+		default:
+			var err error
+			start, err = strconv.Atoi(rangeExpr)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value in cron field %q", expr)
+			}
+			end = start
+			if step > 1 {
+				end = bounds.max
+			}
+		}
+
+		if start < bounds.min || end > bounds.max || start > end {
+			return 0, fmt.Errorf("cron field %q out of range %d-%d", expr, bounds.min, bounds.max)
+		}
+
+		for value := start; value <= end; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+
+	return bits, nil
+}
+
+// Next returns the first time after t the schedule fires, zero if it never fires
+// within 5 years. Times are calculated in UTC.
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	t = t.UTC().Add(time.Minute).Truncate(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, time.UTC)
+			continue
+		}
+
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
+
+// dayMatches follows cron convention, if both day of month and day of week
+// are restricted, either of them matching is enough.
+func (s *CronSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
@@ -0,0 +1,170 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr bool
+	}{
+		{expr: "* * * * *"},
+		{expr: "0 2 * * *"},
+		{expr: "*/15 0-6/2 1,15 1-12 1-5"},
+		{expr: "0 0 * * 7"},
+		{expr: "0 0 * * 0-7"},
+		{expr: "0 0 * *", wantErr: true},
+		{expr: "0 0 * * * *", wantErr: true},
+		{expr: "60 0 * * *", wantErr: true},
+		{expr: "0 24 * * *", wantErr: true},
+		{expr: "0 0 0 * *", wantErr: true},
+		{expr: "0 0 * 13 *", wantErr: true},
+		{expr: "0 0 * * 8", wantErr: true},
+		{expr: "0 5-1 * * *", wantErr: true},
+		{expr: "*/0 * * * *", wantErr: true},
+		{expr: "a * * * *", wantErr: true},
+		{expr: "1-a * * * *", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			_, err := ParseCron(test.expr)
+			if (err != nil) != test.wantErr {
+				t.Errorf("error %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestCronScheduleNext(t *testing.T) {
+	// a saturday
+	now := time.Date(2020, time.February, 1, 10, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		expr string
+		now  time.Time
+		want time.Time
+	}{
+		{
+			name: "every minute",
+			expr: "* * * * *",
+			now:  now.Add(20 * time.Second),
+			want: now.Add(time.Minute),
+		},
+		{
+			name: "fires strictly after now",
+			expr: "30 10 * * *",
+			want: time.Date(2020, time.February, 2, 10, 30, 0, 0, time.UTC),
+		},
+		{
+			name: "value",
+			expr: "0 2 * * *",
+			want: time.Date(2020, time.February, 2, 2, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "range",
+			expr: "0 9-17 * * *",
+			want: time.Date(2020, time.February, 1, 11, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "list",
+			expr: "0,45 * * * *",
+			want: time.Date(2020, time.February, 1, 10, 45, 0, 0, time.UTC),
+		},
+		{
+			name: "step of star",
+			expr: "*/20 * * * *",
+			want: time.Date(2020, time.February, 1, 10, 40, 0, 0, time.UTC),
+		},
+		{
+			name: "step of range",
+			expr: "0 0-12/4 * * *",
+			want: time.Date(2020, time.February, 1, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "step from value",
+			expr: "0 11/6 * * *",
+			want: time.Date(2020, time.February, 1, 11, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "month",
+			expr: "0 0 1 3 *",
+			want: time.Date(2020, time.March, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "leap day",
+			expr: "0 0 29 2 *",
+			now:  time.Date(2020, time.March, 1, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "day of week",
+			expr: "0 0 * * 1",
+			want: time.Date(2020, time.February, 3, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "7 is sunday",
+			expr: "0 0 * * 7",
+			want: time.Date(2020, time.February, 2, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "range to 7",
+			expr: "0 0 * * 6-7",
+			want: time.Date(2020, time.February, 2, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "either day of month or day of week",
+			expr: "0 0 10 * 1",
+			want: time.Date(2020, time.February, 3, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "either day of week or day of month",
+			expr: "0 0 2 * 5",
+			want: time.Date(2020, time.February, 2, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "step of star in day of month restricts days",
+			expr: "0 0 */2 * 0",
+			// odd days which are sundays, the 3rd would match if either is enough
+			want: time.Date(2020, time.February, 9, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "step of star in day of week restricts days",
+			expr: "0 0 3 * */2",
+			// sunday, tuesday, thursday and saturday on the 3rd, the 2nd would
+			// match if either is enough
+			want: time.Date(2020, time.March, 3, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "other time zones converted to utc",
+			expr: "0 2 * * *",
+			now:  now.In(time.FixedZone("UTC+8", 8*60*60)),
+			want: time.Date(2020, time.February, 2, 2, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "never",
+			expr: "0 0 31 2 *",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			schedule, err := ParseCron(test.expr)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			from := test.now
+			if from.IsZero() {
+				from = now
+			}
+
+			if got := schedule.Next(from); !got.Equal(test.want) {
+				t.Errorf("next %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package util
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// ManagedLabels returns labels of objects generated for service, labels of
+// service plus the managed-by marker.
+func ManagedLabels(service *v1.Service) map[string]string {
+	labels := make(map[string]string, len(service.Labels)+1)
+	for k, v := range service.Labels {
+		labels[k] = v
+	}
+	labels[ManagedByLabel] = ManagedByValue
+
+	return labels
+}
+
+// IsManaged tells if obj is marked managed by oasis
+func IsManaged(obj metav1.Object) bool {
+	return obj.GetLabels()[ManagedByLabel] == ManagedByValue
+}
+
+// IsOverridden tells if changes made to obj by others are kept on purpose
+func IsOverridden(obj metav1.Object) bool {
+	return obj.GetAnnotations()[OverrideAnnotation] == "true"
+}
+
+// CanManage tells if obj generated for service may be updated, obj must be marked
+// managed by oasis, and not controlled by anything other than service. Objects
+// marked but not controlled yet are adopted. Objects not marked, i.e. generated
+// by earlier releases, are adopted if they carry the app label of service, and
+// are owned by service or named after it.
+func CanManage(obj metav1.Object, service *v1.Service) bool {
+	controllerRef := metav1.GetControllerOf(obj)
+	if controllerRef != nil && controllerRef.UID != service.UID {
+		return false
+	}
+
+	if IsManaged(obj) {
+		return true
+	}
+
+	appName := service.Labels[AppLabel]
+	if len(appName) == 0 || obj.GetLabels()[AppLabel] != appName {
+		return false
+	}
+
+	return isOwnedBy(obj, service) || obj.GetName() == service.Name || obj.GetName() == appName
+}
+
+func isOwnedBy(obj metav1.Object, service *v1.Service) bool {
+	for _, ref := range obj.GetOwnerReferences() {
+		if ref.UID == service.UID {
+			return true
+		}
+	}
+	return false
+}
+
+// NewServiceControllerRef returns the owner reference making service controller of generated objects
+func NewServiceControllerRef(service *v1.Service) *metav1.OwnerReference {
+	return metav1.NewControllerRef(service, v1.SchemeGroupVersion.WithKind("Service"))
+}
+
+// SetServiceControllerRef makes service controller of obj, replacing owner
+// references pointing to service before.
+func SetServiceControllerRef(obj metav1.Object, service *v1.Service) {
+	ownerReferences := make([]metav1.OwnerReference, 0, len(obj.GetOwnerReferences())+1)
+	for _, ref := range obj.GetOwnerReferences() {
+		if ref.UID != service.UID {
+			ownerReferences = append(ownerReferences, ref)
+		}
+	}
+
+	obj.SetOwnerReferences(append(ownerReferences, *NewServiceControllerRef(service)))
+}
+
+// IsControlledByService tells if obj is controlled by service of name in the same namespace
+func IsControlledByService(obj metav1.Object, name string) bool {
+	return GetControllerServiceName(obj) == name
+}
+
+// GetControllerServiceName returns name of the service controlling obj, empty if there is none
+func GetControllerServiceName(obj metav1.Object) string {
+	controllerRef := metav1.GetControllerOf(obj)
+	if controllerRef == nil ||
+		controllerRef.APIVersion != v1.SchemeGroupVersion.String() ||
+		controllerRef.Kind != "Service" {
+		return ""
+	}
+
+	return controllerRef.Name
+}
+
+// ControllerServiceHandler returns handlers enqueueing key of the service controlling
+// a managed object once the object changes or is deleted, so that changes made by
+// others are reverted, and the object is created again if service still exists.
+func ControllerServiceHandler(queue workqueue.Interface) cache.ResourceEventHandlerFuncs {
+	return cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(old, cur interface{}) {
+			oldObj, err := meta.Accessor(old)
+			if err != nil {
+				utilruntime.HandleError(err)
+				return
+			}
+			curObj, err := meta.Accessor(cur)
+			if err != nil {
+				utilruntime.HandleError(err)
+				return
+			}
+
+			// periodic resync, nothing changed
+			if oldObj.GetResourceVersion() == curObj.GetResourceVersion() {
+				return
+			}
+
+			enqueueControllerService(queue, curObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+
+			deleted, err := meta.Accessor(obj)
+			if err != nil {
+				utilruntime.HandleError(fmt.Errorf("couldn't get object from tombstone %#v", obj))
+				return
+			}
+
+			enqueueControllerService(queue, deleted)
+		},
+	}
+}
+
+func enqueueControllerService(queue workqueue.Interface, obj metav1.Object) {
+	if !IsManaged(obj) {
+		return
+	}
+
+	name := GetControllerServiceName(obj)
+	if len(name) == 0 {
+		return
+	}
+
+	queue.Add(obj.GetNamespace() + "/" + name)
+}
@@ -0,0 +1,166 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func TestCanManage(t *testing.T) {
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "reviews-svc",
+			Namespace: "default",
+			UID:       types.UID("uid-reviews-svc"),
+			Labels:    map[string]string{AppLabel: "reviews", ApplicationNameLabel: "bookinfo", ApplicationVersionLabel: "v1"},
+		},
+	}
+	another := service.DeepCopy()
+	another.Name, another.UID = "ratings", types.UID("uid-ratings")
+
+	ownedBy := func(service *v1.Service, controller bool) []metav1.OwnerReference {
+		ref := NewServiceControllerRef(service)
+		ref.Controller = &controller
+		return []metav1.OwnerReference{*ref}
+	}
+
+	tests := []struct {
+		name    string
+		labels  map[string]string
+		owners  []metav1.OwnerReference
+		objName string
+		want    bool
+	}{
+		{
+			name:   "managed and controlled by service",
+			labels: ManagedLabels(service),
+			owners: ownedBy(service, true),
+			want:   true,
+		},
+		{
+			name:   "managed but not controlled yet",
+			labels: map[string]string{ManagedByLabel: ManagedByValue},
+			want:   true,
+		},
+		{
+			name:   "managed but controlled by another service",
+			labels: ManagedLabels(service),
+			owners: ownedBy(another, true),
+		},
+		{
+			name:    "not managed, named after app",
+			labels:  map[string]string{AppLabel: "reviews"},
+			objName: "reviews",
+			want:    true,
+		},
+		{
+			name:    "not managed, named after service",
+			labels:  map[string]string{AppLabel: "reviews"},
+			objName: "reviews-svc",
+			want:    true,
+		},
+		{
+			name:   "not managed, owned by service",
+			labels: map[string]string{AppLabel: "reviews"},
+			owners: ownedBy(service, false),
+			want:   true,
+		},
+		{
+			name:    "not managed, without app label",
+			objName: "reviews",
+		},
+		{
+			name:    "not managed, of another app",
+			labels:  map[string]string{AppLabel: "ratings"},
+			objName: "reviews",
+		},
+		{
+			name:   "not managed, named otherwise",
+			labels: map[string]string{AppLabel: "reviews"},
+		},
+		{
+			name:    "not managed, controlled by another service",
+			labels:  map[string]string{AppLabel: "reviews"},
+			owners:  ownedBy(another, true),
+			objName: "reviews",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			objName := test.objName
+			if len(objName) == 0 {
+				objName = "reviews-custom"
+			}
+			obj := &metav1.ObjectMeta{Name: objName, Namespace: "default", Labels: test.labels, OwnerReferences: test.owners}
+
+			if got := CanManage(obj, service); got != test.want {
+				t.Errorf("can manage %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestControllerServiceHandler(t *testing.T) {
+	service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "reviews", Namespace: "default", UID: types.UID("uid-reviews")}}
+
+	// generated returns an object generated for service, edited by others since
+	generated := func(resourceVersion string) *v1.ConfigMap {
+		return &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+			Name:            "reviews",
+			Namespace:       "default",
+			ResourceVersion: resourceVersion,
+			Labels:          ManagedLabels(service),
+			OwnerReferences: []metav1.OwnerReference{*NewServiceControllerRef(service)},
+		}}
+	}
+	notManaged := generated("2")
+	notManaged.Labels = nil
+	notControlled := generated("2")
+	notControlled.OwnerReferences = nil
+
+	tests := []struct {
+		name string
+		// old is nil when obj is deleted
+		old  interface{}
+		obj  interface{}
+		want []string
+	}{
+		{name: "changed", old: generated("1"), obj: generated("2"), want: []string{"default/reviews"}},
+		{name: "resynced", old: generated("1"), obj: generated("1")},
+		{name: "changed but not managed", old: generated("1"), obj: notManaged},
+		{name: "changed but not controlled by service", old: generated("1"), obj: notControlled},
+		{name: "deleted", obj: generated("1"), want: []string{"default/reviews"}},
+		{name: "tombstone", obj: cache.DeletedFinalStateUnknown{Key: "default/reviews", Obj: generated("1")}, want: []string{"default/reviews"}},
+		{name: "tombstone of unknown object", obj: cache.DeletedFinalStateUnknown{Key: "default/reviews", Obj: "reviews"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			queue := workqueue.New()
+			defer queue.ShutDown()
+
+			handler := ControllerServiceHandler(queue)
+			if test.old != nil {
+				handler.OnUpdate(test.old, test.obj)
+			} else {
+				handler.OnDelete(test.obj)
+			}
+
+			var keys []string
+			for queue.Len() > 0 {
+				key, _ := queue.Get()
+				keys = append(keys, key.(string))
+				queue.Done(key)
+			}
+			if !reflect.DeepEqual(keys, test.want) {
+				t.Errorf("enqueued %v, want %v", keys, test.want)
+			}
+		})
+	}
+}
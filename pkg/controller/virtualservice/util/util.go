@@ -1,15 +1,25 @@
 package util
 
 import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"reflect"
 	"strings"
+	"sync"
 
 	"istio.io/api/networking/v1beta1"
-	clientgonetworkingv1beta1 "istio.io/client-go/pkg/apis/networking/v1beta1"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+	"zmc.io/oasis/pkg/simple/client/servicemesh"
 )
 
-const (
+// keys of labels and annotation servicemesh applications are identified by,
+// they are configured once by ApplyLabelOptions at startup, and never change since
+var (
 	AppLabel                     = "app"
 	VersionLabel                 = "version"
 	ApplicationNameLabel         = "app.linkedcare.io/name"
@@ -17,13 +27,70 @@ const (
 	ServiceMeshEnabledAnnotation = "servicemesh.linkedcare.io/enabled"
 )
 
+const (
+	// controllerrevisions snapshotting a strategy are labeled with its name
+	StrategyRevisionLabel = "servicemesh.linkedcare.io/strategy"
+
+	// virtualservices and destinationrules generated by oasis are labeled with
+	// managed-by, the ones without it are never touched
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	ManagedByValue = "oasis"
+
+	// managed virtualservices and destinationrules annotated with override "true"
+	// are not reverted to what oasis generates
+	OverrideAnnotation = "servicemesh.linkedcare.io/override"
+
+	// destinationrules record since when subsets kept without ready workloads are unready
+	UnreadySubsetsAnnotation = "servicemesh.linkedcare.io/unready-subsets"
+)
+
 // resource with these following labels considered as part of servicemesh
-var ApplicationLabels = [...]string{
+var ApplicationLabels = []string{
 	ApplicationNameLabel,
 	ApplicationVersionLabel,
 	AppLabel,
 }
 
+// labelOptionsOnce guards keys of labels and annotation from being changed twice
+var labelOptionsOnce sync.Once
+
+// ApplyLabelOptions overrides keys of labels and annotation with the configured ones,
+// empty keys are left as they are. Keys are read without locking afterwards, so it
+// must be called before any informer or server starts. Only the first call takes
+// effect, later ones are ignored, it is safe to call from more than one goroutine.
+func ApplyLabelOptions(options *servicemesh.Options) {
+	labelOptionsOnce.Do(func() {
+		applyLabelOptions(options)
+	})
+}
+
+func applyLabelOptions(options *servicemesh.Options) {
+	if options == nil {
+		return
+	}
+
+	for _, key := range []struct {
+		value  *string
+		option string
+	}{
+		{&AppLabel, options.AppLabel},
+		{&VersionLabel, options.VersionLabel},
+		{&ApplicationNameLabel, options.ApplicationNameLabel},
+		{&ApplicationVersionLabel, options.ApplicationVersionLabel},
+		{&ServiceMeshEnabledAnnotation, options.ServiceMeshEnabledAnnotation},
+	} {
+		if len(key.option) > 0 {
+			*key.value = key.option
+		}
+	}
+
+	ApplicationLabels = []string{
+		ApplicationNameLabel,
+		ApplicationVersionLabel,
+		AppLabel,
+	}
+}
+
 var TrimChars = [...]string{".", "_", "-"}
 
 // normalize version names
@@ -91,34 +158,417 @@ func IsApplicationComponent(lbs map[string]string) bool {
 	return true
 }
 
-// if virtualservice not specified with port number, then fill with service first port
-func FillDestinationPort(vs *clientgonetworkingv1beta1.VirtualService, service *v1.Service) {
-	// fill http port
-	for i := range vs.Spec.Http {
-		for j := range vs.Spec.Http[i].Route {
-			port := vs.Spec.Http[i].Route[j].Destination.Port
-			if port == nil || port.Number == 0 {
-				vs.Spec.Http[i].Route[j].Destination.Port = &v1beta1.PortSelector{
-					Number: uint32(service.Spec.Ports[0].Port),
+// IsApplicationChanged tells if labels or annotations deciding which application
+// component an object belongs to, or whether servicemesh is enabled, are changed.
+func IsApplicationChanged(old, cur *metav1.ObjectMeta) bool {
+	return !reflect.DeepEqual(ExtractApplicationLabels(old), ExtractApplicationLabels(cur)) ||
+		IsServicemeshEnabled(old.Annotations) != IsServicemeshEnabled(cur.Annotations)
+}
+
+// PortProtocol is the protocol of traffic a service port serves
+type PortProtocol string
+
+const (
+	PortProtocolHTTP PortProtocol = "HTTP"
+	PortProtocolTLS  PortProtocol = "TLS"
+	PortProtocolTCP  PortProtocol = "TCP"
+
+	// ports not routed by virtualservice, i.e. udp
+	PortProtocolUnsupported PortProtocol = ""
+)
+
+// ClusterDomain is the domain suffix of services in cluster
+const ClusterDomain = "cluster.local"
+
+// GetPortProtocol classifies service port by istio protocol selection rules,
+// appProtocol takes precedence over the port name prefix.
+func GetPortProtocol(port v1.ServicePort) PortProtocol {
+	if port.Protocol != "" && port.Protocol != v1.ProtocolTCP {
+		return PortProtocolUnsupported
+	}
+
+	protocol := port.Name
+	if port.AppProtocol != nil && len(*port.AppProtocol) > 0 {
+		protocol = *port.AppProtocol
+	} else if i := strings.Index(protocol, "-"); i >= 0 {
+		protocol = protocol[:i]
+	}
+
+	switch strings.ToLower(protocol) {
+	case "http", "http2", "grpc", "grpc-web":
+		return PortProtocolHTTP
+	case "https", "tls":
+		return PortProtocolTLS
+	default:
+		return PortProtocolTCP
+	}
+}
+
+// IsHTTPPort tells if service port serves http traffic
+func IsHTTPPort(port v1.ServicePort) bool {
+	return GetPortProtocol(port) == PortProtocolHTTP
+}
+
+// HasHTTPPort tells if service has any http port
+func HasHTTPPort(service *v1.Service) bool {
+	return len(GetPortsByProtocol(service, PortProtocolHTTP)) > 0
+}
+
+// GetPortsByProtocol returns service ports serving traffic of protocol
+func GetPortsByProtocol(service *v1.Service, protocol PortProtocol) []v1.ServicePort {
+	ports := make([]v1.ServicePort, 0)
+	for _, port := range service.Spec.Ports {
+		if GetPortProtocol(port) == protocol {
+			ports = append(ports, port)
+		}
+	}
+	return ports
+}
+
+// ServiceFQDN returns fully qualified domain name of service
+func ServiceFQDN(service *v1.Service) string {
+	return fmt.Sprintf("%s.%s.svc.%s", service.Name, service.Namespace, ClusterDomain)
+}
+
+// FillDestinationPort fills destinations not specified with port number, with
+// the first service port of the route's protocol
+func FillDestinationPort(spec *v1beta1.VirtualService, service *v1.Service) {
+	if ports := routePorts(service, PortProtocolHTTP); len(ports) > 0 {
+		for _, route := range spec.Http {
+			fillHTTPRoutePort(route, httpMatchedPort(route, ports[0]))
+		}
+	}
+
+	if ports := routePorts(service, PortProtocolTCP); len(ports) > 0 {
+		for _, route := range spec.Tcp {
+			fillTCPRoutePort(route, tcpMatchedPort(route.Match, ports[0]))
+		}
+	}
+
+	if ports := routePorts(service, PortProtocolTLS); len(ports) > 0 {
+		for _, route := range spec.Tls {
+			fillTLSRoutePort(route, tlsMatchedPort(route.Match, ports[0]))
+		}
+	}
+}
+
+// ExpandPortRoutes binds routes to service ports. A route not bound to any port goes to
+// the only port of its protocol, or is copied for each port of its protocol with the
+// port matched, so every service port is routed.
+func ExpandPortRoutes(spec *v1beta1.VirtualService, service *v1.Service) {
+	if ports := routePorts(service, PortProtocolHTTP); len(ports) > 0 {
+		routes := make([]*v1beta1.HTTPRoute, 0, len(spec.Http))
+		for _, route := range spec.Http {
+			if len(ports) == 1 || isHTTPRouteBound(route) {
+				fillHTTPRoutePort(route, httpMatchedPort(route, ports[0]))
+				routes = append(routes, route)
+				continue
+			}
+
+			for _, port := range ports {
+				portRoute := route.DeepCopy()
+				if len(portRoute.Match) == 0 {
+					portRoute.Match = []*v1beta1.HTTPMatchRequest{{}}
 				}
+				for _, match := range portRoute.Match {
+					match.Port = port
+				}
+				if len(portRoute.Name) > 0 {
+					portRoute.Name = fmt.Sprintf("%s-%d", portRoute.Name, port)
+				}
+				fillHTTPRoutePort(portRoute, port)
+				routes = append(routes, portRoute)
 			}
 		}
+		spec.Http = routes
+	}
+
+	if ports := routePorts(service, PortProtocolTCP); len(ports) > 0 {
+		routes := make([]*v1beta1.TCPRoute, 0, len(spec.Tcp))
+		for _, route := range spec.Tcp {
+			if len(ports) == 1 || isTCPRouteBound(route) {
+				fillTCPRoutePort(route, tcpMatchedPort(route.Match, ports[0]))
+				routes = append(routes, route)
+				continue
+			}
 
-		if vs.Spec.Http[i].Mirror != nil && (vs.Spec.Http[i].Mirror.Port == nil || vs.Spec.Http[i].Mirror.Port.Number == 0) {
-			vs.Spec.Http[i].Mirror.Port = &v1beta1.PortSelector{
-				Number: uint32(service.Spec.Ports[0].Port),
+			for _, port := range ports {
+				portRoute := route.DeepCopy()
+				if len(portRoute.Match) == 0 {
+					portRoute.Match = []*v1beta1.L4MatchAttributes{{}}
+				}
+				for _, match := range portRoute.Match {
+					match.Port = port
+				}
+				fillTCPRoutePort(portRoute, port)
+				routes = append(routes, portRoute)
 			}
 		}
+		spec.Tcp = routes
 	}
 
-	// fill tcp port
-	for i := range vs.Spec.Tcp {
-		for j := range vs.Spec.Tcp[i].Route {
-			if vs.Spec.Tcp[i].Route[j].Destination.Port == nil || vs.Spec.Tcp[i].Route[j].Destination.Port.Number == 0 {
-				vs.Spec.Tcp[i].Route[j].Destination.Port = &v1beta1.PortSelector{
-					Number: uint32(service.Spec.Ports[0].Port),
+	if ports := routePorts(service, PortProtocolTLS); len(ports) > 0 {
+		routes := make([]*v1beta1.TLSRoute, 0, len(spec.Tls))
+		for _, route := range spec.Tls {
+			if len(ports) == 1 || isTLSRouteBound(route) {
+				fillTLSRoutePort(route, tlsMatchedPort(route.Match, ports[0]))
+				routes = append(routes, route)
+				continue
+			}
+
+			for _, port := range ports {
+				portRoute := route.DeepCopy()
+				for _, match := range portRoute.Match {
+					match.Port = port
 				}
+				fillTLSRoutePort(portRoute, port)
+				routes = append(routes, portRoute)
 			}
 		}
+		spec.Tls = routes
+	}
+}
+
+// routePorts returns port numbers of protocol, or the first service port if there is none,
+// so routes specified by users always have a port to go
+func routePorts(service *v1.Service, protocol PortProtocol) []uint32 {
+	ports := make([]uint32, 0)
+	for _, port := range GetPortsByProtocol(service, protocol) {
+		ports = append(ports, uint32(port.Port))
+	}
+
+	if len(ports) == 0 && len(service.Spec.Ports) > 0 {
+		ports = append(ports, uint32(service.Spec.Ports[0].Port))
+	}
+	return ports
+}
+
+func isHTTPRouteBound(route *v1beta1.HTTPRoute) bool {
+	for _, match := range route.Match {
+		if match.Port != 0 {
+			return true
+		}
+	}
+	for _, dw := range route.Route {
+		if dw.Destination != nil && dw.Destination.Port != nil && dw.Destination.Port.Number != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func isTCPRouteBound(route *v1beta1.TCPRoute) bool {
+	if tcpMatchedPort(route.Match, 0) != 0 {
+		return true
+	}
+	for _, dw := range route.Route {
+		if dw.Destination != nil && dw.Destination.Port != nil && dw.Destination.Port.Number != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func isTLSRouteBound(route *v1beta1.TLSRoute) bool {
+	if tlsMatchedPort(route.Match, 0) != 0 {
+		return true
+	}
+	for _, dw := range route.Route {
+		if dw.Destination != nil && dw.Destination.Port != nil && dw.Destination.Port.Number != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// httpMatchedPort returns port matched by route, or defaultPort if route matches any port
+func httpMatchedPort(route *v1beta1.HTTPRoute, defaultPort uint32) uint32 {
+	for _, match := range route.Match {
+		if match.Port != 0 {
+			return match.Port
+		}
+	}
+	return defaultPort
+}
+
+func tcpMatchedPort(matches []*v1beta1.L4MatchAttributes, defaultPort uint32) uint32 {
+	for _, match := range matches {
+		if match.Port != 0 {
+			return match.Port
+		}
+	}
+	return defaultPort
+}
+
+func tlsMatchedPort(matches []*v1beta1.TLSMatchAttributes, defaultPort uint32) uint32 {
+	for _, match := range matches {
+		if match.Port != 0 {
+			return match.Port
+		}
+	}
+	return defaultPort
+}
+
+func fillHTTPRoutePort(route *v1beta1.HTTPRoute, port uint32) {
+	for _, dw := range route.Route {
+		fillDestinationPort(dw.Destination, port)
+	}
+	fillDestinationPort(route.Mirror, port)
+}
+
+func fillTCPRoutePort(route *v1beta1.TCPRoute, port uint32) {
+	for _, dw := range route.Route {
+		fillDestinationPort(dw.Destination, port)
+	}
+}
+
+func fillTLSRoutePort(route *v1beta1.TLSRoute, port uint32) {
+	for _, dw := range route.Route {
+		fillDestinationPort(dw.Destination, port)
+	}
+}
+
+func fillDestinationPort(destination *v1beta1.Destination, port uint32) {
+	if destination != nil && (destination.Port == nil || destination.Port.Number == 0) {
+		destination.Port = &v1beta1.PortSelector{Number: port}
+	}
+}
+
+// NewStrategyCondition creates a new strategy condition
+func NewStrategyCondition(condType servicemeshv1alpha1.StrategyConditionType, status v1.ConditionStatus, reason, message string) *servicemeshv1alpha1.StrategyCondition {
+	return &servicemeshv1alpha1.StrategyCondition{
+		Type:               condType,
+		Status:             status,
+		LastProbeTime:      metav1.Now(),
+		LastTransitionTime: metav1.Now(),
+		Reason:             reason,
+		Message:            message,
+	}
+}
+
+// GetStrategyCondition returns the condition with the provided type
+func GetStrategyCondition(status servicemeshv1alpha1.StrategyStatus, condType servicemeshv1alpha1.StrategyConditionType) *servicemeshv1alpha1.StrategyCondition {
+	for i := range status.Conditions {
+		c := status.Conditions[i]
+		if c.Type == condType {
+			return &c
+		}
+	}
+	return nil
+}
+
+// SetStrategyCondition updates the strategy status to include the provided condition. If the condition
+// already exists with the same status, reason and message, it is left untouched.
+func SetStrategyCondition(status *servicemeshv1alpha1.StrategyStatus, condition servicemeshv1alpha1.StrategyCondition) {
+	currentCond := GetStrategyCondition(*status, condition.Type)
+	if currentCond != nil && currentCond.Status == condition.Status && currentCond.Reason == condition.Reason &&
+		currentCond.Message == condition.Message {
+		return
+	}
+
+	// do not update lastTransitionTime if the status of the condition doesn't change,
+	// i.e. only its reason or message changes
+	if currentCond != nil && currentCond.Status == condition.Status {
+		condition.LastTransitionTime = currentCond.LastTransitionTime
+	}
+
+	RemoveStrategyCondition(status, condition.Type)
+	status.Conditions = append(status.Conditions, condition)
+}
+
+// RemoveStrategyCondition removes the strategy condition with the provided type
+func RemoveStrategyCondition(status *servicemeshv1alpha1.StrategyStatus, condType servicemeshv1alpha1.StrategyConditionType) {
+	var conditions []servicemeshv1alpha1.StrategyCondition
+	for _, c := range status.Conditions {
+		if c.Type == condType {
+			continue
+		}
+		conditions = append(conditions, c)
+	}
+	status.Conditions = conditions
+}
+
+// NewServicePolicyCondition creates a new servicepolicy condition
+func NewServicePolicyCondition(condType servicemeshv1alpha1.ServicePolicyConditionType, status v1.ConditionStatus, reason, message string) *servicemeshv1alpha1.ServicePolicyCondition {
+	return &servicemeshv1alpha1.ServicePolicyCondition{
+		Type:               condType,
+		Status:             status,
+		LastProbeTime:      metav1.Now(),
+		LastTransitionTime: metav1.Now(),
+		Reason:             reason,
+		Message:            message,
+	}
+}
+
+// GetServicePolicyCondition returns the condition with the provided type
+func GetServicePolicyCondition(status servicemeshv1alpha1.ServicePolicyStatus, condType servicemeshv1alpha1.ServicePolicyConditionType) *servicemeshv1alpha1.ServicePolicyCondition {
+	for i := range status.Conditions {
+		c := status.Conditions[i]
+		if c.Type == condType {
+			return &c
+		}
 	}
+	return nil
+}
+
+// SetServicePolicyCondition updates the servicepolicy status to include the provided condition. If the
+// condition already exists with the same status, reason and message, it is left untouched.
+func SetServicePolicyCondition(status *servicemeshv1alpha1.ServicePolicyStatus, condition servicemeshv1alpha1.ServicePolicyCondition) {
+	currentCond := GetServicePolicyCondition(*status, condition.Type)
+	if currentCond != nil && currentCond.Status == condition.Status && currentCond.Reason == condition.Reason &&
+		currentCond.Message == condition.Message {
+		return
+	}
+
+	// do not update lastTransitionTime if the status of the condition doesn't change,
+	// i.e. only its reason or message changes
+	if currentCond != nil && currentCond.Status == condition.Status {
+		condition.LastTransitionTime = currentCond.LastTransitionTime
+	}
+
+	RemoveServicePolicyCondition(status, condition.Type)
+	status.Conditions = append(status.Conditions, condition)
+}
+
+// RemoveServicePolicyCondition removes the servicepolicy condition with the provided type
+func RemoveServicePolicyCondition(status *servicemeshv1alpha1.ServicePolicyStatus, condType servicemeshv1alpha1.ServicePolicyConditionType) {
+	var conditions []servicemeshv1alpha1.ServicePolicyCondition
+	for _, c := range status.Conditions {
+		if c.Type == condType {
+			continue
+		}
+		conditions = append(conditions, c)
+	}
+	status.Conditions = conditions
+}
+
+// ConditionEventType returns type of the event recorded once complete condition of a
+// strategy or servicepolicy transits. Only failures are warned, pending is not.
+func ConditionEventType(failed bool) string {
+	if failed {
+		return v1.EventTypeWarning
+	}
+	return v1.EventTypeNormal
+}
+
+// StrategyRevisionName returns name of the controllerrevision snapshotting strategy spec,
+// along with the snapshot. Same specs of a strategy share the same revision name, as long
+// as collision count in strategy status stays the same.
+func StrategyRevisionName(strategy *servicemeshv1alpha1.Strategy) (string, []byte, error) {
+	data, err := json.Marshal(strategy.Spec)
+	if err != nil {
+		return "", nil, err
+	}
+
+	hasher := fnv.New32a()
+	_, _ = hasher.Write(data)
+
+	// add collision count into the hash, so names of colliding revisions differ
+	if collisionCount := strategy.Status.CollisionCount; collisionCount != nil {
+		collisionCountBytes := make([]byte, 8)
+		binary.LittleEndian.PutUint32(collisionCountBytes, uint32(*collisionCount))
+		_, _ = hasher.Write(collisionCountBytes)
+	}
+
+	return fmt.Sprintf("%s-%s", strategy.Name, rand.SafeEncodeString(fmt.Sprint(hasher.Sum32()))), data, nil
 }
@@ -0,0 +1,511 @@
+package util
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"istio.io/api/networking/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+	"zmc.io/oasis/pkg/simple/client/servicemesh"
+)
+
+func TestSetStrategyCondition(t *testing.T) {
+	past := metav1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+	current := servicemeshv1alpha1.StrategyCondition{
+		Type:               servicemeshv1alpha1.StrategyFailed,
+		Status:             v1.ConditionTrue,
+		Reason:             "FailedToDeliver",
+		Message:            "virtualservice default/reviews is forbidden",
+		LastProbeTime:      past,
+		LastTransitionTime: past,
+	}
+
+	tests := []struct {
+		name           string
+		condition      *servicemeshv1alpha1.StrategyCondition
+		wantMessage    string
+		wantProbed     bool
+		wantTransition bool
+	}{
+		{
+			name:        "unchanged",
+			condition:   NewStrategyCondition(current.Type, current.Status, current.Reason, current.Message),
+			wantMessage: current.Message,
+		},
+		{
+			name:        "message changed",
+			condition:   NewStrategyCondition(current.Type, current.Status, current.Reason, "virtualservice default/reviews is invalid"),
+			wantMessage: "virtualservice default/reviews is invalid",
+			wantProbed:  true,
+		},
+		{
+			name:        "reason changed",
+			condition:   NewStrategyCondition(current.Type, current.Status, "Another", current.Message),
+			wantMessage: current.Message,
+			wantProbed:  true,
+		},
+		{
+			name:           "status changed",
+			condition:      NewStrategyCondition(current.Type, v1.ConditionFalse, current.Reason, current.Message),
+			wantMessage:    current.Message,
+			wantProbed:     true,
+			wantTransition: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status := &servicemeshv1alpha1.StrategyStatus{Conditions: []servicemeshv1alpha1.StrategyCondition{current}}
+			SetStrategyCondition(status, *test.condition)
+
+			if len(status.Conditions) != 1 {
+				t.Fatalf("got %d conditions, want 1", len(status.Conditions))
+			}
+
+			got := status.Conditions[0]
+			if got.Message != test.wantMessage {
+				t.Errorf("message %q, want %q", got.Message, test.wantMessage)
+			}
+			if probed := !got.LastProbeTime.Equal(&past); probed != test.wantProbed {
+				t.Errorf("probe time updated %v, want %v", probed, test.wantProbed)
+			}
+			if transited := !got.LastTransitionTime.Equal(&past); transited != test.wantTransition {
+				t.Errorf("transition time updated %v, want %v", transited, test.wantTransition)
+			}
+		})
+	}
+}
+
+func TestSetServicePolicyCondition(t *testing.T) {
+	past := metav1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+	current := servicemeshv1alpha1.ServicePolicyCondition{
+		Type:               servicemeshv1alpha1.ServicePolicyFailed,
+		Status:             v1.ConditionTrue,
+		Reason:             "FailedToDeliver",
+		Message:            "destinationrule default/reviews is forbidden",
+		LastProbeTime:      past,
+		LastTransitionTime: past,
+	}
+
+	tests := []struct {
+		name           string
+		condition      *servicemeshv1alpha1.ServicePolicyCondition
+		wantMessage    string
+		wantProbed     bool
+		wantTransition bool
+	}{
+		{
+			name:        "unchanged",
+			condition:   NewServicePolicyCondition(current.Type, current.Status, current.Reason, current.Message),
+			wantMessage: current.Message,
+		},
+		{
+			name:        "message changed",
+			condition:   NewServicePolicyCondition(current.Type, current.Status, current.Reason, "subsets differ"),
+			wantMessage: "subsets differ",
+			wantProbed:  true,
+		},
+		{
+			name:           "status changed",
+			condition:      NewServicePolicyCondition(current.Type, v1.ConditionFalse, current.Reason, current.Message),
+			wantMessage:    current.Message,
+			wantProbed:     true,
+			wantTransition: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status := &servicemeshv1alpha1.ServicePolicyStatus{Conditions: []servicemeshv1alpha1.ServicePolicyCondition{current}}
+			SetServicePolicyCondition(status, *test.condition)
+
+			if len(status.Conditions) != 1 {
+				t.Fatalf("got %d conditions, want 1", len(status.Conditions))
+			}
+
+			got := status.Conditions[0]
+			if got.Message != test.wantMessage {
+				t.Errorf("message %q, want %q", got.Message, test.wantMessage)
+			}
+			if probed := !got.LastProbeTime.Equal(&past); probed != test.wantProbed {
+				t.Errorf("probe time updated %v, want %v", probed, test.wantProbed)
+			}
+			if transited := !got.LastTransitionTime.Equal(&past); transited != test.wantTransition {
+				t.Errorf("transition time updated %v, want %v", transited, test.wantTransition)
+			}
+		})
+	}
+}
+
+func TestRemoveStrategyCondition(t *testing.T) {
+	status := &servicemeshv1alpha1.StrategyStatus{}
+	SetStrategyCondition(status, *NewStrategyCondition(servicemeshv1alpha1.StrategyComplete, v1.ConditionTrue, "Delivered", ""))
+	SetStrategyCondition(status, *NewStrategyCondition(servicemeshv1alpha1.StrategyFailed, v1.ConditionTrue, "FailedToDeliver", "forbidden"))
+
+	RemoveStrategyCondition(status, servicemeshv1alpha1.StrategyFailed)
+	if GetStrategyCondition(*status, servicemeshv1alpha1.StrategyFailed) != nil {
+		t.Errorf("failed condition is not removed")
+	}
+	if GetStrategyCondition(*status, servicemeshv1alpha1.StrategyComplete) == nil {
+		t.Errorf("complete condition is removed")
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func newPortService(ports ...v1.ServicePort) *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "reviews", Namespace: "default"},
+		Spec:       v1.ServiceSpec{Ports: ports},
+	}
+}
+
+func TestGetPortProtocol(t *testing.T) {
+	tests := []struct {
+		port v1.ServicePort
+		want PortProtocol
+	}{
+		{port: v1.ServicePort{Name: "http"}, want: PortProtocolHTTP},
+		{port: v1.ServicePort{Name: "http-web"}, want: PortProtocolHTTP},
+		{port: v1.ServicePort{Name: "HTTP2"}, want: PortProtocolHTTP},
+		{port: v1.ServicePort{Name: "grpc-api"}, want: PortProtocolHTTP},
+		{port: v1.ServicePort{Name: "grpc-web"}, want: PortProtocolHTTP},
+		{port: v1.ServicePort{Name: "https"}, want: PortProtocolTLS},
+		{port: v1.ServicePort{Name: "tls-db"}, want: PortProtocolTLS},
+		{port: v1.ServicePort{Name: "metrics"}, want: PortProtocolTCP},
+		{port: v1.ServicePort{Name: "httpx"}, want: PortProtocolTCP},
+		{port: v1.ServicePort{}, want: PortProtocolTCP},
+		{port: v1.ServicePort{Name: "metrics", AppProtocol: stringPtr("http")}, want: PortProtocolHTTP},
+		{port: v1.ServicePort{Name: "http", AppProtocol: stringPtr("tcp")}, want: PortProtocolTCP},
+		{port: v1.ServicePort{Name: "http", AppProtocol: stringPtr("")}, want: PortProtocolHTTP},
+		{port: v1.ServicePort{Name: "dns", Protocol: v1.ProtocolUDP}, want: PortProtocolUnsupported},
+		{port: v1.ServicePort{Name: "http", Protocol: v1.ProtocolSCTP}, want: PortProtocolUnsupported},
+	}
+
+	for _, test := range tests {
+		appProtocol := ""
+		if test.port.AppProtocol != nil {
+			appProtocol = *test.port.AppProtocol
+		}
+		if got := GetPortProtocol(test.port); got != test.want {
+			t.Errorf("port %q app protocol %q protocol %s classified %q, want %q", test.port.Name, appProtocol, test.port.Protocol, got, test.want)
+		}
+	}
+}
+
+func TestServiceFQDN(t *testing.T) {
+	if got := ServiceFQDN(newPortService()); got != "reviews.default.svc.cluster.local" {
+		t.Errorf("fqdn %s, want reviews.default.svc.cluster.local", got)
+	}
+}
+
+func destinationPorts(destinations []*v1beta1.HTTPRouteDestination) []uint32 {
+	ports := make([]uint32, 0, len(destinations))
+	for _, destination := range destinations {
+		if destination.Destination.Port == nil {
+			ports = append(ports, 0)
+			continue
+		}
+		ports = append(ports, destination.Destination.Port.Number)
+	}
+	return ports
+}
+
+func newHTTPRoute(name string, matchPort, destinationPort uint32) *v1beta1.HTTPRoute {
+	route := &v1beta1.HTTPRoute{
+		Name:  name,
+		Route: []*v1beta1.HTTPRouteDestination{{Destination: &v1beta1.Destination{Host: "reviews", Subset: "v1"}}},
+	}
+	if matchPort != 0 {
+		route.Match = []*v1beta1.HTTPMatchRequest{{Port: matchPort}}
+	}
+	if destinationPort != 0 {
+		route.Route[0].Destination.Port = &v1beta1.PortSelector{Number: destinationPort}
+	}
+	return route
+}
+
+func TestFillDestinationPort(t *testing.T) {
+	tests := []struct {
+		name    string
+		service *v1.Service
+		route   *v1beta1.HTTPRoute
+		want    uint32
+	}{
+		{
+			name:    "first http port",
+			service: newPortService(v1.ServicePort{Name: "metrics", Port: 9090}, v1.ServicePort{Name: "http", Port: 80}, v1.ServicePort{Name: "http-alt", Port: 8080}),
+			route:   newHTTPRoute("", 0, 0),
+			want:    80,
+		},
+		{
+			name:    "port matched",
+			service: newPortService(v1.ServicePort{Name: "http", Port: 80}, v1.ServicePort{Name: "http-alt", Port: 8080}),
+			route:   newHTTPRoute("", 8080, 0),
+			want:    8080,
+		},
+		{
+			name:    "port specified",
+			service: newPortService(v1.ServicePort{Name: "http", Port: 80}),
+			route:   newHTTPRoute("", 0, 8080),
+			want:    8080,
+		},
+		{
+			name:    "first port without http ports",
+			service: newPortService(v1.ServicePort{Name: "metrics", Port: 9090}),
+			route:   newHTTPRoute("", 0, 0),
+			want:    9090,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			spec := &v1beta1.VirtualService{Http: []*v1beta1.HTTPRoute{test.route}}
+			FillDestinationPort(spec, test.service)
+
+			if len(spec.Http) != 1 {
+				t.Fatalf("got %d routes, want 1", len(spec.Http))
+			}
+			if ports := destinationPorts(spec.Http[0].Route); !reflect.DeepEqual(ports, []uint32{test.want}) {
+				t.Errorf("destination ports %v, want %d", ports, test.want)
+			}
+		})
+	}
+}
+
+func TestExpandPortRoutes(t *testing.T) {
+	multiPort := newPortService(
+		v1.ServicePort{Name: "http", Port: 80},
+		v1.ServicePort{Name: "grpc", Port: 9000},
+		v1.ServicePort{Name: "metrics", Port: 9090},
+		v1.ServicePort{Name: "tcp-db", Port: 5432},
+		v1.ServicePort{Name: "tls", Port: 443},
+		v1.ServicePort{Name: "dns", Port: 53, Protocol: v1.ProtocolUDP},
+	)
+
+	tests := []struct {
+		name    string
+		service *v1.Service
+		spec    *v1beta1.VirtualService
+
+		wantHTTPNames []string
+		wantHTTPPorts []uint32
+		wantTCPPorts  []uint32
+		wantTLSPorts  []uint32
+	}{
+		{
+			name:          "single port",
+			service:       newPortService(v1.ServicePort{Name: "http", Port: 80}),
+			spec:          &v1beta1.VirtualService{Http: []*v1beta1.HTTPRoute{newHTTPRoute("default", 0, 0)}},
+			wantHTTPNames: []string{"default"},
+			wantHTTPPorts: []uint32{80},
+		},
+		{
+			name:          "copied for every http port",
+			service:       multiPort,
+			spec:          &v1beta1.VirtualService{Http: []*v1beta1.HTTPRoute{newHTTPRoute("default", 0, 0), newHTTPRoute("", 0, 0)}},
+			wantHTTPNames: []string{"default-80", "default-9000", "", ""},
+			wantHTTPPorts: []uint32{80, 9000, 80, 9000},
+		},
+		{
+			name:          "bound routes kept",
+			service:       multiPort,
+			spec:          &v1beta1.VirtualService{Http: []*v1beta1.HTTPRoute{newHTTPRoute("grpc", 9000, 0), newHTTPRoute("web", 0, 80)}},
+			wantHTTPNames: []string{"grpc", "web"},
+			wantHTTPPorts: []uint32{9000, 80},
+		},
+		{
+			name:    "tcp and tls routes",
+			service: multiPort,
+			spec: &v1beta1.VirtualService{
+				Tcp: []*v1beta1.TCPRoute{{Route: []*v1beta1.RouteDestination{{Destination: &v1beta1.Destination{Host: "reviews", Subset: "v1"}}}}},
+				Tls: []*v1beta1.TLSRoute{{
+					Match: []*v1beta1.TLSMatchAttributes{{SniHosts: []string{"reviews"}}},
+					Route: []*v1beta1.RouteDestination{{Destination: &v1beta1.Destination{Host: "reviews", Subset: "v1"}}},
+				}},
+			},
+			wantTCPPorts: []uint32{9090, 5432},
+			wantTLSPorts: []uint32{443},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ExpandPortRoutes(test.spec, test.service)
+
+			names := make([]string, 0)
+			httpPorts := make([]uint32, 0)
+			for _, route := range test.spec.Http {
+				names = append(names, route.Name)
+				port := route.Route[0].Destination.Port.Number
+				if matched := httpMatchedPort(route, port); matched != port {
+					t.Errorf("route %q matches port %d, routes to %d", route.Name, matched, port)
+				}
+				httpPorts = append(httpPorts, port)
+			}
+			if len(test.spec.Http) > 0 && (!reflect.DeepEqual(names, test.wantHTTPNames) || !reflect.DeepEqual(httpPorts, test.wantHTTPPorts)) {
+				t.Errorf("http routes %v to ports %v, want %v %v", names, httpPorts, test.wantHTTPNames, test.wantHTTPPorts)
+			}
+
+			tcpPorts := make([]uint32, 0)
+			for _, route := range test.spec.Tcp {
+				port := route.Route[0].Destination.Port.Number
+				if matched := tcpMatchedPort(route.Match, port); matched != port {
+					t.Errorf("tcp route matches port %d, routes to %d", matched, port)
+				}
+				tcpPorts = append(tcpPorts, port)
+			}
+			if len(test.spec.Tcp) > 0 && !reflect.DeepEqual(tcpPorts, test.wantTCPPorts) {
+				t.Errorf("tcp routes to ports %v, want %v", tcpPorts, test.wantTCPPorts)
+			}
+
+			tlsPorts := make([]uint32, 0)
+			for _, route := range test.spec.Tls {
+				tlsPorts = append(tlsPorts, route.Route[0].Destination.Port.Number)
+			}
+			if len(test.spec.Tls) > 0 && !reflect.DeepEqual(tlsPorts, test.wantTLSPorts) {
+				t.Errorf("tls routes to ports %v, want %v", tlsPorts, test.wantTLSPorts)
+			}
+		})
+	}
+}
+
+func TestIsApplicationChanged(t *testing.T) {
+	labels := map[string]string{AppLabel: "reviews", ApplicationNameLabel: "bookinfo", ApplicationVersionLabel: "v1"}
+	enabled := map[string]string{ServiceMeshEnabledAnnotation: "true"}
+
+	with := func(key, value string) map[string]string {
+		changed := make(map[string]string, len(labels)+1)
+		for k, v := range labels {
+			changed[k] = v
+		}
+		if len(value) == 0 {
+			delete(changed, key)
+		} else {
+			changed[key] = value
+		}
+		return changed
+	}
+
+	tests := []struct {
+		name        string
+		labels      map[string]string
+		annotations map[string]string
+		want        bool
+	}{
+		{name: "unchanged", labels: labels, annotations: enabled},
+		{name: "other labels changed", labels: with("team", "a"), annotations: enabled},
+		{name: "app changed", labels: with(AppLabel, "ratings"), annotations: enabled, want: true},
+		{name: "application version changed", labels: with(ApplicationVersionLabel, "v2"), annotations: enabled, want: true},
+		{name: "app label removed", labels: with(AppLabel, ""), annotations: enabled, want: true},
+		{name: "servicemesh disabled", labels: labels, annotations: map[string]string{ServiceMeshEnabledAnnotation: "false"}, want: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			old := &metav1.ObjectMeta{Labels: labels, Annotations: enabled}
+			cur := &metav1.ObjectMeta{Labels: test.labels, Annotations: test.annotations}
+			if got := IsApplicationChanged(old, cur); got != test.want {
+				t.Errorf("changed %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestApplyLabelOptions(t *testing.T) {
+	// keys are package variables, built-in ones are restored for other tests
+	builtin := []string{AppLabel, VersionLabel, ApplicationNameLabel, ApplicationVersionLabel, ServiceMeshEnabledAnnotation}
+	defer applyLabelOptions(&servicemesh.Options{
+		AppLabel:                     builtin[0],
+		VersionLabel:                 builtin[1],
+		ApplicationNameLabel:         builtin[2],
+		ApplicationVersionLabel:      builtin[3],
+		ServiceMeshEnabledAnnotation: builtin[4],
+	})
+
+	tests := []struct {
+		name    string
+		options *servicemesh.Options
+		want    []string
+	}{
+		{
+			name: "nil options",
+			want: builtin,
+		},
+		{
+			name:    "empty keys",
+			options: &servicemesh.Options{},
+			want:    builtin,
+		},
+		{
+			name:    "app and version labels",
+			options: &servicemesh.Options{AppLabel: "app.kubernetes.io/name", VersionLabel: "app.kubernetes.io/version"},
+			want:    []string{"app.kubernetes.io/name", "app.kubernetes.io/version", builtin[2], builtin[3], builtin[4]},
+		},
+		{
+			name: "all keys",
+			options: &servicemesh.Options{
+				AppLabel:                     "app.kubernetes.io/name",
+				VersionLabel:                 "app.kubernetes.io/version",
+				ApplicationNameLabel:         "app.kubernetes.io/part-of",
+				ApplicationVersionLabel:      "app.kubernetes.io/release",
+				ServiceMeshEnabledAnnotation: "sidecar.istio.io/inject",
+			},
+			want: []string{"app.kubernetes.io/name", "app.kubernetes.io/version", "app.kubernetes.io/part-of", "app.kubernetes.io/release", "sidecar.istio.io/inject"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			applyLabelOptions(&servicemesh.Options{
+				AppLabel:                     builtin[0],
+				VersionLabel:                 builtin[1],
+				ApplicationNameLabel:         builtin[2],
+				ApplicationVersionLabel:      builtin[3],
+				ServiceMeshEnabledAnnotation: builtin[4],
+			})
+			applyLabelOptions(test.options)
+
+			got := []string{AppLabel, VersionLabel, ApplicationNameLabel, ApplicationVersionLabel, ServiceMeshEnabledAnnotation}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("keys %v, want %v", got, test.want)
+			}
+
+			// applications are identified by the configured labels
+			wantApplicationLabels := []string{test.want[2], test.want[3], test.want[0]}
+			if !reflect.DeepEqual(ApplicationLabels, wantApplicationLabels) {
+				t.Errorf("application labels %v, want %v", ApplicationLabels, wantApplicationLabels)
+			}
+			labels := map[string]string{test.want[0]: "reviews", test.want[2]: "bookinfo", test.want[3]: "v1"}
+			if !IsApplicationComponent(labels) {
+				t.Errorf("labels %v are not of an application component", labels)
+			}
+			if !IsServicemeshEnabled(map[string]string{test.want[4]: "true"}) {
+				t.Errorf("servicemesh is not enabled by annotation %s", test.want[4])
+			}
+		})
+	}
+}
+
+func TestApplyLabelOptionsOnce(t *testing.T) {
+	builtin := AppLabel
+	labelOptionsOnce = sync.Once{}
+	defer func() {
+		labelOptionsOnce = sync.Once{}
+		applyLabelOptions(&servicemesh.Options{AppLabel: builtin})
+	}()
+
+	ApplyLabelOptions(&servicemesh.Options{AppLabel: "app.kubernetes.io/name"})
+	ApplyLabelOptions(&servicemesh.Options{AppLabel: "app.kubernetes.io/instance"})
+
+	if AppLabel != "app.kubernetes.io/name" {
+		t.Errorf("app label %s, want the first one applied", AppLabel)
+	}
+}
@@ -25,16 +25,22 @@ import (
 	istioclient "istio.io/client-go/pkg/clientset/versioned"
 	clientset "k8s.io/client-go/kubernetes"
 	servicemeshclient "zmc.io/oasis/pkg/client/clientset/versioned"
+	servicemeshscheme "zmc.io/oasis/pkg/client/clientset/versioned/scheme"
 
 	istiolisters "istio.io/client-go/pkg/listers/networking/v1beta1"
+	appslisters "k8s.io/client-go/listers/apps/v1"
 	corelisters "k8s.io/client-go/listers/core/v1"
 	servicemeshlisters "zmc.io/oasis/pkg/client/listers/servicemesh/v1alpha1"
 
 	istioinformers "istio.io/client-go/pkg/informers/externalversions/networking/v1beta1"
+	appsinformers "k8s.io/client-go/informers/apps/v1"
 	coreinformers "k8s.io/client-go/informers/core/v1"
 	servicemeshinformers "zmc.io/oasis/pkg/client/informers/externalversions/servicemesh/v1alpha1"
 
+	"zmc.io/oasis/pkg/controller/metrics"
+	"zmc.io/oasis/pkg/controller/scope"
 	"zmc.io/oasis/pkg/controller/virtualservice/util"
+	"zmc.io/oasis/pkg/simple/client/prometheus"
 
 	networkingv1beta1 "istio.io/client-go/pkg/apis/networking/v1beta1"
 	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
@@ -49,6 +55,12 @@ const (
 	//
 	// 5ms, 10ms, 20ms, 40ms, 80ms, 160ms, 320ms, 640ms, 1.3s, 2.6s, 5.1s, 10.2s, 20.4s, 41s, 82s
 	maxRetries = 15
+
+	// controllerName names the workqueue and labels metrics of the controller
+	controllerName = "virtualservice"
+
+	// reasonDestinationRuleNotFound labels errors syncing a service before its destinationrule is created
+	reasonDestinationRuleNotFound = "DestinationRuleNotFound"
 )
 
 // VirtualServiceController Contact Strategy
@@ -72,6 +84,13 @@ type VirtualServiceController struct {
 
 	strategyLister servicemeshlisters.StrategyLister
 	strategySynced cache.InformerSynced
+
+	revisionLister appslisters.ControllerRevisionLister
+	revisionSynced cache.InformerSynced
+	// namespaces reconciled by the controller
+	scope *scope.Scope
+	// canary 指标查询, 未配置时为空
+	prometheusClient prometheus.Interface
 	// 工作队列
 	queue workqueue.RateLimitingInterface
 	// 工作循环周期
@@ -82,9 +101,15 @@ func NewVirtualServiceController(serviceInformer coreinformers.ServiceInformer,
 	virtualServiceInformer istioinformers.VirtualServiceInformer,
 	destinationRuleInformer istioinformers.DestinationRuleInformer,
 	strategyInformer servicemeshinformers.StrategyInformer,
+	revisionInformer appsinformers.ControllerRevisionInformer,
 	client clientset.Interface,
 	virtualServiceClient istioclient.Interface,
-	servicemeshClient servicemeshclient.Interface) *VirtualServiceController {
+	servicemeshClient servicemeshclient.Interface,
+	prometheusClient prometheus.Interface,
+	scope *scope.Scope) *VirtualServiceController {
+
+	// events are also recorded on strategies
+	utilruntime.Must(servicemeshscheme.AddToScheme(scheme.Scheme))
 
 	broadcaster := record.NewBroadcaster()
 	broadcaster.StartLogging(func(format string, args ...interface{}) {
@@ -97,17 +122,16 @@ func NewVirtualServiceController(serviceInformer coreinformers.ServiceInformer,
 		client:               client,
 		virtualServiceClient: virtualServiceClient,
 		servicemeshClient:    servicemeshClient,
-		queue:                workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "virtualservice"),
+		prometheusClient:     prometheusClient,
+		scope:                scope,
+		queue:                workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), controllerName),
 		workerLoopPeriod:     time.Second,
 	}
 
 	serviceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc:    v.enqueueService,
 		DeleteFunc: v.enqueueService,
-		UpdateFunc: func(old, cur interface{}) {
-			// TODO(jeff): need a more robust mechanism, because user may change labels
-			v.enqueueService(cur)
-		},
+		UpdateFunc: v.updateService,
 	})
 
 	v.serviceLister = serviceInformer.Lister()
@@ -124,6 +148,9 @@ func NewVirtualServiceController(serviceInformer coreinformers.ServiceInformer,
 		},
 	})
 
+	v.revisionLister = revisionInformer.Lister()
+	v.revisionSynced = revisionInformer.Informer().HasSynced
+
 	v.destinationRuleLister = destinationRuleInformer.Lister()
 	v.destinationRuleSynced = destinationRuleInformer.Informer().HasSynced
 
@@ -137,6 +164,11 @@ func NewVirtualServiceController(serviceInformer coreinformers.ServiceInformer,
 	v.virtualServiceLister = virtualServiceInformer.Lister()
 	v.virtualServiceSynced = virtualServiceInformer.Informer().HasSynced
 
+	// managed virtualservices changed or deleted by others are reverted
+	virtualServiceInformer.Informer().AddEventHandler(util.ControllerServiceHandler(v.queue))
+
+	scope.AddNamespaceHandler(v.enqueueNamespace)
+
 	v.eventBroadcaster = broadcaster
 	v.eventRecorder = recorder
 
@@ -148,6 +180,16 @@ func (v *VirtualServiceController) Start(stopCh <-chan struct{}) error {
 	return v.Run(5, stopCh)
 }
 
+// HasSynced tells if caches of all informers the controller watches are synced
+func (v *VirtualServiceController) HasSynced() bool {
+	for _, synced := range []cache.InformerSynced{v.serviceSynced, v.virtualServiceSynced, v.destinationRuleSynced, v.strategySynced, v.revisionSynced, v.scope.HasSynced} {
+		if !synced() {
+			return false
+		}
+	}
+	return true
+}
+
 func (v *VirtualServiceController) Run(workers int, stopCh <-chan struct{}) error {
 	defer utilruntime.HandleCrash()
 	defer v.queue.ShutDown()
@@ -155,7 +197,7 @@ func (v *VirtualServiceController) Run(workers int, stopCh <-chan struct{}) erro
 	log.V(0).Info("starting virtualservice controller")
 	defer log.Info("shutting down virtualservice controller")
 
-	if !cache.WaitForCacheSync(stopCh, v.serviceSynced, v.virtualServiceSynced, v.destinationRuleSynced, v.strategySynced) {
+	if !cache.WaitForCacheSync(stopCh, v.serviceSynced, v.virtualServiceSynced, v.destinationRuleSynced, v.strategySynced, v.revisionSynced, v.scope.HasSynced) {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
 
@@ -181,7 +223,9 @@ func (v *VirtualServiceController) processNextWorkItem() bool {
 
 	defer v.queue.Done(eKey)
 
+	startTime := time.Now()
 	err := v.syncService(eKey.(string))
+	metrics.ObserveReconcile(controllerName, startTime, err)
 	v.handleErr(err, eKey)
 
 	return true
@@ -205,6 +249,11 @@ func (v *VirtualServiceController) syncService(key string) error {
 		return err
 	}
 
+	// namespaces out of scope are left to other controller-managers
+	if !v.scope.Contains(namespace) {
+		return nil
+	}
+
 	// default component name to service name
 	appName := name
 
@@ -215,17 +264,17 @@ func (v *VirtualServiceController) syncService(key string) error {
 	service, err := v.serviceLister.Services(namespace).Get(name)
 	if err != nil {
 		if errors.IsNotFound(err) {
-			// Delete the corresponding virtualservice, as the service has been deleted.
-			err = v.virtualServiceClient.NetworkingV1beta1().VirtualServices(namespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
-			if err != nil && !errors.IsNotFound(err) {
-				log.Error(err, "delete orphan virtualservice failed", "namespace", namespace, "name", service.Name)
+			// Delete the corresponding virtualservices, as the service has been deleted.
+			// They are named after app label of service, so they are found by owner.
+			if err = v.deleteOwnedVirtualServices(namespace, name, nil); err != nil {
 				return err
 			}
+			metrics.SetStrategiesActive(key, false)
 
 			// delete the orphan strategy if there is any
 			err = v.servicemeshClient.ServicemeshV1alpha1().Strategies(namespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
 			if err != nil && !errors.IsNotFound(err) {
-				log.Error(err, "delete orphan strategy failed", "namespace", namespace, "name", service.Name)
+				log.Error(err, "delete orphan strategy failed", "namespace", namespace, "name", name)
 				return err
 			}
 
@@ -235,26 +284,30 @@ func (v *VirtualServiceController) syncService(key string) error {
 		return err
 	}
 
-	if len(service.Labels) < len(util.ApplicationLabels) ||
-		!util.IsApplicationComponent(service.Labels) ||
-		!util.IsServicemeshEnabled(service.Annotations) ||
-		len(service.Spec.Ports) == 0 {
+	if !isVirtualServiceEnabled(service) {
 		// services don't have enough labels to create a virtualservice
 		// or they don't have necessary labels
 		// or they don't have any ports defined
-		return nil
+		// virtualservices generated before are torn down, i.e. service relabeled out of servicemesh
+		metrics.SetStrategiesActive(key, false)
+		return v.deleteOwnedVirtualServices(namespace, name, nil)
 	}
 
 	// get real component name, i.e label app value
 	appName = util.GetComponentName(&service.ObjectMeta)
 
+	// virtualservices named after a previous app label are stale, they route the same host
+	if err = v.deleteOwnedVirtualServices(namespace, name, sets.NewString(appName, GatewayVirtualServiceName(appName))); err != nil {
+		return err
+	}
+
 	destinationRule, err := v.destinationRuleLister.DestinationRules(namespace).Get(name)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			// there is no destinationrule for this service
 			// maybe corresponding workloads are not created yet
 			log.Info("destination rules for service not found, retrying.", "namespace", namespace, "name", name)
-			return fmt.Errorf("destination rule for service %s/%s not found", namespace, name)
+			return metrics.WithReason(reasonDestinationRuleNotFound, fmt.Errorf("destination rule for service %s/%s not found", namespace, name))
 		}
 		log.Error(err, "Couldn't get destinationrule for service.", "service", types.NamespacedName{Name: service.Name, Namespace: service.Namespace}.String())
 		return err
@@ -271,13 +324,11 @@ func (v *VirtualServiceController) syncService(key string) error {
 	if err != nil {
 		log.Error(err, "list strategies for service failed", "namespace", namespace, "name", appName)
 		return err
-	} else if len(strategies) > 1 {
-		// more than one strategies are not allowed, it will cause collision
-		err = fmt.Errorf("more than one strategies applied to service %s/%s is forbbiden", namespace, appName)
-		log.Error(err, "")
-		return err
 	}
 
+	// strategies are merged in order of priority
+	SortStrategies(strategies)
+
 	// get current virtual service
 	currentVirtualService, err := v.virtualServiceLister.VirtualServices(namespace).Get(appName)
 	if err != nil {
@@ -296,122 +347,207 @@ func (v *VirtualServiceController) syncService(key string) error {
 	}
 	vs := currentVirtualService.DeepCopy()
 
-	// create a whole new virtualservice
-
-	// TODO(jeff): use FQDN to replace service name
-	vs.Spec.Hosts = []string{name}
-
-	// check if service has TCP protocol ports
-	for _, port := range service.Spec.Ports {
-		var route networkingv1beta1api.HTTPRouteDestination
-		if port.Protocol == v1.ProtocolTCP {
-			route = networkingv1beta1api.HTTPRouteDestination{
-				Destination: &networkingv1beta1api.Destination{
-					Host:   name,
-					Subset: subsets[0].Name,
-					Port: &networkingv1beta1api.PortSelector{
-						Number: uint32(port.Port),
-					},
-				},
-				Weight: 100,
-			}
+	deliveries := make([]*strategyDelivery, 0, len(strategies))
+	applied := make([]*strategyDelivery, 0, len(strategies))
+	appliedStrategies := make([]*servicemeshv1alpha1.Strategy, 0, len(strategies))
 
-			// a http port, add to HTTPRoute
-			if len(port.Name) > 0 && (port.Name == "http" || strings.HasPrefix(port.Name, "http-")) {
-				vs.Spec.Http = []*networkingv1beta1api.HTTPRoute{{Route: []*networkingv1beta1api.HTTPRouteDestination{&route}}}
-				break
-			}
+	now := time.Now()
+	for _, strategy := range strategies {
+		delivery := &strategyDelivery{strategy: strategy, now: now}
+		deliveries = append(deliveries, delivery)
 
-			// everything else treated as TCPRoute
-			tcpRoute := networkingv1beta1api.TCPRoute{
-				Route: []*networkingv1beta1api.RouteDestination{
-					{
-						Destination: route.Destination,
-						Weight:      route.Weight,
-					},
-				},
-			}
-			vs.Spec.Tcp = []*networkingv1beta1api.TCPRoute{&tcpRoute}
+		// come back when strategy turns on or off by its schedule, or expires
+		if next := nextStrategyTransition(strategy, now); !next.IsZero() {
+			v.queue.AddAfter(key, next.Sub(now))
 		}
+
+		delivery.pendingReason, delivery.pendingMessage = StrategyPendingReason(strategy, subsets, now)
+		if len(delivery.pendingReason) > 0 {
+			continue
+		}
+
+		var requeueAfter time.Duration
+		strategy, requeueAfter, err = v.syncCanaryStep(strategy, service)
+		if err != nil {
+			return err
+		}
+
+		// come back when next canary step is due
+		if requeueAfter > 0 {
+			v.queue.AddAfter(key, requeueAfter)
+		}
+
+		strategy, err = v.syncBlueGreen(strategy)
+		if err != nil {
+			return err
+		}
+
+		delivery.strategy = strategy
+		applied = append(applied, delivery)
+		appliedStrategies = append(appliedStrategies, strategy)
 	}
 
-	if len(strategies) > 0 {
-		// apply strategy spec to virtualservice
+	metrics.SetStrategiesActive(key, len(appliedStrategies) > 0)
 
-		switch strategies[0].Spec.StrategyPolicy {
-		case servicemeshv1alpha1.PolicyPause:
-			break
-		case servicemeshv1alpha1.PolicyWaitForWorkloadReady:
-			set := v.getSubsets(strategies[0])
+	spec, gatewaySpec, conflicts := GenerateVirtualServiceSpecs(service, subsets, appliedStrategies)
+	for i := range applied {
+		applied[i].conflictMessage = conflicts[i]
+	}
+	vs.Spec = *spec
 
-			setNames := sets.String{}
-			for i := range subsets {
-				setNames.Insert(subsets[i].Name)
-			}
+	createVirtualService := len(currentVirtualService.ResourceVersion) == 0
 
-			nonExist := false
-			for k := range set {
-				if !setNames.Has(k) {
-					nonExist = true
-				}
-			}
-			// strategy has subset that are not ready
-			if nonExist {
-				break
-			} else {
-				vs.Spec = v.generateVirtualServiceSpec(strategies[0], service).Spec
+	// services sharing the app label generate the same virtualservice, it routes
+	// the service controlling it only, others report the conflict and leave it alone
+	sharing, err := v.servicesSharingApp(namespace, appName)
+	if err != nil {
+		return err
+	}
+	if len(sharing) > 1 {
+		owner := service.Name
+		if controller := util.GetControllerServiceName(currentVirtualService); !createVirtualService && len(controller) > 0 {
+			owner = controller
+		}
+
+		conflict := fmt.Sprintf("services %s share app label %s, virtualservice %s/%s routes service %s only",
+			strings.Join(sharing, ", "), appName, namespace, appName, owner)
+		for _, delivery := range applied {
+			if len(delivery.conflictMessage) > 0 {
+				delivery.conflictMessage += ", "
 			}
-		case servicemeshv1alpha1.PolicyImmediately:
-			vs.Spec = v.generateVirtualServiceSpec(strategies[0], service).Spec
-		default:
-			vs.Spec = v.generateVirtualServiceSpec(strategies[0], service).Spec
+			delivery.conflictMessage += conflict
+		}
+
+		if owner != service.Name {
+			v.eventRecorder.Event(service, v1.EventTypeWarning, "VirtualServiceConflict", conflict)
+			return v.strategiesDelivered(deliveries)
 		}
+	}
 
+	// never overwrite a virtualservice created by others
+	if !createVirtualService && !util.CanManage(currentVirtualService, service) {
+		err = fmt.Errorf("virtualservice %s/%s is not managed by oasis", namespace, appName)
+		v.eventRecorder.Event(service, v1.EventTypeWarning, "VirtualServiceNotManaged", err.Error())
+		_ = v.strategiesFailed(deliveries, ReasonNotManaged, err)
+		return nil
 	}
 
-	createVirtualService := len(currentVirtualService.ResourceVersion) == 0
+	managedLabels := util.ManagedLabels(service)
 
 	if !createVirtualService &&
 		reflect.DeepEqual(vs.Spec, currentVirtualService.Spec) &&
-		reflect.DeepEqual(service.Labels, currentVirtualService.Labels) {
+		reflect.DeepEqual(managedLabels, currentVirtualService.Labels) &&
+		metav1.IsControlledBy(currentVirtualService, service) {
 		log.V(4).Info("virtual service are equal, skipping update ")
-		return nil
-	}
+	} else if !createVirtualService && util.IsOverridden(currentVirtualService) {
+		// changes made on purpose are kept, strategies report the drift
+		drift := fmt.Sprintf("virtualservice %s/%s is overridden, generated routes are not applied", namespace, appName)
+		for _, delivery := range applied {
+			delivery.driftMessage = drift
+		}
+	} else {
+		newVirtualService := currentVirtualService.DeepCopy()
+		newVirtualService.Labels = managedLabels
+		util.SetServiceControllerRef(newVirtualService, service)
+		newVirtualService.Spec = vs.Spec
+		if newVirtualService.Annotations == nil {
+			newVirtualService.Annotations = make(map[string]string)
+		}
+
+		if len(newVirtualService.Spec.Http) == 0 && len(newVirtualService.Spec.Tcp) == 0 && len(newVirtualService.Spec.Tls) == 0 {
+			err = fmt.Errorf("service %s/%s doesn't have a valid port spec", namespace, name)
+			log.Error(err, "")
+			return v.strategiesFailed(deliveries, ReasonInvalidPortSpec, err)
+		}
+
+		if createVirtualService {
+			_, err = v.virtualServiceClient.NetworkingV1beta1().VirtualServices(namespace).Create(context.TODO(), newVirtualService, metav1.CreateOptions{})
+		} else {
+			_, err = v.virtualServiceClient.NetworkingV1beta1().VirtualServices(namespace).Update(context.TODO(), newVirtualService, metav1.UpdateOptions{})
+		}
 
-	newVirtualService := currentVirtualService.DeepCopy()
-	newVirtualService.Labels = service.Labels
-	newVirtualService.Spec = vs.Spec
-	if newVirtualService.Annotations == nil {
-		newVirtualService.Annotations = make(map[string]string)
+		if err != nil {
+			if createVirtualService {
+				v.eventRecorder.Event(newVirtualService, v1.EventTypeWarning, "FailedToCreateVirtualService", fmt.Sprintf("Failed to create virtualservice for service %v/%v: %v", namespace, name, err))
+			} else {
+				v.eventRecorder.Event(newVirtualService, v1.EventTypeWarning, "FailedToUpdateVirtualService", fmt.Sprintf("Failed to update virtualservice for service %v/%v: %v", namespace, name, err))
+			}
+
+			return v.strategiesFailed(deliveries, ReasonFailedToDeliver, err)
+		}
 	}
 
-	if len(newVirtualService.Spec.Http) == 0 && len(newVirtualService.Spec.Tcp) == 0 && len(newVirtualService.Spec.Tls) == 0 {
-		err = fmt.Errorf("service %s/%s doesn't have a valid port spec", namespace, name)
-		log.Error(err, "")
-		return err
+	// traffic from gateways goes the same way as mesh internal traffic
+	gatewayDrift, err := v.syncGatewayVirtualService(service, appName, gatewaySpec)
+	if err != nil {
+		return v.strategiesFailed(deliveries, ReasonFailedToDeliver, err)
 	}
 
-	if createVirtualService {
-		_, err = v.virtualServiceClient.NetworkingV1beta1().VirtualServices(namespace).Create(context.TODO(), newVirtualService, metav1.CreateOptions{})
-	} else {
-		_, err = v.virtualServiceClient.NetworkingV1beta1().VirtualServices(namespace).Update(context.TODO(), newVirtualService, metav1.UpdateOptions{})
+	if len(gatewayDrift) > 0 {
+		for _, delivery := range applied {
+			if len(delivery.driftMessage) > 0 {
+				delivery.driftMessage += ", "
+			}
+			delivery.driftMessage += gatewayDrift
+		}
 	}
 
+	return v.strategiesDelivered(deliveries)
+}
+
+// deleteOwnedVirtualServices deletes virtualservices generated for service of name, except the ones in keep
+func (v *VirtualServiceController) deleteOwnedVirtualServices(namespace, name string, keep sets.String) error {
+	virtualServices, err := v.virtualServiceLister.VirtualServices(namespace).List(labels.SelectorFromSet(map[string]string{util.ManagedByLabel: util.ManagedByValue}))
 	if err != nil {
-		if createVirtualService {
-			v.eventRecorder.Event(newVirtualService, v1.EventTypeWarning, "FailedToCreateVirtualService", fmt.Sprintf("Failed to create virtualservice for service %v/%v: %v", namespace, name, err))
-		} else {
-			v.eventRecorder.Event(newVirtualService, v1.EventTypeWarning, "FailedToUpdateVirtualService", fmt.Sprintf("Failed to update virtualservice for service %v/%v: %v", namespace, name, err))
+		log.Error(err, "list managed virtualservices failed", "namespace", namespace)
+		return err
+	}
+
+	for _, vs := range virtualServices {
+		if !util.IsControlledByService(vs, name) || keep.Has(vs.Name) {
+			continue
 		}
 
-		return err
+		err = v.virtualServiceClient.NetworkingV1beta1().VirtualServices(namespace).Delete(context.TODO(), vs.Name, metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			log.Error(err, "delete orphan virtualservice failed", "namespace", namespace, "name", vs.Name)
+			return err
+		}
 	}
 
 	return nil
 }
 
+// isVirtualServiceEnabled tells if virtualservice is generated for service, service must
+// have all the application labels, be enabled in servicemesh, and have ports defined
+func isVirtualServiceEnabled(service *v1.Service) bool {
+	return len(service.Labels) >= len(util.ApplicationLabels) &&
+		util.IsApplicationComponent(service.Labels) &&
+		util.IsServicemeshEnabled(service.Annotations) &&
+		len(service.Spec.Ports) > 0
+}
+
+// servicesSharingApp returns sorted names of services in namespace generating
+// virtualservice named after app
+func (v *VirtualServiceController) servicesSharingApp(namespace, appName string) ([]string, error) {
+	services, err := v.serviceLister.Services(namespace).List(labels.SelectorFromSet(map[string]string{util.AppLabel: appName}))
+	if err != nil {
+		log.Error(err, "list services of app failed", "namespace", namespace, "name", appName)
+		return nil, err
+	}
+
+	names := sets.NewString()
+	for _, service := range services {
+		if isVirtualServiceEnabled(service) {
+			names.Insert(service.Name)
+		}
+	}
+	return names.List(), nil
+}
+
 func (v *VirtualServiceController) enqueueService(obj interface{}) {
-	key, err := cache.MetaNamespaceKeyFunc(obj)
+	// deleted services may come as tombstones
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
 	if err != nil {
 		utilruntime.HandleError(fmt.Errorf("couldn't get key for object %+v: %v", obj, err))
 		return
@@ -420,6 +556,52 @@ func (v *VirtualServiceController) enqueueService(obj interface{}) {
 	v.queue.Add(key)
 }
 
+// enqueueNamespace enqueues all services of namespace, i.e. namespace moved into scope
+func (v *VirtualServiceController) enqueueNamespace(namespace string) {
+	services, err := v.serviceLister.Services(namespace).List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("couldn't list services of namespace %s: %v", namespace, err))
+		return
+	}
+
+	for _, service := range services {
+		v.enqueueService(service)
+	}
+}
+
+// updateService enqueues service, and services sharing its previous application
+// identity if labels are changed, so routes of both identities are rebuilt.
+func (v *VirtualServiceController) updateService(old, cur interface{}) {
+	oldService := old.(*v1.Service)
+	curService := cur.(*v1.Service)
+
+	v.enqueueService(cur)
+
+	if !util.IsApplicationChanged(&oldService.ObjectMeta, &curService.ObjectMeta) {
+		return
+	}
+
+	log.V(2).Info("service application changed", "namespace", curService.Namespace, "name", curService.Name,
+		"old", oldService.Labels, "new", curService.Labels)
+
+	lbs := util.ExtractApplicationLabels(&oldService.ObjectMeta)
+	if len(lbs) == 0 {
+		return
+	}
+
+	services, err := v.serviceLister.Services(oldService.Namespace).List(labels.SelectorFromSet(lbs))
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+
+	for _, service := range services {
+		if service.Name != curService.Name {
+			v.enqueueService(service)
+		}
+	}
+}
+
 func (v *VirtualServiceController) handleErr(err error, key interface{}) {
 	if err == nil {
 		v.queue.Forget(key)
@@ -437,7 +619,8 @@ func (v *VirtualServiceController) handleErr(err error, key interface{}) {
 	utilruntime.HandleError(err)
 }
 
-func (v *VirtualServiceController) getSubsets(strategy *servicemeshv1alpha1.Strategy) sets.String {
+// StrategySubsets returns names of all subsets strategy routes traffic to
+func StrategySubsets(strategy *servicemeshv1alpha1.Strategy) sets.String {
 	set := sets.String{}
 
 	for _, httpRoute := range strategy.Spec.Template.Spec.Http {
@@ -450,6 +633,32 @@ func (v *VirtualServiceController) getSubsets(strategy *servicemeshv1alpha1.Stra
 		}
 	}
 
+	if len(strategy.Spec.Steps) > 0 && len(strategy.Spec.CanaryVersion) > 0 {
+		set.Insert(util.NormalizeVersionName(strategy.Spec.PrincipalVersion))
+		set.Insert(util.NormalizeVersionName(strategy.Spec.CanaryVersion))
+	}
+
+	if isMirror(strategy) {
+		set.Insert(util.NormalizeVersionName(strategy.Spec.Mirror.Version))
+	}
+
+	if isBlueGreen(strategy) {
+		set.Insert(util.NormalizeVersionName(strategy.Spec.PrincipalVersion))
+		set.Insert(util.NormalizeVersionName(strategy.Spec.BlueGreen.PreviewVersion))
+	}
+
+	if isFaultInjection(strategy) {
+		if len(strategy.Spec.FaultInjection.Version) > 0 {
+			set.Insert(util.NormalizeVersionName(strategy.Spec.FaultInjection.Version))
+		} else if len(strategy.Spec.PrincipalVersion) > 0 {
+			set.Insert(util.NormalizeVersionName(strategy.Spec.PrincipalVersion))
+		}
+	}
+
+	for _, segment := range strategy.Spec.Segments {
+		set.Insert(util.NormalizeVersionName(segment.Version))
+	}
+
 	for _, tcpRoute := range strategy.Spec.Template.Spec.Tcp {
 		for _, dw := range tcpRoute.Route {
 			set.Insert(dw.Destination.Subset)
@@ -465,30 +674,202 @@ func (v *VirtualServiceController) getSubsets(strategy *servicemeshv1alpha1.Stra
 	return set
 }
 
-func (v *VirtualServiceController) generateVirtualServiceSpec(strategy *servicemeshv1alpha1.Strategy, service *v1.Service) *networkingv1beta1.VirtualService {
+// StrategyPendingReason tells why strategy is not applied to service at now, given
+// subsets ready to receive traffic. Reason is empty if strategy is applied.
+func StrategyPendingReason(strategy *servicemeshv1alpha1.Strategy, subsets []*networkingv1beta1api.Subset, now time.Time) (string, string) {
+	active, _, err := isStrategyActive(strategy, now)
+	if err != nil {
+		return ReasonInvalidSchedule, err.Error()
+	} else if !active {
+		return ReasonOutOfScheduleWindow, "strategy is out of its schedule window"
+	}
+
+	// faults are removed once the experiment expires
+	if expired, _ := isFaultInjectionExpired(strategy, now); expired {
+		return ReasonFaultInjectionExpired, "fault injection experiment expired"
+	}
+
+	// subsets ready to receive traffic
+	setNames := sets.String{}
+	for i := range subsets {
+		setNames.Insert(subsets[i].Name)
+	}
+
+	switch strategy.Spec.StrategyPolicy {
+	case servicemeshv1alpha1.PolicyPause:
+		return ReasonPaused, "strategy is paused"
+	case servicemeshv1alpha1.PolicyWaitForWorkloadReady:
+		// strategy has subset that are not ready
+		for _, k := range StrategySubsets(strategy).List() {
+			if !setNames.Has(k) {
+				return ReasonWaitingForWorkload, fmt.Sprintf("subset %s is not ready", k)
+			}
+		}
+	}
+
+	// never mirror to a subset not ready, whatever the policy is
+	if isMirror(strategy) {
+		if subset := util.NormalizeVersionName(strategy.Spec.Mirror.Version); !setNames.Has(subset) {
+			return ReasonMirrorNotReady, fmt.Sprintf("mirror subset %s is not ready", subset)
+		}
+	}
+
+	return "", ""
+}
+
+// nextStrategyTransition returns when strategy turns on or off by its schedule,
+// or its fault injection expires, whichever comes first. Zero if never.
+func nextStrategyTransition(strategy *servicemeshv1alpha1.Strategy, now time.Time) time.Time {
+	_, next, _ := isStrategyActive(strategy, now)
+	if _, expireTime := isFaultInjectionExpired(strategy, now); !expireTime.IsZero() && (next.IsZero() || expireTime.Before(next)) {
+		next = expireTime
+	}
+	return next
+}
+
+// GenerateVirtualServiceSpecs generates spec of virtualservice for service, merged from
+// strategies applied in order of priority, and the one of gateways which is nil if no
+// strategy serves traffic from gateways. Ports without any strategy route to the first
+// subset. Conflicts of every strategy are returned in the same order as strategies.
+func GenerateVirtualServiceSpecs(service *v1.Service, subsets []*networkingv1beta1api.Subset,
+	strategies []*servicemeshv1alpha1.Strategy) (*networkingv1beta1api.VirtualService, *networkingv1beta1api.VirtualService, []string) {
+
+	// create a whole new virtualservice, routes all ports to the first subset
+	host := util.ServiceFQDN(service)
+	defaultSpec := &networkingv1beta1api.VirtualService{Hosts: []string{host}}
+
+	destinations := versionDestinations(host, subsets[0].Name)
+	if util.HasHTTPPort(service) {
+		defaultSpec.Http = []*networkingv1beta1api.HTTPRoute{{Route: destinations}}
+	}
+
+	if len(util.GetPortsByProtocol(service, util.PortProtocolTCP)) > 0 {
+		defaultSpec.Tcp = []*networkingv1beta1api.TCPRoute{{Route: tcpDestinations(destinations)}}
+	}
+
+	// tls routes are matched by sni
+	for _, port := range util.GetPortsByProtocol(service, util.PortProtocolTLS) {
+		defaultSpec.Tls = append(defaultSpec.Tls, &networkingv1beta1api.TLSRoute{
+			Match: []*networkingv1beta1api.TLSMatchAttributes{{SniHosts: []string{host}, Port: uint32(port.Port)}},
+			Route: tcpDestinations(destinations),
+		})
+	}
+
+	util.ExpandPortRoutes(defaultSpec, service)
+
+	if len(strategies) == 0 {
+		return defaultSpec, nil, nil
+	}
+
+	specs := make([]*networkingv1beta1api.VirtualService, 0, len(strategies))
+	for _, strategy := range strategies {
+		specs = append(specs, &GenerateVirtualServiceSpec(strategy, service).Spec)
+	}
+
+	spec, conflicts := mergeVirtualServiceSpecs(strategies, specs)
+
+	// routes before bound to ports, used by traffic from gateways
+	gatewaySpec := generateGatewayVirtualServiceSpec(spec, strategies)
+	if gatewaySpec != nil {
+		util.FillDestinationPort(gatewaySpec, service)
+	}
+
+	util.ExpandPortRoutes(spec, service)
+
+	// ports of protocols strategies leave alone are still routed
+	if len(spec.Http) == 0 {
+		spec.Http = defaultSpec.Http
+	}
+	if len(spec.Tcp) == 0 {
+		spec.Tcp = defaultSpec.Tcp
+	}
+	if len(spec.Tls) == 0 {
+		spec.Tls = defaultSpec.Tls
+	}
+
+	// default routes get timeout and retries too, of the strategy with the highest priority
+	for _, strategy := range strategies {
+		applyRequestPolicies(spec, strategy)
+	}
+
+	return spec, gatewaySpec, conflicts
+}
+
+// GenerateVirtualServiceSpec generates virtualservice of a single strategy for service
+func GenerateVirtualServiceSpec(strategy *servicemeshv1alpha1.Strategy, service *v1.Service) *networkingv1beta1.VirtualService {
 
 	// Define VirtualService to be created
 	vs := &networkingv1beta1.VirtualService{
-		Spec: strategy.Spec.Template.Spec,
+		Spec: *strategy.Spec.Template.Spec.DeepCopy(),
+	}
+
+	if len(vs.Spec.Hosts) == 0 {
+		vs.Spec.Hosts = []string{util.ServiceFQDN(service)}
+	}
+
+	// progressive canary, split default routes between principal and canary version
+	if len(strategy.Spec.Steps) > 0 && len(strategy.Spec.CanaryVersion) > 0 {
+		ensureDefaultRoutes(vs, service)
+		setDefaultDestinations(vs, canaryDestinations(util.ServiceFQDN(service),
+			util.NormalizeVersionName(strategy.Spec.PrincipalVersion),
+			util.NormalizeVersionName(strategy.Spec.CanaryVersion),
+			currentCanaryWeight(strategy)))
+	}
+
+	// blue/green, active version takes all traffic
+	if isBlueGreen(strategy) {
+		applyBlueGreen(vs, strategy, service)
+	}
+
+	// user segments are matched in order, ahead of the default route
+	if len(strategy.Spec.Segments) > 0 && util.HasHTTPPort(service) {
+		routes := orderHTTPRoutes(vs.Spec.Http)
+
+		// requests out of any segment go to principal version
+		if !hasDefaultHTTPRoute(routes) && len(strategy.Spec.PrincipalVersion) > 0 {
+			routes = append(routes, &networkingv1beta1api.HTTPRoute{
+				Route: []*networkingv1beta1api.HTTPRouteDestination{
+					{
+						Destination: &networkingv1beta1api.Destination{
+							Host:   util.ServiceFQDN(service),
+							Subset: util.NormalizeVersionName(strategy.Spec.PrincipalVersion),
+						},
+						Weight: 100,
+					},
+				},
+			})
+		}
+
+		vs.Spec.Http = append(segmentRoutes(strategy, util.ServiceFQDN(service)), routes...)
 	}
 
 	// one version rules them all
 	if len(strategy.Spec.GovernorVersion) > 0 {
 		governorDestinationWeight := networkingv1beta1api.HTTPRouteDestination{
 			Destination: &networkingv1beta1api.Destination{
-				Host:   service.Name,
+				Host:   util.ServiceFQDN(service),
 				Subset: strategy.Spec.GovernorVersion,
 			},
 			Weight: 100,
 		}
 
-		if len(strategy.Spec.Template.Spec.Http) > 0 {
+		ensureDefaultRoutes(vs, service)
+
+		if len(vs.Spec.Http) > 0 {
 			governorRoute := networkingv1beta1api.HTTPRoute{
 				Route: []*networkingv1beta1api.HTTPRouteDestination{&governorDestinationWeight},
 			}
 
+			// timeout and retries of routes replaced still apply
+			if route := defaultHTTPRoute(vs.Spec.Http); route != nil {
+				governorRoute.Timeout = route.Timeout
+				governorRoute.Retries = route.Retries
+			}
+
 			vs.Spec.Http = []*networkingv1beta1api.HTTPRoute{&governorRoute}
-		} else if len(strategy.Spec.Template.Spec.Tcp) > 0 {
+		}
+
+		if len(vs.Spec.Tcp) > 0 {
 			tcpRoute := networkingv1beta1api.TCPRoute{
 				Route: []*networkingv1beta1api.RouteDestination{
 					{
@@ -507,7 +888,19 @@ func (v *VirtualServiceController) generateVirtualServiceSpec(strategy *servicem
 
 	}
 
-	util.FillDestinationPort(vs, service)
+	// mirror traffic to another version, segment routes included
+	if isMirror(strategy) {
+		applyMirror(vs, strategy, service)
+	}
+
+	// inject faults into requests until the experiment expires
+	if isFaultInjection(strategy) {
+		applyFaultInjection(vs, strategy, service)
+	}
+
+	// typed timeout and retries cover every http route generated above
+	applyRequestPolicies(&vs.Spec, strategy)
+
 	return vs
 }
 
@@ -534,9 +927,22 @@ func (v *VirtualServiceController) addDestinationRule(obj interface{}) {
 	v.queue.Add(key)
 }
 
-// when a strategy created
+// when a strategy created, changed or deleted, step progression of canaries
+// relies on it, so tombstones of deleted strategies are unwrapped.
 func (v *VirtualServiceController) addStrategy(obj interface{}) {
-	strategy := obj.(*servicemeshv1alpha1.Strategy)
+	strategy, ok := obj.(*servicemeshv1alpha1.Strategy)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("couldn't get object from tombstone %#v", obj))
+			return
+		}
+		strategy, ok = tombstone.Obj.(*servicemeshv1alpha1.Strategy)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("tombstone contained object that is not a strategy %#v", obj))
+			return
+		}
+	}
 
 	lbs := util.ExtractApplicationLabels(&strategy.ObjectMeta)
 	if len(lbs) == 0 {
@@ -0,0 +1,554 @@
+package virtualservice
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	networkingv1beta1 "istio.io/client-go/pkg/apis/networking/v1beta1"
+	istiofake "istio.io/client-go/pkg/clientset/versioned/fake"
+	istioinformers "istio.io/client-go/pkg/informers/externalversions"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	k8sinformers "k8s.io/client-go/informers"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+
+	networkingv1beta1api "istio.io/api/networking/v1beta1"
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+	servicemeshfake "zmc.io/oasis/pkg/client/clientset/versioned/fake"
+	servicemeshinformers "zmc.io/oasis/pkg/client/informers/externalversions"
+	"zmc.io/oasis/pkg/controller/virtualservice/util"
+)
+
+const (
+	testNamespace = "default"
+	testApp       = "reviews"
+)
+
+// newTestController creates a controller writing strategies through a fake client,
+// services are served from indexer.
+func newTestController(services []*v1.Service, objects ...runtime.Object) *VirtualServiceController {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, service := range services {
+		_ = indexer.Add(service)
+	}
+
+	return &VirtualServiceController{
+		servicemeshClient: servicemeshfake.NewSimpleClientset(objects...),
+		eventRecorder:     record.NewFakeRecorder(100),
+		serviceLister:     corelisters.NewServiceLister(indexer),
+		queue:             workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "virtualservice"),
+	}
+}
+
+// fixture is a controller reading objects from informer caches filled by tests,
+// and writing them through fake clients
+type fixture struct {
+	t *testing.T
+
+	controller *VirtualServiceController
+
+	k8sClient         *k8sfake.Clientset
+	istioClient       *istiofake.Clientset
+	servicemeshClient *servicemeshfake.Clientset
+
+	k8sInformers         k8sinformers.SharedInformerFactory
+	istioInformers       istioinformers.SharedInformerFactory
+	servicemeshInformers servicemeshinformers.SharedInformerFactory
+}
+
+func newFixture(t *testing.T, objects ...runtime.Object) *fixture {
+	f := &fixture{t: t}
+
+	var k8sObjects, istioObjects, servicemeshObjects []runtime.Object
+	for _, obj := range objects {
+		switch obj.(type) {
+		case *networkingv1beta1.VirtualService, *networkingv1beta1.DestinationRule:
+			istioObjects = append(istioObjects, obj)
+		case *servicemeshv1alpha1.Strategy:
+			servicemeshObjects = append(servicemeshObjects, obj)
+		default:
+			k8sObjects = append(k8sObjects, obj)
+		}
+	}
+
+	f.k8sClient = k8sfake.NewSimpleClientset(k8sObjects...)
+	f.istioClient = istiofake.NewSimpleClientset(istioObjects...)
+	f.servicemeshClient = servicemeshfake.NewSimpleClientset(servicemeshObjects...)
+
+	f.k8sInformers = k8sinformers.NewSharedInformerFactory(f.k8sClient, 0)
+	f.istioInformers = istioinformers.NewSharedInformerFactory(f.istioClient, 0)
+	f.servicemeshInformers = servicemeshinformers.NewSharedInformerFactory(f.servicemeshClient, 0)
+
+	f.controller = NewVirtualServiceController(f.k8sInformers.Core().V1().Services(),
+		f.istioInformers.Networking().V1beta1().VirtualServices(),
+		f.istioInformers.Networking().V1beta1().DestinationRules(),
+		f.servicemeshInformers.Servicemesh().V1alpha1().Strategies(),
+		f.k8sInformers.Apps().V1().ControllerRevisions(),
+		f.k8sClient,
+		f.istioClient,
+		f.servicemeshClient,
+		nil,
+		nil)
+
+	f.controller.eventBroadcaster.Shutdown()
+	f.controller.eventRecorder = record.NewFakeRecorder(100)
+
+	for _, obj := range objects {
+		f.addToCache(obj)
+	}
+
+	return f
+}
+
+// addToCache puts obj into cache of informer watching it
+func (f *fixture) addToCache(obj runtime.Object) {
+	var informer cache.SharedIndexInformer
+	switch obj.(type) {
+	case *v1.Service:
+		informer = f.k8sInformers.Core().V1().Services().Informer()
+	case *networkingv1beta1.VirtualService:
+		informer = f.istioInformers.Networking().V1beta1().VirtualServices().Informer()
+	case *networkingv1beta1.DestinationRule:
+		informer = f.istioInformers.Networking().V1beta1().DestinationRules().Informer()
+	case *servicemeshv1alpha1.Strategy:
+		informer = f.servicemeshInformers.Servicemesh().V1alpha1().Strategies().Informer()
+	case *appsv1.ControllerRevision:
+		informer = f.k8sInformers.Apps().V1().ControllerRevisions().Informer()
+	default:
+		f.t.Fatalf("no informer watches %T", obj)
+	}
+
+	if err := informer.GetIndexer().Add(obj); err != nil {
+		f.t.Fatalf("add %T to cache failed, %v", obj, err)
+	}
+}
+
+// sync syncs service of name, and returns the virtualservice written
+func (f *fixture) sync(name string) *networkingv1beta1.VirtualService {
+	if err := f.controller.syncService(testNamespace + "/" + name); err != nil {
+		f.t.Fatalf("sync service %s failed, %v", name, err)
+	}
+
+	vs, err := f.istioClient.NetworkingV1beta1().VirtualServices(testNamespace).Get(context.TODO(), testApp, metav1.GetOptions{})
+	if err != nil {
+		f.t.Fatalf("get virtualservice failed, %v", err)
+	}
+	return vs
+}
+
+// strategy returns strategy of name persisted
+func (f *fixture) strategy(name string) *servicemeshv1alpha1.Strategy {
+	strategy, err := f.servicemeshClient.ServicemeshV1alpha1().Strategies(testNamespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		f.t.Fatalf("get strategy %s failed, %v", name, err)
+	}
+	return strategy
+}
+
+func testApplicationLabels() map[string]string {
+	return map[string]string{
+		util.AppLabel:                testApp,
+		util.ApplicationNameLabel:    "bookinfo",
+		util.ApplicationVersionLabel: "v1",
+	}
+}
+
+func newTestService(name string, ports ...v1.ServicePort) *v1.Service {
+	if len(ports) == 0 {
+		ports = []v1.ServicePort{{Name: "http", Protocol: v1.ProtocolTCP, Port: 80}}
+	}
+
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   testNamespace,
+			UID:         types.UID("uid-" + name),
+			Labels:      testApplicationLabels(),
+			Annotations: map[string]string{util.ServiceMeshEnabledAnnotation: "true"},
+		},
+		Spec: v1.ServiceSpec{
+			Selector: map[string]string{util.AppLabel: testApp},
+			Ports:    ports,
+		},
+	}
+}
+
+func newTestDestinationRule(name string, subsets ...string) *networkingv1beta1.DestinationRule {
+	dr := &networkingv1beta1.DestinationRule{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: testNamespace,
+			Labels:    testApplicationLabels(),
+		},
+		Spec: networkingv1beta1api.DestinationRule{Host: name},
+	}
+	for _, subset := range subsets {
+		dr.Spec.Subsets = append(dr.Spec.Subsets, &networkingv1beta1api.Subset{
+			Name:   subset,
+			Labels: map[string]string{util.VersionLabel: subset},
+		})
+	}
+	return dr
+}
+
+func newTestStrategy(name string, spec servicemeshv1alpha1.StrategySpec) *servicemeshv1alpha1.Strategy {
+	return &servicemeshv1alpha1.Strategy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       name,
+			Namespace:  testNamespace,
+			Generation: 1,
+			Labels:     testApplicationLabels(),
+		},
+		Spec: spec,
+	}
+}
+
+func TestAddStrategy(t *testing.T) {
+	strategy := newTestStrategy("canary", servicemeshv1alpha1.StrategySpec{})
+
+	tests := []struct {
+		name string
+		obj  interface{}
+		want int
+	}{
+		{name: "strategy", obj: strategy, want: 1},
+		{name: "tombstone", obj: cache.DeletedFinalStateUnknown{Key: "default/canary", Obj: strategy}, want: 1},
+		{name: "tombstone of another kind", obj: cache.DeletedFinalStateUnknown{Key: "default/canary", Obj: &v1.Pod{}}, want: 0},
+		{name: "unknown object", obj: &v1.Pod{}, want: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v := newTestController([]*v1.Service{newTestService("reviews"), newTestService("reviews-admin")})
+			v.addStrategy(test.obj)
+
+			// services sharing app label are all enqueued
+			want := test.want * 2
+			if got := v.queue.Len(); got != want {
+				t.Errorf("enqueued %d services, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestEnqueueServiceTombstone(t *testing.T) {
+	v := newTestController(nil)
+	v.enqueueService(cache.DeletedFinalStateUnknown{Key: "default/reviews", Obj: newTestService("reviews")})
+
+	key, _ := v.queue.Get()
+	if key != "default/reviews" {
+		t.Errorf("enqueued %v, want default/reviews", key)
+	}
+}
+
+func TestSyncServicePorts(t *testing.T) {
+	service := newTestService("reviews",
+		v1.ServicePort{Name: "http", Port: 80},
+		v1.ServicePort{Name: "grpc-api", Port: 9000},
+		v1.ServicePort{Name: "metrics", Port: 9090},
+		v1.ServicePort{Name: "tls", Port: 443},
+		v1.ServicePort{Name: "dns", Port: 53, Protocol: v1.ProtocolUDP},
+	)
+	host := "reviews.default.svc.cluster.local"
+
+	canary := newTestStrategy("canary", canarySpec())
+	canary.Status = stepStatus(1, 0, 1)
+
+	tests := []struct {
+		name       string
+		strategies []*servicemeshv1alpha1.Strategy
+
+		wantHTTPSubsets []string
+	}{
+		{
+			name:            "no strategy",
+			wantHTTPSubsets: []string{"v1"},
+		},
+		{
+			name:            "canary",
+			strategies:      []*servicemeshv1alpha1.Strategy{canary},
+			wantHTTPSubsets: []string{"v1", "v2"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			objects := []runtime.Object{service, newTestDestinationRule("reviews", "v1", "v2")}
+			for _, strategy := range test.strategies {
+				objects = append(objects, strategy)
+			}
+			spec := newFixture(t, objects...).sync("reviews").Spec
+
+			if !reflect.DeepEqual(spec.Hosts, []string{host}) {
+				t.Errorf("hosts %v, want %s", spec.Hosts, host)
+			}
+
+			// every http port is routed alike
+			httpPorts := make([]uint32, 0)
+			for _, route := range spec.Http {
+				subsets := make([]string, 0)
+				for _, destination := range route.Route {
+					if destination.Destination.Host != host || destination.Destination.Port.Number != route.Match[0].Port {
+						t.Errorf("route %v goes to another host or port", destination)
+					}
+					subsets = append(subsets, destination.Destination.Subset)
+				}
+				if !reflect.DeepEqual(subsets, test.wantHTTPSubsets) {
+					t.Errorf("port %d routes to %v, want %v", route.Match[0].Port, subsets, test.wantHTTPSubsets)
+				}
+				httpPorts = append(httpPorts, route.Match[0].Port)
+			}
+			if !reflect.DeepEqual(httpPorts, []uint32{80, 9000}) {
+				t.Errorf("http ports %v, want [80 9000]", httpPorts)
+			}
+
+			// ports of other protocols route to the first subset
+			if len(spec.Tcp) != 1 || spec.Tcp[0].Route[0].Destination.Port.Number != 9090 || spec.Tcp[0].Route[0].Destination.Subset != "v1" {
+				t.Errorf("tcp routes %v, want port 9090 to v1", spec.Tcp)
+			}
+			if len(spec.Tls) != 1 || spec.Tls[0].Match[0].Port != 443 || !reflect.DeepEqual(spec.Tls[0].Match[0].SniHosts, []string{host}) ||
+				spec.Tls[0].Route[0].Destination.Port.Number != 443 {
+				t.Errorf("tls routes %v, want port 443 matched by sni", spec.Tls)
+			}
+		})
+	}
+}
+
+func TestSyncServiceAdoption(t *testing.T) {
+	service := newTestService("reviews")
+
+	tests := []struct {
+		name    string
+		labels  map[string]string
+		managed bool
+	}{
+		{
+			name:    "generated by earlier releases",
+			labels:  testApplicationLabels(),
+			managed: true,
+		},
+		{
+			name:    "marked but not controlled",
+			labels:  util.ManagedLabels(service),
+			managed: true,
+		},
+		{
+			name:   "created by others",
+			labels: map[string]string{"team": "reviews"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			current := &networkingv1beta1.VirtualService{
+				ObjectMeta: metav1.ObjectMeta{Name: testApp, Namespace: testNamespace, ResourceVersion: "1", Labels: test.labels},
+				Spec:       networkingv1beta1api.VirtualService{Hosts: []string{"reviews"}, Http: []*networkingv1beta1api.HTTPRoute{{Route: versionDestinations("reviews", "v1")}}},
+			}
+			f := newFixture(t, service, newTestDestinationRule("reviews", "v1", "v2"), newTestStrategy("canary", canarySpec()), current)
+
+			vs := f.sync("reviews")
+			if adopted := util.IsManaged(vs) && metav1.IsControlledBy(vs, service); adopted != test.managed {
+				t.Errorf("adopted %v, want %v, labels %v owners %v", adopted, test.managed, vs.Labels, vs.OwnerReferences)
+			}
+			if updated := !reflect.DeepEqual(vs.Spec, current.Spec); updated != test.managed {
+				t.Errorf("updated %v, want %v", updated, test.managed)
+			}
+
+			complete := util.GetStrategyCondition(f.strategy("canary").Status, servicemeshv1alpha1.StrategyComplete)
+			if delivered := complete != nil && complete.Reason == ReasonDelivered; delivered != test.managed {
+				t.Errorf("complete condition %v, want delivered %v", complete, test.managed)
+			}
+		})
+	}
+}
+
+func TestSyncServiceSharedAppLabel(t *testing.T) {
+	owner, other := newTestService("reviews"), newTestService("reviews-v2")
+
+	current := &networkingv1beta1.VirtualService{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            testApp,
+			Namespace:       testNamespace,
+			ResourceVersion: "1",
+			Labels:          util.ManagedLabels(owner),
+			OwnerReferences: []metav1.OwnerReference{*util.NewServiceControllerRef(owner)},
+		},
+	}
+	conflict := "services reviews, reviews-v2 share app label reviews, virtualservice default/reviews routes service reviews only"
+
+	tests := []struct {
+		name      string
+		service   string
+		wantEvent string
+	}{
+		{name: "controlling service", service: "reviews"},
+		{name: "another service", service: "reviews-v2", wantEvent: "Warning VirtualServiceConflict"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t, owner, other, newTestDestinationRule(test.service, "v1", "v2"), newTestStrategy("canary", canarySpec()), current)
+
+			vs := f.sync(test.service)
+			if !metav1.IsControlledBy(vs, owner) {
+				t.Errorf("virtualservice is taken over, owners %v", vs.OwnerReferences)
+			}
+
+			status := f.strategy("canary").Status
+			if complete := util.GetStrategyCondition(status, servicemeshv1alpha1.StrategyComplete); complete == nil || complete.Reason != ReasonDelivered {
+				t.Errorf("complete condition %v, want delivered", complete)
+			}
+			if conflicted := util.GetStrategyCondition(status, servicemeshv1alpha1.StrategyConflicted); conflicted == nil || conflicted.Message != conflict {
+				t.Errorf("conflicted condition %v, want message %q", conflicted, conflict)
+			}
+
+			events := drainEvents(f.controller.eventRecorder)
+			found := false
+			for _, event := range events {
+				found = found || strings.HasPrefix(event, "Warning VirtualServiceConflict")
+			}
+			if found != (len(test.wantEvent) > 0) {
+				t.Errorf("events %v, want conflict event %v", events, len(test.wantEvent) > 0)
+			}
+		})
+	}
+}
+
+// queuedKeys drains keys enqueued so far, in sorted order
+func queuedKeys(queue workqueue.RateLimitingInterface) []string {
+	keys := sets.NewString()
+	for queue.Len() > 0 {
+		key, _ := queue.Get()
+		keys.Insert(key.(string))
+		queue.Done(key)
+	}
+	return keys.List()
+}
+
+func TestUpdateService(t *testing.T) {
+	relabeled := func(app string) *v1.Service {
+		service := newTestService("reviews")
+		service.Labels[util.AppLabel] = app
+		return service
+	}
+	sibling := newTestService("reviews-v2")
+
+	tests := []struct {
+		name string
+		old  *v1.Service
+		cur  *v1.Service
+		want []string
+	}{
+		{
+			name: "application unchanged",
+			old:  newTestService("reviews"),
+			cur:  newTestService("reviews"),
+			want: []string{"default/reviews"},
+		},
+		{
+			name: "app label changed",
+			old:  newTestService("reviews"),
+			cur:  relabeled("ratings"),
+			want: []string{"default/reviews", "default/reviews-v2"},
+		},
+		{
+			name: "app label added",
+			old: func() *v1.Service {
+				service := newTestService("reviews")
+				delete(service.Labels, util.AppLabel)
+				return service
+			}(),
+			cur:  newTestService("reviews"),
+			want: []string{"default/reviews"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t, test.cur, sibling)
+			f.controller.updateService(test.old, test.cur)
+
+			if keys := queuedKeys(f.controller.queue); !reflect.DeepEqual(keys, test.want) {
+				t.Errorf("enqueued %v, want %v", keys, test.want)
+			}
+		})
+	}
+}
+
+func TestSyncServiceRelabeled(t *testing.T) {
+	generated := func(service *v1.Service, name string) *networkingv1beta1.VirtualService {
+		return &networkingv1beta1.VirtualService{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            name,
+				Namespace:       testNamespace,
+				ResourceVersion: "1",
+				Labels:          util.ManagedLabels(service),
+				OwnerReferences: []metav1.OwnerReference{*util.NewServiceControllerRef(service)},
+			},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		service func(service *v1.Service)
+
+		wantKept    []string
+		wantDeleted []string
+	}{
+		{
+			name:        "app label changed",
+			service:     func(service *v1.Service) {},
+			wantKept:    []string{"reviews", "others"},
+			wantDeleted: []string{"ratings", "ratings-gateway"},
+		},
+		{
+			name: "servicemesh disabled",
+			service: func(service *v1.Service) {
+				service.Annotations[util.ServiceMeshEnabledAnnotation] = "false"
+			},
+			wantKept:    []string{"others"},
+			wantDeleted: []string{"reviews", "ratings", "ratings-gateway"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			service := newTestService("reviews")
+			test.service(service)
+
+			// generated when service was labeled app ratings
+			previous := newTestService("reviews")
+			previous.Labels[util.AppLabel] = "ratings"
+
+			others := generated(newTestService("reviews-v2"), "others")
+			f := newFixture(t, service, newTestDestinationRule("reviews", "v1"),
+				generated(previous, "ratings"), generated(previous, "ratings-gateway"), generated(service, "reviews"), others)
+
+			if err := f.controller.syncService(testNamespace + "/reviews"); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			for _, name := range test.wantKept {
+				if _, err := f.istioClient.NetworkingV1beta1().VirtualServices(testNamespace).Get(context.TODO(), name, metav1.GetOptions{}); err != nil {
+					t.Errorf("virtualservice %s is not kept, %v", name, err)
+				}
+			}
+			for _, name := range test.wantDeleted {
+				if _, err := f.istioClient.NetworkingV1beta1().VirtualServices(testNamespace).Get(context.TODO(), name, metav1.GetOptions{}); !errors.IsNotFound(err) {
+					t.Errorf("virtualservice %s is not deleted, %v", name, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package workload
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	appsinformers "k8s.io/client-go/informers/apps/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	DeploymentKind  = "Deployment"
+	StatefulSetKind = "StatefulSet"
+	DaemonSetKind   = "DaemonSet"
+)
+
+func newWorkload(kind string, meta *metav1.ObjectMeta, selector *metav1.LabelSelector, readyReplicas int32) *Workload {
+	w := &Workload{
+		Kind:          kind,
+		Namespace:     meta.Namespace,
+		Name:          meta.Name,
+		Labels:        meta.Labels,
+		Annotations:   meta.Annotations,
+		ReadyReplicas: readyReplicas,
+	}
+
+	if selector != nil {
+		w.Selector = selector.MatchLabels
+	}
+
+	return w
+}
+
+type deploymentSource struct {
+	informer appsinformers.DeploymentInformer
+}
+
+func NewDeploymentSource(informer appsinformers.DeploymentInformer) Source {
+	return &deploymentSource{informer: informer}
+}
+
+func (s *deploymentSource) Kind() string {
+	return DeploymentKind
+}
+
+func (s *deploymentSource) Informer() cache.SharedIndexInformer {
+	return s.informer.Informer()
+}
+
+func (s *deploymentSource) List(namespace string, selector labels.Selector) ([]*Workload, error) {
+	deployments, err := s.informer.Lister().Deployments(namespace).List(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	workloads := make([]*Workload, 0, len(deployments))
+	for _, deployment := range deployments {
+		w, _ := s.Workload(deployment)
+		workloads = append(workloads, w)
+	}
+	return workloads, nil
+}
+
+func (s *deploymentSource) Workload(obj interface{}) (*Workload, bool) {
+	deployment, ok := obj.(*appsv1.Deployment)
+	if !ok {
+		return nil, false
+	}
+	return newWorkload(DeploymentKind, &deployment.ObjectMeta, deployment.Spec.Selector, deployment.Status.ReadyReplicas), true
+}
+
+type statefulSetSource struct {
+	informer appsinformers.StatefulSetInformer
+}
+
+func NewStatefulSetSource(informer appsinformers.StatefulSetInformer) Source {
+	return &statefulSetSource{informer: informer}
+}
+
+func (s *statefulSetSource) Kind() string {
+	return StatefulSetKind
+}
+
+func (s *statefulSetSource) Informer() cache.SharedIndexInformer {
+	return s.informer.Informer()
+}
+
+func (s *statefulSetSource) List(namespace string, selector labels.Selector) ([]*Workload, error) {
+	statefulSets, err := s.informer.Lister().StatefulSets(namespace).List(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	workloads := make([]*Workload, 0, len(statefulSets))
+	for _, statefulSet := range statefulSets {
+		w, _ := s.Workload(statefulSet)
+		workloads = append(workloads, w)
+	}
+	return workloads, nil
+}
+
+func (s *statefulSetSource) Workload(obj interface{}) (*Workload, bool) {
+	statefulSet, ok := obj.(*appsv1.StatefulSet)
+	if !ok {
+		return nil, false
+	}
+	return newWorkload(StatefulSetKind, &statefulSet.ObjectMeta, statefulSet.Spec.Selector, statefulSet.Status.ReadyReplicas), true
+}
+
+type daemonSetSource struct {
+	informer appsinformers.DaemonSetInformer
+}
+
+func NewDaemonSetSource(informer appsinformers.DaemonSetInformer) Source {
+	return &daemonSetSource{informer: informer}
+}
+
+func (s *daemonSetSource) Kind() string {
+	return DaemonSetKind
+}
+
+func (s *daemonSetSource) Informer() cache.SharedIndexInformer {
+	return s.informer.Informer()
+}
+
+func (s *daemonSetSource) List(namespace string, selector labels.Selector) ([]*Workload, error) {
+	daemonSets, err := s.informer.Lister().DaemonSets(namespace).List(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	workloads := make([]*Workload, 0, len(daemonSets))
+	for _, daemonSet := range daemonSets {
+		w, _ := s.Workload(daemonSet)
+		workloads = append(workloads, w)
+	}
+	return workloads, nil
+}
+
+// Workload of daemonset is ready if pods are ready on any node
+func (s *daemonSetSource) Workload(obj interface{}) (*Workload, bool) {
+	daemonSet, ok := obj.(*appsv1.DaemonSet)
+	if !ok {
+		return nil, false
+	}
+	return newWorkload(DaemonSetKind, &daemonSet.ObjectMeta, daemonSet.Spec.Selector, daemonSet.Status.NumberReady), true
+}
@@ -0,0 +1,95 @@
+package workload
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+const RolloutKind = "Rollout"
+
+// RolloutResource is argo rollouts, watched as unstructured objects so oasis
+// doesn't depend on argo types
+var RolloutResource = schema.GroupVersionResource{Group: "argoproj.io", Version: "v1alpha1", Resource: "rollouts"}
+
+type rolloutSource struct {
+	informer informers.GenericInformer
+}
+
+// NewRolloutSource creates source of argo rollouts, rollouts are watched through factory
+func NewRolloutSource(factory dynamicinformer.DynamicSharedInformerFactory) Source {
+	return &rolloutSource{informer: factory.ForResource(RolloutResource)}
+}
+
+// IsRolloutInstalled tells if rollout crd is installed, informers of resources
+// not served never get synced.
+func IsRolloutInstalled(client discovery.DiscoveryInterface) (bool, error) {
+	resourceList, err := client.ServerResourcesForGroupVersion(RolloutResource.GroupVersion().String())
+	if err != nil {
+		// group version not served
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	for _, resource := range resourceList.APIResources {
+		if resource.Name == RolloutResource.Resource {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *rolloutSource) Kind() string {
+	return RolloutKind
+}
+
+func (s *rolloutSource) Informer() cache.SharedIndexInformer {
+	return s.informer.Informer()
+}
+
+func (s *rolloutSource) List(namespace string, selector labels.Selector) ([]*Workload, error) {
+	objects, err := s.informer.Lister().ByNamespace(namespace).List(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	workloads := make([]*Workload, 0, len(objects))
+	for _, obj := range objects {
+		w, ok := s.Workload(obj)
+		if !ok {
+			return nil, fmt.Errorf("unexpected rollout object %#v", obj)
+		}
+		workloads = append(workloads, w)
+	}
+	return workloads, nil
+}
+
+func (s *rolloutSource) Workload(obj interface{}) (*Workload, bool) {
+	rollout, ok := obj.(*unstructured.Unstructured)
+	if !ok || rollout.GetKind() != RolloutKind {
+		return nil, false
+	}
+
+	// malformed fields are taken as absent
+	selector, _, _ := unstructured.NestedStringMap(rollout.Object, "spec", "selector", "matchLabels")
+	readyReplicas, _, _ := unstructured.NestedInt64(rollout.Object, "status", "readyReplicas")
+
+	return &Workload{
+		Kind:          RolloutKind,
+		Namespace:     rollout.GetNamespace(),
+		Name:          rollout.GetName(),
+		Labels:        rollout.GetLabels(),
+		Annotations:   rollout.GetAnnotations(),
+		Selector:      selector,
+		ReadyReplicas: int32(readyReplicas),
+	}, true
+}
@@ -0,0 +1,61 @@
+package workload
+
+import (
+	"k8s.io/apimachinery/pkg/labels"
+	k8sinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Workload is a set of pods running a version of an application component,
+// i.e. a deployment, statefulset, daemonset or rollout.
+type Workload struct {
+	Kind        string
+	Namespace   string
+	Name        string
+	Labels      map[string]string
+	Annotations map[string]string
+
+	// Selector are labels selecting pods of the workload
+	Selector map[string]string
+
+	ReadyReplicas int32
+}
+
+// Source lists and watches workloads of a kind, versions of a service are
+// collected from every source.
+type Source interface {
+	// Kind of workloads of the source
+	Kind() string
+
+	// Informer watches workloads of the source
+	Informer() cache.SharedIndexInformer
+
+	// List lists workloads in namespace labeled with selector
+	List(namespace string, selector labels.Selector) ([]*Workload, error)
+
+	// Workload converts an object watched by informer, it returns false if the object
+	// is not of the kind of the source, tombstones are not unwrapped.
+	Workload(obj interface{}) (*Workload, bool)
+}
+
+// KubernetesSources returns sources of built-in workloads, deployments, statefulsets and daemonsets
+func KubernetesSources(factory k8sinformers.SharedInformerFactory) []Source {
+	return []Source{
+		NewDeploymentSource(factory.Apps().V1().Deployments()),
+		NewStatefulSetSource(factory.Apps().V1().StatefulSets()),
+		NewDaemonSetSource(factory.Apps().V1().DaemonSets()),
+	}
+}
+
+// ListAll lists workloads of all sources in namespace labeled with selector
+func ListAll(sources []Source, namespace string, selector labels.Selector) ([]*Workload, error) {
+	workloads := make([]*Workload, 0)
+	for _, source := range sources {
+		list, err := source.List(namespace, selector)
+		if err != nil {
+			return nil, err
+		}
+		workloads = append(workloads, list...)
+	}
+	return workloads, nil
+}
@@ -0,0 +1,292 @@
+package workload
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const testNamespace = "default"
+
+func testLabels(version string) map[string]string {
+	return map[string]string{"app": "reviews", "version": version}
+}
+
+func testMeta(name, version string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:        name,
+		Namespace:   testNamespace,
+		Labels:      testLabels(version),
+		Annotations: map[string]string{"servicemesh.linkedcare.io/enabled": "true"},
+	}
+}
+
+func newTestRollout(name, version string, readyReplicas int64) *unstructured.Unstructured {
+	meta := testMeta(name, version)
+	rollout := &unstructured.Unstructured{}
+	rollout.SetAPIVersion(RolloutResource.GroupVersion().String())
+	rollout.SetKind(RolloutKind)
+	rollout.SetNamespace(meta.Namespace)
+	rollout.SetName(meta.Name)
+	rollout.SetLabels(meta.Labels)
+	rollout.SetAnnotations(meta.Annotations)
+	_ = unstructured.SetNestedStringMap(rollout.Object, testLabels(version), "spec", "selector", "matchLabels")
+	_ = unstructured.SetNestedField(rollout.Object, readyReplicas, "status", "readyReplicas")
+	return rollout
+}
+
+// newTestSources returns sources of all kinds, deployments, statefulsets, daemonsets and rollouts
+func newTestSources() []Source {
+	factory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	dynamicFactory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()), 0)
+	return append(KubernetesSources(factory), NewRolloutSource(dynamicFactory))
+}
+
+func TestSourceWorkload(t *testing.T) {
+	selector := &metav1.LabelSelector{MatchLabels: testLabels("v1")}
+
+	tests := []struct {
+		name string
+		obj  interface{}
+		want *Workload
+	}{
+		{
+			name: "deployment",
+			obj: &appsv1.Deployment{
+				ObjectMeta: testMeta("reviews-v1", "v1"),
+				Spec:       appsv1.DeploymentSpec{Selector: selector},
+				Status:     appsv1.DeploymentStatus{ReadyReplicas: 2},
+			},
+			want: &Workload{Kind: DeploymentKind, ReadyReplicas: 2},
+		},
+		{
+			name: "statefulset",
+			obj: &appsv1.StatefulSet{
+				ObjectMeta: testMeta("reviews-v1", "v1"),
+				Spec:       appsv1.StatefulSetSpec{Selector: selector},
+				Status:     appsv1.StatefulSetStatus{ReadyReplicas: 3},
+			},
+			want: &Workload{Kind: StatefulSetKind, ReadyReplicas: 3},
+		},
+		{
+			name: "daemonset ready on nodes",
+			obj: &appsv1.DaemonSet{
+				ObjectMeta: testMeta("reviews-v1", "v1"),
+				Spec:       appsv1.DaemonSetSpec{Selector: selector},
+				Status:     appsv1.DaemonSetStatus{NumberReady: 4},
+			},
+			want: &Workload{Kind: DaemonSetKind, ReadyReplicas: 4},
+		},
+		{
+			name: "rollout",
+			obj:  newTestRollout("reviews-v1", "v1", 5),
+			want: &Workload{Kind: RolloutKind, ReadyReplicas: 5},
+		},
+		{
+			name: "rollout without status",
+			obj: func() *unstructured.Unstructured {
+				rollout := newTestRollout("reviews-v1", "v1", 0)
+				unstructured.RemoveNestedField(rollout.Object, "status")
+				return rollout
+			}(),
+			want: &Workload{Kind: RolloutKind},
+		},
+		{
+			name: "unstructured of another kind",
+			obj: func() *unstructured.Unstructured {
+				rollout := newTestRollout("reviews-v1", "v1", 1)
+				rollout.SetKind("Experiment")
+				return rollout
+			}(),
+		},
+		{
+			name: "pod",
+			obj:  &v1.Pod{ObjectMeta: testMeta("reviews-v1", "v1")},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []*Workload
+			for _, source := range newTestSources() {
+				if w, ok := source.Workload(test.obj); ok {
+					if w.Kind != source.Kind() {
+						t.Errorf("workload of kind %s from source of %s", w.Kind, source.Kind())
+					}
+					got = append(got, w)
+				}
+			}
+
+			if test.want == nil {
+				if len(got) > 0 {
+					t.Errorf("unexpected workloads %v", got)
+				}
+				return
+			}
+
+			// exactly one source converts the object
+			if len(got) != 1 {
+				t.Fatalf("got %d workloads, want 1", len(got))
+			}
+			test.want.Namespace = testNamespace
+			test.want.Name = "reviews-v1"
+			test.want.Labels = testLabels("v1")
+			test.want.Annotations = map[string]string{"servicemesh.linkedcare.io/enabled": "true"}
+			test.want.Selector = testLabels("v1")
+			if !reflect.DeepEqual(got[0], test.want) {
+				t.Errorf("workload %+v, want %+v", got[0], test.want)
+			}
+		})
+	}
+}
+
+func TestListAll(t *testing.T) {
+	sources := newTestSources()
+	objects := []interface{}{
+		&appsv1.Deployment{ObjectMeta: testMeta("reviews-v1", "v1")},
+		&appsv1.StatefulSet{ObjectMeta: testMeta("reviews-v2", "v2")},
+		&appsv1.DaemonSet{ObjectMeta: testMeta("reviews-v3", "v3")},
+		newTestRollout("reviews-v4", "v4", 1),
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "ratings-v1", Namespace: testNamespace, Labels: map[string]string{"app": "ratings"}}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "reviews-v1", Namespace: "staging", Labels: testLabels("v1")}},
+	}
+	for _, obj := range objects {
+		for _, source := range sources {
+			if _, ok := source.Workload(obj); ok {
+				if err := source.Informer().GetIndexer().Add(obj); err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+			}
+		}
+	}
+
+	tests := []struct {
+		name      string
+		namespace string
+		selector  labels.Selector
+		want      []string
+	}{
+		{
+			name:      "workloads of all kinds",
+			namespace: testNamespace,
+			selector:  labels.SelectorFromSet(map[string]string{"app": "reviews"}),
+			want:      []string{"DaemonSet/reviews-v3", "Deployment/reviews-v1", "Rollout/reviews-v4", "StatefulSet/reviews-v2"},
+		},
+		{
+			name:      "selected version",
+			namespace: testNamespace,
+			selector:  labels.SelectorFromSet(testLabels("v4")),
+			want:      []string{"Rollout/reviews-v4"},
+		},
+		{
+			name:      "another namespace",
+			namespace: "staging",
+			selector:  labels.SelectorFromSet(map[string]string{"app": "reviews"}),
+			want:      []string{"Deployment/reviews-v1"},
+		},
+		{
+			name:      "nothing selected",
+			namespace: testNamespace,
+			selector:  labels.SelectorFromSet(map[string]string{"app": "details"}),
+			want:      []string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			workloads, err := ListAll(sources, test.namespace, test.selector)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			got := make([]string, 0, len(workloads))
+			for _, w := range workloads {
+				got = append(got, w.Kind+"/"+w.Name)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("workloads %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+// failingDiscovery fails discovering resources of any group version with err
+type failingDiscovery struct {
+	*fakediscovery.FakeDiscovery
+	err error
+}
+
+func (d *failingDiscovery) ServerResourcesForGroupVersion(_ string) (*metav1.APIResourceList, error) {
+	return nil, d.err
+}
+
+func TestIsRolloutInstalled(t *testing.T) {
+	tests := []struct {
+		name      string
+		resources []*metav1.APIResourceList
+		err       error
+		want      bool
+		wantErr   bool
+	}{
+		{
+			name: "installed",
+			resources: []*metav1.APIResourceList{{
+				GroupVersion: RolloutResource.GroupVersion().String(),
+				APIResources: []metav1.APIResource{{Name: "analysisruns"}, {Name: RolloutResource.Resource}},
+			}},
+			want: true,
+		},
+		{
+			name: "group served without rollouts",
+			resources: []*metav1.APIResourceList{{
+				GroupVersion: RolloutResource.GroupVersion().String(),
+				APIResources: []metav1.APIResource{{Name: "workflows"}},
+			}},
+		},
+		{
+			name: "group not served",
+			err:  apierrors.NewNotFound(schema.GroupResource{Group: RolloutResource.Group}, RolloutResource.Version),
+		},
+		{
+			name:    "apiserver unreachable",
+			err:     errors.New("connection refused"),
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fakeDiscovery := &fakediscovery.FakeDiscovery{Fake: &k8stesting.Fake{Resources: test.resources}}
+			var client discovery.DiscoveryInterface = fakeDiscovery
+			if test.err != nil {
+				client = &failingDiscovery{FakeDiscovery: fakeDiscovery, err: test.err}
+			}
+
+			got, err := IsRolloutInstalled(client)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("error %v, want error %v", err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("installed %v, want %v", got, test.want)
+			}
+		})
+	}
+}
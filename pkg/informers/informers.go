@@ -4,6 +4,8 @@ import (
 	"time"
 
 	istioinformers "istio.io/client-go/pkg/informers/externalversions"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
 	k8sinformers "k8s.io/client-go/informers"
 	msinformers "zmc.io/oasis/pkg/client/informers/externalversions"
 
@@ -50,6 +52,12 @@ func NewInformerFactories(client k8sclient.Interface, msClient meshclient.Interf
 	return factory
 }
 
+// NewDynamicInformerFactory creates informer factory of resources oasis has no typed client for,
+// it is started by the caller.
+func NewDynamicInformerFactory(client dynamic.Interface) dynamicinformer.DynamicSharedInformerFactory {
+	return dynamicinformer.NewDynamicSharedInformerFactory(client, defaultResync)
+}
+
 func (f *informerFactories) KubernetesSharedInformerFactory() k8sinformers.SharedInformerFactory {
 	return f.informerFactory
 }
@@ -0,0 +1,161 @@
+package v1alpha1
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/emicklei/go-restful"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	"zmc.io/oasis/pkg/api"
+	meshclient "zmc.io/oasis/pkg/client/clientset/versioned"
+	"zmc.io/oasis/pkg/controller/workload"
+	"zmc.io/oasis/pkg/informers"
+	"zmc.io/oasis/pkg/models/servicemesh/preview"
+	"zmc.io/oasis/pkg/models/servicemesh/strategy"
+)
+
+type handler struct {
+	blueGreenOperator strategy.BlueGreenOperator
+	revisionOperator  strategy.RevisionOperator
+	previewOperator   preview.Operator
+}
+
+func newHandler(client meshclient.Interface, informerFactory informers.InformerFactory,
+	workloadSources []workload.Source, subsetGracePeriod time.Duration) *handler {
+	k8sInformers := informerFactory.KubernetesSharedInformerFactory()
+	meshInformers := informerFactory.MeshSharedInformerFactory()
+	istioInformers := informerFactory.IstioSharedInformerFactory()
+
+	return &handler{
+		blueGreenOperator: strategy.NewBlueGreenOperator(client),
+		revisionOperator:  strategy.NewRevisionOperator(client, k8sInformers.Apps().V1().ControllerRevisions().Lister()),
+		previewOperator: preview.NewOperator(k8sInformers.Core().V1().Services().Lister(),
+			workloadSources,
+			meshInformers.Servicemesh().V1alpha1().Strategies().Lister(),
+			meshInformers.Servicemesh().V1alpha1().ServicePolicies().Lister(),
+			istioInformers.Networking().V1beta1().VirtualServices().Lister(),
+			istioInformers.Networking().V1beta1().DestinationRules().Lister(),
+			subsetGracePeriod),
+	}
+}
+
+func (h *handler) handlePromoteStrategy(request *restful.Request, response *restful.Response) {
+	namespace := request.PathParameter("namespace")
+	name := request.PathParameter("strategy")
+
+	result, err := h.blueGreenOperator.Promote(namespace, name)
+	if err != nil {
+		handleError(response, request, err)
+		return
+	}
+
+	response.WriteEntity(result)
+}
+
+func (h *handler) handleAbortStrategy(request *restful.Request, response *restful.Response) {
+	namespace := request.PathParameter("namespace")
+	name := request.PathParameter("strategy")
+
+	result, err := h.blueGreenOperator.Abort(namespace, name)
+	if err != nil {
+		handleError(response, request, err)
+		return
+	}
+
+	response.WriteEntity(result)
+}
+
+func (h *handler) handleListStrategyRevisions(request *restful.Request, response *restful.Response) {
+	namespace := request.PathParameter("namespace")
+	name := request.PathParameter("strategy")
+
+	result, err := h.revisionOperator.ListRevisions(namespace, name)
+	if err != nil {
+		handleError(response, request, err)
+		return
+	}
+
+	response.WriteEntity(result)
+}
+
+func (h *handler) handleDiffStrategyRevisions(request *restful.Request, response *restful.Response) {
+	namespace := request.PathParameter("namespace")
+	name := request.PathParameter("strategy")
+
+	from, err := strconv.ParseInt(request.PathParameter("revision"), 10, 64)
+	if err != nil {
+		api.HandleBadRequest(response, request, fmt.Errorf("invalid revision, %v", err))
+		return
+	}
+
+	to := int64(0)
+	if param := request.QueryParameter("to"); len(param) > 0 {
+		to, err = strconv.ParseInt(param, 10, 64)
+		if err != nil {
+			api.HandleBadRequest(response, request, fmt.Errorf("invalid revision to diff with, %v", err))
+			return
+		}
+	}
+
+	result, err := h.revisionOperator.DiffRevisions(namespace, name, from, to)
+	if err != nil {
+		handleError(response, request, err)
+		return
+	}
+
+	response.WriteEntity(result)
+}
+
+func (h *handler) handleRollbackStrategy(request *restful.Request, response *restful.Response) {
+	namespace := request.PathParameter("namespace")
+	name := request.PathParameter("strategy")
+
+	revision, err := strconv.ParseInt(request.PathParameter("revision"), 10, 64)
+	if err != nil {
+		api.HandleBadRequest(response, request, fmt.Errorf("invalid revision, %v", err))
+		return
+	}
+
+	result, err := h.revisionOperator.Rollback(namespace, name, revision)
+	if err != nil {
+		handleError(response, request, err)
+		return
+	}
+
+	response.WriteEntity(result)
+}
+
+func (h *handler) handlePreviewService(request *restful.Request, response *restful.Response) {
+	namespace := request.PathParameter("namespace")
+	name := request.PathParameter("service")
+
+	var proposal preview.Request
+	if err := request.ReadEntity(&proposal); err != nil {
+		api.HandleBadRequest(response, request, err)
+		return
+	}
+
+	result, err := h.previewOperator.Preview(namespace, name, &proposal)
+	if err != nil {
+		handleError(response, request, err)
+		return
+	}
+
+	response.WriteEntity(result)
+}
+
+func handleError(response *restful.Response, request *restful.Request, err error) {
+	switch {
+	case apierrors.IsNotFound(err), errors.Is(err, strategy.ErrRevisionNotFound):
+		api.HandleNotFound(response, request, err)
+	case errors.Is(err, strategy.ErrNotBlueGreen), errors.Is(err, preview.ErrNotInMesh), errors.Is(err, preview.ErrInvalid):
+		api.HandleBadRequest(response, request, err)
+	case errors.Is(err, strategy.ErrNotPreviewed), apierrors.IsConflict(err):
+		api.HandleConflict(response, request, err)
+	default:
+		api.HandleInternalError(response, request, err)
+	}
+}
@@ -0,0 +1,92 @@
+package v1alpha1
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/emicklei/go-restful"
+	restfulspec "github.com/emicklei/go-restful-openapi"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"zmc.io/oasis/pkg/api"
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+	"zmc.io/oasis/pkg/apiserver/runtime"
+	meshclient "zmc.io/oasis/pkg/client/clientset/versioned"
+	"zmc.io/oasis/pkg/controller/workload"
+	"zmc.io/oasis/pkg/informers"
+	"zmc.io/oasis/pkg/models/servicemesh/preview"
+	"zmc.io/oasis/pkg/models/servicemesh/strategy"
+)
+
+const (
+	GroupName = "servicemesh"
+
+	tagStrategy = "Strategy"
+	tagService  = "Service"
+)
+
+var GroupVersion = schema.GroupVersion{Group: GroupName, Version: "v1alpha1"}
+
+// AddToContainer installs servicemesh apis, versions of services are collected from workloadSources,
+// subsets are held in previewed destinationrules for subsetGracePeriod, the same as controllers do
+func AddToContainer(c *restful.Container, client meshclient.Interface, informerFactory informers.InformerFactory,
+	workloadSources []workload.Source, subsetGracePeriod time.Duration) error {
+	webservice := runtime.NewWebService(GroupVersion)
+	handler := newHandler(client, informerFactory, workloadSources, subsetGracePeriod)
+
+	webservice.Route(webservice.POST("/namespaces/{namespace}/strategies/{strategy}/promote").
+		To(handler.handlePromoteStrategy).
+		Metadata(restfulspec.KeyOpenAPITags, []string{tagStrategy}).
+		Doc("Promote preview version of a blue/green strategy, all traffic is routed to it.").
+		Param(webservice.PathParameter("namespace", "the name of the namespace")).
+		Param(webservice.PathParameter("strategy", "the name of the strategy")).
+		Returns(http.StatusOK, api.StatusOK, servicemeshv1alpha1.Strategy{}))
+
+	webservice.Route(webservice.POST("/namespaces/{namespace}/strategies/{strategy}/abort").
+		To(handler.handleAbortStrategy).
+		Metadata(restfulspec.KeyOpenAPITags, []string{tagStrategy}).
+		Doc("Abort a blue/green strategy, all traffic is reverted to principal version.").
+		Param(webservice.PathParameter("namespace", "the name of the namespace")).
+		Param(webservice.PathParameter("strategy", "the name of the strategy")).
+		Returns(http.StatusOK, api.StatusOK, servicemeshv1alpha1.Strategy{}))
+
+	webservice.Route(webservice.GET("/namespaces/{namespace}/strategies/{strategy}/revisions").
+		To(handler.handleListStrategyRevisions).
+		Metadata(restfulspec.KeyOpenAPITags, []string{tagStrategy}).
+		Doc("List revisions of a strategy, from the latest to the oldest.").
+		Param(webservice.PathParameter("namespace", "the name of the namespace")).
+		Param(webservice.PathParameter("strategy", "the name of the strategy")).
+		Returns(http.StatusOK, api.StatusOK, []strategy.Revision{}))
+
+	webservice.Route(webservice.GET("/namespaces/{namespace}/strategies/{strategy}/revisions/{revision}/diff").
+		To(handler.handleDiffStrategyRevisions).
+		Metadata(restfulspec.KeyOpenAPITags, []string{tagStrategy}).
+		Doc("Show differences between a revision and another one, or current spec of the strategy.").
+		Param(webservice.PathParameter("namespace", "the name of the namespace")).
+		Param(webservice.PathParameter("strategy", "the name of the strategy")).
+		Param(webservice.PathParameter("revision", "the revision to diff from")).
+		Param(webservice.QueryParameter("to", "the revision to diff to, current spec of the strategy if not specified").Required(false)).
+		Returns(http.StatusOK, api.StatusOK, strategy.RevisionDiff{}))
+
+	webservice.Route(webservice.POST("/namespaces/{namespace}/strategies/{strategy}/revisions/{revision}/rollback").
+		To(handler.handleRollbackStrategy).
+		Metadata(restfulspec.KeyOpenAPITags, []string{tagStrategy}).
+		Doc("Rollback spec of a strategy to the revision.").
+		Param(webservice.PathParameter("namespace", "the name of the namespace")).
+		Param(webservice.PathParameter("strategy", "the name of the strategy")).
+		Param(webservice.PathParameter("revision", "the revision to rollback to")).
+		Returns(http.StatusOK, api.StatusOK, servicemeshv1alpha1.Strategy{}))
+
+	webservice.Route(webservice.POST("/namespaces/{namespace}/services/{service}/preview").
+		To(handler.handlePreviewService).
+		Metadata(restfulspec.KeyOpenAPITags, []string{tagService}).
+		Doc("Preview virtualservices and destinationrule generated for a service with the proposed strategy and servicepolicy, and differences against live ones. Nothing is written.").
+		Param(webservice.PathParameter("namespace", "the name of the namespace")).
+		Param(webservice.PathParameter("service", "the name of the service")).
+		Reads(preview.Request{}).
+		Returns(http.StatusOK, api.StatusOK, preview.Preview{}))
+
+	c.Add(webservice)
+
+	return nil
+}
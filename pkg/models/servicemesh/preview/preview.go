@@ -0,0 +1,364 @@
+package preview
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	networkingv1beta1api "istio.io/api/networking/v1beta1"
+	networkingv1beta1 "istio.io/client-go/pkg/apis/networking/v1beta1"
+	istiolisters "istio.io/client-go/pkg/listers/networking/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/diff"
+	corelisters "k8s.io/client-go/listers/core/v1"
+
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+	servicemeshlisters "zmc.io/oasis/pkg/client/listers/servicemesh/v1alpha1"
+	"zmc.io/oasis/pkg/controller/destinationrule"
+	"zmc.io/oasis/pkg/controller/virtualservice"
+	"zmc.io/oasis/pkg/controller/virtualservice/util"
+	"zmc.io/oasis/pkg/controller/workload"
+	"zmc.io/oasis/pkg/webhook/servicemesh"
+)
+
+var (
+	// ErrNotInMesh is returned when service is not part of servicemesh
+	ErrNotInMesh = errors.New("service is not part of servicemesh")
+
+	// ErrInvalid is returned when proposed strategy or servicepolicy is invalid
+	ErrInvalid = errors.New("invalid proposal")
+)
+
+// Request carries a strategy and a servicepolicy proposed for service, both optional.
+// They replace the ones of the same name, or are added to the ones applied to service.
+type Request struct {
+	Strategy *servicemeshv1alpha1.Strategy `json:"strategy,omitempty"`
+
+	ServicePolicy *servicemeshv1alpha1.ServicePolicy `json:"servicePolicy,omitempty"`
+}
+
+// Preview shows istio objects generated for service as if proposals were applied,
+// and differences against live objects. Objects are nil if they would not exist.
+type Preview struct {
+	VirtualService *networkingv1beta1.VirtualService `json:"virtualService,omitempty"`
+
+	// Human readable differences against live virtualservice, empty if the same
+	VirtualServiceDiff string `json:"virtualServiceDiff,omitempty"`
+
+	GatewayVirtualService *networkingv1beta1.VirtualService `json:"gatewayVirtualService,omitempty"`
+
+	// Human readable differences against live gateway virtualservice, empty if the same
+	GatewayVirtualServiceDiff string `json:"gatewayVirtualServiceDiff,omitempty"`
+
+	DestinationRule *networkingv1beta1.DestinationRule `json:"destinationRule,omitempty"`
+
+	// Human readable differences against live destinationrule, empty if the same
+	DestinationRuleDiff string `json:"destinationRuleDiff,omitempty"`
+
+	// Subsets kept in destinationrule though their workloads are not ready
+	HeldSubsets []servicemeshv1alpha1.HeldSubset `json:"heldSubsets,omitempty"`
+
+	// Strategies applied to service in order of priority
+	Strategies []Delivery `json:"strategies"`
+
+	// Servicepolicies applied to service in order of priority
+	ServicePolicies []Delivery `json:"servicePolicies"`
+}
+
+// Delivery tells how a strategy or servicepolicy would be delivered
+type Delivery struct {
+	Name string `json:"name"`
+
+	// Reason and message why it is not delivered, empty if delivered
+	PendingReason  string `json:"pendingReason,omitempty"`
+	PendingMessage string `json:"pendingMessage,omitempty"`
+
+	// Part of it overridden by others with higher priority
+	Conflict string `json:"conflict,omitempty"`
+}
+
+// Operator previews istio objects generated for services
+type Operator interface {
+	// Preview generates istio objects of service with proposals applied, nothing is written
+	Preview(namespace, name string, request *Request) (*Preview, error)
+}
+
+type operator struct {
+	serviceLister         corelisters.ServiceLister
+	workloadSources       []workload.Source
+	strategyLister        servicemeshlisters.StrategyLister
+	servicePolicyLister   servicemeshlisters.ServicePolicyLister
+	virtualServiceLister  istiolisters.VirtualServiceLister
+	destinationRuleLister istiolisters.DestinationRuleLister
+	subsetGracePeriod     time.Duration
+}
+
+func NewOperator(serviceLister corelisters.ServiceLister,
+	workloadSources []workload.Source,
+	strategyLister servicemeshlisters.StrategyLister,
+	servicePolicyLister servicemeshlisters.ServicePolicyLister,
+	virtualServiceLister istiolisters.VirtualServiceLister,
+	destinationRuleLister istiolisters.DestinationRuleLister,
+	subsetGracePeriod time.Duration) Operator {
+	return &operator{
+		serviceLister:         serviceLister,
+		workloadSources:       workloadSources,
+		strategyLister:        strategyLister,
+		servicePolicyLister:   servicePolicyLister,
+		virtualServiceLister:  virtualServiceLister,
+		destinationRuleLister: destinationRuleLister,
+		subsetGracePeriod:     subsetGracePeriod,
+	}
+}
+
+func (o *operator) Preview(namespace, name string, request *Request) (*Preview, error) {
+	service, err := o.serviceLister.Services(namespace).Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if !util.IsApplicationComponent(service.Labels) ||
+		!util.IsServicemeshEnabled(service.Annotations) ||
+		len(service.Spec.Ports) == 0 {
+		return nil, fmt.Errorf("%w: %s/%s", ErrNotInMesh, namespace, name)
+	}
+
+	appName := util.GetComponentName(&service.ObjectMeta)
+	preview := &Preview{}
+
+	// destinationrule comes first, virtualservice routes to its subsets
+	servicePolicies, err := o.servicePolicyLister.ServicePolicies(namespace).List(labels.SelectorFromSet(map[string]string{util.AppLabel: appName}))
+	if err != nil {
+		return nil, err
+	}
+
+	if request.ServicePolicy != nil {
+		sp := request.ServicePolicy.DeepCopy()
+		sp.Namespace = namespace
+		if errs := servicemesh.ValidateServicePolicy(sp); len(errs) > 0 {
+			return nil, fmt.Errorf("%w: servicepolicy %s, %v", ErrInvalid, sp.Name, errs.ToAggregate())
+		}
+		if util.GetComponentName(&sp.ObjectMeta) != appName {
+			return nil, fmt.Errorf("%w: servicepolicy %s is not applied to service %s", ErrInvalid, sp.Name, name)
+		}
+		servicePolicies = replaceServicePolicy(servicePolicies, sp)
+	}
+
+	// strategies hold subsets in destinationrule, so proposals are applied before it is generated
+	strategies, err := o.strategyLister.Strategies(namespace).List(labels.SelectorFromSet(map[string]string{util.AppLabel: appName}))
+	if err != nil {
+		return nil, err
+	}
+
+	if request.Strategy != nil {
+		strategy := request.Strategy.DeepCopy()
+		strategy.Namespace = namespace
+		servicemesh.DefaultStrategy(strategy)
+		if errs := servicemesh.ValidateStrategy(strategy); len(errs) > 0 {
+			return nil, fmt.Errorf("%w: strategy %s, %v", ErrInvalid, strategy.Name, errs.ToAggregate())
+		}
+		if util.GetComponentName(&strategy.ObjectMeta) != appName {
+			return nil, fmt.Errorf("%w: strategy %s is not applied to service %s", ErrInvalid, strategy.Name, name)
+		}
+		strategies = replaceStrategy(strategies, strategy)
+	}
+
+	workloads, err := workload.ListAll(o.workloadSources, namespace, labels.Set(service.Spec.Selector).AsSelectorPreValidated())
+	if err != nil {
+		return nil, err
+	}
+
+	destinationrule.SortServicePolicies(servicePolicies)
+	drSpec, policyConflicts := destinationrule.GenerateDestinationRuleSpec(service, workloads, servicePolicies)
+	for i, sp := range servicePolicies {
+		preview.ServicePolicies = append(preview.ServicePolicies, Delivery{Name: sp.Name, Conflict: policyConflicts[i]})
+	}
+
+	liveDestinationRule, err := o.destinationRuleLister.DestinationRules(namespace).Get(name)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	dr := &networkingv1beta1.DestinationRule{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+	if liveDestinationRule != nil {
+		dr = liveDestinationRule.DeepCopy()
+	}
+
+	// subsets are held the same way the controller does
+	now := time.Now()
+	var unreadySubsets string
+	preview.HeldSubsets, unreadySubsets, _ = destinationrule.RetainSubsets(drSpec, dr, strategies, o.subsetGracePeriod, now)
+
+	dr.Labels = util.ManagedLabels(service)
+	util.SetServiceControllerRef(dr, service)
+	if dr.Annotations == nil {
+		dr.Annotations = make(map[string]string)
+	}
+	if len(unreadySubsets) > 0 {
+		dr.Annotations[util.UnreadySubsetsAnnotation] = unreadySubsets
+	} else {
+		delete(dr.Annotations, util.UnreadySubsetsAnnotation)
+	}
+	dr.Spec.Host = drSpec.Host
+	dr.Spec.TrafficPolicy = drSpec.TrafficPolicy
+	dr.Spec.Subsets = drSpec.Subsets
+	preview.DestinationRule = dr
+
+	if preview.DestinationRuleDiff, err = diffObjects(liveDestinationRule, dr); err != nil {
+		return nil, err
+	}
+
+	// then virtualservices
+	virtualservice.SortStrategies(strategies)
+
+	liveVirtualService, err := o.getVirtualService(namespace, appName)
+	if err != nil {
+		return nil, err
+	}
+	liveGatewayVirtualService, err := o.getVirtualService(namespace, virtualservice.GatewayVirtualServiceName(appName))
+	if err != nil {
+		return nil, err
+	}
+
+	// no virtualservice is generated until workloads are ready
+	if len(drSpec.Subsets) == 0 {
+		for _, strategy := range strategies {
+			preview.Strategies = append(preview.Strategies, Delivery{Name: strategy.Name,
+				PendingReason: virtualservice.ReasonWaitingForWorkload, PendingMessage: "service has no ready subsets"})
+		}
+		return preview, nil
+	}
+
+	applied := make([]*servicemeshv1alpha1.Strategy, 0, len(strategies))
+	appliedIndexes := make([]int, 0, len(strategies))
+	preview.Strategies = make([]Delivery, len(strategies))
+	for i, strategy := range strategies {
+		preview.Strategies[i].Name = strategy.Name
+		preview.Strategies[i].PendingReason, preview.Strategies[i].PendingMessage = virtualservice.StrategyPendingReason(strategy, drSpec.Subsets, now)
+		if len(preview.Strategies[i].PendingReason) == 0 {
+			applied = append(applied, strategy)
+			appliedIndexes = append(appliedIndexes, i)
+		}
+	}
+
+	spec, gatewaySpec, conflicts := virtualservice.GenerateVirtualServiceSpecs(service, drSpec.Subsets, applied)
+	for i, index := range appliedIndexes {
+		preview.Strategies[index].Conflict = conflicts[i]
+	}
+
+	preview.VirtualService = generatedVirtualService(liveVirtualService, service, appName, spec)
+	if preview.VirtualServiceDiff, err = diffObjects(liveVirtualService, preview.VirtualService); err != nil {
+		return nil, err
+	}
+
+	if gatewaySpec != nil {
+		preview.GatewayVirtualService = generatedVirtualService(liveGatewayVirtualService, service, virtualservice.GatewayVirtualServiceName(appName), gatewaySpec)
+	}
+	if preview.GatewayVirtualServiceDiff, err = diffObjects(liveGatewayVirtualService, preview.GatewayVirtualService); err != nil {
+		return nil, err
+	}
+
+	return preview, nil
+}
+
+func (o *operator) getVirtualService(namespace, name string) (*networkingv1beta1.VirtualService, error) {
+	vs, err := o.virtualServiceLister.VirtualServices(namespace).Get(name)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	return vs, err
+}
+
+// generatedVirtualService returns virtualservice of name the controller writes for service
+func generatedVirtualService(live *networkingv1beta1.VirtualService, service *v1.Service, name string, spec *networkingv1beta1api.VirtualService) *networkingv1beta1.VirtualService {
+	vs := &networkingv1beta1.VirtualService{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: service.Namespace}}
+	if live != nil {
+		vs = live.DeepCopy()
+	}
+
+	vs.Labels = util.ManagedLabels(service)
+	util.SetServiceControllerRef(vs, service)
+	vs.Spec = *spec
+
+	return vs
+}
+
+func replaceStrategy(strategies []*servicemeshv1alpha1.Strategy, strategy *servicemeshv1alpha1.Strategy) []*servicemeshv1alpha1.Strategy {
+	result := make([]*servicemeshv1alpha1.Strategy, 0, len(strategies)+1)
+	for _, s := range strategies {
+		if s.Name != strategy.Name {
+			result = append(result, s)
+		} else if equality.Semantic.DeepEqual(s.Spec, strategy.Spec) {
+			// proposal starts from where the live one is
+			strategy.Status = *s.Status.DeepCopy()
+		} else {
+			// a changed spec starts canary over, as the controller does once it observes it
+			strategy.Status = *s.Status.DeepCopy()
+			strategy.Status.CurrentStep = nil
+			strategy.Status.CurrentStepTime = nil
+			strategy.Status.ObservedGeneration = 0
+			util.RemoveStrategyCondition(&strategy.Status, servicemeshv1alpha1.StrategyFailed)
+		}
+	}
+	return append(result, strategy)
+}
+
+func replaceServicePolicy(servicePolicies []*servicemeshv1alpha1.ServicePolicy, sp *servicemeshv1alpha1.ServicePolicy) []*servicemeshv1alpha1.ServicePolicy {
+	result := make([]*servicemeshv1alpha1.ServicePolicy, 0, len(servicePolicies)+1)
+	for _, s := range servicePolicies {
+		if s.Name != sp.Name {
+			result = append(result, s)
+		}
+	}
+	return append(result, sp)
+}
+
+// diffObjects compares labels and specs of live and generated objects as plain json
+// objects, so only meaningful fields show up. Either may be nil if it doesn't exist.
+func diffObjects(live, generated interface{}) (string, error) {
+	liveObj, err := toUnstructured(live)
+	if err != nil {
+		return "", err
+	}
+	generatedObj, err := toUnstructured(generated)
+	if err != nil {
+		return "", err
+	}
+
+	if reflect.DeepEqual(liveObj, generatedObj) {
+		return "", nil
+	}
+	return diff.ObjectReflectDiff(liveObj, generatedObj), nil
+}
+
+func toUnstructured(obj interface{}) (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	if obj == nil || reflect.ValueOf(obj).IsNil() {
+		return result, nil
+	}
+
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	full := make(map[string]interface{})
+	if err = json.Unmarshal(data, &full); err != nil {
+		return nil, err
+	}
+
+	if metadata, ok := full["metadata"].(map[string]interface{}); ok && metadata["labels"] != nil {
+		result["labels"] = metadata["labels"]
+	}
+	if spec, ok := full["spec"]; ok {
+		result["spec"] = spec
+	}
+
+	return result, nil
+}
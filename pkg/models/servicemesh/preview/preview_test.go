@@ -0,0 +1,444 @@
+package preview
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	networkingv1beta1api "istio.io/api/networking/v1beta1"
+	networkingv1beta1 "istio.io/client-go/pkg/apis/networking/v1beta1"
+	istiolisters "istio.io/client-go/pkg/listers/networking/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/informers"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+	servicemeshlisters "zmc.io/oasis/pkg/client/listers/servicemesh/v1alpha1"
+	"zmc.io/oasis/pkg/controller/virtualservice"
+	"zmc.io/oasis/pkg/controller/virtualservice/util"
+	"zmc.io/oasis/pkg/controller/workload"
+	oasisinformers "zmc.io/oasis/pkg/informers"
+)
+
+const (
+	testNamespace = "default"
+	testApp       = "reviews"
+)
+
+func testApplicationLabels() map[string]string {
+	return map[string]string{
+		util.AppLabel:                testApp,
+		util.ApplicationNameLabel:    "bookinfo",
+		util.ApplicationVersionLabel: "v1",
+	}
+}
+
+func versionLabels(version string) map[string]string {
+	labels := testApplicationLabels()
+	labels[util.VersionLabel] = version
+	return labels
+}
+
+func newTestService() *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        testApp,
+			Namespace:   testNamespace,
+			UID:         "uid-" + testApp,
+			Labels:      testApplicationLabels(),
+			Annotations: map[string]string{util.ServiceMeshEnabledAnnotation: "true"},
+		},
+		Spec: v1.ServiceSpec{
+			Selector: map[string]string{util.AppLabel: testApp},
+			Ports:    []v1.ServicePort{{Name: "http", Port: 80}},
+		},
+	}
+}
+
+func newTestDeployment(version string) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        testApp + "-" + version,
+			Namespace:   testNamespace,
+			Labels:      versionLabels(version),
+			Annotations: map[string]string{util.ServiceMeshEnabledAnnotation: "true"},
+		},
+		Spec:   appsv1.DeploymentSpec{Selector: &metav1.LabelSelector{MatchLabels: versionLabels(version)}},
+		Status: appsv1.DeploymentStatus{ReadyReplicas: 1},
+	}
+}
+
+func newTestRollout(version string) *unstructured.Unstructured {
+	rollout := &unstructured.Unstructured{}
+	rollout.SetAPIVersion(workload.RolloutResource.GroupVersion().String())
+	rollout.SetKind(workload.RolloutKind)
+	rollout.SetNamespace(testNamespace)
+	rollout.SetName(testApp + "-" + version)
+	rollout.SetLabels(versionLabels(version))
+	rollout.SetAnnotations(map[string]string{util.ServiceMeshEnabledAnnotation: "true"})
+	_ = unstructured.SetNestedStringMap(rollout.Object, versionLabels(version), "spec", "selector", "matchLabels")
+	_ = unstructured.SetNestedField(rollout.Object, int64(1), "status", "readyReplicas")
+	return rollout
+}
+
+// newLiveDestinationRule returns destinationrule with subsets of versions, and subsets
+// unready since the given time
+func newLiveDestinationRule(t *testing.T, unreadySince map[string]metav1.Time, versions ...string) *networkingv1beta1.DestinationRule {
+	dr := &networkingv1beta1.DestinationRule{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            testApp,
+			Namespace:       testNamespace,
+			ResourceVersion: "1",
+			Labels:          testApplicationLabels(),
+		},
+	}
+	for _, version := range versions {
+		dr.Spec.Subsets = append(dr.Spec.Subsets, &networkingv1beta1api.Subset{Name: version, Labels: map[string]string{util.VersionLabel: version}})
+	}
+
+	if len(unreadySince) > 0 {
+		value, err := json.Marshal(unreadySince)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		dr.Annotations = map[string]string{util.UnreadySubsetsAnnotation: string(value)}
+	}
+	return dr
+}
+
+func newCanaryStrategy(name, version string) *servicemeshv1alpha1.Strategy {
+	strategy := &servicemeshv1alpha1.Strategy{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace, Labels: testApplicationLabels()},
+		Spec: servicemeshv1alpha1.StrategySpec{
+			Type:             servicemeshv1alpha1.CanaryType,
+			PrincipalVersion: version,
+		},
+	}
+	strategy.Spec.Template.Spec.Hosts = []string{testApp}
+	strategy.Spec.Template.Spec.Http = []*networkingv1beta1api.HTTPRoute{{
+		Route: []*networkingv1beta1api.HTTPRouteDestination{{
+			Destination: &networkingv1beta1api.Destination{Host: testApp, Subset: version},
+			Weight:      100,
+		}},
+	}}
+	return strategy
+}
+
+// newTestOperator serves objects from caches, deployments and rollouts are both workload sources
+func newTestOperator(t *testing.T, gracePeriod time.Duration, objects ...runtime.Object) Operator {
+	k8sInformers := informers.NewSharedInformerFactory(kubefake.NewSimpleClientset(), 0)
+	dynamicInformers := oasisinformers.NewDynamicInformerFactory(dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()))
+	sources := append(workload.KubernetesSources(k8sInformers), workload.NewRolloutSource(dynamicInformers))
+
+	indexer := func() cache.Indexer {
+		return cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	}
+	services, strategies, servicePolicies, virtualServices, destinationRules := indexer(), indexer(), indexer(), indexer(), indexer()
+
+	for _, obj := range objects {
+		var err error
+		switch o := obj.(type) {
+		case *v1.Service:
+			err = services.Add(o)
+		case *appsv1.Deployment:
+			err = k8sInformers.Apps().V1().Deployments().Informer().GetIndexer().Add(o)
+		case *unstructured.Unstructured:
+			err = dynamicInformers.ForResource(workload.RolloutResource).Informer().GetIndexer().Add(o)
+		case *servicemeshv1alpha1.Strategy:
+			err = strategies.Add(o)
+		case *servicemeshv1alpha1.ServicePolicy:
+			err = servicePolicies.Add(o)
+		case *networkingv1beta1.VirtualService:
+			err = virtualServices.Add(o)
+		case *networkingv1beta1.DestinationRule:
+			err = destinationRules.Add(o)
+		default:
+			t.Fatalf("unexpected object %#v", obj)
+		}
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+
+	return NewOperator(corelisters.NewServiceLister(services),
+		sources,
+		servicemeshlisters.NewStrategyLister(strategies),
+		servicemeshlisters.NewServicePolicyLister(servicePolicies),
+		istiolisters.NewVirtualServiceLister(virtualServices),
+		istiolisters.NewDestinationRuleLister(destinationRules),
+		gracePeriod)
+}
+
+func subsetNames(dr *networkingv1beta1.DestinationRule) []string {
+	names := make([]string, 0, len(dr.Spec.Subsets))
+	for _, subset := range dr.Spec.Subsets {
+		names = append(names, subset.Name)
+	}
+	// workloads are listed from caches in no particular order
+	sort.Strings(names)
+	return names
+}
+
+func TestPreview(t *testing.T) {
+	tests := []struct {
+		name     string
+		objects  []runtime.Object
+		proposal *Request
+
+		wantSubsets    []string
+		wantStrategies []Delivery
+		// subset the default http route goes to, empty if no virtualservice is generated
+		wantRoute string
+		wantErr   error
+	}{
+		{
+			name:           "no ready workload",
+			objects:        []runtime.Object{newCanaryStrategy("canary", "v1")},
+			wantSubsets:    []string{},
+			wantStrategies: []Delivery{{Name: "canary", PendingReason: virtualservice.ReasonWaitingForWorkload, PendingMessage: "service has no ready subsets"}},
+		},
+		{
+			name:           "live strategy",
+			objects:        []runtime.Object{newTestDeployment("v1"), newTestDeployment("v2"), newCanaryStrategy("canary", "v2")},
+			wantSubsets:    []string{"v1", "v2"},
+			wantStrategies: []Delivery{{Name: "canary"}},
+			wantRoute:      "v2",
+		},
+		{
+			name:           "proposal replaces live strategy of the same name",
+			objects:        []runtime.Object{newTestDeployment("v1"), newTestDeployment("v2"), newCanaryStrategy("canary", "v2")},
+			proposal:       &Request{Strategy: newCanaryStrategy("canary", "v1")},
+			wantSubsets:    []string{"v1", "v2"},
+			wantStrategies: []Delivery{{Name: "canary"}},
+			wantRoute:      "v1",
+		},
+		{
+			name:           "proposal added to live strategies",
+			objects:        []runtime.Object{newTestDeployment("v1"), newTestDeployment("v2"), newCanaryStrategy("canary", "v2")},
+			proposal:       &Request{Strategy: newCanaryStrategy("next", "v1")},
+			wantSubsets:    []string{"v1", "v2"},
+			wantStrategies: []Delivery{{Name: "canary"}, {Name: "next", Conflict: "default http route is overridden by strategy canary"}},
+			wantRoute:      "v2",
+		},
+		{
+			name:    "proposal of another app",
+			objects: []runtime.Object{newTestDeployment("v1")},
+			proposal: &Request{Strategy: func() *servicemeshv1alpha1.Strategy {
+				strategy := newCanaryStrategy("canary", "v1")
+				strategy.Labels[util.AppLabel] = "ratings"
+				return strategy
+			}()},
+			wantErr: ErrInvalid,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			o := newTestOperator(t, 5*time.Minute, append(test.objects, newTestService())...)
+			request := test.proposal
+			if request == nil {
+				request = &Request{}
+			}
+
+			preview, err := o.Preview(testNamespace, testApp, request)
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) {
+					t.Fatalf("error %v, want %v", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			if names := subsetNames(preview.DestinationRule); !reflect.DeepEqual(names, test.wantSubsets) {
+				t.Errorf("subsets %v, want %v", names, test.wantSubsets)
+			}
+			if !metav1.IsControlledBy(preview.DestinationRule, newTestService()) || len(preview.DestinationRuleDiff) == 0 {
+				t.Errorf("destinationrule %v is not previewed as a new one controlled by service", preview.DestinationRule)
+			}
+
+			if !reflect.DeepEqual(preview.Strategies, test.wantStrategies) {
+				t.Errorf("strategies %+v, want %+v", preview.Strategies, test.wantStrategies)
+			}
+
+			if len(test.wantRoute) == 0 {
+				if preview.VirtualService != nil {
+					t.Errorf("unexpected virtualservice %v", preview.VirtualService)
+				}
+				return
+			}
+
+			vs := preview.VirtualService
+			if vs == nil || len(vs.Spec.Http) == 0 {
+				t.Fatalf("virtualservice %v, want http routes", vs)
+			}
+			route := vs.Spec.Http[len(vs.Spec.Http)-1].Route
+			if len(route) != 1 || route[0].Destination.Subset != test.wantRoute {
+				t.Errorf("default route %v, want subset %s", route, test.wantRoute)
+			}
+		})
+	}
+}
+
+func TestPreviewSubsets(t *testing.T) {
+	longAgo := metav1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+
+	tests := []struct {
+		name     string
+		objects  []runtime.Object
+		proposal *Request
+
+		wantSubsets []string
+		wantHeld    []servicemeshv1alpha1.HeldSubset
+		// unready subsets recorded in annotation
+		wantUnready []string
+	}{
+		{
+			name:        "versions of deployments and rollouts",
+			objects:     []runtime.Object{newTestDeployment("v1"), newTestRollout("v2")},
+			wantSubsets: []string{"v1", "v2"},
+		},
+		{
+			name:        "unready subset held in grace period",
+			objects:     []runtime.Object{newTestDeployment("v1"), newLiveDestinationRule(t, nil, "v1", "v2")},
+			wantSubsets: []string{"v1", "v2"},
+			wantHeld:    []servicemeshv1alpha1.HeldSubset{{Name: "v2", Reason: servicemeshv1alpha1.SubsetHeldInGracePeriod}},
+			wantUnready: []string{"v2"},
+		},
+		{
+			name: "unready subset removed after grace period",
+			objects: []runtime.Object{newTestDeployment("v1"),
+				newLiveDestinationRule(t, map[string]metav1.Time{"v2": longAgo}, "v1", "v2")},
+			wantSubsets: []string{"v1"},
+		},
+		{
+			name: "unready subset held by strategy",
+			objects: []runtime.Object{newTestDeployment("v1"), newCanaryStrategy("canary", "v2"),
+				newLiveDestinationRule(t, map[string]metav1.Time{"v2": longAgo}, "v1", "v2")},
+			wantSubsets: []string{"v1", "v2"},
+			wantHeld:    []servicemeshv1alpha1.HeldSubset{{Name: "v2", Reason: servicemeshv1alpha1.SubsetHeldByStrategy, Strategies: []string{"canary"}}},
+			wantUnready: []string{"v2"},
+		},
+		{
+			name: "unready subset held by proposed strategy",
+			objects: []runtime.Object{newTestDeployment("v1"),
+				newLiveDestinationRule(t, map[string]metav1.Time{"v2": longAgo}, "v1", "v2")},
+			proposal:    &Request{Strategy: newCanaryStrategy("canary", "v2")},
+			wantSubsets: []string{"v1", "v2"},
+			wantHeld:    []servicemeshv1alpha1.HeldSubset{{Name: "v2", Reason: servicemeshv1alpha1.SubsetHeldByStrategy, Strategies: []string{"canary"}}},
+			wantUnready: []string{"v2"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			o := newTestOperator(t, 5*time.Minute, append(test.objects, newTestService())...)
+			request := test.proposal
+			if request == nil {
+				request = &Request{}
+			}
+
+			preview, err := o.Preview(testNamespace, testApp, request)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			if names := subsetNames(preview.DestinationRule); !reflect.DeepEqual(names, test.wantSubsets) {
+				t.Errorf("subsets %v, want %v", names, test.wantSubsets)
+			}
+
+			if len(preview.HeldSubsets) != len(test.wantHeld) {
+				t.Fatalf("held subsets %v, want %v", preview.HeldSubsets, test.wantHeld)
+			}
+			for i, held := range preview.HeldSubsets {
+				want := test.wantHeld[i]
+				if held.Name != want.Name || held.Reason != want.Reason || !reflect.DeepEqual(held.Strategies, want.Strategies) {
+					t.Errorf("held subset %v, want %v", held, want)
+				}
+			}
+
+			unready := make(map[string]metav1.Time)
+			if value, ok := preview.DestinationRule.Annotations[util.UnreadySubsetsAnnotation]; ok {
+				if err := json.Unmarshal([]byte(value), &unready); err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+			}
+			if len(unready) != len(test.wantUnready) {
+				t.Errorf("unready subsets %v, want %v", unready, test.wantUnready)
+			}
+			for _, name := range test.wantUnready {
+				if _, ok := unready[name]; !ok {
+					t.Errorf("unready subsets %v, want %v", unready, test.wantUnready)
+				}
+			}
+		})
+	}
+}
+
+func TestPreviewNotInMesh(t *testing.T) {
+	service := newTestService()
+	service.Annotations = nil
+
+	o := newTestOperator(t, 5*time.Minute, service)
+	if _, err := o.Preview(testNamespace, testApp, &Request{}); !errors.Is(err, ErrNotInMesh) {
+		t.Errorf("error %v, want %v", err, ErrNotInMesh)
+	}
+}
+
+func TestReplaceStrategy(t *testing.T) {
+	step := int32(1)
+	stepTime := metav1.NewTime(time.Now().Add(-time.Hour))
+	live := newCanaryStrategy("canary", "v1")
+	live.Status = servicemeshv1alpha1.StrategyStatus{CurrentStep: &step, CurrentStepTime: &stepTime, ObservedGeneration: 3}
+	util.SetStrategyCondition(&live.Status, *util.NewStrategyCondition(servicemeshv1alpha1.StrategyFailed, v1.ConditionTrue, "AnalysisFailed", "error rate exceeds"))
+
+	tests := []struct {
+		name     string
+		proposal *servicemeshv1alpha1.Strategy
+
+		wantStep *int32
+	}{
+		{
+			name:     "same spec goes on from live step",
+			proposal: newCanaryStrategy("canary", "v1"),
+			wantStep: &step,
+		},
+		{
+			name:     "changed spec starts over",
+			proposal: newCanaryStrategy("canary", "v2"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			strategies := replaceStrategy([]*servicemeshv1alpha1.Strategy{live, newCanaryStrategy("other", "v1")}, test.proposal)
+			if len(strategies) != 2 || strategies[1] != test.proposal {
+				t.Fatalf("strategies %v, want live one replaced by proposal", strategies)
+			}
+
+			status := test.proposal.Status
+			if !reflect.DeepEqual(status.CurrentStep, test.wantStep) {
+				t.Errorf("current step %v, want %v", status.CurrentStep, test.wantStep)
+			}
+
+			restarted := test.wantStep == nil
+			if restarted && (status.CurrentStepTime != nil || status.ObservedGeneration != 0) {
+				t.Errorf("canary progress of live spec is kept, status %v", status)
+			}
+			if failed := util.GetStrategyCondition(status, servicemeshv1alpha1.StrategyFailed) != nil; failed == restarted {
+				t.Errorf("failed %v, want %v", failed, !restarted)
+			}
+		})
+	}
+}
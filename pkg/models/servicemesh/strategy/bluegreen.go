@@ -0,0 +1,90 @@
+package strategy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+	meshclient "zmc.io/oasis/pkg/client/clientset/versioned"
+)
+
+var (
+	// ErrNotBlueGreen is returned when acting blue/green actions on other strategies
+	ErrNotBlueGreen = errors.New("strategy is not a blue/green strategy")
+
+	// ErrNotPreviewed is returned when controller has not started previewing yet
+	ErrNotPreviewed = errors.New("strategy has not been previewed yet")
+)
+
+// BlueGreenOperator moves blue/green strategies between phases
+type BlueGreenOperator interface {
+	// Promote routes all traffic to preview version
+	Promote(namespace, name string) (*servicemeshv1alpha1.Strategy, error)
+
+	// Abort reverts all traffic to principal version
+	Abort(namespace, name string) (*servicemeshv1alpha1.Strategy, error)
+}
+
+type blueGreenOperator struct {
+	client meshclient.Interface
+}
+
+func NewBlueGreenOperator(client meshclient.Interface) BlueGreenOperator {
+	return &blueGreenOperator{client: client}
+}
+
+func (o *blueGreenOperator) Promote(namespace, name string) (*servicemeshv1alpha1.Strategy, error) {
+	return o.transit(namespace, name, servicemeshv1alpha1.BlueGreenPromoted, func(strategy *servicemeshv1alpha1.Strategy) string {
+		return strategy.Spec.BlueGreen.PreviewVersion
+	})
+}
+
+func (o *blueGreenOperator) Abort(namespace, name string) (*servicemeshv1alpha1.Strategy, error) {
+	return o.transit(namespace, name, servicemeshv1alpha1.BlueGreenAborted, func(strategy *servicemeshv1alpha1.Strategy) string {
+		return strategy.Spec.PrincipalVersion
+	})
+}
+
+// transit moves strategy to phase, with all traffic routed to active version
+func (o *blueGreenOperator) transit(namespace, name string, phase servicemeshv1alpha1.BlueGreenPhase, active func(*servicemeshv1alpha1.Strategy) string) (*servicemeshv1alpha1.Strategy, error) {
+	var result *servicemeshv1alpha1.Strategy
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		strategy, err := o.client.ServicemeshV1alpha1().Strategies(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		if strategy.Spec.Type != servicemeshv1alpha1.BlueGreenType || strategy.Spec.BlueGreen == nil {
+			return ErrNotBlueGreen
+		}
+
+		bg := strategy.Status.BlueGreen
+		if bg == nil || bg.PreviewVersion != strategy.Spec.BlueGreen.PreviewVersion {
+			return ErrNotPreviewed
+		}
+
+		if bg.Phase == phase {
+			result = strategy
+			return nil
+		}
+
+		now := metav1.Now()
+		bg.Phase = phase
+		bg.ActiveVersion = active(strategy)
+		bg.LastTransitionTime = &now
+
+		result, err = o.client.ServicemeshV1alpha1().Strategies(namespace).UpdateStatus(context.TODO(), strategy, metav1.UpdateOptions{})
+		return err
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("%s strategy %s/%s failed, %w", phase, namespace, name, err)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,123 @@
+package strategy
+
+import (
+	"errors"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+	"zmc.io/oasis/pkg/client/clientset/versioned/fake"
+)
+
+func newBlueGreenStrategy(status *servicemeshv1alpha1.BlueGreenStatus) *servicemeshv1alpha1.Strategy {
+	return &servicemeshv1alpha1.Strategy{
+		ObjectMeta: metav1.ObjectMeta{Name: "bluegreen", Namespace: "default"},
+		Spec: servicemeshv1alpha1.StrategySpec{
+			Type:             servicemeshv1alpha1.BlueGreenType,
+			PrincipalVersion: "v1",
+			BlueGreen:        &servicemeshv1alpha1.BlueGreenStrategy{PreviewVersion: "v2"},
+		},
+		Status: servicemeshv1alpha1.StrategyStatus{BlueGreen: status},
+	}
+}
+
+func TestBlueGreenOperator(t *testing.T) {
+	since := metav1.Now()
+	previewing := &servicemeshv1alpha1.BlueGreenStatus{Phase: servicemeshv1alpha1.BlueGreenPreviewing, PreviewVersion: "v2", ActiveVersion: "v1", LastTransitionTime: &since}
+	promoted := &servicemeshv1alpha1.BlueGreenStatus{Phase: servicemeshv1alpha1.BlueGreenPromoted, PreviewVersion: "v2", ActiveVersion: "v2", LastTransitionTime: &since}
+
+	canary := newBlueGreenStrategy(previewing)
+	canary.Spec.Type = servicemeshv1alpha1.CanaryType
+
+	tests := []struct {
+		name     string
+		strategy *servicemeshv1alpha1.Strategy
+		promote  bool
+
+		wantPhase  servicemeshv1alpha1.BlueGreenPhase
+		wantActive string
+		wantErr    error
+	}{
+		{
+			name:       "promote",
+			strategy:   newBlueGreenStrategy(previewing.DeepCopy()),
+			promote:    true,
+			wantPhase:  servicemeshv1alpha1.BlueGreenPromoted,
+			wantActive: "v2",
+		},
+		{
+			name:       "abort",
+			strategy:   newBlueGreenStrategy(previewing.DeepCopy()),
+			wantPhase:  servicemeshv1alpha1.BlueGreenAborted,
+			wantActive: "v1",
+		},
+		{
+			name:       "abort after promoted",
+			strategy:   newBlueGreenStrategy(promoted.DeepCopy()),
+			wantPhase:  servicemeshv1alpha1.BlueGreenAborted,
+			wantActive: "v1",
+		},
+		{
+			name:       "promote twice",
+			strategy:   newBlueGreenStrategy(promoted.DeepCopy()),
+			promote:    true,
+			wantPhase:  servicemeshv1alpha1.BlueGreenPromoted,
+			wantActive: "v2",
+		},
+		{
+			name:     "not previewed",
+			strategy: newBlueGreenStrategy(nil),
+			promote:  true,
+			wantErr:  ErrNotPreviewed,
+		},
+		{
+			name: "preview version changed",
+			strategy: newBlueGreenStrategy(&servicemeshv1alpha1.BlueGreenStatus{
+				Phase: servicemeshv1alpha1.BlueGreenPreviewing, PreviewVersion: "v0", ActiveVersion: "v1", LastTransitionTime: &since,
+			}),
+			promote: true,
+			wantErr: ErrNotPreviewed,
+		},
+		{
+			name:     "not blue/green",
+			strategy: canary,
+			promote:  true,
+			wantErr:  ErrNotBlueGreen,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			operator := NewBlueGreenOperator(fake.NewSimpleClientset(test.strategy))
+
+			action := operator.Abort
+			if test.promote {
+				action = operator.Promote
+			}
+
+			got, err := action(test.strategy.Namespace, test.strategy.Name)
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) {
+					t.Errorf("error %v, want %v", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			bg := got.Status.BlueGreen
+			if bg.Phase != test.wantPhase || bg.ActiveVersion != test.wantActive || bg.LastTransitionTime == nil {
+				t.Errorf("blue/green status %v, want phase %s with active version %s", bg, test.wantPhase, test.wantActive)
+			}
+		})
+	}
+}
+
+func TestBlueGreenOperatorNotFound(t *testing.T) {
+	operator := NewBlueGreenOperator(fake.NewSimpleClientset())
+	if _, err := operator.Promote("default", "missing"); err == nil {
+		t.Errorf("promote missing strategy succeeded")
+	}
+}
@@ -0,0 +1,231 @@
+package strategy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/diff"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	"k8s.io/client-go/util/retry"
+
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+	meshclient "zmc.io/oasis/pkg/client/clientset/versioned"
+	"zmc.io/oasis/pkg/controller/virtualservice/util"
+)
+
+// ErrRevisionNotFound is returned when strategy has no such revision
+var ErrRevisionNotFound = errors.New("revision not found")
+
+// Revision is a snapshot of strategy spec once delivered to istio
+type Revision struct {
+	// Name of the controllerrevision
+	Name string `json:"name"`
+
+	// Revision number, increases every time a spec is delivered
+	Revision int64 `json:"revision"`
+
+	// Time when the revision was recorded
+	CreationTimestamp metav1.Time `json:"creationTimestamp"`
+
+	// Current tells if the revision is the spec of strategy now
+	Current bool `json:"current"`
+
+	Spec servicemeshv1alpha1.StrategySpec `json:"spec"`
+}
+
+// RevisionDiff shows differences between two revisions
+type RevisionDiff struct {
+	// Revision diff from
+	From int64 `json:"from"`
+
+	// Revision diff to, 0 stands for current spec of strategy
+	To int64 `json:"to"`
+
+	// Human readable differences, empty if specs are the same
+	Diff string `json:"diff"`
+}
+
+// RevisionOperator browses and rolls back strategy revisions
+type RevisionOperator interface {
+	// ListRevisions returns revisions of strategy, from the latest to the oldest
+	ListRevisions(namespace, name string) ([]*Revision, error)
+
+	// DiffRevisions compares two revisions, to 0 compares with current spec
+	DiffRevisions(namespace, name string, from, to int64) (*RevisionDiff, error)
+
+	// Rollback replaces strategy spec with the one in revision
+	Rollback(namespace, name string, revision int64) (*servicemeshv1alpha1.Strategy, error)
+}
+
+type revisionOperator struct {
+	client         meshclient.Interface
+	revisionLister appslisters.ControllerRevisionLister
+}
+
+func NewRevisionOperator(client meshclient.Interface, revisionLister appslisters.ControllerRevisionLister) RevisionOperator {
+	return &revisionOperator{client: client, revisionLister: revisionLister}
+}
+
+func (o *revisionOperator) ListRevisions(namespace, name string) ([]*Revision, error) {
+	strategy, err := o.client.ServicemeshV1alpha1().Strategies(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return o.listRevisions(strategy)
+}
+
+func (o *revisionOperator) DiffRevisions(namespace, name string, from, to int64) (*RevisionDiff, error) {
+	strategy, err := o.client.ServicemeshV1alpha1().Strategies(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	revisions, err := o.listRevisions(strategy)
+	if err != nil {
+		return nil, err
+	}
+
+	fromRevision := findRevision(revisions, from)
+	if fromRevision == nil {
+		return nil, fmt.Errorf("%w: %d", ErrRevisionNotFound, from)
+	}
+
+	toSpec := &strategy.Spec
+	if to != 0 {
+		toRevision := findRevision(revisions, to)
+		if toRevision == nil {
+			return nil, fmt.Errorf("%w: %d", ErrRevisionNotFound, to)
+		}
+		toSpec = &toRevision.Spec
+	}
+
+	// specs are compared as plain json objects, so only meaningful fields show up
+	fromObj, err := toUnstructured(&fromRevision.Spec)
+	if err != nil {
+		return nil, err
+	}
+	toObj, err := toUnstructured(toSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RevisionDiff{From: from, To: to}
+	if !reflect.DeepEqual(fromObj, toObj) {
+		result.Diff = diff.ObjectReflectDiff(fromObj, toObj)
+	}
+
+	return result, nil
+}
+
+func (o *revisionOperator) Rollback(namespace, name string, revision int64) (*servicemeshv1alpha1.Strategy, error) {
+	var result *servicemeshv1alpha1.Strategy
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		strategy, err := o.client.ServicemeshV1alpha1().Strategies(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		revisions, err := o.listRevisions(strategy)
+		if err != nil {
+			return err
+		}
+
+		target := findRevision(revisions, revision)
+		if target == nil {
+			return fmt.Errorf("%w: %d", ErrRevisionNotFound, revision)
+		}
+
+		if target.Current {
+			result = strategy
+			return nil
+		}
+
+		strategy.Spec = target.Spec
+		result, err = o.client.ServicemeshV1alpha1().Strategies(namespace).Update(context.TODO(), strategy, metav1.UpdateOptions{})
+		return err
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("rollback strategy %s/%s to revision %d failed, %w", namespace, name, revision, err)
+	}
+
+	return result, nil
+}
+
+// listRevisions returns revisions owned by strategy, from the latest to the oldest
+func (o *revisionOperator) listRevisions(strategy *servicemeshv1alpha1.Strategy) ([]*Revision, error) {
+	controllerRevisions, err := o.revisionLister.ControllerRevisions(strategy.Namespace).List(labels.SelectorFromSet(map[string]string{util.StrategyRevisionLabel: strategy.Name}))
+	if err != nil {
+		return nil, err
+	}
+
+	_, current, err := util.StrategyRevisionName(strategy)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := make([]*Revision, 0, len(controllerRevisions))
+	for _, controllerRevision := range controllerRevisions {
+		if !metav1.IsControlledBy(controllerRevision, strategy) {
+			continue
+		}
+
+		revision, err := newRevision(controllerRevision)
+		if err != nil {
+			return nil, err
+		}
+		revision.Current = bytes.Equal(controllerRevision.Data.Raw, current)
+
+		revisions = append(revisions, revision)
+	}
+
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].Revision > revisions[j].Revision
+	})
+
+	return revisions, nil
+}
+
+func newRevision(controllerRevision *appsv1.ControllerRevision) (*Revision, error) {
+	revision := &Revision{
+		Name:              controllerRevision.Name,
+		Revision:          controllerRevision.Revision,
+		CreationTimestamp: controllerRevision.CreationTimestamp,
+	}
+
+	if err := json.Unmarshal(controllerRevision.Data.Raw, &revision.Spec); err != nil {
+		return nil, fmt.Errorf("decode revision %s/%s failed, %v", controllerRevision.Namespace, controllerRevision.Name, err)
+	}
+
+	return revision, nil
+}
+
+func findRevision(revisions []*Revision, revision int64) *Revision {
+	for _, r := range revisions {
+		if r.Revision == revision {
+			return r
+		}
+	}
+	return nil
+}
+
+func toUnstructured(spec *servicemeshv1alpha1.StrategySpec) (map[string]interface{}, error) {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	obj := make(map[string]interface{})
+	err = json.Unmarshal(data, &obj)
+	return obj, err
+}
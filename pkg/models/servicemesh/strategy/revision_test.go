@@ -0,0 +1,162 @@
+package strategy
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	"k8s.io/client-go/tools/cache"
+
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+	"zmc.io/oasis/pkg/client/clientset/versioned/fake"
+	"zmc.io/oasis/pkg/controller/virtualservice/util"
+)
+
+func newRevisionStrategy(principal string) *servicemeshv1alpha1.Strategy {
+	return &servicemeshv1alpha1.Strategy{
+		ObjectMeta: metav1.ObjectMeta{Name: "canary", Namespace: "default", UID: types.UID("uid-canary")},
+		Spec:       servicemeshv1alpha1.StrategySpec{PrincipalVersion: principal},
+	}
+}
+
+// newTestRevision snapshots strategy with principal version as revision
+func newTestRevision(t *testing.T, owner *servicemeshv1alpha1.Strategy, principal string, revision int64) *appsv1.ControllerRevision {
+	strategy := owner.DeepCopy()
+	strategy.Spec.PrincipalVersion = principal
+
+	name, data, err := util.StrategyRevisionName(strategy)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	return &appsv1.ControllerRevision{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       strategy.Namespace,
+			Labels:          map[string]string{util.StrategyRevisionLabel: strategy.Name},
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(strategy, servicemeshv1alpha1.SchemeGroupVersion.WithKind(servicemeshv1alpha1.ResourceKindStrategy))},
+		},
+		Data:     runtime.RawExtension{Raw: data},
+		Revision: revision,
+	}
+}
+
+// newTestRevisionOperator serves strategy from a fake client, and its revisions v1, v2 and v3 from cache
+func newTestRevisionOperator(t *testing.T, strategy *servicemeshv1alpha1.Strategy) RevisionOperator {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for i, version := range []string{"v1", "v2", "v3"} {
+		_ = indexer.Add(newTestRevision(t, strategy, version, int64(i+1)))
+	}
+
+	// revisions of other strategies are left alone
+	other := newTestRevision(t, strategy, "v4", 4)
+	other.OwnerReferences[0].UID = types.UID("uid-other")
+	_ = indexer.Add(other)
+
+	return NewRevisionOperator(fake.NewSimpleClientset(strategy), appslisters.NewControllerRevisionLister(indexer))
+}
+
+func TestListRevisions(t *testing.T) {
+	operator := newTestRevisionOperator(t, newRevisionStrategy("v2"))
+
+	revisions, err := operator.ListRevisions("default", "canary")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	got := make([]string, 0, len(revisions))
+	for _, revision := range revisions {
+		item := revision.Spec.PrincipalVersion
+		if revision.Current {
+			item += "*"
+		}
+		got = append(got, item)
+	}
+
+	if want := []string{"v3", "v2*", "v1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("revisions %v, want %v", got, want)
+	}
+}
+
+func TestDiffRevisions(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to int64
+
+		wantDiff []string
+		wantErr  error
+	}{
+		{name: "two revisions", from: 1, to: 3, wantDiff: []string{"v1", "v3"}},
+		{name: "with current spec", from: 1, to: 0, wantDiff: []string{"v1", "v2"}},
+		{name: "same spec", from: 2, to: 0},
+		{name: "revision not found", from: 5, to: 0, wantErr: ErrRevisionNotFound},
+		{name: "revision of other strategy", from: 1, to: 4, wantErr: ErrRevisionNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			operator := newTestRevisionOperator(t, newRevisionStrategy("v2"))
+
+			diff, err := operator.DiffRevisions("default", "canary", test.from, test.to)
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) {
+					t.Errorf("error %v, want %v", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			if len(test.wantDiff) == 0 && len(diff.Diff) > 0 {
+				t.Errorf("unexpected diff %s", diff.Diff)
+			}
+			for _, want := range test.wantDiff {
+				if !strings.Contains(diff.Diff, want) {
+					t.Errorf("diff %s, want containing %s", diff.Diff, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRollback(t *testing.T) {
+	tests := []struct {
+		name     string
+		revision int64
+
+		want    string
+		wantErr error
+	}{
+		{name: "previous revision", revision: 1, want: "v1"},
+		{name: "current revision", revision: 2, want: "v2"},
+		{name: "revision not found", revision: 5, wantErr: ErrRevisionNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			operator := newTestRevisionOperator(t, newRevisionStrategy("v2"))
+
+			strategy, err := operator.Rollback("default", "canary", test.revision)
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) {
+					t.Errorf("error %v, want %v", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			if strategy.Spec.PrincipalVersion != test.want {
+				t.Errorf("principal version %s, want %s", strategy.Spec.PrincipalVersion, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package healthz
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+)
+
+const (
+	HealthzPath = "/healthz"
+	LivezPath   = "/livez"
+	ReadyzPath  = "/readyz"
+
+	istioNetworkingGroupVersion = "networking.istio.io/v1beta1"
+)
+
+// istioResources are istio resources oasis reads and writes
+var istioResources = []string{"virtualservices", "destinationrules"}
+
+// Checker checks a single aspect of health, request is failed if it returns an error
+type Checker = healthz.Checker
+
+// Ping always succeeds, it tells the process is able to serve requests
+var Ping Checker = healthz.Ping
+
+// Probes collects liveness and readiness checks. Every check is available at
+// path of its probe followed by its name, e.g. /readyz/informer-sync, while
+// the probe path itself aggregates all of them.
+type Probes struct {
+	livez  map[string]Checker
+	readyz map[string]Checker
+}
+
+func NewProbes() *Probes {
+	return &Probes{
+		livez:  map[string]Checker{"ping": Ping},
+		readyz: map[string]Checker{"ping": Ping},
+	}
+}
+
+// AddLivezCheck adds a check failing which the process should be restarted.
+// Checks have to be added before probes are installed.
+func (p *Probes) AddLivezCheck(name string, check Checker) {
+	p.livez[name] = check
+}
+
+// AddReadyzCheck adds a check failing which the process should not receive traffic.
+// Checks have to be added before probes are installed.
+func (p *Probes) AddReadyzCheck(name string, check Checker) {
+	p.readyz[name] = check
+}
+
+// Install registers /healthz, /livez and /readyz to mux, /healthz is kept for
+// compatibility and serves the same checks as /livez.
+func (p *Probes) Install(mux *http.ServeMux) {
+	livez := &healthz.Handler{Checks: p.livez}
+	readyz := &healthz.Handler{Checks: p.readyz}
+
+	for path, handler := range map[string]http.Handler{HealthzPath: livez, LivezPath: livez, ReadyzPath: readyz} {
+		mux.Handle(path, http.StripPrefix(path, handler))
+		mux.Handle(path+"/", http.StripPrefix(path, handler))
+	}
+}
+
+// Serve serves probes at addr until stopCh is closed, it returns at once and
+// probes are not served if addr is empty or "0".
+func (p *Probes) Serve(addr string, stopCh <-chan struct{}) {
+	if len(addr) == 0 || addr == "0" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	p.Install(mux)
+	server := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		<-stopCh
+		_ = server.Shutdown(context.Background())
+	}()
+
+	go func() {
+		klog.V(0).Infof("Serving health probes on %s", addr)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			klog.Errorf("health probes server stopped, %v", err)
+		}
+	}()
+}
+
+// InformersSynced fails until all informers have synced their caches
+func InformersSynced(synced ...cache.InformerSynced) Checker {
+	return func(_ *http.Request) error {
+		for _, hasSynced := range synced {
+			if !hasSynced() {
+				return fmt.Errorf("informer caches are not synced")
+			}
+		}
+		return nil
+	}
+}
+
+// LeaderElected fails until elected is closed, which happens at once when
+// leader election is disabled.
+func LeaderElected(elected <-chan struct{}) Checker {
+	return func(_ *http.Request) error {
+		select {
+		case <-elected:
+			return nil
+		default:
+			return fmt.Errorf("waiting for leadership")
+		}
+	}
+}
+
+// IstioResourcesReachable fails if istio networking resources can not be discovered
+// from kubernetes apiserver, it fails as well if apiserver is not reachable, i.e.
+// caches are no longer refreshed.
+func IstioResourcesReachable(client discovery.DiscoveryInterface) Checker {
+	return func(_ *http.Request) error {
+		resourceList, err := client.ServerResourcesForGroupVersion(istioNetworkingGroupVersion)
+		if err != nil {
+			return fmt.Errorf("cannot discover %s, %v", istioNetworkingGroupVersion, err)
+		}
+
+		for _, resource := range istioResources {
+			found := false
+			for _, apiResource := range resourceList.APIResources {
+				if apiResource.Name == resource {
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				return fmt.Errorf("resource %s of %s not found", resource, istioNetworkingGroupVersion)
+			}
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,135 @@
+package healthz
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func failing(_ *http.Request) error {
+	return errors.New("failing")
+}
+
+func TestProbes(t *testing.T) {
+	probes := NewProbes()
+	probes.AddLivezCheck("live", Ping)
+	probes.AddReadyzCheck("ready", Ping)
+	probes.AddReadyzCheck("not-ready", failing)
+
+	mux := http.NewServeMux()
+	probes.Install(mux)
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{path: HealthzPath, want: http.StatusOK},
+		{path: LivezPath, want: http.StatusOK},
+		{path: LivezPath + "/live", want: http.StatusOK},
+		{path: HealthzPath + "/live", want: http.StatusOK},
+		{path: ReadyzPath, want: http.StatusInternalServerError},
+		{path: ReadyzPath + "/ready", want: http.StatusOK},
+		{path: ReadyzPath + "/not-ready", want: http.StatusInternalServerError},
+		{path: ReadyzPath + "/ping", want: http.StatusOK},
+		{path: ReadyzPath + "/unknown", want: http.StatusNotFound},
+		// readiness checks are not part of liveness
+		{path: LivezPath + "/not-ready", want: http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, test.path, nil))
+			if recorder.Code != test.want {
+				t.Errorf("status %d, want %d, body %s", recorder.Code, test.want, recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestInformersSynced(t *testing.T) {
+	synced := func() bool { return true }
+	syncing := func() bool { return false }
+
+	tests := []struct {
+		name    string
+		synced  []cache.InformerSynced
+		wantErr bool
+	}{
+		{name: "no informers"},
+		{name: "all synced", synced: []cache.InformerSynced{synced, synced}},
+		{name: "one syncing", synced: []cache.InformerSynced{synced, syncing}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := InformersSynced(test.synced...)(nil); (err != nil) != test.wantErr {
+				t.Errorf("error %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestLeaderElected(t *testing.T) {
+	elected := make(chan struct{})
+	check := LeaderElected(elected)
+
+	if err := check(nil); err == nil {
+		t.Errorf("expected error before elected, got nil")
+	}
+
+	close(elected)
+	if err := check(nil); err != nil {
+		t.Errorf("unexpected error %v after elected", err)
+	}
+}
+
+func TestIstioResourcesReachable(t *testing.T) {
+	tests := []struct {
+		name      string
+		resources []*metav1.APIResourceList
+		wantErr   bool
+	}{
+		{
+			name: "all resources served",
+			resources: []*metav1.APIResourceList{{
+				GroupVersion: istioNetworkingGroupVersion,
+				APIResources: []metav1.APIResource{{Name: "virtualservices"}, {Name: "destinationrules"}, {Name: "gateways"}},
+			}},
+		},
+		{
+			name: "destinationrules missing",
+			resources: []*metav1.APIResourceList{{
+				GroupVersion: istioNetworkingGroupVersion,
+				APIResources: []metav1.APIResource{{Name: "virtualservices"}},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "group version not served",
+			resources: []*metav1.APIResourceList{{
+				GroupVersion: "networking.istio.io/v1alpha3",
+				APIResources: []metav1.APIResource{{Name: "virtualservices"}, {Name: "destinationrules"}},
+			}},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := kubefake.NewSimpleClientset()
+			discovery := client.Discovery().(*fakediscovery.FakeDiscovery)
+			discovery.Resources = test.resources
+
+			if err := IstioResourcesReachable(discovery)(nil); (err != nil) != test.wantErr {
+				t.Errorf("error %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	apiv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// ErrNoData is returned when a query matches no series
+var ErrNoData = errors.New("no data points found")
+
+// Interface is the prometheus query client used by controllers
+type Interface interface {
+	// Query evaluates an instant query at current time,
+	// and returns value of the first sample
+	Query(ctx context.Context, query string) (float64, error)
+}
+
+type prometheus struct {
+	client apiv1.API
+}
+
+// NewPrometheus creates a prometheus client for given prometheus url
+func NewPrometheus(host string) (Interface, error) {
+	client, err := api.NewClient(api.Config{Address: host})
+	if err != nil {
+		return nil, err
+	}
+
+	return &prometheus{client: apiv1.NewAPI(client)}, nil
+}
+
+func (p *prometheus) Query(ctx context.Context, query string) (float64, error) {
+	value, _, err := p.client.Query(ctx, query, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	vector, ok := value.(model.Vector)
+	if !ok {
+		return 0, fmt.Errorf("unexpected value type %s of query %s", value.Type(), query)
+	}
+
+	if len(vector) == 0 {
+		return 0, ErrNoData
+	}
+
+	return float64(vector[0].Value), nil
+}
@@ -0,0 +1,80 @@
+package prometheus
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newStubServer serves instant queries with body as the response
+func newStubServer(status int, body string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/query" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+}
+
+func TestQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    float64
+		wantErr error
+		failed  bool
+	}{
+		{
+			name:   "vector",
+			status: http.StatusOK,
+			body:   `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1600000000,"2.5"]}]}}`,
+			want:   2.5,
+		},
+		{
+			name:    "empty vector",
+			status:  http.StatusOK,
+			body:    `{"status":"success","data":{"resultType":"vector","result":[]}}`,
+			wantErr: ErrNoData,
+		},
+		{
+			name:   "scalar",
+			status: http.StatusOK,
+			body:   `{"status":"success","data":{"resultType":"scalar","result":[1600000000,"1"]}}`,
+			failed: true,
+		},
+		{
+			name:   "bad query",
+			status: http.StatusBadRequest,
+			body:   `{"status":"error","errorType":"bad_data","error":"parse error"}`,
+			failed: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newStubServer(test.status, test.body)
+			defer server.Close()
+
+			client, err := NewPrometheus(server.URL)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			got, err := client.Query(context.TODO(), "up")
+			if test.wantErr != nil && err != test.wantErr {
+				t.Errorf("error %v, want %v", err, test.wantErr)
+			}
+			if test.failed != (err != nil && test.wantErr == nil) {
+				t.Errorf("error %v, want failure %v", err, test.failed)
+			}
+			if err == nil && got != test.want {
+				t.Errorf("value %v, want %v", got, test.want)
+			}
+		})
+	}
+}
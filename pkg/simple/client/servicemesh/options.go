@@ -1,6 +1,11 @@
 package servicemesh
 
-import "github.com/spf13/pflag"
+import (
+	"fmt"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
 
 type Options struct {
 
@@ -12,6 +17,14 @@ type Options struct {
 
 	// prometheus service url for servicemesh metrics
 	ServicemeshPrometheusHost string `json:"servicemeshPrometheusHost,omitempty" yaml:"servicemeshPrometheusHost"`
+
+	// keys of labels and annotation servicemesh applications are identified by,
+	// built-in keys are used if they are empty
+	AppLabel                     string `json:"appLabel,omitempty" yaml:"appLabel"`
+	VersionLabel                 string `json:"versionLabel,omitempty" yaml:"versionLabel"`
+	ApplicationNameLabel         string `json:"applicationNameLabel,omitempty" yaml:"applicationNameLabel"`
+	ApplicationVersionLabel      string `json:"applicationVersionLabel,omitempty" yaml:"applicationVersionLabel"`
+	ServiceMeshEnabledAnnotation string `json:"serviceMeshEnabledAnnotation,omitempty" yaml:"serviceMeshEnabledAnnotation"`
 }
 
 // NewServiceMeshOptions returns a `zero` instance
@@ -26,6 +39,22 @@ func NewServiceMeshOptions() *Options {
 func (s *Options) Validate() []error {
 	errors := []error{}
 
+	keys := map[string]string{
+		"appLabel":                     s.AppLabel,
+		"versionLabel":                 s.VersionLabel,
+		"applicationNameLabel":         s.ApplicationNameLabel,
+		"applicationVersionLabel":      s.ApplicationVersionLabel,
+		"serviceMeshEnabledAnnotation": s.ServiceMeshEnabledAnnotation,
+	}
+	for name, key := range keys {
+		if len(key) == 0 {
+			continue
+		}
+		for _, msg := range validation.IsQualifiedName(key) {
+			errors = append(errors, fmt.Errorf("invalid %s %q, %s", name, key, msg))
+		}
+	}
+
 	return errors
 }
 
@@ -41,6 +70,26 @@ func (s *Options) ApplyTo(options *Options) {
 	if s.IstioPilotHost != "" {
 		options.IstioPilotHost = s.IstioPilotHost
 	}
+
+	if s.AppLabel != "" {
+		options.AppLabel = s.AppLabel
+	}
+
+	if s.VersionLabel != "" {
+		options.VersionLabel = s.VersionLabel
+	}
+
+	if s.ApplicationNameLabel != "" {
+		options.ApplicationNameLabel = s.ApplicationNameLabel
+	}
+
+	if s.ApplicationVersionLabel != "" {
+		options.ApplicationVersionLabel = s.ApplicationVersionLabel
+	}
+
+	if s.ServiceMeshEnabledAnnotation != "" {
+		options.ServiceMeshEnabledAnnotation = s.ServiceMeshEnabledAnnotation
+	}
 }
 
 func (s *Options) AddFlags(fs *pflag.FlagSet, c *Options) {
@@ -52,4 +101,19 @@ func (s *Options) AddFlags(fs *pflag.FlagSet, c *Options) {
 
 	fs.StringVar(&s.ServicemeshPrometheusHost, "servicemesh-prometheus-host", c.ServicemeshPrometheusHost, ""+
 		"prometheus service for servicemesh")
+
+	fs.StringVar(&s.AppLabel, "app-label", c.AppLabel, ""+
+		"key of label naming component of application, app if empty")
+
+	fs.StringVar(&s.VersionLabel, "version-label", c.VersionLabel, ""+
+		"key of label naming version of component, version if empty")
+
+	fs.StringVar(&s.ApplicationNameLabel, "application-name-label", c.ApplicationNameLabel, ""+
+		"key of label naming application, app.linkedcare.io/name if empty")
+
+	fs.StringVar(&s.ApplicationVersionLabel, "application-version-label", c.ApplicationVersionLabel, ""+
+		"key of label naming version of application, app.linkedcare.io/version if empty")
+
+	fs.StringVar(&s.ServiceMeshEnabledAnnotation, "servicemesh-enabled-annotation", c.ServiceMeshEnabledAnnotation, ""+
+		"key of annotation enabling servicemesh for service, servicemesh.linkedcare.io/enabled if empty")
 }
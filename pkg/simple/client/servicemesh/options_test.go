@@ -0,0 +1,72 @@
+package servicemesh
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name       string
+		options    *Options
+		wantErrors int
+	}{
+		{name: "built-in keys", options: NewServiceMeshOptions()},
+		{name: "custom keys", options: &Options{AppLabel: "app.kubernetes.io/name", VersionLabel: "app.kubernetes.io/version", ServiceMeshEnabledAnnotation: "sidecar.istio.io/inject"}},
+		{name: "invalid label key", options: &Options{AppLabel: "app name"}, wantErrors: 1},
+		{name: "invalid prefix", options: &Options{VersionLabel: "Example_Com/version"}, wantErrors: 1},
+		{name: "several invalid keys", options: &Options{ApplicationNameLabel: "-name", ApplicationVersionLabel: "version name"}, wantErrors: 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if errs := test.options.Validate(); len(errs) != test.wantErrors {
+				t.Errorf("errors %v, want %d", errs, test.wantErrors)
+			}
+		})
+	}
+}
+
+func TestApplyTo(t *testing.T) {
+	tests := []struct {
+		name    string
+		options *Options
+		to      *Options
+		want    *Options
+	}{
+		{
+			name:    "empty keys are left as they are",
+			options: &Options{},
+			to:      &Options{AppLabel: "app", IstioPilotHost: "istiod"},
+			want:    &Options{AppLabel: "app", IstioPilotHost: "istiod"},
+		},
+		{
+			name: "keys are overridden",
+			options: &Options{
+				AppLabel:                     "app.kubernetes.io/name",
+				VersionLabel:                 "app.kubernetes.io/version",
+				ApplicationNameLabel:         "app.kubernetes.io/part-of",
+				ApplicationVersionLabel:      "app.kubernetes.io/release",
+				ServiceMeshEnabledAnnotation: "sidecar.istio.io/inject",
+			},
+			to: &Options{AppLabel: "app", IstioPilotHost: "istiod"},
+			want: &Options{
+				IstioPilotHost:               "istiod",
+				AppLabel:                     "app.kubernetes.io/name",
+				VersionLabel:                 "app.kubernetes.io/version",
+				ApplicationNameLabel:         "app.kubernetes.io/part-of",
+				ApplicationVersionLabel:      "app.kubernetes.io/release",
+				ServiceMeshEnabledAnnotation: "sidecar.istio.io/inject",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.options.ApplyTo(test.to)
+			if !reflect.DeepEqual(test.to, test.want) {
+				t.Errorf("options %+v, want %+v", test.to, test.want)
+			}
+		})
+	}
+}
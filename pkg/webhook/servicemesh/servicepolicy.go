@@ -0,0 +1,192 @@
+package servicemesh
+
+import (
+	"context"
+	"net/http"
+
+	istiolisters "istio.io/client-go/pkg/listers/networking/v1beta1"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+	"zmc.io/oasis/pkg/controller/virtualservice/util"
+)
+
+// ServicePolicyValidator rejects servicepolicies that can never be delivered to istio
+type ServicePolicyValidator struct {
+	ServiceLister         corelisters.ServiceLister
+	DestinationRuleLister istiolisters.DestinationRuleLister
+	// ListersSynced tells if listers above have synced, subsets are not checked before that
+	ListersSynced cache.InformerSynced
+	decoder       *admission.Decoder
+}
+
+func (v *ServicePolicyValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation == admissionv1beta1.Delete {
+		return admission.Allowed("")
+	}
+
+	sp := &servicemeshv1alpha1.ServicePolicy{}
+	if err := v.decoder.Decode(req, sp); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	allErrs := ValidateServicePolicy(sp)
+	if len(allErrs) == 0 && v.ListersSynced() {
+		subsets, found, err := liveSubsets(v.ServiceLister, v.DestinationRuleLister, sp.Namespace, sp.Labels[util.AppLabel])
+		if err != nil {
+			return admission.Errored(http.StatusInternalServerError, err)
+		}
+
+		// subsets are checked once destinationrules are created
+		if !found {
+			return admission.Allowed("")
+		}
+
+		subsetsPath := field.NewPath("spec", "template", "spec", "subsets")
+		for i, subset := range sp.Spec.Template.Spec.Subsets {
+			if !subsets.Has(subset.Name) {
+				allErrs = append(allErrs, field.NotFound(subsetsPath.Index(i).Child("name"), subset.Name))
+			}
+		}
+	}
+
+	if len(allErrs) > 0 {
+		return admission.Denied(allErrs.ToAggregate().Error())
+	}
+
+	return admission.Allowed("")
+}
+
+func (v *ServicePolicyValidator) InjectDecoder(decoder *admission.Decoder) error {
+	v.decoder = decoder
+	return nil
+}
+
+// ValidateServicePolicy checks servicepolicy on its own, without looking at other resources
+func ValidateServicePolicy(sp *servicemeshv1alpha1.ServicePolicy) field.ErrorList {
+	allErrs := validateApplicationLabels(sp.Labels, field.NewPath("metadata", "labels"))
+
+	subsetsPath := field.NewPath("spec", "template", "spec", "subsets")
+	names := sets.NewString()
+	for i, subset := range sp.Spec.Template.Spec.Subsets {
+		if len(subset.Name) == 0 {
+			allErrs = append(allErrs, field.Required(subsetsPath.Index(i).Child("name"), ""))
+		} else if names.Has(subset.Name) {
+			allErrs = append(allErrs, field.Duplicate(subsetsPath.Index(i).Child("name"), subset.Name))
+		}
+		names.Insert(subset.Name)
+	}
+
+	if resilience := sp.Spec.Resilience; resilience != nil {
+		allErrs = append(allErrs, validateResilience(resilience, field.NewPath("spec", "resilience"))...)
+	}
+
+	return allErrs
+}
+
+// validateResilience checks parameters of resilience presets, zero counts are rejected
+// since istio takes them as unlimited
+func validateResilience(resilience *servicemeshv1alpha1.ResiliencePolicy, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if resilience.ConnectionLimits == nil && resilience.CircuitBreaker == nil && resilience.OutlierEjection == nil {
+		allErrs = append(allErrs, field.Required(fldPath, "resilience requires at least one of connectionLimits, circuitBreaker or outlierEjection"))
+	}
+
+	if limits := resilience.ConnectionLimits; limits != nil {
+		limitsPath := fldPath.Child("connectionLimits")
+		allErrs = append(allErrs, validatePositiveCount(limits.MaxConnections, limitsPath.Child("maxConnections"))...)
+		allErrs = append(allErrs, validatePositiveDuration(limits.ConnectTimeout, limitsPath.Child("connectTimeout"))...)
+		allErrs = append(allErrs, validatePositiveCount(limits.MaxRequestsPerConnection, limitsPath.Child("maxRequestsPerConnection"))...)
+		allErrs = append(allErrs, validatePositiveDuration(limits.IdleTimeout, limitsPath.Child("idleTimeout"))...)
+	}
+
+	if circuitBreaker := resilience.CircuitBreaker; circuitBreaker != nil {
+		circuitBreakerPath := fldPath.Child("circuitBreaker")
+		allErrs = append(allErrs, validatePositiveCount(circuitBreaker.MaxPendingRequests, circuitBreakerPath.Child("maxPendingRequests"))...)
+		allErrs = append(allErrs, validatePositiveCount(circuitBreaker.MaxRequests, circuitBreakerPath.Child("maxRequests"))...)
+		allErrs = append(allErrs, validatePositiveCount(circuitBreaker.MaxRetries, circuitBreakerPath.Child("maxRetries"))...)
+	}
+
+	if ejection := resilience.OutlierEjection; ejection != nil {
+		ejectionPath := fldPath.Child("outlierEjection")
+		allErrs = append(allErrs, validatePositiveCount(ejection.Consecutive5xxErrors, ejectionPath.Child("consecutive5xxErrors"))...)
+		allErrs = append(allErrs, validatePositiveCount(ejection.ConsecutiveGatewayErrors, ejectionPath.Child("consecutiveGatewayErrors"))...)
+		allErrs = append(allErrs, validatePositiveDuration(ejection.Interval, ejectionPath.Child("interval"))...)
+		allErrs = append(allErrs, validatePositiveDuration(ejection.BaseEjectionTime, ejectionPath.Child("baseEjectionTime"))...)
+		if ejection.MaxEjectionPercent != nil {
+			allErrs = append(allErrs, validatePercentage(*ejection.MaxEjectionPercent, ejectionPath.Child("maxEjectionPercent"))...)
+		}
+		if ejection.MinHealthPercent != nil {
+			allErrs = append(allErrs, validatePercentage(*ejection.MinHealthPercent, ejectionPath.Child("minHealthPercent"))...)
+		}
+	}
+
+	return allErrs
+}
+
+func validatePositiveCount(count *int32, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if count != nil && *count <= 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath, *count, "must be positive"))
+	}
+	return allErrs
+}
+
+func validatePositiveDuration(duration *metav1.Duration, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if duration != nil && duration.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath, duration.Duration.String(), "must be positive"))
+	}
+	return allErrs
+}
+
+// validateApplicationLabels checks resource has labels of servicemesh application
+func validateApplicationLabels(labels map[string]string, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	for _, label := range util.ApplicationLabels {
+		if len(labels[label]) == 0 {
+			allErrs = append(allErrs, field.Required(fldPath.Key(label), "required by servicemesh application"))
+		}
+	}
+	return allErrs
+}
+
+// liveSubsets returns subsets of destinationrules created for services of the application,
+// destinationrules are named after services, which may differ from the application.
+// It tells whether any destinationrule is found, subsets are not known before that.
+func liveSubsets(serviceLister corelisters.ServiceLister, destinationRuleLister istiolisters.DestinationRuleLister,
+	namespace, appName string) (sets.String, bool, error) {
+	subsets := sets.NewString()
+
+	services, err := serviceLister.Services(namespace).List(labels.SelectorFromSet(map[string]string{util.AppLabel: appName}))
+	if err != nil {
+		return nil, false, err
+	}
+
+	found := false
+	for _, service := range services {
+		dr, err := destinationRuleLister.DestinationRules(namespace).Get(service.Name)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return nil, false, err
+		}
+
+		found = true
+		for _, subset := range dr.Spec.Subsets {
+			subsets.Insert(subset.Name)
+		}
+	}
+
+	return subsets, found, nil
+}
@@ -0,0 +1,310 @@
+package servicemesh
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	networkingv1beta1api "istio.io/api/networking/v1beta1"
+	networkingv1beta1 "istio.io/client-go/pkg/apis/networking/v1beta1"
+	istiolisters "istio.io/client-go/pkg/listers/networking/v1beta1"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+	"zmc.io/oasis/pkg/client/clientset/versioned/scheme"
+	"zmc.io/oasis/pkg/controller/virtualservice/util"
+)
+
+// newTestListers serves services and destinationrules of objects from indexers
+func newTestListers(objects ...runtime.Object) (corelisters.ServiceLister, istiolisters.DestinationRuleLister) {
+	services := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	destinationRules := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+
+	for _, obj := range objects {
+		switch obj.(type) {
+		case *v1.Service:
+			_ = services.Add(obj)
+		case *networkingv1beta1.DestinationRule:
+			_ = destinationRules.Add(obj)
+		}
+	}
+
+	return corelisters.NewServiceLister(services), istiolisters.NewDestinationRuleLister(destinationRules)
+}
+
+func newTestService(name string) *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: testNamespace,
+			Labels:    testApplicationLabels(),
+		},
+	}
+}
+
+func newTestDestinationRule(name string, subsets ...string) *networkingv1beta1.DestinationRule {
+	dr := &networkingv1beta1.DestinationRule{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace},
+	}
+	for _, subset := range subsets {
+		dr.Spec.Subsets = append(dr.Spec.Subsets, &networkingv1beta1api.Subset{Name: subset})
+	}
+	return dr
+}
+
+// newTestRequest wraps obj in an admission request creating it
+func newTestRequest(t *testing.T, obj runtime.Object) admission.Request {
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatalf("marshal %T failed, %v", obj, err)
+	}
+
+	return admission.Request{AdmissionRequest: admissionv1beta1.AdmissionRequest{
+		Operation: admissionv1beta1.Create,
+		Object:    runtime.RawExtension{Raw: raw},
+	}}
+}
+
+// newTestUpdateRequest wraps obj in an admission request updating old to it
+func newTestUpdateRequest(t *testing.T, old, obj runtime.Object) admission.Request {
+	req := newTestRequest(t, obj)
+	raw, err := json.Marshal(old)
+	if err != nil {
+		t.Fatalf("marshal %T failed, %v", old, err)
+	}
+
+	req.Operation = admissionv1beta1.Update
+	req.OldObject = runtime.RawExtension{Raw: raw}
+	return req
+}
+
+func alwaysSynced() bool {
+	return true
+}
+
+func newTestDecoder(t *testing.T) *admission.Decoder {
+	decoder, err := admission.NewDecoder(scheme.Scheme)
+	if err != nil {
+		t.Fatalf("create decoder failed, %v", err)
+	}
+	return decoder
+}
+
+func TestLiveSubsets(t *testing.T) {
+	productpage := newTestService("productpage")
+	productpage.Labels = map[string]string{util.AppLabel: "productpage"}
+
+	tests := []struct {
+		name    string
+		objects []runtime.Object
+
+		want      []string
+		wantFound bool
+	}{
+		{
+			name:    "destinationrule named after service",
+			objects: []runtime.Object{newTestService("reviews-svc"), newTestDestinationRule("reviews-svc", "v1", "v2")},
+			want:    []string{"v1", "v2"}, wantFound: true,
+		},
+		{
+			name: "subsets of services sharing app label",
+			objects: []runtime.Object{
+				newTestService("reviews"), newTestDestinationRule("reviews", "v1"),
+				newTestService("reviews-admin"), newTestDestinationRule("reviews-admin", "v2"),
+			},
+			want: []string{"v1", "v2"}, wantFound: true,
+		},
+		{
+			name:    "destinationrule of other application",
+			objects: []runtime.Object{productpage, newTestDestinationRule("productpage", "v1")},
+			want:    []string{},
+		},
+		{
+			name:    "destinationrule not created yet",
+			objects: []runtime.Object{newTestService("reviews")},
+			want:    []string{},
+		},
+		{
+			name:    "destinationrule named after app label only",
+			objects: []runtime.Object{newTestDestinationRule(testApp, "v1")},
+			want:    []string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			serviceLister, destinationRuleLister := newTestListers(test.objects...)
+
+			got, found, err := liveSubsets(serviceLister, destinationRuleLister, testNamespace, testApp)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if found != test.wantFound {
+				t.Errorf("found %v, want %v", found, test.wantFound)
+			}
+			if !got.Equal(sets.NewString(test.want...)) {
+				t.Errorf("subsets %v, want %v", got.List(), test.want)
+			}
+		})
+	}
+}
+
+func TestServicePolicyValidator(t *testing.T) {
+	newServicePolicy := func(subsets ...string) *servicemeshv1alpha1.ServicePolicy {
+		sp := &servicemeshv1alpha1.ServicePolicy{
+			TypeMeta:   metav1.TypeMeta{APIVersion: servicemeshv1alpha1.SchemeGroupVersion.String(), Kind: "ServicePolicy"},
+			ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: testNamespace, Labels: testApplicationLabels()},
+		}
+		for _, subset := range subsets {
+			sp.Spec.Template.Spec.Subsets = append(sp.Spec.Template.Spec.Subsets, &networkingv1beta1api.Subset{Name: subset})
+		}
+		return sp
+	}
+
+	tests := []struct {
+		name    string
+		sp      *servicemeshv1alpha1.ServicePolicy
+		objects []runtime.Object
+		allowed bool
+	}{
+		{
+			name:    "subsets of destinationrule named after service",
+			sp:      newServicePolicy("v1"),
+			objects: []runtime.Object{newTestService("reviews-svc"), newTestDestinationRule("reviews-svc", "v1")},
+			allowed: true,
+		},
+		{
+			name:    "unknown subset",
+			sp:      newServicePolicy("v3"),
+			objects: []runtime.Object{newTestService("reviews"), newTestDestinationRule("reviews", "v1")},
+		},
+		{
+			name:    "destinationrule not created yet",
+			sp:      newServicePolicy("v3"),
+			objects: []runtime.Object{newTestService("reviews")},
+			allowed: true,
+		},
+		{
+			name: "invalid labels",
+			sp: func() *servicemeshv1alpha1.ServicePolicy {
+				sp := newServicePolicy()
+				sp.Labels = nil
+				return sp
+			}(),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			serviceLister, destinationRuleLister := newTestListers(test.objects...)
+			validator := &ServicePolicyValidator{ServiceLister: serviceLister, DestinationRuleLister: destinationRuleLister, ListersSynced: alwaysSynced}
+			_ = validator.InjectDecoder(newTestDecoder(t))
+
+			response := validator.Handle(context.TODO(), newTestRequest(t, test.sp))
+			if response.Allowed != test.allowed {
+				t.Errorf("allowed %v, want %v, result %v", response.Allowed, test.allowed, response.Result)
+			}
+			if !response.Allowed && response.Result.Code != http.StatusForbidden {
+				t.Errorf("not denied, result %v", response.Result)
+			}
+		})
+	}
+}
+
+func TestValidateServicePolicyResilience(t *testing.T) {
+	duration := func(d time.Duration) *metav1.Duration {
+		return &metav1.Duration{Duration: d}
+	}
+
+	tests := []struct {
+		name       string
+		resilience *servicemeshv1alpha1.ResiliencePolicy
+		wantFields []string
+	}{
+		{
+			name: "resilience not specified",
+		},
+		{
+			name:       "no presets",
+			resilience: &servicemeshv1alpha1.ResiliencePolicy{},
+			wantFields: []string{"spec.resilience"},
+		},
+		{
+			name: "presets by default",
+			resilience: &servicemeshv1alpha1.ResiliencePolicy{
+				ConnectionLimits: &servicemeshv1alpha1.ConnectionLimits{},
+				CircuitBreaker:   &servicemeshv1alpha1.CircuitBreaker{},
+				OutlierEjection:  &servicemeshv1alpha1.OutlierEjection{},
+			},
+		},
+		{
+			name: "valid parameters",
+			resilience: &servicemeshv1alpha1.ResiliencePolicy{
+				ConnectionLimits: &servicemeshv1alpha1.ConnectionLimits{MaxConnections: int32Ptr(100), ConnectTimeout: duration(time.Second)},
+				CircuitBreaker:   &servicemeshv1alpha1.CircuitBreaker{MaxRetries: int32Ptr(3)},
+				OutlierEjection:  &servicemeshv1alpha1.OutlierEjection{MaxEjectionPercent: int32Ptr(100), MinHealthPercent: int32Ptr(0)},
+			},
+		},
+		{
+			name: "zero counts",
+			resilience: &servicemeshv1alpha1.ResiliencePolicy{
+				ConnectionLimits: &servicemeshv1alpha1.ConnectionLimits{MaxConnections: int32Ptr(0)},
+				CircuitBreaker:   &servicemeshv1alpha1.CircuitBreaker{MaxRequests: int32Ptr(-1)},
+				OutlierEjection:  &servicemeshv1alpha1.OutlierEjection{ConsecutiveGatewayErrors: int32Ptr(0)},
+			},
+			wantFields: []string{
+				"spec.resilience.connectionLimits.maxConnections",
+				"spec.resilience.circuitBreaker.maxRequests",
+				"spec.resilience.outlierEjection.consecutiveGatewayErrors",
+			},
+		},
+		{
+			name: "non positive durations",
+			resilience: &servicemeshv1alpha1.ResiliencePolicy{
+				ConnectionLimits: &servicemeshv1alpha1.ConnectionLimits{IdleTimeout: duration(0)},
+				OutlierEjection:  &servicemeshv1alpha1.OutlierEjection{Interval: duration(-time.Second)},
+			},
+			wantFields: []string{
+				"spec.resilience.connectionLimits.idleTimeout",
+				"spec.resilience.outlierEjection.interval",
+			},
+		},
+		{
+			name: "percentages out of range",
+			resilience: &servicemeshv1alpha1.ResiliencePolicy{
+				OutlierEjection: &servicemeshv1alpha1.OutlierEjection{MaxEjectionPercent: int32Ptr(101), MinHealthPercent: int32Ptr(-1)},
+			},
+			wantFields: []string{
+				"spec.resilience.outlierEjection.maxEjectionPercent",
+				"spec.resilience.outlierEjection.minHealthPercent",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sp := &servicemeshv1alpha1.ServicePolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: testNamespace, Labels: testApplicationLabels()},
+				Spec:       servicemeshv1alpha1.ServicePolicySpec{Resilience: test.resilience},
+			}
+
+			var fields []string
+			for _, err := range ValidateServicePolicy(sp) {
+				fields = append(fields, err.Field)
+			}
+			if !reflect.DeepEqual(fields, test.wantFields) {
+				t.Errorf("invalid fields %v, want %v", fields, test.wantFields)
+			}
+		})
+	}
+}
@@ -0,0 +1,436 @@
+package servicemesh
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	networkingv1beta1api "istio.io/api/networking/v1beta1"
+	istiolisters "istio.io/client-go/pkg/listers/networking/v1beta1"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+	"zmc.io/oasis/pkg/controller/virtualservice"
+	"zmc.io/oasis/pkg/controller/virtualservice/util"
+)
+
+// StrategyDefaulter fills default values of strategy fields
+type StrategyDefaulter struct {
+	decoder *admission.Decoder
+}
+
+func (d *StrategyDefaulter) Handle(ctx context.Context, req admission.Request) admission.Response {
+	strategy := &servicemeshv1alpha1.Strategy{}
+	if err := d.decoder.Decode(req, strategy); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	DefaultStrategy(strategy)
+
+	marshaled, err := json.Marshal(strategy)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaled)
+}
+
+func (d *StrategyDefaulter) InjectDecoder(decoder *admission.Decoder) error {
+	d.decoder = decoder
+	return nil
+}
+
+// DefaultStrategy sets default values of fields not specified
+func DefaultStrategy(strategy *servicemeshv1alpha1.Strategy) {
+	if len(strategy.Spec.Type) == 0 {
+		strategy.Spec.Type = servicemeshv1alpha1.CanaryType
+	}
+
+	if len(strategy.Spec.StrategyPolicy) == 0 {
+		strategy.Spec.StrategyPolicy = servicemeshv1alpha1.PolicyImmediately
+	}
+
+	if strategy.Spec.Mirror != nil && strategy.Spec.Mirror.Percentage == nil {
+		percentage := int32(100)
+		strategy.Spec.Mirror.Percentage = &percentage
+	}
+}
+
+// StrategyValidator rejects strategies that can never be delivered to istio
+type StrategyValidator struct {
+	ServiceLister         corelisters.ServiceLister
+	DestinationRuleLister istiolisters.DestinationRuleLister
+	// ListersSynced tells if listers above have synced, subsets are not checked before that
+	ListersSynced cache.InformerSynced
+	decoder       *admission.Decoder
+}
+
+func (v *StrategyValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation == admissionv1beta1.Delete {
+		return admission.Allowed("")
+	}
+
+	strategy := &servicemeshv1alpha1.Strategy{}
+	if err := v.decoder.Decode(req, strategy); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	allErrs := ValidateStrategy(strategy)
+
+	validate, err := v.shouldValidateSubsets(req, strategy)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	// subsets are checked against live destinationrule, an empty cache knows
+	// no subset at all, so nothing is checked until listers have synced
+	if len(allErrs) == 0 && validate && v.ListersSynced() {
+		errs, err := v.validateSubsets(strategy)
+		if err != nil {
+			return admission.Errored(http.StatusInternalServerError, err)
+		}
+		allErrs = append(allErrs, errs...)
+	}
+
+	if len(allErrs) > 0 {
+		return admission.Denied(allErrs.ToAggregate().Error())
+	}
+
+	return admission.Allowed("")
+}
+
+func (v *StrategyValidator) InjectDecoder(decoder *admission.Decoder) error {
+	v.decoder = decoder
+	return nil
+}
+
+// shouldValidateSubsets tells if subsets of strategy are checked against live destinationrule.
+// Strategies going to wait for their subsets are never checked, and an update is checked
+// only if it routes to subsets not checked before, so strategies routing to a subset
+// that has gone since are still able to be paused or edited in other ways.
+func (v *StrategyValidator) shouldValidateSubsets(req admission.Request, strategy *servicemeshv1alpha1.Strategy) (bool, error) {
+	if !isSubsetsRequired(strategy) {
+		return false, nil
+	}
+
+	if req.Operation != admissionv1beta1.Update {
+		return true, nil
+	}
+
+	old := &servicemeshv1alpha1.Strategy{}
+	if err := v.decoder.DecodeRaw(req.OldObject, old); err != nil {
+		return false, err
+	}
+
+	return !isSubsetsRequired(old) ||
+		old.Labels[util.AppLabel] != strategy.Labels[util.AppLabel] ||
+		!virtualservice.StrategySubsets(old).Equal(virtualservice.StrategySubsets(strategy)), nil
+}
+
+// isSubsetsRequired tells if strategy requires its subsets exist before applied
+func isSubsetsRequired(strategy *servicemeshv1alpha1.Strategy) bool {
+	return strategy.Spec.StrategyPolicy != servicemeshv1alpha1.PolicyWaitForWorkloadReady &&
+		strategy.Spec.StrategyPolicy != servicemeshv1alpha1.PolicyPause
+}
+
+// validateSubsets checks every subset strategy routes to exists in destinationrule
+func (v *StrategyValidator) validateSubsets(strategy *servicemeshv1alpha1.Strategy) (field.ErrorList, error) {
+	allErrs := field.ErrorList{}
+
+	subsets, found, err := liveSubsets(v.ServiceLister, v.DestinationRuleLister, strategy.Namespace, strategy.Labels[util.AppLabel])
+	if err != nil {
+		return nil, err
+	}
+
+	// subsets are checked once destinationrules are created
+	if !found {
+		return allErrs, nil
+	}
+
+	for _, subset := range virtualservice.StrategySubsets(strategy).List() {
+		if !subsets.Has(subset) {
+			allErrs = append(allErrs, field.NotFound(field.NewPath("spec"), fmt.Sprintf("subset %s", subset)))
+		}
+	}
+
+	return allErrs, nil
+}
+
+// ValidateStrategy checks strategy on its own, without looking at other resources
+func ValidateStrategy(strategy *servicemeshv1alpha1.Strategy) field.ErrorList {
+	allErrs := validateApplicationLabels(strategy.Labels, field.NewPath("metadata", "labels"))
+
+	spec := &strategy.Spec
+	specPath := field.NewPath("spec")
+
+	switch spec.StrategyPolicy {
+	case "", servicemeshv1alpha1.PolicyImmediately, servicemeshv1alpha1.PolicyWaitForWorkloadReady, servicemeshv1alpha1.PolicyPause:
+	default:
+		allErrs = append(allErrs, field.NotSupported(specPath.Child("strategyPolicy"), spec.StrategyPolicy,
+			[]string{string(servicemeshv1alpha1.PolicyImmediately), string(servicemeshv1alpha1.PolicyWaitForWorkloadReady), string(servicemeshv1alpha1.PolicyPause)}))
+	}
+
+	templatePath := specPath.Child("template", "spec")
+	template := &spec.Template.Spec
+	hasRoutes := len(template.Http) > 0 || len(template.Tcp) > 0 || len(template.Tls) > 0
+
+	switch spec.Type {
+	case "", servicemeshv1alpha1.CanaryType:
+		if !hasRoutes && len(spec.Steps) == 0 && len(spec.Segments) == 0 && len(spec.GovernorVersion) == 0 &&
+			spec.Timeout == nil && spec.Retries == nil {
+			allErrs = append(allErrs, field.Required(templatePath, "canary strategy requires template routes, steps, segments, governor, timeout or retries"))
+		}
+	case servicemeshv1alpha1.BlueGreenType:
+		if spec.BlueGreen == nil {
+			allErrs = append(allErrs, field.Required(specPath.Child("blueGreen"), "blue/green strategy requires blueGreen"))
+		} else if len(spec.BlueGreen.PreviewVersion) == 0 {
+			allErrs = append(allErrs, field.Required(specPath.Child("blueGreen", "preview"), ""))
+		}
+		if len(spec.PrincipalVersion) == 0 {
+			allErrs = append(allErrs, field.Required(specPath.Child("principal"), "blue/green strategy requires principal version"))
+		}
+	case servicemeshv1alpha1.Mirror:
+		if spec.Mirror == nil {
+			allErrs = append(allErrs, field.Required(specPath.Child("mirror"), "mirror strategy requires mirror"))
+		} else if len(spec.Mirror.Version) == 0 {
+			allErrs = append(allErrs, field.Required(specPath.Child("mirror", "version"), ""))
+		}
+	case servicemeshv1alpha1.FaultInjectionType:
+		if spec.FaultInjection == nil {
+			allErrs = append(allErrs, field.Required(specPath.Child("faultInjection"), "fault injection strategy requires faultInjection"))
+		}
+	default:
+		allErrs = append(allErrs, field.NotSupported(specPath.Child("type"), spec.Type,
+			[]string{string(servicemeshv1alpha1.CanaryType), string(servicemeshv1alpha1.BlueGreenType), string(servicemeshv1alpha1.Mirror), string(servicemeshv1alpha1.FaultInjectionType)}))
+	}
+
+	if len(spec.Steps) > 0 {
+		if len(spec.CanaryVersion) == 0 {
+			allErrs = append(allErrs, field.Required(specPath.Child("canary"), "canary steps require canary version"))
+		}
+		if len(spec.PrincipalVersion) == 0 {
+			allErrs = append(allErrs, field.Required(specPath.Child("principal"), "canary steps require principal version"))
+		}
+	}
+
+	for i, step := range spec.Steps {
+		stepPath := specPath.Child("steps").Index(i)
+		allErrs = append(allErrs, validatePercentage(step.Weight, stepPath.Child("weight"))...)
+		if step.Pause.Duration < 0 {
+			allErrs = append(allErrs, field.Invalid(stepPath.Child("pause"), step.Pause.Duration.String(), "must not be negative"))
+		}
+	}
+
+	if analysis := spec.Analysis; analysis != nil {
+		analysisPath := specPath.Child("analysis")
+		if analysis.MaxErrorRate != nil {
+			allErrs = append(allErrs, validatePercentage(*analysis.MaxErrorRate, analysisPath.Child("maxErrorRate"))...)
+		}
+		if analysis.Interval.Duration < 0 {
+			allErrs = append(allErrs, field.Invalid(analysisPath.Child("interval"), analysis.Interval.Duration.String(), "must not be negative"))
+		}
+	}
+
+	for i, segment := range spec.Segments {
+		segmentPath := specPath.Child("segments").Index(i)
+		if len(segment.Version) == 0 {
+			allErrs = append(allErrs, field.Required(segmentPath.Child("version"), ""))
+		}
+		if len(segment.Headers) == 0 && segment.Cookie == nil && len(segment.SourceLabels) == 0 && len(segment.URIPrefix) == 0 {
+			allErrs = append(allErrs, field.Required(segmentPath, "segment requires at least one of headers, cookie, sourceLabels or uriPrefix"))
+		}
+		allErrs = append(allErrs, validateSegmentHeaders(&segment, segmentPath.Child("headers"))...)
+	}
+
+	if spec.Mirror != nil && spec.Mirror.Percentage != nil {
+		allErrs = append(allErrs, validatePercentage(*spec.Mirror.Percentage, specPath.Child("mirror", "percentage"))...)
+	}
+
+	if faultInjection := spec.FaultInjection; faultInjection != nil {
+		allErrs = append(allErrs, validateFaultInjection(spec, specPath.Child("faultInjection"))...)
+	}
+
+	if spec.Timeout != nil && spec.Timeout.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("timeout"), spec.Timeout.Duration.String(), "must be positive"))
+	}
+
+	if retries := spec.Retries; retries != nil {
+		retriesPath := specPath.Child("retries")
+		if retries.Attempts < 0 {
+			allErrs = append(allErrs, field.Invalid(retriesPath.Child("attempts"), retries.Attempts, "must not be negative"))
+		}
+		if retries.PerTryTimeout != nil && retries.PerTryTimeout.Duration <= 0 {
+			allErrs = append(allErrs, field.Invalid(retriesPath.Child("perTryTimeout"), retries.PerTryTimeout.Duration.String(), "must be positive"))
+		}
+		for i, retryOn := range retries.RetryOn {
+			if len(retryOn) == 0 || strings.Contains(retryOn, ",") {
+				allErrs = append(allErrs, field.Invalid(retriesPath.Child("retryOn").Index(i), retryOn, "must be a single non-empty condition"))
+			}
+		}
+	}
+
+	if ingress := spec.Ingress; ingress != nil {
+		ingressPath := specPath.Child("ingress")
+		if len(ingress.Gateways) == 0 {
+			allErrs = append(allErrs, field.Required(ingressPath.Child("gateways"), ""))
+		}
+		if len(ingress.Hosts) == 0 {
+			allErrs = append(allErrs, field.Required(ingressPath.Child("hosts"), ""))
+		}
+	}
+
+	if schedule := spec.Schedule; schedule != nil {
+		schedulePath := specPath.Child("schedule")
+		if schedule.StartTime != nil && schedule.EndTime != nil && !schedule.EndTime.After(schedule.StartTime.Time) {
+			allErrs = append(allErrs, field.Invalid(schedulePath.Child("endTime"), schedule.EndTime.String(), "must be after startTime"))
+		}
+		if len(schedule.Cron) > 0 {
+			if _, err := util.ParseCron(schedule.Cron); err != nil {
+				allErrs = append(allErrs, field.Invalid(schedulePath.Child("cron"), schedule.Cron, err.Error()))
+			}
+			if schedule.Duration == nil || schedule.Duration.Duration <= 0 {
+				allErrs = append(allErrs, field.Required(schedulePath.Child("duration"), "cron schedule requires a positive duration"))
+			}
+		}
+	}
+
+	allErrs = append(allErrs, validateRouteWeights(template, templatePath)...)
+
+	return allErrs
+}
+
+// validateFaultInjection checks faults are well formed, and injected into something known
+func validateFaultInjection(spec *servicemeshv1alpha1.StrategySpec, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	faultInjection := spec.FaultInjection
+
+	if faultInjection.Delay == nil && faultInjection.Abort == nil {
+		allErrs = append(allErrs, field.Required(fldPath, "fault injection requires delay or abort"))
+	}
+
+	if delay := faultInjection.Delay; delay != nil {
+		if delay.FixedDelay.Duration <= 0 {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("delay", "fixedDelay"), delay.FixedDelay.Duration.String(), "must be positive"))
+		}
+		allErrs = append(allErrs, validatePercentage(delay.Percentage, fldPath.Child("delay", "percentage"))...)
+	}
+
+	if abort := faultInjection.Abort; abort != nil {
+		if abort.HTTPStatus < 200 || abort.HTTPStatus > 599 {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("abort", "httpStatus"), abort.HTTPStatus, "must be between 200 and 599"))
+		}
+		allErrs = append(allErrs, validatePercentage(abort.Percentage, fldPath.Child("abort", "percentage"))...)
+	}
+
+	if len(faultInjection.Segment) > 0 {
+		found := false
+		for i, segment := range spec.Segments {
+			if segment.Name == faultInjection.Segment || fmt.Sprintf("segment-%d", i) == faultInjection.Segment {
+				found = true
+				break
+			}
+		}
+		if !found {
+			allErrs = append(allErrs, field.NotFound(fldPath.Child("segment"), faultInjection.Segment))
+		}
+	}
+
+	if len(faultInjection.Segment) == 0 && len(faultInjection.Version) == 0 && len(spec.PrincipalVersion) == 0 {
+		allErrs = append(allErrs, field.Required(fldPath.Child("version"), "fault injection requires segment, version or principal version"))
+	}
+
+	return allErrs
+}
+
+// validateSegmentHeaders checks header names of segment, they are matched in
+// lower case, so names differ only in case collide with each other.
+func validateSegmentHeaders(segment *servicemeshv1alpha1.UserSegment, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	seen := sets.NewString()
+	if segment.Cookie != nil {
+		seen.Insert("cookie")
+	}
+
+	for _, name := range sets.StringKeySet(segment.Headers).List() {
+		for _, msg := range validation.IsHTTPHeaderName(name) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Key(name), name, msg))
+		}
+
+		lower := strings.ToLower(name)
+		if seen.Has(lower) {
+			allErrs = append(allErrs, field.Duplicate(fldPath.Key(name), name))
+		}
+		seen.Insert(lower)
+	}
+
+	return allErrs
+}
+
+// validateRouteWeights checks weights of destinations in every route sum up to 100
+func validateRouteWeights(spec *networkingv1beta1api.VirtualService, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	for i, route := range spec.Http {
+		weights := make([]int32, 0, len(route.Route))
+		for _, dw := range route.Route {
+			weights = append(weights, dw.Weight)
+		}
+		allErrs = append(allErrs, validateWeights(weights, fldPath.Child("http").Index(i).Child("route"))...)
+	}
+
+	for i, route := range spec.Tcp {
+		weights := make([]int32, 0, len(route.Route))
+		for _, dw := range route.Route {
+			weights = append(weights, dw.Weight)
+		}
+		allErrs = append(allErrs, validateWeights(weights, fldPath.Child("tcp").Index(i).Child("route"))...)
+	}
+
+	for i, route := range spec.Tls {
+		weights := make([]int32, 0, len(route.Route))
+		for _, dw := range route.Route {
+			weights = append(weights, dw.Weight)
+		}
+		allErrs = append(allErrs, validateWeights(weights, fldPath.Child("tls").Index(i).Child("route"))...)
+	}
+
+	return allErrs
+}
+
+// validateWeights follows istio rules, a single destination may omit its weight,
+// otherwise weights must sum up to 100.
+func validateWeights(weights []int32, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	sum := int32(0)
+	for i, weight := range weights {
+		allErrs = append(allErrs, validatePercentage(weight, fldPath.Index(i).Child("weight"))...)
+		sum += weight
+	}
+
+	if len(weights) == 1 && sum == 0 {
+		return allErrs
+	}
+
+	if len(weights) > 0 && sum != 100 {
+		allErrs = append(allErrs, field.Invalid(fldPath, sum, "weights of destinations must sum up to 100"))
+	}
+
+	return allErrs
+}
+
+func validatePercentage(value int32, fldPath *field.Path) field.ErrorList {
+	if value < 0 || value > 100 {
+		return field.ErrorList{field.Invalid(fldPath, value, "must be between 0 and 100")}
+	}
+	return nil
+}
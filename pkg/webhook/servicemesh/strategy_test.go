@@ -0,0 +1,416 @@
+package servicemesh
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	servicemeshv1alpha1 "zmc.io/oasis/pkg/apis/servicemesh/v1alpha1"
+	"zmc.io/oasis/pkg/controller/virtualservice/util"
+)
+
+const (
+	testNamespace = "default"
+	testApp       = "reviews"
+)
+
+func testApplicationLabels() map[string]string {
+	return map[string]string{
+		util.AppLabel:                testApp,
+		util.ApplicationNameLabel:    "bookinfo",
+		util.ApplicationVersionLabel: "v1",
+	}
+}
+
+func newTestStrategy(spec servicemeshv1alpha1.StrategySpec) *servicemeshv1alpha1.Strategy {
+	return &servicemeshv1alpha1.Strategy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "strategy",
+			Namespace: testNamespace,
+			Labels:    testApplicationLabels(),
+		},
+		Spec: spec,
+	}
+}
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+func TestValidateStrategy(t *testing.T) {
+	tests := []struct {
+		name string
+		spec servicemeshv1alpha1.StrategySpec
+		// fields rejected, empty if strategy is valid
+		wantFields []string
+	}{
+		{
+			name:       "canary without anything to route",
+			spec:       servicemeshv1alpha1.StrategySpec{Type: servicemeshv1alpha1.CanaryType},
+			wantFields: []string{"spec.template.spec"},
+		},
+		{
+			name: "timeout only",
+			spec: servicemeshv1alpha1.StrategySpec{Timeout: &metav1.Duration{Duration: 2 * time.Second}},
+		},
+		{
+			name: "retries only",
+			spec: servicemeshv1alpha1.StrategySpec{Retries: &servicemeshv1alpha1.RetryPolicy{Attempts: 3, RetryOn: []string{"5xx"}}},
+		},
+		{
+			name: "invalid timeout and retries",
+			spec: servicemeshv1alpha1.StrategySpec{
+				Timeout: &metav1.Duration{},
+				Retries: &servicemeshv1alpha1.RetryPolicy{Attempts: -1, PerTryTimeout: &metav1.Duration{}, RetryOn: []string{"5xx,reset"}},
+			},
+			wantFields: []string{"spec.timeout", "spec.retries.attempts", "spec.retries.perTryTimeout", "spec.retries.retryOn[0]"},
+		},
+		{
+			name: "segments",
+			spec: servicemeshv1alpha1.StrategySpec{
+				PrincipalVersion: "v1",
+				Segments: []servicemeshv1alpha1.UserSegment{
+					{Version: "v2", Headers: map[string]string{"x-user": "tester"}},
+					{Version: "v2", Cookie: &servicemeshv1alpha1.CookieMatch{Name: "group", Value: "beta"}},
+				},
+			},
+		},
+		{
+			name: "segment without version",
+			spec: servicemeshv1alpha1.StrategySpec{
+				Segments: []servicemeshv1alpha1.UserSegment{{URIPrefix: "/api"}},
+			},
+			wantFields: []string{"spec.segments[0].version"},
+		},
+		{
+			name: "segment without conditions",
+			spec: servicemeshv1alpha1.StrategySpec{
+				Segments: []servicemeshv1alpha1.UserSegment{{Version: "v2"}},
+			},
+			wantFields: []string{"spec.segments[0]"},
+		},
+		{
+			name: "segment with invalid header name",
+			spec: servicemeshv1alpha1.StrategySpec{
+				Segments: []servicemeshv1alpha1.UserSegment{{Version: "v2", Headers: map[string]string{"x user": "tester"}}},
+			},
+			wantFields: []string{"spec.segments[0].headers[x user]"},
+		},
+		{
+			name: "segment with headers differ only in case",
+			spec: servicemeshv1alpha1.StrategySpec{
+				Segments: []servicemeshv1alpha1.UserSegment{{Version: "v2", Headers: map[string]string{"X-User": "a", "x-user": "b"}}},
+			},
+			wantFields: []string{"spec.segments[0].headers[x-user]"},
+		},
+		{
+			name: "segment with cookie header and cookie",
+			spec: servicemeshv1alpha1.StrategySpec{
+				Segments: []servicemeshv1alpha1.UserSegment{{
+					Version: "v2",
+					Headers: map[string]string{"Cookie": "group=beta"},
+					Cookie:  &servicemeshv1alpha1.CookieMatch{Name: "group", Value: "beta"},
+				}},
+			},
+			wantFields: []string{"spec.segments[0].headers[Cookie]"},
+		},
+		{
+			name: "blue/green",
+			spec: servicemeshv1alpha1.StrategySpec{
+				Type:             servicemeshv1alpha1.BlueGreenType,
+				PrincipalVersion: "v1",
+				BlueGreen:        &servicemeshv1alpha1.BlueGreenStrategy{PreviewVersion: "v2"},
+			},
+		},
+		{
+			name: "blue/green without preview version",
+			spec: servicemeshv1alpha1.StrategySpec{
+				Type:             servicemeshv1alpha1.BlueGreenType,
+				PrincipalVersion: "v1",
+				BlueGreen:        &servicemeshv1alpha1.BlueGreenStrategy{},
+			},
+			wantFields: []string{"spec.blueGreen.preview"},
+		},
+		{
+			name: "blue/green without principal version",
+			spec: servicemeshv1alpha1.StrategySpec{
+				Type: servicemeshv1alpha1.BlueGreenType,
+			},
+			wantFields: []string{"spec.blueGreen", "spec.principal"},
+		},
+		{
+			name: "mirror",
+			spec: servicemeshv1alpha1.StrategySpec{
+				Type:   servicemeshv1alpha1.Mirror,
+				Mirror: &servicemeshv1alpha1.MirrorStrategy{Version: "v2", Percentage: int32Ptr(50)},
+			},
+		},
+		{
+			name: "mirror without version",
+			spec: servicemeshv1alpha1.StrategySpec{
+				Type:   servicemeshv1alpha1.Mirror,
+				Mirror: &servicemeshv1alpha1.MirrorStrategy{},
+			},
+			wantFields: []string{"spec.mirror.version"},
+		},
+		{
+			name: "mirror percentage out of range",
+			spec: servicemeshv1alpha1.StrategySpec{
+				Type:   servicemeshv1alpha1.Mirror,
+				Mirror: &servicemeshv1alpha1.MirrorStrategy{Version: "v2", Percentage: int32Ptr(101)},
+			},
+			wantFields: []string{"spec.mirror.percentage"},
+		},
+		{
+			name: "fault injection",
+			spec: servicemeshv1alpha1.StrategySpec{
+				Type:             servicemeshv1alpha1.FaultInjectionType,
+				PrincipalVersion: "v1",
+				FaultInjection: &servicemeshv1alpha1.FaultInjectionStrategy{
+					Delay: &servicemeshv1alpha1.FaultDelay{FixedDelay: metav1.Duration{Duration: time.Second}, Percentage: 10},
+					Abort: &servicemeshv1alpha1.FaultAbort{HTTPStatus: 503, Percentage: 10},
+				},
+			},
+		},
+		{
+			name: "fault injection without faultInjection",
+			spec: servicemeshv1alpha1.StrategySpec{
+				Type:             servicemeshv1alpha1.FaultInjectionType,
+				PrincipalVersion: "v1",
+			},
+			wantFields: []string{"spec.faultInjection"},
+		},
+		{
+			name: "fault injection without faults",
+			spec: servicemeshv1alpha1.StrategySpec{
+				Type:             servicemeshv1alpha1.FaultInjectionType,
+				PrincipalVersion: "v1",
+				FaultInjection:   &servicemeshv1alpha1.FaultInjectionStrategy{},
+			},
+			wantFields: []string{"spec.faultInjection"},
+		},
+		{
+			name: "fault injection with invalid faults",
+			spec: servicemeshv1alpha1.StrategySpec{
+				Type:             servicemeshv1alpha1.FaultInjectionType,
+				PrincipalVersion: "v1",
+				FaultInjection: &servicemeshv1alpha1.FaultInjectionStrategy{
+					Delay: &servicemeshv1alpha1.FaultDelay{Percentage: 101},
+					Abort: &servicemeshv1alpha1.FaultAbort{HTTPStatus: 600, Percentage: -1},
+				},
+			},
+			wantFields: []string{
+				"spec.faultInjection.delay.fixedDelay", "spec.faultInjection.delay.percentage",
+				"spec.faultInjection.abort.httpStatus", "spec.faultInjection.abort.percentage",
+			},
+		},
+		{
+			name: "fault injection into segment",
+			spec: servicemeshv1alpha1.StrategySpec{
+				Type:           servicemeshv1alpha1.FaultInjectionType,
+				Segments:       []servicemeshv1alpha1.UserSegment{{Name: "testers", Version: "v2", URIPrefix: "/api"}, {Version: "v3", URIPrefix: "/v3"}},
+				FaultInjection: &servicemeshv1alpha1.FaultInjectionStrategy{Segment: "segment-1", Abort: &servicemeshv1alpha1.FaultAbort{HTTPStatus: 503, Percentage: 10}},
+			},
+		},
+		{
+			name: "fault injection into unknown segment",
+			spec: servicemeshv1alpha1.StrategySpec{
+				Type:           servicemeshv1alpha1.FaultInjectionType,
+				Segments:       []servicemeshv1alpha1.UserSegment{{Name: "testers", Version: "v2", URIPrefix: "/api"}},
+				FaultInjection: &servicemeshv1alpha1.FaultInjectionStrategy{Segment: "others", Abort: &servicemeshv1alpha1.FaultAbort{HTTPStatus: 503, Percentage: 10}},
+			},
+			wantFields: []string{"spec.faultInjection.segment"},
+		},
+		{
+			name: "fault injection into nothing",
+			spec: servicemeshv1alpha1.StrategySpec{
+				Type:           servicemeshv1alpha1.FaultInjectionType,
+				FaultInjection: &servicemeshv1alpha1.FaultInjectionStrategy{Abort: &servicemeshv1alpha1.FaultAbort{HTTPStatus: 503, Percentage: 10}},
+			},
+			wantFields: []string{"spec.faultInjection.version"},
+		},
+		{
+			name: "ingress",
+			spec: servicemeshv1alpha1.StrategySpec{
+				GovernorVersion: "v1",
+				Ingress:         &servicemeshv1alpha1.IngressStrategy{Gateways: []string{"istio-system/public"}, Hosts: []string{"reviews.example.com"}},
+			},
+		},
+		{
+			name: "ingress without gateways and hosts",
+			spec: servicemeshv1alpha1.StrategySpec{
+				GovernorVersion: "v1",
+				Ingress:         &servicemeshv1alpha1.IngressStrategy{},
+			},
+			wantFields: []string{"spec.ingress.gateways", "spec.ingress.hosts"},
+		},
+		{
+			name: "cron schedule",
+			spec: servicemeshv1alpha1.StrategySpec{
+				GovernorVersion: "v1",
+				Schedule:        &servicemeshv1alpha1.StrategySchedule{Cron: "0 2 * * 7", Duration: &metav1.Duration{Duration: 2 * time.Hour}},
+			},
+		},
+		{
+			name: "invalid cron schedule",
+			spec: servicemeshv1alpha1.StrategySpec{
+				GovernorVersion: "v1",
+				Schedule:        &servicemeshv1alpha1.StrategySchedule{Cron: "0 2 * * 8"},
+			},
+			wantFields: []string{"spec.schedule.cron", "spec.schedule.duration"},
+		},
+		{
+			name: "schedule ends before start",
+			spec: servicemeshv1alpha1.StrategySpec{
+				GovernorVersion: "v1",
+				Schedule: &servicemeshv1alpha1.StrategySchedule{
+					StartTime: &metav1.Time{Time: time.Date(2020, time.February, 2, 0, 0, 0, 0, time.UTC)},
+					EndTime:   &metav1.Time{Time: time.Date(2020, time.February, 1, 0, 0, 0, 0, time.UTC)},
+				},
+			},
+			wantFields: []string{"spec.schedule.endTime"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			errs := ValidateStrategy(newTestStrategy(test.spec))
+
+			fields := make([]string, 0, len(errs))
+			for _, err := range errs {
+				fields = append(fields, err.Field)
+			}
+
+			if strings.Join(fields, ",") != strings.Join(test.wantFields, ",") {
+				t.Errorf("rejected fields %v, want %v, errors: %v", fields, test.wantFields, errs.ToAggregate())
+			}
+		})
+	}
+}
+
+func TestStrategyValidator(t *testing.T) {
+	newStrategy := func(policy servicemeshv1alpha1.StrategyPolicy) *servicemeshv1alpha1.Strategy {
+		strategy := newTestStrategy(servicemeshv1alpha1.StrategySpec{
+			StrategyPolicy:   policy,
+			PrincipalVersion: "v1",
+			CanaryVersion:    "v2",
+			Steps:            []servicemeshv1alpha1.CanaryStep{{Weight: 50}},
+		})
+		strategy.TypeMeta = metav1.TypeMeta{APIVersion: servicemeshv1alpha1.SchemeGroupVersion.String(), Kind: "Strategy"}
+		return strategy
+	}
+
+	tests := []struct {
+		name     string
+		strategy *servicemeshv1alpha1.Strategy
+		// strategy updated from, nil if strategy is created
+		old      *servicemeshv1alpha1.Strategy
+		objects  []runtime.Object
+		unsynced bool
+		allowed  bool
+	}{
+		{
+			name:     "subsets of destinationrule named after service",
+			strategy: newStrategy(servicemeshv1alpha1.PolicyImmediately),
+			objects:  []runtime.Object{newTestService("reviews-svc"), newTestDestinationRule("reviews-svc", "v1", "v2")},
+			allowed:  true,
+		},
+		{
+			name:     "unknown subset",
+			strategy: newStrategy(servicemeshv1alpha1.PolicyImmediately),
+			objects:  []runtime.Object{newTestService("reviews"), newTestDestinationRule("reviews", "v1")},
+		},
+		{
+			name:     "unknown subset waiting for workload",
+			strategy: newStrategy(servicemeshv1alpha1.PolicyWaitForWorkloadReady),
+			objects:  []runtime.Object{newTestService("reviews"), newTestDestinationRule("reviews", "v1")},
+			allowed:  true,
+		},
+		{
+			name:     "destinationrule not created yet",
+			strategy: newStrategy(servicemeshv1alpha1.PolicyImmediately),
+			objects:  []runtime.Object{newTestService("reviews")},
+			allowed:  true,
+		},
+		{
+			name:     "listers not synced yet",
+			strategy: newStrategy(servicemeshv1alpha1.PolicyImmediately),
+			unsynced: true,
+			allowed:  true,
+		},
+		{
+			name: "update keeps subset gone since",
+			strategy: func() *servicemeshv1alpha1.Strategy {
+				strategy := newStrategy(servicemeshv1alpha1.PolicyImmediately)
+				strategy.Spec.Steps[0].Weight = 20
+				return strategy
+			}(),
+			old:     newStrategy(servicemeshv1alpha1.PolicyImmediately),
+			objects: []runtime.Object{newTestService("reviews"), newTestDestinationRule("reviews", "v1")},
+			allowed: true,
+		},
+		{
+			name: "update routes to unknown subset",
+			strategy: func() *servicemeshv1alpha1.Strategy {
+				strategy := newStrategy(servicemeshv1alpha1.PolicyImmediately)
+				strategy.Spec.CanaryVersion = "v3"
+				return strategy
+			}(),
+			old:     newStrategy(servicemeshv1alpha1.PolicyImmediately),
+			objects: []runtime.Object{newTestService("reviews"), newTestDestinationRule("reviews", "v1", "v2")},
+		},
+		{
+			name:     "update stops waiting for unknown subset",
+			strategy: newStrategy(servicemeshv1alpha1.PolicyImmediately),
+			old:      newStrategy(servicemeshv1alpha1.PolicyWaitForWorkloadReady),
+			objects:  []runtime.Object{newTestService("reviews"), newTestDestinationRule("reviews", "v1")},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			serviceLister, destinationRuleLister := newTestListers(test.objects...)
+			validator := &StrategyValidator{ServiceLister: serviceLister, DestinationRuleLister: destinationRuleLister, ListersSynced: alwaysSynced}
+			if test.unsynced {
+				validator.ListersSynced = func() bool { return false }
+			}
+			_ = validator.InjectDecoder(newTestDecoder(t))
+
+			req := newTestRequest(t, test.strategy)
+			if test.old != nil {
+				req = newTestUpdateRequest(t, test.old, test.strategy)
+			}
+
+			response := validator.Handle(context.TODO(), req)
+			if response.Allowed != test.allowed {
+				t.Errorf("allowed %v, want %v, result %v", response.Allowed, test.allowed, response.Result)
+			}
+			if !response.Allowed && response.Result.Code != http.StatusForbidden {
+				t.Errorf("not denied, result %v", response.Result)
+			}
+		})
+	}
+}
+
+func TestDefaultStrategy(t *testing.T) {
+	strategy := newTestStrategy(servicemeshv1alpha1.StrategySpec{
+		Mirror: &servicemeshv1alpha1.MirrorStrategy{Version: "v2"},
+	})
+
+	DefaultStrategy(strategy)
+
+	if strategy.Spec.Type != servicemeshv1alpha1.CanaryType {
+		t.Errorf("type %s, want %s", strategy.Spec.Type, servicemeshv1alpha1.CanaryType)
+	}
+	if strategy.Spec.StrategyPolicy != servicemeshv1alpha1.PolicyImmediately {
+		t.Errorf("policy %s, want %s", strategy.Spec.StrategyPolicy, servicemeshv1alpha1.PolicyImmediately)
+	}
+	if percentage := strategy.Spec.Mirror.Percentage; percentage == nil || *percentage != 100 {
+		t.Errorf("mirror percentage %v, want 100", percentage)
+	}
+}